@@ -2,19 +2,25 @@ package main
 
 import (
 	_ "chat_backend/docs"
+	"chat_backend/internal/delivery/jobs"
+	"chat_backend/internal/delivery/middlewares"
 	"chat_backend/internal/delivery/router"
 	"chat_backend/pkg/utils"
+	"context"
 	"github.com/bytedance/sonic"
 	"github.com/cloudinary/cloudinary-go/v2"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/helmet"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gookit/validate"
 	"github.com/joho/godotenv"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // @title			Chat Application API
@@ -27,9 +33,12 @@ import (
 func main() {
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatalf("Error reading environment: %v", err)
+		slog.Error("read environment", "error", err)
+		os.Exit(1)
 	}
 
+	slog.SetDefault(utils.NewLogger())
+
 	app := fiber.New(fiber.Config{
 		StrictRouting: true,
 		CaseSensitive: true,
@@ -43,7 +52,8 @@ func main() {
 		AllowCredentials: true,
 	}))
 	app.Use(helmet.New())
-	app.Use(logger.New())
+	app.Use(middlewares.RequestID())
+	app.Use(middlewares.Logger())
 	app.Use(recover.New())
 
 	validate.Config(func(opt *validate.GlobalOption) {
@@ -55,7 +65,61 @@ func main() {
 
 	cld, _ := cloudinary.NewFromURL(os.Getenv("CLOUDINARY_URL"))
 
-	router.AppRouter(app, queries, cld)
+	services := router.AppRouter(app, db, queries, cld)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	runJobs(ctx, &wg, services)
+
+	go func() {
+		<-ctx.Done()
+		if err := app.ShutdownWithTimeout(utils.GetEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second)); err != nil {
+			slog.Error("shut down", "error", err)
+		}
+	}()
 
-	log.Fatalln(app.Listen(":6060"))
+	if err := app.Listen(":6060"); err != nil {
+		slog.Error("listen", "error", err)
+		os.Exit(1)
+	}
+
+	// The jobs use the database, so they have to finish before it closes.
+	stop()
+	wg.Wait()
+}
+
+// runJobs starts the background jobs. They stop once ctx is done.
+func runJobs(ctx context.Context, wg *sync.WaitGroup, services router.Services) {
+	every := func(name string, interval time.Duration, job func(ctx context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jobs.Every(ctx, name, interval, job)
+		}()
+	}
+
+	// Accounts are purged once their restore window has ended.
+	every("purge deleted users", utils.GetEnvDuration("ACCOUNT_PURGE_INTERVAL", time.Hour), func(ctx context.Context) error {
+		purged, err := services.User.PurgeDeletedUsers(ctx)
+		if purged > 0 {
+			slog.InfoContext(ctx, "purge deleted users", "count", purged)
+		}
+		return err
+	})
+	// Queued data exports are built off the request path.
+	every("build data exports", utils.GetEnvDuration("DATA_EXPORT_INTERVAL", time.Minute), func(ctx context.Context) error {
+		built, err := services.DataExport.ProcessExports(ctx)
+		if built > 0 {
+			slog.InfoContext(ctx, "build data exports", "count", built)
+		}
+		return err
+	})
+	// Notifications for the native apps are sent, and retried, off the
+	// request path.
+	every("send push deliveries", utils.GetEnvDuration("PUSH_DELIVERY_INTERVAL", 2*time.Second), func(ctx context.Context) error {
+		_, err := services.Push.ProcessDeliveries(ctx)
+		return err
+	})
 }
@@ -0,0 +1,105 @@
+// Command keys manages the keyring that signs session tokens.
+//
+// Usage:
+//
+//	keys list
+//	keys generate
+//	keys activate <kid>
+//	keys retire <kid>
+//
+// A rotation generates a key, waits for every instance to reload the keyring
+// (KEYRING_REFRESH), activates it so that new tokens are signed with it, and
+// retires the previous key once the tokens it signed have expired.
+package main
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/internal/app/services"
+	"chat_backend/pkg/utils"
+	"context"
+	"fmt"
+	"github.com/joho/godotenv"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		slog.Warn("read environment", "error", err)
+	}
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	key, err := utils.GetKeyringKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "keys:", err)
+		os.Exit(1)
+	}
+
+	db, queries := utils.Database()
+	defer db.Close()
+
+	repo := repositories.NewSigningKeyRepo(queries, key, utils.GetTimeouts())
+	keyring := services.NewKeyringService(repo, 0)
+	ctx := context.Background()
+
+	switch command := os.Args[1]; {
+	case command == "list" && len(os.Args) == 2:
+		err = list(ctx, keyring)
+	case command == "generate" && len(os.Args) == 2:
+		var kid string
+		if kid, err = keyring.GenerateKey(ctx); err == nil {
+			fmt.Println(kid)
+		}
+	case command == "activate" && len(os.Args) == 3:
+		err = keyring.ActivateKey(ctx, os.Args[2])
+	case command == "retire" && len(os.Args) == 3:
+		err = keyring.RetireKey(ctx, os.Args[2])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "keys:", err)
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+func list(ctx context.Context, keyring services.KeyringService) error {
+	keys, err := keyring.ListKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tSTATUS\tCREATED\tACTIVATED\tRETIRED")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			key.Kid,
+			key.Status,
+			key.CreatedAt.Time.Format(time.RFC3339),
+			formatTime(key.ActivatedAt.Time, key.ActivatedAt.Valid),
+			formatTime(key.RetiredAt.Time, key.RetiredAt.Valid),
+		)
+	}
+
+	return w.Flush()
+}
+
+func formatTime(t time.Time, valid bool) string {
+	if !valid {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: keys list | generate | activate <kid> | retire <kid>")
+	os.Exit(2)
+}
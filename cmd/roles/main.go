@@ -0,0 +1,109 @@
+// Command roles manages the roles of users, chiefly to appoint the first
+// admin, who can then assign roles through the admin endpoints.
+//
+// Usage:
+//
+//	roles list
+//	roles show <username>
+//	roles assign <username> <role>
+//	roles remove <username> <role>
+package main
+
+import (
+	"chat_backend/generated"
+	"chat_backend/internal/app/repositories"
+	"chat_backend/internal/app/services"
+	"chat_backend/pkg/password"
+	"chat_backend/pkg/utils"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		slog.Warn("read environment", "error", err)
+	}
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	db, queries := utils.Database()
+	defer db.Close()
+
+	timeouts := utils.GetTimeouts()
+	hasher := password.NewHasher()
+	newRepositories := func(queries *generated.Queries) repositories.Repositories {
+		return repositories.Repositories{
+			Auth: repositories.NewAuthRepo(queries, hasher, timeouts),
+			Role: repositories.NewRoleRepo(queries, timeouts),
+		}
+	}
+
+	repos := newRepositories(queries)
+	roles := services.NewRoleService(repos.Role, repositories.NewTxManager(db, newRepositories, utils.GetEnvInt("TX_MAX_ATTEMPTS", 3)))
+	ctx := context.Background()
+
+	userID := func(username string) uuid.UUID {
+		user, err := repos.Auth.GetUserByUsername(ctx, username)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "roles: user", username, "not found")
+			db.Close()
+			os.Exit(1)
+		}
+
+		return user.ID
+	}
+
+	var err error
+	switch command := os.Args[1]; {
+	case command == "list" && len(os.Args) == 2:
+		err = list(ctx, roles)
+	case command == "show" && len(os.Args) == 3:
+		var held []string
+		if held, err = roles.GetUserRoles(ctx, userID(os.Args[2])); err == nil {
+			fmt.Println(strings.Join(held, "\n"))
+		}
+	case command == "assign" && len(os.Args) == 4:
+		_, err = roles.AssignRole(ctx, userID(os.Args[2]), os.Args[3])
+	case command == "remove" && len(os.Args) == 4:
+		var removed bool
+		if removed, err = roles.RemoveRole(ctx, userID(os.Args[2]), os.Args[3]); err == nil && !removed {
+			err = fmt.Errorf("%s does not hold the %s role", os.Args[2], os.Args[3])
+		}
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "roles:", err)
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+func list(ctx context.Context, roles services.RoleService) error {
+	rows, err := roles.ListRoles(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROLE\tPERMISSIONS\tDESCRIPTION")
+	for _, role := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", role.Name, strings.Join(role.Permissions, ","), role.Description)
+	}
+
+	return w.Flush()
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: roles list | show <username> | assign <username> <role> | remove <username> <role>")
+	os.Exit(2)
+}
@@ -9,11 +9,204 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type ApiToken struct {
+	ID         uuid.UUID          `json:"id"`
+	UserID     uuid.UUID          `json:"user_id"`
+	Name       string             `json:"name"`
+	TokenHash  string             `json:"token_hash"`
+	Prefix     string             `json:"prefix"`
+	Scopes     []string           `json:"scopes"`
+	ExpiresAt  pgtype.Timestamptz `json:"expires_at"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+type AuditEvent struct {
+	ID        int64              `json:"id"`
+	Action    string             `json:"action"`
+	ActorID   pgtype.UUID        `json:"actor_id"`
+	TargetID  pgtype.UUID        `json:"target_id"`
+	Ip        string             `json:"ip"`
+	UserAgent string             `json:"user_agent"`
+	RequestID string             `json:"request_id"`
+	Metadata  []byte             `json:"metadata"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type DataExport struct {
+	ID          uuid.UUID          `json:"id"`
+	UserID      uuid.UUID          `json:"user_id"`
+	Status      string             `json:"status"`
+	Archive     []byte             `json:"archive"`
+	StartedAt   pgtype.Timestamptz `json:"started_at"`
+	CompletedAt pgtype.Timestamptz `json:"completed_at"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+}
+
+type LinkedIdentity struct {
+	ID        uuid.UUID          `json:"id"`
+	UserID    uuid.UUID          `json:"user_id"`
+	Provider  string             `json:"provider"`
+	Subject   string             `json:"subject"`
+	Email     pgtype.Text        `json:"email"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type NotificationSetting struct {
+	UserID     uuid.UUID          `json:"user_id"`
+	Level      string             `json:"level"`
+	MutedUntil pgtype.Timestamptz `json:"muted_until"`
+	DndStart   pgtype.Int2        `json:"dnd_start"`
+	DndEnd     pgtype.Int2        `json:"dnd_end"`
+	UpdatedAt  pgtype.Timestamptz `json:"updated_at"`
+}
+
+type Passkey struct {
+	ID              uuid.UUID          `json:"id"`
+	UserID          uuid.UUID          `json:"user_id"`
+	Name            string             `json:"name"`
+	CredentialID    []byte             `json:"credential_id"`
+	PublicKey       []byte             `json:"public_key"`
+	AttestationType string             `json:"attestation_type"`
+	Transports      []string           `json:"transports"`
+	Aaguid          []byte             `json:"aaguid"`
+	SignCount       int64              `json:"sign_count"`
+	BackupEligible  bool               `json:"backup_eligible"`
+	BackupState     bool               `json:"backup_state"`
+	LastUsedAt      pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+}
+
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type PushDelivery struct {
+	ID            uuid.UUID          `json:"id"`
+	DeviceID      uuid.UUID          `json:"device_id"`
+	CollapseKey   string             `json:"collapse_key"`
+	Payload       []byte             `json:"payload"`
+	Count         int32              `json:"count"`
+	Revision      int32              `json:"revision"`
+	Attempts      int32              `json:"attempts"`
+	NextAttemptAt pgtype.Timestamptz `json:"next_attempt_at"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+}
+
+type PushDevice struct {
+	ID          uuid.UUID          `json:"id"`
+	UserID      uuid.UUID          `json:"user_id"`
+	Platform    string             `json:"platform"`
+	Token       string             `json:"token"`
+	SessionHash string             `json:"session_hash"`
+	LastUsedAt  pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+}
+
+type PushSubscription struct {
+	ID         uuid.UUID          `json:"id"`
+	UserID     uuid.UUID          `json:"user_id"`
+	Endpoint   string             `json:"endpoint"`
+	P256dh     string             `json:"p256dh"`
+	Auth       string             `json:"auth"`
+	UserAgent  string             `json:"user_agent"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID          `json:"id"`
+	UserID    uuid.UUID          `json:"user_id"`
+	CodeHash  string             `json:"code_hash"`
+	UsedAt    pgtype.Timestamptz `json:"used_at"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type Role struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type RolePermission struct {
+	Role       string `json:"role"`
+	Permission string `json:"permission"`
+}
+
+type SigningKey struct {
+	Kid         string             `json:"kid"`
+	PrivateKey  []byte             `json:"private_key"`
+	Status      string             `json:"status"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+	ActivatedAt pgtype.Timestamptz `json:"activated_at"`
+	RetiredAt   pgtype.Timestamptz `json:"retired_at"`
+}
+
+type TwoFactorState struct {
+	UserID         uuid.UUID          `json:"user_id"`
+	LastStep       int64              `json:"last_step"`
+	FailedAttempts int32              `json:"failed_attempts"`
+	LastFailedAt   pgtype.Timestamptz `json:"last_failed_at"`
+}
+
+type UsedPreAuthToken struct {
+	TokenHash string             `json:"token_hash"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
 type User struct {
+	ID              uuid.UUID          `json:"id"`
+	Username        string             `json:"username"`
+	Password        string             `json:"password"`
+	Avatar          pgtype.Text        `json:"avatar"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt       pgtype.Timestamptz `json:"updated_at"`
+	Email           pgtype.Text        `json:"email"`
+	EmailVerifiedAt pgtype.Timestamptz `json:"email_verified_at"`
+	TotpSecret      pgtype.Text        `json:"totp_secret"`
+	TotpEnabledAt   pgtype.Timestamptz `json:"totp_enabled_at"`
+	DeletedAt       pgtype.Timestamptz `json:"deleted_at"`
+	PurgeAt         pgtype.Timestamptz `json:"purge_at"`
+	DisplayName     pgtype.Text        `json:"display_name"`
+	Bio             pgtype.Text        `json:"bio"`
+	StatusText      pgtype.Text        `json:"status_text"`
+	StatusEmoji     pgtype.Text        `json:"status_emoji"`
+	StatusExpiresAt pgtype.Timestamptz `json:"status_expires_at"`
+	Pronouns        pgtype.Text        `json:"pronouns"`
+	Timezone        pgtype.Text        `json:"timezone"`
+	Locale          pgtype.Text        `json:"locale"`
+	Avatar32        pgtype.Text        `json:"avatar_32"`
+	Avatar64        pgtype.Text        `json:"avatar_64"`
+	Avatar256       pgtype.Text        `json:"avatar_256"`
+	PasswordSet     bool               `json:"password_set"`
+}
+
+type UserRole struct {
+	UserID    uuid.UUID          `json:"user_id"`
+	Role      string             `json:"role"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type UserToken struct {
 	ID        uuid.UUID          `json:"id"`
-	Username  string             `json:"username"`
-	Password  string             `json:"password"`
-	Avatar    pgtype.Text        `json:"avatar"`
+	UserID    uuid.UUID          `json:"user_id"`
+	Purpose   string             `json:"purpose"`
+	TokenHash string             `json:"token_hash"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+	UsedAt    pgtype.Timestamptz `json:"used_at"`
 	CreatedAt pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt pgtype.Timestamptz `json:"updated_at"`
+}
+
+type UsernameHistory struct {
+	ID         uuid.UUID          `json:"id"`
+	UserID     uuid.UUID          `json:"user_id"`
+	Username   string             `json:"username"`
+	ReleasedAt pgtype.Timestamptz `json:"released_at"`
+}
+
+type WebauthnSession struct {
+	ID          uuid.UUID          `json:"id"`
+	SessionData []byte             `json:"session_data"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
 }
@@ -12,111 +12,2536 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const acceptTOTPStep = `-- name: AcceptTOTPStep :execrows
+insert into two_factor_state (user_id, last_step)
+values ($1, $2)
+on conflict (user_id) do update
+    set last_step = excluded.last_step
+where two_factor_state.last_step < excluded.last_step
+`
+
+type AcceptTOTPStepParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	LastStep int64     `json:"last_step"`
+}
+
+// Records step as the last accepted one unless it is not newer.
+func (q *Queries) AcceptTOTPStep(ctx context.Context, arg AcceptTOTPStepParams) (int64, error) {
+	result, err := q.db.Exec(ctx, acceptTOTPStep, arg.UserID, arg.LastStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const activateSigningKey = `-- name: ActivateSigningKey :execrows
+update signing_keys
+set status       = 'active',
+    activated_at = timezone('utc', now())
+where kid = $1
+  and status = 'pending'
+`
+
+func (q *Queries) ActivateSigningKey(ctx context.Context, kid string) (int64, error) {
+	result, err := q.db.Exec(ctx, activateSigningKey, kid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const assignUserRole = `-- name: AssignUserRole :execrows
+insert into user_roles (user_id, role)
+values ($1, $2)
+on conflict do nothing
+`
+
+type AssignUserRoleParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Role   string    `json:"role"`
+}
+
+func (q *Queries) AssignUserRole(ctx context.Context, arg AssignUserRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, assignUserRole, arg.UserID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const claimDataExport = `-- name: ClaimDataExport :one
+update data_exports
+set status     = 'running',
+    started_at = timezone('utc', now())
+where id = (select id
+            from data_exports
+            where status = 'pending'
+               or (status = 'running' and started_at < $1)
+            order by created_at
+            limit 1 for update skip locked)
+returning id, user_id
+`
+
+type ClaimDataExportRow struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) ClaimDataExport(ctx context.Context, startedAt pgtype.Timestamptz) (ClaimDataExportRow, error) {
+	row := q.db.QueryRow(ctx, claimDataExport, startedAt)
+	var i ClaimDataExportRow
+	err := row.Scan(&i.ID, &i.UserID)
+	return i, err
+}
+
+const claimPushDeliveries = `-- name: ClaimPushDeliveries :many
+update push_deliveries d
+set attempts        = d.attempts + 1,
+    next_attempt_at = $1
+from push_devices p
+where p.id = d.device_id
+  and d.id in (select id
+               from push_deliveries
+               where next_attempt_at <= $2
+               order by next_attempt_at
+               limit $3 for update skip locked)
+returning d.id, d.device_id, d.collapse_key, d.payload, d.count, d.revision, d.attempts, p.platform, p.token
+`
+
+type ClaimPushDeliveriesParams struct {
+	LeaseUntil pgtype.Timestamptz `json:"lease_until"`
+	Now        pgtype.Timestamptz `json:"now"`
+	Batch      int32              `json:"batch"`
+}
+
+type ClaimPushDeliveriesRow struct {
+	ID          uuid.UUID `json:"id"`
+	DeviceID    uuid.UUID `json:"device_id"`
+	CollapseKey string    `json:"collapse_key"`
+	Payload     []byte    `json:"payload"`
+	Count       int32     `json:"count"`
+	Revision    int32     `json:"revision"`
+	Attempts    int32     `json:"attempts"`
+	Platform    string    `json:"platform"`
+	Token       string    `json:"token"`
+}
+
+// Leases the due deliveries until lease_until, when they are due again
+// unless they were completed or rescheduled.
+func (q *Queries) ClaimPushDeliveries(ctx context.Context, arg ClaimPushDeliveriesParams) ([]ClaimPushDeliveriesRow, error) {
+	rows, err := q.db.Query(ctx, claimPushDeliveries, arg.LeaseUntil, arg.Now, arg.Batch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimPushDeliveriesRow
+	for rows.Next() {
+		var i ClaimPushDeliveriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.DeviceID,
+			&i.CollapseKey,
+			&i.Payload,
+			&i.Count,
+			&i.Revision,
+			&i.Attempts,
+			&i.Platform,
+			&i.Token,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const completeDataExport = `-- name: CompleteDataExport :exec
+update data_exports
+set status       = 'ready',
+    archive      = $2,
+    completed_at = timezone('utc', now()),
+    expires_at   = $3
+where id = $1
+`
+
+type CompleteDataExportParams struct {
+	ID        uuid.UUID          `json:"id"`
+	Archive   []byte             `json:"archive"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) CompleteDataExport(ctx context.Context, arg CompleteDataExportParams) error {
+	_, err := q.db.Exec(ctx, completeDataExport, arg.ID, arg.Archive, arg.ExpiresAt)
+	return err
+}
+
+const completePushDelivery = `-- name: CompletePushDelivery :exec
+delete
+from push_deliveries
+where id = $1
+  and revision = $2
+`
+
+type CompletePushDeliveryParams struct {
+	ID       uuid.UUID `json:"id"`
+	Revision int32     `json:"revision"`
+}
+
+func (q *Queries) CompletePushDelivery(ctx context.Context, arg CompletePushDeliveryParams) error {
+	_, err := q.db.Exec(ctx, completePushDelivery, arg.ID, arg.Revision)
+	return err
+}
+
+const consumeUserToken = `-- name: ConsumeUserToken :one
+update user_tokens
+set used_at = timezone('utc', now())
+where token_hash = $1
+  and purpose = $2
+  and used_at is null
+  and expires_at > now()
+returning user_id
+`
+
+type ConsumeUserTokenParams struct {
+	TokenHash string `json:"token_hash"`
+	Purpose   string `json:"purpose"`
+}
+
+func (q *Queries) ConsumeUserToken(ctx context.Context, arg ConsumeUserTokenParams) (uuid.UUID, error) {
+	row := q.db.QueryRow(ctx, consumeUserToken, arg.TokenHash, arg.Purpose)
+	var user_id uuid.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const consumeWebauthnSession = `-- name: ConsumeWebauthnSession :one
+delete
+from webauthn_sessions
+where id = $1
+  and expires_at > now()
+returning session_data
+`
+
+func (q *Queries) ConsumeWebauthnSession(ctx context.Context, id uuid.UUID) ([]byte, error) {
+	row := q.db.QueryRow(ctx, consumeWebauthnSession, id)
+	var session_data []byte
+	err := row.Scan(&session_data)
+	return session_data, err
+}
+
+const countActiveDataExports = `-- name: CountActiveDataExports :one
+select count(*)
+from data_exports
+where user_id = $1
+  and status in ('pending', 'running')
+`
+
+func (q *Queries) CountActiveDataExports(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countActiveDataExports, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countApiTokens = `-- name: CountApiTokens :one
+select count(*)
+from api_tokens
+where user_id = $1
+`
+
+func (q *Queries) CountApiTokens(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countApiTokens, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countLinkedIdentities = `-- name: CountLinkedIdentities :one
+select count(*)
+from linked_identities
+where user_id = $1
+`
+
+func (q *Queries) CountLinkedIdentities(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countLinkedIdentities, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countRoleMembers = `-- name: CountRoleMembers :one
+select count(*)
+from user_roles r
+         join users u on u.id = r.user_id
+where r.role = $1
+  and u.deleted_at is null
+`
+
+func (q *Queries) CountRoleMembers(ctx context.Context, role string) (int64, error) {
+	row := q.db.QueryRow(ctx, countRoleMembers, role)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUsernameChanges = `-- name: CountUsernameChanges :one
+select count(*)
+from username_history
+where user_id = $1
+  and released_at > $2
+`
+
+type CountUsernameChangesParams struct {
+	UserID     uuid.UUID          `json:"user_id"`
+	ReleasedAt pgtype.Timestamptz `json:"released_at"`
+}
+
+func (q *Queries) CountUsernameChanges(ctx context.Context, arg CountUsernameChangesParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countUsernameChanges, arg.UserID, arg.ReleasedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createApiToken = `-- name: CreateApiToken :one
+insert into api_tokens (user_id, name, token_hash, prefix, scopes, expires_at)
+values ($1, $2, $3, $4, $5, $6)
+returning id, created_at
+`
+
+type CreateApiTokenParams struct {
+	UserID    uuid.UUID          `json:"user_id"`
+	Name      string             `json:"name"`
+	TokenHash string             `json:"token_hash"`
+	Prefix    string             `json:"prefix"`
+	Scopes    []string           `json:"scopes"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+type CreateApiTokenRow struct {
+	ID        uuid.UUID          `json:"id"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) CreateApiToken(ctx context.Context, arg CreateApiTokenParams) (CreateApiTokenRow, error) {
+	row := q.db.QueryRow(ctx, createApiToken,
+		arg.UserID,
+		arg.Name,
+		arg.TokenHash,
+		arg.Prefix,
+		arg.Scopes,
+		arg.ExpiresAt,
+	)
+	var i CreateApiTokenRow
+	err := row.Scan(&i.ID, &i.CreatedAt)
+	return i, err
+}
+
+const createAuditEvent = `-- name: CreateAuditEvent :exec
+insert into audit_events (action, actor_id, target_id, ip, user_agent, request_id, metadata)
+values ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type CreateAuditEventParams struct {
+	Action    string      `json:"action"`
+	ActorID   pgtype.UUID `json:"actor_id"`
+	TargetID  pgtype.UUID `json:"target_id"`
+	Ip        string      `json:"ip"`
+	UserAgent string      `json:"user_agent"`
+	RequestID string      `json:"request_id"`
+	Metadata  []byte      `json:"metadata"`
+}
+
+func (q *Queries) CreateAuditEvent(ctx context.Context, arg CreateAuditEventParams) error {
+	_, err := q.db.Exec(ctx, createAuditEvent,
+		arg.Action,
+		arg.ActorID,
+		arg.TargetID,
+		arg.Ip,
+		arg.UserAgent,
+		arg.RequestID,
+		arg.Metadata,
+	)
+	return err
+}
+
+const createDataExport = `-- name: CreateDataExport :one
+insert into data_exports (user_id)
+values ($1)
+returning id, status, created_at
+`
+
+type CreateDataExportRow struct {
+	ID        uuid.UUID          `json:"id"`
+	Status    string             `json:"status"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) CreateDataExport(ctx context.Context, userID uuid.UUID) (CreateDataExportRow, error) {
+	row := q.db.QueryRow(ctx, createDataExport, userID)
+	var i CreateDataExportRow
+	err := row.Scan(&i.ID, &i.Status, &i.CreatedAt)
+	return i, err
+}
+
+const createLinkedIdentity = `-- name: CreateLinkedIdentity :exec
+insert into linked_identities (user_id, provider, subject, email)
+values ($1, $2, $3, $4)
+`
+
+type CreateLinkedIdentityParams struct {
+	UserID   uuid.UUID   `json:"user_id"`
+	Provider string      `json:"provider"`
+	Subject  string      `json:"subject"`
+	Email    pgtype.Text `json:"email"`
+}
+
+func (q *Queries) CreateLinkedIdentity(ctx context.Context, arg CreateLinkedIdentityParams) error {
+	_, err := q.db.Exec(ctx, createLinkedIdentity,
+		arg.UserID,
+		arg.Provider,
+		arg.Subject,
+		arg.Email,
+	)
+	return err
+}
+
 const createNewUser = `-- name: CreateNewUser :exec
-insert into users (username, password, avatar)
-values ($1, $2, $3)
+insert into users (username, password, email, password_set)
+values ($1, $2, $3, $4)
+`
+
+type CreateNewUserParams struct {
+	Username    string      `json:"username"`
+	Password    string      `json:"password"`
+	Email       pgtype.Text `json:"email"`
+	PasswordSet bool        `json:"password_set"`
+}
+
+func (q *Queries) CreateNewUser(ctx context.Context, arg CreateNewUserParams) error {
+	_, err := q.db.Exec(ctx, createNewUser,
+		arg.Username,
+		arg.Password,
+		arg.Email,
+		arg.PasswordSet,
+	)
+	return err
+}
+
+const createPasskey = `-- name: CreatePasskey :exec
+insert into passkeys (user_id, name, credential_id, public_key, attestation_type, transports, aaguid, sign_count,
+                      backup_eligible, backup_state)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`
+
+type CreatePasskeyParams struct {
+	UserID          uuid.UUID `json:"user_id"`
+	Name            string    `json:"name"`
+	CredentialID    []byte    `json:"credential_id"`
+	PublicKey       []byte    `json:"public_key"`
+	AttestationType string    `json:"attestation_type"`
+	Transports      []string  `json:"transports"`
+	Aaguid          []byte    `json:"aaguid"`
+	SignCount       int64     `json:"sign_count"`
+	BackupEligible  bool      `json:"backup_eligible"`
+	BackupState     bool      `json:"backup_state"`
+}
+
+func (q *Queries) CreatePasskey(ctx context.Context, arg CreatePasskeyParams) error {
+	_, err := q.db.Exec(ctx, createPasskey,
+		arg.UserID,
+		arg.Name,
+		arg.CredentialID,
+		arg.PublicKey,
+		arg.AttestationType,
+		arg.Transports,
+		arg.Aaguid,
+		arg.SignCount,
+		arg.BackupEligible,
+		arg.BackupState,
+	)
+	return err
+}
+
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+insert into recovery_codes (user_id, code_hash)
+values ($1, $2)
+`
+
+type CreateRecoveryCodeParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	CodeHash string    `json:"code_hash"`
+}
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	_, err := q.db.Exec(ctx, createRecoveryCode, arg.UserID, arg.CodeHash)
+	return err
+}
+
+const createSigningKey = `-- name: CreateSigningKey :exec
+insert into signing_keys (kid, private_key, status, activated_at)
+values ($1, $2, $3, case when $3 = 'active' then timezone('utc', now()) end)
+on conflict (kid) do nothing
+`
+
+type CreateSigningKeyParams struct {
+	Kid        string `json:"kid"`
+	PrivateKey []byte `json:"private_key"`
+	Status     string `json:"status"`
+}
+
+func (q *Queries) CreateSigningKey(ctx context.Context, arg CreateSigningKeyParams) error {
+	_, err := q.db.Exec(ctx, createSigningKey, arg.Kid, arg.PrivateKey, arg.Status)
+	return err
+}
+
+const createUsernameHistory = `-- name: CreateUsernameHistory :exec
+insert into username_history (user_id, username)
+values ($1, $2)
+`
+
+type CreateUsernameHistoryParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+}
+
+func (q *Queries) CreateUsernameHistory(ctx context.Context, arg CreateUsernameHistoryParams) error {
+	_, err := q.db.Exec(ctx, createUsernameHistory, arg.UserID, arg.Username)
+	return err
+}
+
+const createUserToken = `-- name: CreateUserToken :exec
+insert into user_tokens (user_id, purpose, token_hash, expires_at)
+values ($1, $2, $3, $4)
+`
+
+type CreateUserTokenParams struct {
+	UserID    uuid.UUID          `json:"user_id"`
+	Purpose   string             `json:"purpose"`
+	TokenHash string             `json:"token_hash"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) CreateUserToken(ctx context.Context, arg CreateUserTokenParams) error {
+	_, err := q.db.Exec(ctx, createUserToken,
+		arg.UserID,
+		arg.Purpose,
+		arg.TokenHash,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createWebauthnSession = `-- name: CreateWebauthnSession :one
+insert into webauthn_sessions (session_data, expires_at)
+values ($1, $2)
+returning id
+`
+
+type CreateWebauthnSessionParams struct {
+	SessionData []byte             `json:"session_data"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) CreateWebauthnSession(ctx context.Context, arg CreateWebauthnSessionParams) (uuid.UUID, error) {
+	row := q.db.QueryRow(ctx, createWebauthnSession, arg.SessionData, arg.ExpiresAt)
+	var id uuid.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const deleteApiToken = `-- name: DeleteApiToken :execrows
+delete
+from api_tokens
+where id = $1
+  and user_id = $2
+`
+
+type DeleteApiTokenParams struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) DeleteApiToken(ctx context.Context, arg DeleteApiTokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteApiToken, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredDataExports = `-- name: DeleteExpiredDataExports :execrows
+delete
+from data_exports
+where expires_at <= timezone('utc', now())
+`
+
+func (q *Queries) DeleteExpiredDataExports(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredDataExports)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredWebauthnSessions = `-- name: DeleteExpiredWebauthnSessions :exec
+delete
+from webauthn_sessions
+where expires_at <= now()
+`
+
+func (q *Queries) DeleteExpiredWebauthnSessions(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredWebauthnSessions)
+	return err
+}
+
+const deleteForeignPushDevice = `-- name: DeleteForeignPushDevice :exec
+delete
+from push_devices
+where platform = $1
+  and token = $2
+  and user_id <> $3
+`
+
+type DeleteForeignPushDeviceParams struct {
+	Platform string    `json:"platform"`
+	Token    string    `json:"token"`
+	UserID   uuid.UUID `json:"user_id"`
+}
+
+// Forgets a device token registered by another user, and what was queued
+// for it, before the user registers it.
+func (q *Queries) DeleteForeignPushDevice(ctx context.Context, arg DeleteForeignPushDeviceParams) error {
+	_, err := q.db.Exec(ctx, deleteForeignPushDevice, arg.Platform, arg.Token, arg.UserID)
+	return err
+}
+
+const deleteLinkedIdentity = `-- name: DeleteLinkedIdentity :execrows
+delete
+from linked_identities
+where user_id = $1
+  and provider = $2
+`
+
+type DeleteLinkedIdentityParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Provider string    `json:"provider"`
+}
+
+func (q *Queries) DeleteLinkedIdentity(ctx context.Context, arg DeleteLinkedIdentityParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteLinkedIdentity, arg.UserID, arg.Provider)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deletePasskey = `-- name: DeletePasskey :execrows
+delete
+from passkeys
+where id = $1
+  and user_id = $2
+`
+
+type DeletePasskeyParams struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) DeletePasskey(ctx context.Context, arg DeletePasskeyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deletePasskey, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deletePushDevice = `-- name: DeletePushDevice :execrows
+delete
+from push_devices
+where id = $1
+  and user_id = $2
+`
+
+type DeletePushDeviceParams struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) DeletePushDevice(ctx context.Context, arg DeletePushDeviceParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deletePushDevice, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deletePushDeviceByID = `-- name: DeletePushDeviceByID :exec
+delete
+from push_devices
+where id = $1
+`
+
+func (q *Queries) DeletePushDeviceByID(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deletePushDeviceByID, id)
+	return err
+}
+
+const deletePushDevicesBySession = `-- name: DeletePushDevicesBySession :exec
+delete
+from push_devices
+where session_hash = $1
+`
+
+func (q *Queries) DeletePushDevicesBySession(ctx context.Context, sessionHash string) error {
+	_, err := q.db.Exec(ctx, deletePushDevicesBySession, sessionHash)
+	return err
+}
+
+const deletePushSubscription = `-- name: DeletePushSubscription :execrows
+delete
+from push_subscriptions
+where id = $1
+  and user_id = $2
+`
+
+type DeletePushSubscriptionParams struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) DeletePushSubscription(ctx context.Context, arg DeletePushSubscriptionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deletePushSubscription, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deletePushSubscriptionByEndpoint = `-- name: DeletePushSubscriptionByEndpoint :exec
+delete
+from push_subscriptions
+where endpoint = $1
+`
+
+func (q *Queries) DeletePushSubscriptionByEndpoint(ctx context.Context, endpoint string) error {
+	_, err := q.db.Exec(ctx, deletePushSubscriptionByEndpoint, endpoint)
+	return err
+}
+
+const deleteRecoveryCodes = `-- name: DeleteRecoveryCodes :exec
+delete
+from recovery_codes
+where user_id = $1
+`
+
+func (q *Queries) DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteRecoveryCodes, userID)
+	return err
+}
+
+const deleteUserByUsername = `-- name: DeleteUserByUsername :exec
+delete
+from users
+where username = $1
+`
+
+func (q *Queries) DeleteUserByUsername(ctx context.Context, username string) error {
+	_, err := q.db.Exec(ctx, deleteUserByUsername, username)
+	return err
+}
+
+const deleteUserPersonalData = `-- name: DeleteUserPersonalData :exec
+with tokens as (delete from user_tokens where user_id = $1),
+     codes as (delete from recovery_codes where user_id = $1),
+     keys as (delete from passkeys where user_id = $1),
+     identities as (delete from linked_identities where user_id = $1),
+     api_tokens as (delete from api_tokens where user_id = $1),
+     exports as (delete from data_exports where user_id = $1),
+     usernames as (delete from username_history where user_id = $1),
+     notifications as (delete from notification_settings where user_id = $1),
+     push as (delete from push_subscriptions where user_id = $1),
+     devices as (delete from push_devices where user_id = $1),
+     factors as (delete from two_factor_state where user_id = $1)
+delete
+from user_roles
+where user_id = $1
+`
+
+func (q *Queries) DeleteUserPersonalData(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteUserPersonalData, userID)
+	return err
+}
+
+const deleteUserTokens = `-- name: DeleteUserTokens :exec
+delete
+from user_tokens
+where user_id = $1
+  and purpose = $2
+`
+
+type DeleteUserTokensParams struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Purpose string    `json:"purpose"`
+}
+
+func (q *Queries) DeleteUserTokens(ctx context.Context, arg DeleteUserTokensParams) error {
+	_, err := q.db.Exec(ctx, deleteUserTokens, arg.UserID, arg.Purpose)
+	return err
+}
+
+const enableUserTOTP = `-- name: EnableUserTOTP :exec
+update users
+set totp_enabled_at = timezone('utc', now()),
+    updated_at      = timezone('utc', now())
+where id = $1
+  and totp_secret is not null
+`
+
+func (q *Queries) EnableUserTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, enableUserTOTP, id)
+	return err
+}
+
+const enqueuePushDeliveries = `-- name: EnqueuePushDeliveries :execrows
+insert into push_deliveries (device_id, collapse_key, payload, next_attempt_at)
+select id, $1::varchar, $2::jsonb, $3::timestamptz
+from push_devices
+where user_id = $4
+on conflict (device_id, collapse_key) do update
+    set payload         = excluded.payload,
+        count           = push_deliveries.count + 1,
+        revision        = push_deliveries.revision + 1,
+        attempts        = 0,
+        next_attempt_at = excluded.next_attempt_at
+`
+
+type EnqueuePushDeliveriesParams struct {
+	CollapseKey string             `json:"collapse_key"`
+	Payload     []byte             `json:"payload"`
+	Now         pgtype.Timestamptz `json:"now"`
+	UserID      uuid.UUID          `json:"user_id"`
+}
+
+// Queues payload for every device of the user, merging it into a waiting
+// delivery with the same collapse key.
+func (q *Queries) EnqueuePushDeliveries(ctx context.Context, arg EnqueuePushDeliveriesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, enqueuePushDeliveries,
+		arg.CollapseKey,
+		arg.Payload,
+		arg.Now,
+		arg.UserID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const failDataExport = `-- name: FailDataExport :exec
+update data_exports
+set status       = 'failed',
+    completed_at = timezone('utc', now()),
+    expires_at   = $2
+where id = $1
+`
+
+type FailDataExportParams struct {
+	ID        uuid.UUID          `json:"id"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) FailDataExport(ctx context.Context, arg FailDataExportParams) error {
+	_, err := q.db.Exec(ctx, failDataExport, arg.ID, arg.ExpiresAt)
+	return err
+}
+
+const getApiTokenByHash = `-- name: GetApiTokenByHash :one
+select id, user_id, scopes
+from api_tokens
+where token_hash = $1
+  and (expires_at is null or expires_at > timezone('utc', now()))
+  and user_id in (select id from users where deleted_at is null)
+`
+
+type GetApiTokenByHashRow struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	Scopes []string  `json:"scopes"`
+}
+
+func (q *Queries) GetApiTokenByHash(ctx context.Context, tokenHash string) (GetApiTokenByHashRow, error) {
+	row := q.db.QueryRow(ctx, getApiTokenByHash, tokenHash)
+	var i GetApiTokenByHashRow
+	err := row.Scan(&i.ID, &i.UserID, &i.Scopes)
+	return i, err
+}
+
+const getDataExportArchive = `-- name: GetDataExportArchive :one
+select archive
+from data_exports
+where id = $1
+  and user_id = $2
+  and status = 'ready'
+  and expires_at > timezone('utc', now())
+`
+
+type GetDataExportArchiveParams struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) GetDataExportArchive(ctx context.Context, arg GetDataExportArchiveParams) ([]byte, error) {
+	row := q.db.QueryRow(ctx, getDataExportArchive, arg.ID, arg.UserID)
+	var archive []byte
+	err := row.Scan(&archive)
+	return archive, err
+}
+
+const getLinkedIdentity = `-- name: GetLinkedIdentity :one
+select id, user_id, provider, subject, email, created_at
+from linked_identities
+where provider = $1
+  and subject = $2
+`
+
+type GetLinkedIdentityParams struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+}
+
+func (q *Queries) GetLinkedIdentity(ctx context.Context, arg GetLinkedIdentityParams) (LinkedIdentity, error) {
+	row := q.db.QueryRow(ctx, getLinkedIdentity, arg.Provider, arg.Subject)
+	var i LinkedIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Provider,
+		&i.Subject,
+		&i.Email,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getNotificationSettings = `-- name: GetNotificationSettings :one
+select coalesce(s.level, 'all')::text as level,
+       s.muted_until,
+       s.dnd_start,
+       s.dnd_end,
+       u.timezone
+from users u
+         left join notification_settings s on s.user_id = u.id
+where u.id = $1
+`
+
+type GetNotificationSettingsRow struct {
+	Level      string             `json:"level"`
+	MutedUntil pgtype.Timestamptz `json:"muted_until"`
+	DndStart   pgtype.Int2        `json:"dnd_start"`
+	DndEnd     pgtype.Int2        `json:"dnd_end"`
+	Timezone   pgtype.Text        `json:"timezone"`
+}
+
+func (q *Queries) GetNotificationSettings(ctx context.Context, id uuid.UUID) (GetNotificationSettingsRow, error) {
+	row := q.db.QueryRow(ctx, getNotificationSettings, id)
+	var i GetNotificationSettingsRow
+	err := row.Scan(
+		&i.Level,
+		&i.MutedUntil,
+		&i.DndStart,
+		&i.DndEnd,
+		&i.Timezone,
+	)
+	return i, err
+}
+
+const getPasskeysByUserID = `-- name: GetPasskeysByUserID :many
+select id, user_id, name, credential_id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, last_used_at, created_at
+from passkeys
+where user_id = $1
+order by created_at
+`
+
+func (q *Queries) GetPasskeysByUserID(ctx context.Context, userID uuid.UUID) ([]Passkey, error) {
+	rows, err := q.db.Query(ctx, getPasskeysByUserID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Passkey
+	for rows.Next() {
+		var i Passkey
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.CredentialID,
+			&i.PublicKey,
+			&i.AttestationType,
+			&i.Transports,
+			&i.Aaguid,
+			&i.SignCount,
+			&i.BackupEligible,
+			&i.BackupState,
+			&i.LastUsedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPublicUserByUsername = `-- name: GetPublicUserByUsername :one
+select id,
+       username,
+       avatar,
+       avatar_32,
+       avatar_64,
+       avatar_256,
+       created_at,
+       display_name,
+       bio,
+       case when status_expires_at is null or status_expires_at > now() then status_text end       as status_text,
+       case when status_expires_at is null or status_expires_at > now() then status_emoji end      as status_emoji,
+       case when status_expires_at is null or status_expires_at > now() then status_expires_at end as status_expires_at,
+       pronouns,
+       timezone
+from users
+where lower(username) = lower($1)
+  and deleted_at is null
+`
+
+type GetPublicUserByUsernameRow struct {
+	ID              uuid.UUID          `json:"id"`
+	Username        string             `json:"username"`
+	Avatar          pgtype.Text        `json:"avatar"`
+	Avatar32        pgtype.Text        `json:"avatar_32"`
+	Avatar64        pgtype.Text        `json:"avatar_64"`
+	Avatar256       pgtype.Text        `json:"avatar_256"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	DisplayName     pgtype.Text        `json:"display_name"`
+	Bio             pgtype.Text        `json:"bio"`
+	StatusText      pgtype.Text        `json:"status_text"`
+	StatusEmoji     pgtype.Text        `json:"status_emoji"`
+	StatusExpiresAt pgtype.Timestamptz `json:"status_expires_at"`
+	Pronouns        pgtype.Text        `json:"pronouns"`
+	Timezone        pgtype.Text        `json:"timezone"`
+}
+
+func (q *Queries) GetPublicUserByUsername(ctx context.Context, lower string) (GetPublicUserByUsernameRow, error) {
+	row := q.db.QueryRow(ctx, getPublicUserByUsername, lower)
+	var i GetPublicUserByUsernameRow
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Avatar,
+		&i.Avatar32,
+		&i.Avatar64,
+		&i.Avatar256,
+		&i.CreatedAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.StatusText,
+		&i.StatusEmoji,
+		&i.StatusExpiresAt,
+		&i.Pronouns,
+		&i.Timezone,
+	)
+	return i, err
+}
+
+const getRecoveryCodes = `-- name: GetRecoveryCodes :many
+select id, code_hash
+from recovery_codes
+where user_id = $1
+  and used_at is null
+`
+
+type GetRecoveryCodesRow struct {
+	ID       uuid.UUID `json:"id"`
+	CodeHash string    `json:"code_hash"`
+}
+
+func (q *Queries) GetRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]GetRecoveryCodesRow, error) {
+	rows, err := q.db.Query(ctx, getRecoveryCodes, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecoveryCodesRow
+	for rows.Next() {
+		var i GetRecoveryCodesRow
+		if err := rows.Scan(&i.ID, &i.CodeHash); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTwoFactorState = `-- name: GetTwoFactorState :one
+select last_step, failed_attempts, last_failed_at
+from two_factor_state
+where user_id = $1
+`
+
+type GetTwoFactorStateRow struct {
+	LastStep       int64              `json:"last_step"`
+	FailedAttempts int32              `json:"failed_attempts"`
+	LastFailedAt   pgtype.Timestamptz `json:"last_failed_at"`
+}
+
+func (q *Queries) GetTwoFactorState(ctx context.Context, userID uuid.UUID) (GetTwoFactorStateRow, error) {
+	row := q.db.QueryRow(ctx, getTwoFactorState, userID)
+	var i GetTwoFactorStateRow
+	err := row.Scan(
+		&i.LastStep,
+		&i.FailedAttempts,
+		&i.LastFailedAt,
+	)
+	return i, err
+}
+
+const getUserAccountByID = `-- name: GetUserAccountByID :one
+select id, username, password, avatar, created_at, updated_at, email, email_verified_at, totp_secret, totp_enabled_at, deleted_at, purge_at, display_name, bio, status_text, status_emoji, status_expires_at, pronouns, timezone, locale, avatar_32, avatar_64, avatar_256, password_set
+from users
+where id = $1
+`
+
+func (q *Queries) GetUserAccountByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, getUserAccountByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Password,
+		&i.Avatar,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.DeletedAt,
+		&i.PurgeAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.StatusText,
+		&i.StatusEmoji,
+		&i.StatusExpiresAt,
+		&i.Pronouns,
+		&i.Timezone,
+		&i.Locale,
+		&i.Avatar32,
+		&i.Avatar64,
+		&i.Avatar256,
+		&i.PasswordSet,
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+select id, username, password, avatar, created_at, updated_at, email, email_verified_at, totp_secret, totp_enabled_at, deleted_at, purge_at, display_name, bio, status_text, status_emoji, status_expires_at, pronouns, timezone, locale, avatar_32, avatar_64, avatar_256, password_set
+from users
+where email = $1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email pgtype.Text) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Password,
+		&i.Avatar,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.DeletedAt,
+		&i.PurgeAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.StatusText,
+		&i.StatusEmoji,
+		&i.StatusExpiresAt,
+		&i.Pronouns,
+		&i.Timezone,
+		&i.Locale,
+		&i.Avatar32,
+		&i.Avatar64,
+		&i.Avatar256,
+		&i.PasswordSet,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+select username,
+       avatar,
+       avatar_32,
+       avatar_64,
+       avatar_256,
+       created_at,
+       updated_at,
+       email,
+       email_verified_at,
+       display_name,
+       bio,
+       case when status_expires_at is null or status_expires_at > now() then status_text end       as status_text,
+       case when status_expires_at is null or status_expires_at > now() then status_emoji end      as status_emoji,
+       case when status_expires_at is null or status_expires_at > now() then status_expires_at end as status_expires_at,
+       pronouns,
+       timezone,
+       locale
+from users
+where id = $1
+`
+
+type GetUserByIDRow struct {
+	Username        string             `json:"username"`
+	Avatar          pgtype.Text        `json:"avatar"`
+	Avatar32        pgtype.Text        `json:"avatar_32"`
+	Avatar64        pgtype.Text        `json:"avatar_64"`
+	Avatar256       pgtype.Text        `json:"avatar_256"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt       pgtype.Timestamptz `json:"updated_at"`
+	Email           pgtype.Text        `json:"email"`
+	EmailVerifiedAt pgtype.Timestamptz `json:"email_verified_at"`
+	DisplayName     pgtype.Text        `json:"display_name"`
+	Bio             pgtype.Text        `json:"bio"`
+	StatusText      pgtype.Text        `json:"status_text"`
+	StatusEmoji     pgtype.Text        `json:"status_emoji"`
+	StatusExpiresAt pgtype.Timestamptz `json:"status_expires_at"`
+	Pronouns        pgtype.Text        `json:"pronouns"`
+	Timezone        pgtype.Text        `json:"timezone"`
+	Locale          pgtype.Text        `json:"locale"`
+}
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (GetUserByIDRow, error) {
+	row := q.db.QueryRow(ctx, getUserByID, id)
+	var i GetUserByIDRow
+	err := row.Scan(
+		&i.Username,
+		&i.Avatar,
+		&i.Avatar32,
+		&i.Avatar64,
+		&i.Avatar256,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.EmailVerifiedAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.StatusText,
+		&i.StatusEmoji,
+		&i.StatusExpiresAt,
+		&i.Pronouns,
+		&i.Timezone,
+		&i.Locale,
+	)
+	return i, err
+}
+
+const getUserByUsername = `-- name: GetUserByUsername :one
+select id, username, password, avatar, created_at, updated_at, email, email_verified_at, totp_secret, totp_enabled_at, deleted_at, purge_at, display_name, bio, status_text, status_emoji, status_expires_at, pronouns, timezone, locale, avatar_32, avatar_64, avatar_256, password_set
+from users
+where lower(username) = lower($1)
+`
+
+func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByUsername, username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Password,
+		&i.Avatar,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.DeletedAt,
+		&i.PurgeAt,
+		&i.DisplayName,
+		&i.Bio,
+		&i.StatusText,
+		&i.StatusEmoji,
+		&i.StatusExpiresAt,
+		&i.Pronouns,
+		&i.Timezone,
+		&i.Locale,
+		&i.Avatar32,
+		&i.Avatar64,
+		&i.Avatar256,
+		&i.PasswordSet,
+	)
+	return i, err
+}
+
+const getUsernameHolder = `-- name: GetUsernameHolder :one
+select user_id
+from username_history
+where lower(username) = lower($1)
+  and released_at > $2
+order by released_at desc
+limit 1
+`
+
+type GetUsernameHolderParams struct {
+	Username      string             `json:"username"`
+	ReleasedAfter pgtype.Timestamptz `json:"released_after"`
+}
+
+func (q *Queries) GetUsernameHolder(ctx context.Context, arg GetUsernameHolderParams) (uuid.UUID, error) {
+	row := q.db.QueryRow(ctx, getUsernameHolder, arg.Username, arg.ReleasedAfter)
+	var user_id uuid.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const getUserPermissions = `-- name: GetUserPermissions :many
+select distinct permission
+from role_permissions
+where role = 'user'
+   or role in (select role from user_roles where user_id = $1)
+order by permission
+`
+
+func (q *Queries) GetUserPermissions(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	rows, err := q.db.Query(ctx, getUserPermissions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		items = append(items, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserRoles = `-- name: GetUserRoles :many
+select role
+from user_roles
+where user_id = $1
+order by role
+`
+
+func (q *Queries) GetUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	rows, err := q.db.Query(ctx, getUserRoles, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		items = append(items, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isUserActive = `-- name: IsUserActive :one
+select exists(select 1
+              from users
+              where id = $1
+                and deleted_at is null)
+`
+
+func (q *Queries) IsUserActive(ctx context.Context, id uuid.UUID) (bool, error) {
+	row := q.db.QueryRow(ctx, isUserActive, id)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listApiTokens = `-- name: ListApiTokens :many
+select id, name, prefix, scopes, expires_at, last_used_at, created_at
+from api_tokens
+where user_id = $1
+order by created_at
+`
+
+type ListApiTokensRow struct {
+	ID         uuid.UUID          `json:"id"`
+	Name       string             `json:"name"`
+	Prefix     string             `json:"prefix"`
+	Scopes     []string           `json:"scopes"`
+	ExpiresAt  pgtype.Timestamptz `json:"expires_at"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) ListApiTokens(ctx context.Context, userID uuid.UUID) ([]ListApiTokensRow, error) {
+	rows, err := q.db.Query(ctx, listApiTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListApiTokensRow
+	for rows.Next() {
+		var i ListApiTokensRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Prefix,
+			&i.Scopes,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAuditEvents = `-- name: ListAuditEvents :many
+select id, action, actor_id, target_id, ip, user_agent, request_id, metadata, created_at
+from audit_events
+where ($1::varchar is null or action = $1)
+  and ($2::uuid is null or actor_id = $2)
+  and ($3::uuid is null or target_id = $3)
+  and ($4::timestamptz is null or created_at >= $4)
+  and ($5::timestamptz is null or created_at < $5)
+  and ($6::bigint is null or id < $6)
+order by id desc
+limit $7
+`
+
+type ListAuditEventsParams struct {
+	Action   pgtype.Text        `json:"action"`
+	ActorID  pgtype.UUID        `json:"actor_id"`
+	TargetID pgtype.UUID        `json:"target_id"`
+	Since    pgtype.Timestamptz `json:"since"`
+	Until    pgtype.Timestamptz `json:"until"`
+	Before   pgtype.Int8        `json:"before"`
+	Limit    int32              `json:"limit"`
+}
+
+func (q *Queries) ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditEvent, error) {
+	rows, err := q.db.Query(ctx, listAuditEvents,
+		arg.Action,
+		arg.ActorID,
+		arg.TargetID,
+		arg.Since,
+		arg.Until,
+		arg.Before,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.Action,
+			&i.ActorID,
+			&i.TargetID,
+			&i.Ip,
+			&i.UserAgent,
+			&i.RequestID,
+			&i.Metadata,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDataExports = `-- name: ListDataExports :many
+select id, status, created_at, completed_at, expires_at
+from data_exports
+where user_id = $1
+  and (expires_at is null or expires_at > timezone('utc', now()))
+order by created_at desc
+`
+
+type ListDataExportsRow struct {
+	ID          uuid.UUID          `json:"id"`
+	Status      string             `json:"status"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+	CompletedAt pgtype.Timestamptz `json:"completed_at"`
+	ExpiresAt   pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) ListDataExports(ctx context.Context, userID uuid.UUID) ([]ListDataExportsRow, error) {
+	rows, err := q.db.Query(ctx, listDataExports, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListDataExportsRow
+	for rows.Next() {
+		var i ListDataExportsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Status,
+			&i.CreatedAt,
+			&i.CompletedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLinkedIdentities = `-- name: ListLinkedIdentities :many
+select provider, email, created_at
+from linked_identities
+where user_id = $1
+order by created_at
+`
+
+type ListLinkedIdentitiesRow struct {
+	Provider  string             `json:"provider"`
+	Email     pgtype.Text        `json:"email"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) ListLinkedIdentities(ctx context.Context, userID uuid.UUID) ([]ListLinkedIdentitiesRow, error) {
+	rows, err := q.db.Query(ctx, listLinkedIdentities, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListLinkedIdentitiesRow
+	for rows.Next() {
+		var i ListLinkedIdentitiesRow
+		if err := rows.Scan(&i.Provider, &i.Email, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPasskeys = `-- name: ListPasskeys :many
+select id, name, last_used_at, created_at
+from passkeys
+where user_id = $1
+order by created_at
+`
+
+type ListPasskeysRow struct {
+	ID         uuid.UUID          `json:"id"`
+	Name       string             `json:"name"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) ListPasskeys(ctx context.Context, userID uuid.UUID) ([]ListPasskeysRow, error) {
+	rows, err := q.db.Query(ctx, listPasskeys, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListPasskeysRow
+	for rows.Next() {
+		var i ListPasskeysRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.LastUsedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPushDevices = `-- name: ListPushDevices :many
+select id, platform, last_used_at, created_at
+from push_devices
+where user_id = $1
+order by created_at
 `
 
-type CreateNewUserParams struct {
-	Username string      `json:"username"`
-	Password string      `json:"password"`
-	Avatar   pgtype.Text `json:"avatar"`
+type ListPushDevicesRow struct {
+	ID         uuid.UUID          `json:"id"`
+	Platform   string             `json:"platform"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
 }
 
-func (q *Queries) CreateNewUser(ctx context.Context, arg CreateNewUserParams) error {
-	_, err := q.db.Exec(ctx, createNewUser, arg.Username, arg.Password, arg.Avatar)
+func (q *Queries) ListPushDevices(ctx context.Context, userID uuid.UUID) ([]ListPushDevicesRow, error) {
+	rows, err := q.db.Query(ctx, listPushDevices, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListPushDevicesRow
+	for rows.Next() {
+		var i ListPushDevicesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Platform,
+			&i.LastUsedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPushSubscriptions = `-- name: ListPushSubscriptions :many
+select id, endpoint, user_agent, last_used_at, created_at
+from push_subscriptions
+where user_id = $1
+order by created_at
+`
+
+type ListPushSubscriptionsRow struct {
+	ID         uuid.UUID          `json:"id"`
+	Endpoint   string             `json:"endpoint"`
+	UserAgent  string             `json:"user_agent"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) ListPushSubscriptions(ctx context.Context, userID uuid.UUID) ([]ListPushSubscriptionsRow, error) {
+	rows, err := q.db.Query(ctx, listPushSubscriptions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListPushSubscriptionsRow
+	for rows.Next() {
+		var i ListPushSubscriptionsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Endpoint,
+			&i.UserAgent,
+			&i.LastUsedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPushTargets = `-- name: ListPushTargets :many
+select id, endpoint, p256dh, auth
+from push_subscriptions
+where user_id = $1
+`
+
+type ListPushTargetsRow struct {
+	ID       uuid.UUID `json:"id"`
+	Endpoint string    `json:"endpoint"`
+	P256dh   string    `json:"p256dh"`
+	Auth     string    `json:"auth"`
+}
+
+func (q *Queries) ListPushTargets(ctx context.Context, userID uuid.UUID) ([]ListPushTargetsRow, error) {
+	rows, err := q.db.Query(ctx, listPushTargets, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListPushTargetsRow
+	for rows.Next() {
+		var i ListPushTargetsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Endpoint,
+			&i.P256dh,
+			&i.Auth,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRoles = `-- name: ListRoles :many
+select r.name,
+       r.description,
+       coalesce(array_agg(rp.permission order by rp.permission) filter (where rp.permission is not null),
+                '{}')::text[] as permissions
+from roles r
+         left join role_permissions rp on rp.role = r.name
+group by r.name
+order by r.name
+`
+
+type ListRolesRow struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func (q *Queries) ListRoles(ctx context.Context) ([]ListRolesRow, error) {
+	rows, err := q.db.Query(ctx, listRoles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRolesRow
+	for rows.Next() {
+		var i ListRolesRow
+		if err := rows.Scan(&i.Name, &i.Description, &i.Permissions); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSigningKeys = `-- name: ListSigningKeys :many
+select kid, private_key, status, created_at, activated_at, retired_at
+from signing_keys
+order by created_at
+`
+
+func (q *Queries) ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	rows, err := q.db.Query(ctx, listSigningKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SigningKey
+	for rows.Next() {
+		var i SigningKey
+		if err := rows.Scan(
+			&i.Kid,
+			&i.PrivateKey,
+			&i.Status,
+			&i.CreatedAt,
+			&i.ActivatedAt,
+			&i.RetiredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserAuditEvents = `-- name: ListUserAuditEvents :many
+select id,
+       action,
+       actor_id,
+       target_id,
+       case when actor_id is null or actor_id = $1 then ip else '' end::varchar as ip,
+       case when actor_id is null or actor_id = $1 then user_agent else '' end::varchar as user_agent,
+       request_id,
+       metadata,
+       created_at
+from audit_events
+where (actor_id = $1 or target_id = $1)
+  and ($2::bigint is null or id < $2)
+order by id desc
+limit $3
+`
+
+type ListUserAuditEventsParams struct {
+	UserID pgtype.UUID `json:"user_id"`
+	Before pgtype.Int8 `json:"before"`
+	Limit  int32       `json:"limit"`
+}
+
+// Another user's address and user agent are left out of the events they
+// caused on the account, such as an admin changing its roles.
+func (q *Queries) ListUserAuditEvents(ctx context.Context, arg ListUserAuditEventsParams) ([]AuditEvent, error) {
+	rows, err := q.db.Query(ctx, listUserAuditEvents, arg.UserID, arg.Before, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.Action,
+			&i.ActorID,
+			&i.TargetID,
+			&i.Ip,
+			&i.UserAgent,
+			&i.RequestID,
+			&i.Metadata,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsernameHistory = `-- name: ListUsernameHistory :many
+select username, released_at
+from username_history
+where user_id = $1
+order by released_at desc
+`
+
+type ListUsernameHistoryRow struct {
+	Username   string             `json:"username"`
+	ReleasedAt pgtype.Timestamptz `json:"released_at"`
+}
+
+func (q *Queries) ListUsernameHistory(ctx context.Context, userID uuid.UUID) ([]ListUsernameHistoryRow, error) {
+	rows, err := q.db.Query(ctx, listUsernameHistory, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsernameHistoryRow
+	for rows.Next() {
+		var i ListUsernameHistoryRow
+		if err := rows.Scan(&i.Username, &i.ReleasedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersToPurge = `-- name: ListUsersToPurge :many
+select id
+from users
+where purge_at <= timezone('utc', now())
+order by purge_at
+limit $1
+`
+
+func (q *Queries) ListUsersToPurge(ctx context.Context, limit int32) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, listUsersToPurge, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const purgeUser = `-- name: PurgeUser :execrows
+update users
+set username          = 'deleted_' || left(replace(id::text, '-', ''), 22),
+    password          = '',
+    password_set      = false,
+    avatar            = null,
+    avatar_32         = null,
+    avatar_64         = null,
+    avatar_256        = null,
+    email             = null,
+    email_verified_at = null,
+    totp_secret       = null,
+    totp_enabled_at   = null,
+    display_name      = null,
+    bio               = null,
+    status_text       = null,
+    status_emoji      = null,
+    status_expires_at = null,
+    pronouns          = null,
+    timezone          = null,
+    locale            = null,
+    purge_at          = null,
+    updated_at        = timezone('utc', now())
+where id = $1
+  and purge_at <= timezone('utc', now())
+`
+
+func (q *Queries) PurgeUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const recordTwoFactorFailure = `-- name: RecordTwoFactorFailure :exec
+insert into two_factor_state (user_id, failed_attempts, last_failed_at)
+values ($1, 1, $2)
+on conflict (user_id) do update
+    set failed_attempts = case
+                              when two_factor_state.last_failed_at > $3::timestamptz
+                                  then two_factor_state.failed_attempts + 1
+                              else 1 end,
+        last_failed_at  = excluded.last_failed_at
+`
+
+type RecordTwoFactorFailureParams struct {
+	UserID      uuid.UUID          `json:"user_id"`
+	Now         pgtype.Timestamptz `json:"now"`
+	WindowStart pgtype.Timestamptz `json:"window_start"`
+}
+
+// Counts a failed attempt, starting over when the previous one was before
+// window_start.
+func (q *Queries) RecordTwoFactorFailure(ctx context.Context, arg RecordTwoFactorFailureParams) error {
+	_, err := q.db.Exec(ctx, recordTwoFactorFailure, arg.UserID, arg.Now, arg.WindowStart)
 	return err
 }
 
-const deleteUser = `-- name: DeleteUser :exec
+const rehashUserPassword = `-- name: RehashUserPassword :execrows
+update users
+set password = $3
+where id = $1
+  and password = $2
+`
+
+type RehashUserPasswordParams struct {
+	ID         uuid.UUID `json:"id"`
+	Password   string    `json:"password"`
+	Password_2 string    `json:"password_2"`
+}
+
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error) {
+	result, err := q.db.Exec(ctx, rehashUserPassword, arg.ID, arg.Password, arg.Password_2)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const removeUserRole = `-- name: RemoveUserRole :execrows
 delete
-from users
+from user_roles
+where user_id = $1
+  and role = $2
+`
+
+type RemoveUserRoleParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Role   string    `json:"role"`
+}
+
+func (q *Queries) RemoveUserRole(ctx context.Context, arg RemoveUserRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, removeUserRole, arg.UserID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const resetTwoFactorFailures = `-- name: ResetTwoFactorFailures :exec
+update two_factor_state
+set failed_attempts = 0,
+    last_failed_at  = null
+where user_id = $1
+`
+
+func (q *Queries) ResetTwoFactorFailures(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, resetTwoFactorFailures, userID)
+	return err
+}
+
+const resolveUsername = `-- name: ResolveUsername :one
+select u.username
+from username_history h
+         join users u on u.id = h.user_id
+where lower(h.username) = lower($1)
+  and u.deleted_at is null
+order by h.released_at desc
+limit 1
+`
+
+func (q *Queries) ResolveUsername(ctx context.Context, lower string) (string, error) {
+	row := q.db.QueryRow(ctx, resolveUsername, lower)
+	var username string
+	err := row.Scan(&username)
+	return username, err
+}
+
+const restoreUser = `-- name: RestoreUser :execrows
+update users
+set deleted_at = null,
+    purge_at   = null,
+    updated_at = timezone('utc', now())
+where id = $1
+  and purge_at is not null
+`
+
+func (q *Queries) RestoreUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, restoreUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const retireSigningKey = `-- name: RetireSigningKey :execrows
+update signing_keys
+set status     = 'retired',
+    retired_at = timezone('utc', now())
+where kid = $1
+  and status <> 'retired'
+`
+
+func (q *Queries) RetireSigningKey(ctx context.Context, kid string) (int64, error) {
+	result, err := q.db.Exec(ctx, retireSigningKey, kid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const retryPushDelivery = `-- name: RetryPushDelivery :exec
+update push_deliveries
+set next_attempt_at = $3
 where id = $1
+  and revision = $2
 `
 
-func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.Exec(ctx, deleteUser, id)
+type RetryPushDeliveryParams struct {
+	ID            uuid.UUID          `json:"id"`
+	Revision      int32              `json:"revision"`
+	NextAttemptAt pgtype.Timestamptz `json:"next_attempt_at"`
+}
+
+func (q *Queries) RetryPushDelivery(ctx context.Context, arg RetryPushDeliveryParams) error {
+	_, err := q.db.Exec(ctx, retryPushDelivery, arg.ID, arg.Revision, arg.NextAttemptAt)
 	return err
 }
 
-const deleteUserByUsername = `-- name: DeleteUserByUsername :exec
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :execrows
+update users
+set deleted_at = timezone('utc', now()),
+    purge_at   = $2,
+    updated_at = timezone('utc', now())
+where id = $1
+  and deleted_at is null
+`
+
+type ScheduleUserDeletionParams struct {
+	ID      uuid.UUID          `json:"id"`
+	PurgeAt pgtype.Timestamptz `json:"purge_at"`
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) (int64, error) {
+	result, err := q.db.Exec(ctx, scheduleUserDeletion, arg.ID, arg.PurgeAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const scrubUserAuditEvents = `-- name: ScrubUserAuditEvents :exec
+update audit_events
+set ip         = '',
+    user_agent = ''
+where (actor_id = $1::uuid or (actor_id is null and target_id = $1::uuid))
+  and (ip <> '' or user_agent <> '')
+`
+
+// Clears the address and user agent of the events the account caused,
+// counting the anonymous ones against it such as failed sign ins.
+func (q *Queries) ScrubUserAuditEvents(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, scrubUserAuditEvents, userID)
+	return err
+}
+
+const touchApiToken = `-- name: TouchApiToken :exec
+update api_tokens
+set last_used_at = timezone('utc', now())
+where id = $1
+  and (last_used_at is null or last_used_at < timezone('utc', now()) - interval '1 minute')
+`
+
+func (q *Queries) TouchApiToken(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, touchApiToken, id)
+	return err
+}
+
+const touchPushDevice = `-- name: TouchPushDevice :exec
+update push_devices
+set last_used_at = timezone('utc', now())
+where id = $1
+`
+
+func (q *Queries) TouchPushDevice(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, touchPushDevice, id)
+	return err
+}
+
+const touchPushSubscription = `-- name: TouchPushSubscription :exec
+update push_subscriptions
+set last_used_at = timezone('utc', now())
+where id = $1
+`
+
+func (q *Queries) TouchPushSubscription(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, touchPushSubscription, id)
+	return err
+}
+
+const trimPushDevices = `-- name: TrimPushDevices :exec
 delete
-from users
-where username = $1
+from push_devices
+where user_id = $1
+  and id not in (select id
+                 from push_devices
+                 where user_id = $1
+                 order by created_at desc
+                 limit $2)
 `
 
-func (q *Queries) DeleteUserByUsername(ctx context.Context, username string) error {
-	_, err := q.db.Exec(ctx, deleteUserByUsername, username)
+type TrimPushDevicesParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Keep   int32     `json:"keep"`
+}
+
+// Deletes all but the newest devices of the user.
+func (q *Queries) TrimPushDevices(ctx context.Context, arg TrimPushDevicesParams) error {
+	_, err := q.db.Exec(ctx, trimPushDevices, arg.UserID, arg.Keep)
 	return err
 }
 
-const getUserByID = `-- name: GetUserByID :one
-select username, avatar, created_at, updated_at
-from users
+const trimPushSubscriptions = `-- name: TrimPushSubscriptions :exec
+delete
+from push_subscriptions
+where user_id = $1
+  and id not in (select id
+                 from push_subscriptions
+                 where user_id = $1
+                 order by created_at desc
+                 limit $2)
+`
+
+type TrimPushSubscriptionsParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Keep   int32     `json:"keep"`
+}
+
+// Deletes all but the newest subscriptions of the user.
+func (q *Queries) TrimPushSubscriptions(ctx context.Context, arg TrimPushSubscriptionsParams) error {
+	_, err := q.db.Exec(ctx, trimPushSubscriptions, arg.UserID, arg.Keep)
+	return err
+}
+
+const updatePasskeySignCount = `-- name: UpdatePasskeySignCount :exec
+update passkeys
+set sign_count   = $2,
+    backup_state = $3,
+    last_used_at = timezone('utc', now())
+where credential_id = $1
+`
+
+type UpdatePasskeySignCountParams struct {
+	CredentialID []byte `json:"credential_id"`
+	SignCount    int64  `json:"sign_count"`
+	BackupState  bool   `json:"backup_state"`
+}
+
+func (q *Queries) UpdatePasskeySignCount(ctx context.Context, arg UpdatePasskeySignCountParams) error {
+	_, err := q.db.Exec(ctx, updatePasskeySignCount, arg.CredentialID, arg.SignCount, arg.BackupState)
+	return err
+}
+
+const updateUser = `-- name: UpdateUser :exec
+update users as u
+set username     = coalesce(nullif($1, ''), u.username),
+    password     = coalesce(nullif($2, ''), u.password),
+    password_set = u.password_set or $2 <> '',
+    updated_at   = timezone('utc', now())
+where id = $3
+`
+
+type UpdateUserParams struct {
+	Column1 interface{} `json:"column_1"`
+	Column2 interface{} `json:"column_2"`
+	ID      uuid.UUID   `json:"id"`
+}
+
+func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
+	_, err := q.db.Exec(ctx, updateUser, arg.Column1, arg.Column2, arg.ID)
+	return err
+}
+
+const updateUserAvatar = `-- name: UpdateUserAvatar :exec
+update users
+set avatar     = $2,
+    avatar_32  = $3,
+    avatar_64  = $4,
+    avatar_256 = $5,
+    updated_at = timezone('utc', now())
 where id = $1
 `
 
-type GetUserByIDRow struct {
-	Username  string             `json:"username"`
-	Avatar    pgtype.Text        `json:"avatar"`
-	CreatedAt pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt pgtype.Timestamptz `json:"updated_at"`
+type UpdateUserAvatarParams struct {
+	ID        uuid.UUID   `json:"id"`
+	Avatar    pgtype.Text `json:"avatar"`
+	Avatar32  pgtype.Text `json:"avatar_32"`
+	Avatar64  pgtype.Text `json:"avatar_64"`
+	Avatar256 pgtype.Text `json:"avatar_256"`
 }
 
-func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (GetUserByIDRow, error) {
-	row := q.db.QueryRow(ctx, getUserByID, id)
-	var i GetUserByIDRow
+func (q *Queries) UpdateUserAvatar(ctx context.Context, arg UpdateUserAvatarParams) error {
+	_, err := q.db.Exec(ctx, updateUserAvatar,
+		arg.ID,
+		arg.Avatar,
+		arg.Avatar32,
+		arg.Avatar64,
+		arg.Avatar256,
+	)
+	return err
+}
+
+const updateUserEmail = `-- name: UpdateUserEmail :exec
+update users
+set email             = $2,
+    email_verified_at = null,
+    updated_at        = timezone('utc', now())
+where id = $1
+`
+
+type UpdateUserEmailParams struct {
+	ID    uuid.UUID   `json:"id"`
+	Email pgtype.Text `json:"email"`
+}
+
+func (q *Queries) UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) error {
+	_, err := q.db.Exec(ctx, updateUserEmail, arg.ID, arg.Email)
+	return err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+update users
+set password     = $2,
+    password_set = true,
+    updated_at   = timezone('utc', now())
+where id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID       uuid.UUID `json:"id"`
+	Password string    `json:"password"`
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.Exec(ctx, updateUserPassword, arg.ID, arg.Password)
+	return err
+}
+
+const updateUserProfile = `-- name: UpdateUserProfile :exec
+update users as u
+set display_name      = case when $1::text is null then u.display_name else nullif($1, '') end,
+    bio               = case when $2::text is null then u.bio else nullif($2, '') end,
+    pronouns          = case when $3::text is null then u.pronouns else nullif($3, '') end,
+    timezone          = case when $4::text is null then u.timezone else nullif($4, '') end,
+    locale            = case when $5::text is null then u.locale else nullif($5, '') end,
+    status_text       = case when $6::boolean then nullif($7::text, '') else u.status_text end,
+    status_emoji      = case when $6::boolean then nullif($8::text, '') else u.status_emoji end,
+    status_expires_at = case when $6::boolean then $9::timestamptz else u.status_expires_at end,
+    updated_at        = timezone('utc', now())
+where id = $10
+`
+
+type UpdateUserProfileParams struct {
+	DisplayName     pgtype.Text        `json:"display_name"`
+	Bio             pgtype.Text        `json:"bio"`
+	Pronouns        pgtype.Text        `json:"pronouns"`
+	Timezone        pgtype.Text        `json:"timezone"`
+	Locale          pgtype.Text        `json:"locale"`
+	SetStatus       bool               `json:"set_status"`
+	StatusText      pgtype.Text        `json:"status_text"`
+	StatusEmoji     pgtype.Text        `json:"status_emoji"`
+	StatusExpiresAt pgtype.Timestamptz `json:"status_expires_at"`
+	ID              uuid.UUID          `json:"id"`
+}
+
+// A null argument keeps the column, an empty string clears it. The status is
+// replaced as a whole when set_status is true.
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) error {
+	_, err := q.db.Exec(ctx, updateUserProfile,
+		arg.DisplayName,
+		arg.Bio,
+		arg.Pronouns,
+		arg.Timezone,
+		arg.Locale,
+		arg.SetStatus,
+		arg.StatusText,
+		arg.StatusEmoji,
+		arg.StatusExpiresAt,
+		arg.ID,
+	)
+	return err
+}
+
+const updateUserTOTPSecret = `-- name: UpdateUserTOTPSecret :exec
+update users
+set totp_secret     = $2,
+    totp_enabled_at = null,
+    updated_at      = timezone('utc', now())
+where id = $1
+`
+
+type UpdateUserTOTPSecretParams struct {
+	ID         uuid.UUID   `json:"id"`
+	TotpSecret pgtype.Text `json:"totp_secret"`
+}
+
+func (q *Queries) UpdateUserTOTPSecret(ctx context.Context, arg UpdateUserTOTPSecretParams) error {
+	_, err := q.db.Exec(ctx, updateUserTOTPSecret, arg.ID, arg.TotpSecret)
+	return err
+}
+
+const upsertNotificationSettings = `-- name: UpsertNotificationSettings :exec
+insert into notification_settings as s (user_id, level, muted_until, dnd_start, dnd_end)
+values ($1, coalesce($2::text, 'all'), $3::timestamptz,
+        $4::smallint, $5::smallint)
+on conflict (user_id) do update
+    set level       = coalesce($2::text, s.level),
+        muted_until = case when $6::boolean then $3::timestamptz else s.muted_until end,
+        dnd_start   = case when $7::boolean then $4::smallint else s.dnd_start end,
+        dnd_end     = case when $7::boolean then $5::smallint else s.dnd_end end,
+        updated_at  = timezone('utc', now())
+`
+
+type UpsertNotificationSettingsParams struct {
+	UserID        uuid.UUID          `json:"user_id"`
+	Level         pgtype.Text        `json:"level"`
+	MutedUntil    pgtype.Timestamptz `json:"muted_until"`
+	DndStart      pgtype.Int2        `json:"dnd_start"`
+	DndEnd        pgtype.Int2        `json:"dnd_end"`
+	SetMutedUntil bool               `json:"set_muted_until"`
+	SetDnd        bool               `json:"set_dnd"`
+}
+
+// A null level keeps the current one. The mute and the do-not-disturb
+// window are replaced when their set_ argument is true.
+func (q *Queries) UpsertNotificationSettings(ctx context.Context, arg UpsertNotificationSettingsParams) error {
+	_, err := q.db.Exec(ctx, upsertNotificationSettings,
+		arg.UserID,
+		arg.Level,
+		arg.MutedUntil,
+		arg.DndStart,
+		arg.DndEnd,
+		arg.SetMutedUntil,
+		arg.SetDnd,
+	)
+	return err
+}
+
+const upsertPushDevice = `-- name: UpsertPushDevice :one
+insert into push_devices (user_id, platform, token, session_hash)
+values ($1, $2, $3, $4)
+on conflict (platform, token) do update
+    set session_hash = excluded.session_hash,
+        created_at   = timezone('utc', now())
+returning id, platform, last_used_at, created_at
+`
+
+type UpsertPushDeviceParams struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Platform    string    `json:"platform"`
+	Token       string    `json:"token"`
+	SessionHash string    `json:"session_hash"`
+}
+
+type UpsertPushDeviceRow struct {
+	ID         uuid.UUID          `json:"id"`
+	Platform   string             `json:"platform"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) UpsertPushDevice(ctx context.Context, arg UpsertPushDeviceParams) (UpsertPushDeviceRow, error) {
+	row := q.db.QueryRow(ctx, upsertPushDevice,
+		arg.UserID,
+		arg.Platform,
+		arg.Token,
+		arg.SessionHash,
+	)
+	var i UpsertPushDeviceRow
 	err := row.Scan(
-		&i.Username,
-		&i.Avatar,
+		&i.ID,
+		&i.Platform,
+		&i.LastUsedAt,
 		&i.CreatedAt,
-		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const getUserByUsername = `-- name: GetUserByUsername :one
-select id, username, password, avatar, created_at, updated_at
-from users
-where username = $1
+const upsertPushSubscription = `-- name: UpsertPushSubscription :one
+insert into push_subscriptions (user_id, endpoint, p256dh, auth, user_agent)
+values ($1, $2, $3, $4, $5)
+on conflict (endpoint) do update
+    set user_id      = excluded.user_id,
+        p256dh       = excluded.p256dh,
+        auth         = excluded.auth,
+        user_agent   = excluded.user_agent,
+        last_used_at = null,
+        created_at   = timezone('utc', now())
+returning id, endpoint, user_agent, last_used_at, created_at
 `
 
-func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
-	row := q.db.QueryRow(ctx, getUserByUsername, username)
-	var i User
+type UpsertPushSubscriptionParams struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Endpoint  string    `json:"endpoint"`
+	P256dh    string    `json:"p256dh"`
+	Auth      string    `json:"auth"`
+	UserAgent string    `json:"user_agent"`
+}
+
+type UpsertPushSubscriptionRow struct {
+	ID         uuid.UUID          `json:"id"`
+	Endpoint   string             `json:"endpoint"`
+	UserAgent  string             `json:"user_agent"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) UpsertPushSubscription(ctx context.Context, arg UpsertPushSubscriptionParams) (UpsertPushSubscriptionRow, error) {
+	row := q.db.QueryRow(ctx, upsertPushSubscription,
+		arg.UserID,
+		arg.Endpoint,
+		arg.P256dh,
+		arg.Auth,
+		arg.UserAgent,
+	)
+	var i UpsertPushSubscriptionRow
 	err := row.Scan(
 		&i.ID,
-		&i.Username,
-		&i.Password,
-		&i.Avatar,
+		&i.Endpoint,
+		&i.UserAgent,
+		&i.LastUsedAt,
 		&i.CreatedAt,
-		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const updateUser = `-- name: UpdateUser :exec
-update users as u
-set username   = coalesce(nullif($1, ''), u.username),
-    password   = coalesce(nullif($2, ''), u.password),
-    avatar     = coalesce(nullif($3, ''), u.avatar),
-    updated_at = timezone('utc', now())
-where id = $4
+const usePreAuthToken = `-- name: UsePreAuthToken :execrows
+with expired as (
+    delete from used_pre_auth_tokens
+    where expires_at < timezone('utc', now()))
+insert
+into used_pre_auth_tokens (token_hash, expires_at)
+values ($1, $2)
+on conflict do nothing
 `
 
-type UpdateUserParams struct {
-	Column1 interface{} `json:"column_1"`
-	Column2 interface{} `json:"column_2"`
-	Column3 interface{} `json:"column_3"`
-	ID      uuid.UUID   `json:"id"`
+type UsePreAuthTokenParams struct {
+	TokenHash string             `json:"token_hash"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
-	_, err := q.db.Exec(ctx, updateUser,
-		arg.Column1,
-		arg.Column2,
-		arg.Column3,
-		arg.ID,
-	)
+// Marks the token used, affecting no row when it was already. Expired tokens
+// are forgotten on the way.
+func (q *Queries) UsePreAuthToken(ctx context.Context, arg UsePreAuthTokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, usePreAuthToken, arg.TokenHash, arg.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const useRecoveryCode = `-- name: UseRecoveryCode :execrows
+update recovery_codes
+set used_at = timezone('utc', now())
+where id = $1
+  and used_at is null
+`
+
+func (q *Queries) UseRecoveryCode(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, useRecoveryCode, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const verifyUserEmail = `-- name: VerifyUserEmail :exec
+update users
+set email_verified_at = timezone('utc', now())
+where id = $1
+`
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, verifyUserEmail, id)
 	return err
 }
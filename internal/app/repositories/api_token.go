@@ -0,0 +1,130 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+// ApiTokenPrefix starts every API token, so that they can be told apart from
+// session tokens and found by secret scanners.
+const ApiTokenPrefix = "chat_pat_"
+
+type ApiTokenRepository interface {
+	// CreateToken issues an API token. Only its keyed hash is stored, the
+	// returned value cannot be recovered later.
+	CreateToken(ctx context.Context, userID uuid.UUID, input *ApiTokenInput) (string, generated.CreateApiTokenRow, error)
+	// GetToken fails with pgx.ErrNoRows when the token is unknown, revoked
+	// or expired.
+	GetToken(ctx context.Context, token string) (generated.GetApiTokenByHashRow, error)
+	// TouchToken records that the token was used, at most once a minute.
+	TouchToken(ctx context.Context, id uuid.UUID) error
+	ListTokens(ctx context.Context, userID uuid.UUID) ([]generated.ListApiTokensRow, error)
+	CountTokens(ctx context.Context, userID uuid.UUID) (int64, error)
+	DeleteToken(ctx context.Context, userID, id uuid.UUID) (bool, error)
+}
+
+type ApiTokenInput struct {
+	Name   string   `json:"name" validate:"required|max_len:64"`
+	Scopes []string `json:"scopes" validate:"required"`
+	// ExpiresAt is optional, tokens without it never expire.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type apiTokenRepository struct {
+	Queries  *generated.Queries
+	Secret   []byte
+	Timeouts utils.Timeouts
+}
+
+func (a *apiTokenRepository) CreateToken(ctx context.Context, userID uuid.UUID, input *ApiTokenInput) (string, generated.CreateApiTokenRow, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", generated.CreateApiTokenRow{}, err
+	}
+	token := ApiTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	ctx, cancel := context.WithTimeout(ctx, a.Timeouts.Query)
+	defer cancel()
+
+	row, err := a.Queries.CreateApiToken(ctx, generated.CreateApiTokenParams{
+		UserID:    userID,
+		Name:      input.Name,
+		TokenHash: a.hash(token),
+		Prefix:    token[:len(ApiTokenPrefix)+4],
+		Scopes:    input.Scopes,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  input.ExpiresAt,
+			Valid: !input.ExpiresAt.IsZero(),
+		},
+	})
+	if err != nil {
+		return "", generated.CreateApiTokenRow{}, err
+	}
+
+	return token, row, nil
+}
+
+func (a *apiTokenRepository) GetToken(ctx context.Context, token string) (generated.GetApiTokenByHashRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.Timeouts.Query)
+	defer cancel()
+
+	return a.Queries.GetApiTokenByHash(ctx, a.hash(token))
+}
+
+func (a *apiTokenRepository) TouchToken(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, a.Timeouts.Query)
+	defer cancel()
+
+	return a.Queries.TouchApiToken(ctx, id)
+}
+
+func (a *apiTokenRepository) ListTokens(ctx context.Context, userID uuid.UUID) ([]generated.ListApiTokensRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.Timeouts.Query)
+	defer cancel()
+
+	return a.Queries.ListApiTokens(ctx, userID)
+}
+
+func (a *apiTokenRepository) CountTokens(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.Timeouts.Query)
+	defer cancel()
+
+	return a.Queries.CountApiTokens(ctx, userID)
+}
+
+func (a *apiTokenRepository) DeleteToken(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.Timeouts.Query)
+	defer cancel()
+
+	deleted, err := a.Queries.DeleteApiToken(ctx, generated.DeleteApiTokenParams{
+		ID:     id,
+		UserID: userID,
+	})
+
+	return deleted > 0, err
+}
+
+// hash signs the token with the secret, so a leaked table cannot be used to
+// authenticate.
+func (a *apiTokenRepository) hash(token string) string {
+	mac := hmac.New(sha256.New, a.Secret)
+	mac.Write([]byte("api_token:" + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func NewApiTokenRepo(queries *generated.Queries, secret []byte, timeouts utils.Timeouts) ApiTokenRepository {
+	return &apiTokenRepository{
+		Queries:  queries,
+		Secret:   secret,
+		Timeouts: timeouts,
+	}
+}
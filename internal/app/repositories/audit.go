@@ -0,0 +1,73 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type AuditRepository interface {
+	CreateEvent(ctx context.Context, params generated.CreateAuditEventParams) error
+	ListEvents(ctx context.Context, params generated.ListAuditEventsParams) ([]generated.AuditEvent, error)
+	// ListUserEvents lists the events the user caused or was the target of,
+	// newest first, starting before the given event ID when it is not zero.
+	// The IP and user agent of events another user caused are left empty.
+	ListUserEvents(ctx context.Context, userID uuid.UUID, before int64, limit int32) ([]generated.AuditEvent, error)
+}
+
+// AuditFilter selects audit events. Since and Until are RFC 3339 times, and
+// Before is the ID of the last event of the previous page.
+type AuditFilter struct {
+	Action string `query:"action" validate:"max_len:50"`
+	Actor  string `query:"actor" validate:"max_len:36"`
+	Target string `query:"target" validate:"max_len:36"`
+	Since  string `query:"since" validate:"max_len:40"`
+	Until  string `query:"until" validate:"max_len:40"`
+	Before int64  `query:"before" validate:"min:0"`
+	Limit  int    `query:"limit" validate:"min:0|max:100"`
+}
+
+type auditRepository struct {
+	Queries  *generated.Queries
+	Timeouts utils.Timeouts
+}
+
+func (a *auditRepository) CreateEvent(ctx context.Context, params generated.CreateAuditEventParams) error {
+	ctx, cancel := context.WithTimeout(ctx, a.Timeouts.Query)
+	defer cancel()
+
+	return a.Queries.CreateAuditEvent(ctx, params)
+}
+
+func (a *auditRepository) ListEvents(ctx context.Context, params generated.ListAuditEventsParams) ([]generated.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.Timeouts.Query)
+	defer cancel()
+
+	return a.Queries.ListAuditEvents(ctx, params)
+}
+
+func (a *auditRepository) ListUserEvents(ctx context.Context, userID uuid.UUID, before int64, limit int32) ([]generated.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.Timeouts.Query)
+	defer cancel()
+
+	return a.Queries.ListUserAuditEvents(ctx, generated.ListUserAuditEventsParams{
+		UserID: pgtype.UUID{
+			Bytes: userID,
+			Valid: true,
+		},
+		Before: pgtype.Int8{
+			Int64: before,
+			Valid: before > 0,
+		},
+		Limit: limit,
+	})
+}
+
+func NewAuditRepo(queries *generated.Queries, timeouts utils.Timeouts) AuditRepository {
+	return &auditRepository{
+		Queries:  queries,
+		Timeouts: timeouts,
+	}
+}
@@ -2,68 +2,194 @@ package repositories
 
 import (
 	"chat_backend/generated"
+	"chat_backend/pkg/password"
 	"chat_backend/pkg/utils"
 	"context"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
-	"github.com/matthewhartstonge/argon2"
-	"log"
+	"strings"
 )
 
 type AuthRepository interface {
-	GetUserByUsername(username string) (generated.User, error)
-	CreateNewUser(input *AuthInput) error
+	GetUserByUsername(ctx context.Context, username string) (generated.User, error)
+	GetUserByEmail(ctx context.Context, email string) (generated.User, error)
+	GetUserAccountByID(ctx context.Context, id uuid.UUID) (generated.User, error)
+	CreateNewUser(ctx context.Context, input *AuthInput) error
+	// CreateProvisionedUser creates an account whose password is not known
+	// to anyone, so it does not count as a way to sign in.
+	CreateProvisionedUser(ctx context.Context, input *AuthInput) error
+	UpdatePassword(ctx context.Context, id uuid.UUID, password string) error
+	UpdateEmail(ctx context.Context, id uuid.UUID, email string) error
+	VerifyEmail(ctx context.Context, id uuid.UUID) error
 	HashPassword(password string) ([]byte, error)
 	VerifyPassword(currentPassword, password string) (bool, error)
+	NeedsRehash(currentPassword string) bool
+	// RehashPassword replaces the hash of password, unless it has changed
+	// since currentPassword was read.
+	RehashPassword(ctx context.Context, id uuid.UUID, currentPassword, password string) error
+	// RestoreUser cancels a pending deletion. It reports false when none is
+	// pending.
+	RestoreUser(ctx context.Context, id uuid.UUID) (bool, error)
 }
 
 type authRepository struct {
-	Queries *generated.Queries
+	Queries  *generated.Queries
+	Hasher   *password.Hasher
+	Timeouts utils.Timeouts
 }
 
 func (r *authRepository) HashPassword(password string) ([]byte, error) {
-	argon := argon2.DefaultConfig()
-	return argon.HashEncoded([]byte(password))
+	return r.Hasher.Hash(password)
 }
 
 func (r *authRepository) VerifyPassword(currentPassword, password string) (bool, error) {
-	return argon2.VerifyEncoded([]byte(password), []byte(currentPassword))
+	return r.Hasher.Verify(currentPassword, password)
+}
+
+func (r *authRepository) NeedsRehash(currentPassword string) bool {
+	return r.Hasher.NeedsRehash(currentPassword)
+}
+
+func (r *authRepository) RehashPassword(ctx context.Context, id uuid.UUID, currentPassword, password string) error {
+	hash, err := r.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	_, err = r.Queries.RehashUserPassword(ctx, generated.RehashUserPasswordParams{
+		ID:         id,
+		Password:   currentPassword,
+		Password_2: string(hash),
+	})
+
+	return err
 }
 
 type AuthInput struct {
 	Username string `json:"username" validate:"required|max_len:30"`
 	Password string `json:"password" validate:"required|max_len:100"`
+	Email    string `json:"email,omitempty" validate:"email|max_len:254"`
+}
+
+type ForgotPasswordInput struct {
+	Email string `json:"email" validate:"required|email|max_len:254"`
+}
+
+type ResetPasswordInput struct {
+	Token    string `json:"token" validate:"required|max_len:100"`
+	Password string `json:"password" validate:"required|max_len:100"`
+}
+
+type VerifyEmailInput struct {
+	Token string `json:"token" validate:"required|max_len:100"`
+}
+
+func (r *authRepository) CreateNewUser(ctx context.Context, input *AuthInput) error {
+	return r.createUser(ctx, input, true)
 }
 
-func (r *authRepository) CreateNewUser(input *AuthInput) error {
+func (r *authRepository) CreateProvisionedUser(ctx context.Context, input *AuthInput) error {
+	return r.createUser(ctx, input, false)
+}
+
+func (r *authRepository) createUser(ctx context.Context, input *AuthInput, passwordSet bool) error {
 	hash, err := r.HashPassword(input.Password)
 	if err != nil {
 		return err
 	}
 
-	err = r.Queries.CreateNewUser(context.Background(), generated.CreateNewUserParams{
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.CreateNewUser(ctx, generated.CreateNewUserParams{
 		Username: input.Username,
 		Password: string(hash),
-		Avatar: pgtype.Text{
-			String: utils.GetRandomImage(),
-			Valid:  true,
+		Email: pgtype.Text{
+			String: NormalizeEmail(input.Email),
+			Valid:  len(input.Email) > 0,
 		},
+		PasswordSet: passwordSet,
 	})
+}
+
+func (r *authRepository) UpdatePassword(ctx context.Context, id uuid.UUID, password string) error {
+	hash, err := r.HashPassword(password)
 	if err != nil {
 		return err
 	}
-	if err != nil {
-		log.Printf("Error in /signup - create new user: %v", err)
-	}
 
-	return nil
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.UpdateUserPassword(ctx, generated.UpdateUserPasswordParams{
+		ID:       id,
+		Password: string(hash),
+	})
 }
 
-func (r *authRepository) GetUserByUsername(username string) (generated.User, error) {
-	return r.Queries.GetUserByUsername(context.Background(), username)
+func (r *authRepository) UpdateEmail(ctx context.Context, id uuid.UUID, email string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.UpdateUserEmail(ctx, generated.UpdateUserEmailParams{
+		ID: id,
+		Email: pgtype.Text{
+			String: NormalizeEmail(email),
+			Valid:  len(email) > 0,
+		},
+	})
+}
+
+func (r *authRepository) RestoreUser(ctx context.Context, id uuid.UUID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	rows, err := r.Queries.RestoreUser(ctx, id)
+	return rows > 0, err
+}
+
+func (r *authRepository) VerifyEmail(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.VerifyUserEmail(ctx, id)
+}
+
+func (r *authRepository) GetUserByEmail(ctx context.Context, email string) (generated.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.GetUserByEmail(ctx, pgtype.Text{
+		String: NormalizeEmail(email),
+		Valid:  true,
+	})
+}
+
+func (r *authRepository) GetUserAccountByID(ctx context.Context, id uuid.UUID) (generated.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.GetUserAccountByID(ctx, id)
+}
+
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (r *authRepository) GetUserByUsername(ctx context.Context, username string) (generated.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.GetUserByUsername(ctx, username)
 }
 
-func NewAuthRepo(queries *generated.Queries) AuthRepository {
+func NewAuthRepo(queries *generated.Queries, hasher *password.Hasher, timeouts utils.Timeouts) AuthRepository {
 	return &authRepository{
-		Queries: queries,
+		Queries:  queries,
+		Hasher:   hasher,
+		Timeouts: timeouts,
 	}
 }
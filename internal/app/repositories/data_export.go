@@ -0,0 +1,114 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+type DataExportRepository interface {
+	CreateExport(ctx context.Context, userID uuid.UUID) (generated.CreateDataExportRow, error)
+	// CountActiveExports counts the exports still pending or running.
+	CountActiveExports(ctx context.Context, userID uuid.UUID) (int64, error)
+	// ClaimExport marks the oldest pending export running and returns it. An
+	// export left running for longer than stale, by a worker that stopped,
+	// is claimed again. It fails with pgx.ErrNoRows when there is none.
+	ClaimExport(ctx context.Context, stale time.Duration) (generated.ClaimDataExportRow, error)
+	CompleteExport(ctx context.Context, id uuid.UUID, archive []byte, expiresAt time.Time) error
+	FailExport(ctx context.Context, id uuid.UUID, expiresAt time.Time) error
+	ListExports(ctx context.Context, userID uuid.UUID) ([]generated.ListDataExportsRow, error)
+	// GetArchive fails with pgx.ErrNoRows unless the export is ready and
+	// has not expired.
+	GetArchive(ctx context.Context, userID, id uuid.UUID) ([]byte, error)
+	DeleteExpiredExports(ctx context.Context) (int64, error)
+}
+
+type dataExportRepository struct {
+	Queries  *generated.Queries
+	Timeouts utils.Timeouts
+}
+
+func (d *dataExportRepository) CreateExport(ctx context.Context, userID uuid.UUID) (generated.CreateDataExportRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeouts.Query)
+	defer cancel()
+
+	return d.Queries.CreateDataExport(ctx, userID)
+}
+
+func (d *dataExportRepository) CountActiveExports(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeouts.Query)
+	defer cancel()
+
+	return d.Queries.CountActiveDataExports(ctx, userID)
+}
+
+func (d *dataExportRepository) ClaimExport(ctx context.Context, stale time.Duration) (generated.ClaimDataExportRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeouts.Query)
+	defer cancel()
+
+	return d.Queries.ClaimDataExport(ctx, pgtype.Timestamptz{
+		Time:  time.Now().Add(-stale),
+		Valid: true,
+	})
+}
+
+func (d *dataExportRepository) CompleteExport(ctx context.Context, id uuid.UUID, archive []byte, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeouts.Query)
+	defer cancel()
+
+	return d.Queries.CompleteDataExport(ctx, generated.CompleteDataExportParams{
+		ID:      id,
+		Archive: archive,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  expiresAt,
+			Valid: true,
+		},
+	})
+}
+
+func (d *dataExportRepository) FailExport(ctx context.Context, id uuid.UUID, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeouts.Query)
+	defer cancel()
+
+	return d.Queries.FailDataExport(ctx, generated.FailDataExportParams{
+		ID: id,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  expiresAt,
+			Valid: true,
+		},
+	})
+}
+
+func (d *dataExportRepository) ListExports(ctx context.Context, userID uuid.UUID) ([]generated.ListDataExportsRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeouts.Query)
+	defer cancel()
+
+	return d.Queries.ListDataExports(ctx, userID)
+}
+
+func (d *dataExportRepository) GetArchive(ctx context.Context, userID, id uuid.UUID) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeouts.Query)
+	defer cancel()
+
+	return d.Queries.GetDataExportArchive(ctx, generated.GetDataExportArchiveParams{
+		ID:     id,
+		UserID: userID,
+	})
+}
+
+func (d *dataExportRepository) DeleteExpiredExports(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeouts.Query)
+	defer cancel()
+
+	return d.Queries.DeleteExpiredDataExports(ctx)
+}
+
+func NewDataExportRepo(queries *generated.Queries, timeouts utils.Timeouts) DataExportRepository {
+	return &dataExportRepository{
+		Queries:  queries,
+		Timeouts: timeouts,
+	}
+}
@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type IdentityRepository interface {
+	GetIdentity(ctx context.Context, provider, subject string) (generated.LinkedIdentity, error)
+	ListIdentities(ctx context.Context, userID uuid.UUID) ([]generated.ListLinkedIdentitiesRow, error)
+	CountIdentities(ctx context.Context, userID uuid.UUID) (int64, error)
+	LinkIdentity(ctx context.Context, userID uuid.UUID, provider, subject, email string) error
+	UnlinkIdentity(ctx context.Context, userID uuid.UUID, provider string) (bool, error)
+}
+
+type identityRepository struct {
+	Queries  *generated.Queries
+	Timeouts utils.Timeouts
+}
+
+func (i *identityRepository) GetIdentity(ctx context.Context, provider, subject string) (generated.LinkedIdentity, error) {
+	ctx, cancel := context.WithTimeout(ctx, i.Timeouts.Query)
+	defer cancel()
+
+	return i.Queries.GetLinkedIdentity(ctx, generated.GetLinkedIdentityParams{
+		Provider: provider,
+		Subject:  subject,
+	})
+}
+
+func (i *identityRepository) ListIdentities(ctx context.Context, userID uuid.UUID) ([]generated.ListLinkedIdentitiesRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, i.Timeouts.Query)
+	defer cancel()
+
+	return i.Queries.ListLinkedIdentities(ctx, userID)
+}
+
+func (i *identityRepository) CountIdentities(ctx context.Context, userID uuid.UUID) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, i.Timeouts.Query)
+	defer cancel()
+
+	return i.Queries.CountLinkedIdentities(ctx, userID)
+}
+
+func (i *identityRepository) LinkIdentity(ctx context.Context, userID uuid.UUID, provider, subject, email string) error {
+	ctx, cancel := context.WithTimeout(ctx, i.Timeouts.Query)
+	defer cancel()
+
+	return i.Queries.CreateLinkedIdentity(ctx, generated.CreateLinkedIdentityParams{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  subject,
+		Email: pgtype.Text{
+			String: NormalizeEmail(email),
+			Valid:  len(email) > 0,
+		},
+	})
+}
+
+func (i *identityRepository) UnlinkIdentity(ctx context.Context, userID uuid.UUID, provider string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, i.Timeouts.Query)
+	defer cancel()
+
+	deleted, err := i.Queries.DeleteLinkedIdentity(ctx, generated.DeleteLinkedIdentityParams{
+		UserID:   userID,
+		Provider: provider,
+	})
+
+	return deleted > 0, err
+}
+
+func NewIdentityRepo(queries *generated.Queries, timeouts utils.Timeouts) IdentityRepository {
+	return &identityRepository{
+		Queries:  queries,
+		Timeouts: timeouts,
+	}
+}
@@ -0,0 +1,122 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+const (
+	NotifyAll      = "all"
+	NotifyMentions = "mentions"
+	NotifyNone     = "none"
+)
+
+var errInvalidClock = errors.New("clock time is not HH:MM")
+
+type NotificationRepository interface {
+	// GetSettings returns the account-wide settings, or the defaults for
+	// users who never changed them. It fails with pgx.ErrNoRows when the user
+	// does not exist.
+	GetSettings(ctx context.Context, userID uuid.UUID) (generated.GetNotificationSettingsRow, error)
+	// UpdateSettings changes the account-wide settings.
+	UpdateSettings(ctx context.Context, userID uuid.UUID, input *NotificationSettingsInput) error
+}
+
+// NotificationSettingsInput changes the fields that are set. Empty fields
+// clear the mute and the do-not-disturb schedule, an empty level is ignored.
+type NotificationSettingsInput struct {
+	Level *string `json:"level,omitempty" validate:"in:all,mentions,none"`
+	// MutedUntil is an RFC 3339 time.
+	MutedUntil *string `json:"muted_until,omitempty" validate:"max_len:40"`
+	// The do-not-disturb schedule is set with both ends as HH:MM in the
+	// user's timezone. It may wrap past midnight, such as 22:00 to 07:00.
+	DNDStart *string `json:"dnd_start,omitempty" validate:"max_len:5"`
+	DNDEnd   *string `json:"dnd_end,omitempty" validate:"max_len:5"`
+}
+
+// SetsSchedule reports whether the input replaces the do-not-disturb
+// schedule.
+func (i *NotificationSettingsInput) SetsSchedule() bool {
+	return i.DNDStart != nil || i.DNDEnd != nil
+}
+
+// ParseClock returns the minutes after midnight of a HH:MM time.
+func ParseClock(clock string) (int16, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, errInvalidClock
+	}
+
+	return int16(t.Hour()*60 + t.Minute()), nil
+}
+
+// FormatClock is the reverse of ParseClock.
+func FormatClock(minutes int16) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+type notificationRepository struct {
+	Queries  *generated.Queries
+	Timeouts utils.Timeouts
+}
+
+func (n *notificationRepository) GetSettings(ctx context.Context, userID uuid.UUID) (generated.GetNotificationSettingsRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, n.Timeouts.Query)
+	defer cancel()
+
+	return n.Queries.GetNotificationSettings(ctx, userID)
+}
+
+func (n *notificationRepository) UpdateSettings(ctx context.Context, userID uuid.UUID, input *NotificationSettingsInput) error {
+	params := generated.UpsertNotificationSettingsParams{
+		UserID:        userID,
+		SetMutedUntil: input.MutedUntil != nil,
+		SetDnd:        input.SetsSchedule(),
+	}
+
+	if input.Level != nil && len(*input.Level) > 0 {
+		params.Level = optionalText(input.Level)
+	}
+
+	if input.MutedUntil != nil && len(*input.MutedUntil) > 0 {
+		mutedUntil, err := time.Parse(time.RFC3339, *input.MutedUntil)
+		if err != nil {
+			return err
+		}
+		params.MutedUntil = pgtype.Timestamptz{
+			Time:  mutedUntil,
+			Valid: true,
+		}
+	}
+
+	if input.DNDStart != nil && input.DNDEnd != nil && len(*input.DNDStart) > 0 && len(*input.DNDEnd) > 0 {
+		start, err := ParseClock(*input.DNDStart)
+		if err != nil {
+			return err
+		}
+		end, err := ParseClock(*input.DNDEnd)
+		if err != nil {
+			return err
+		}
+		params.DndStart = pgtype.Int2{Int16: start, Valid: true}
+		params.DndEnd = pgtype.Int2{Int16: end, Valid: true}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.Timeouts.Query)
+	defer cancel()
+
+	return n.Queries.UpsertNotificationSettings(ctx, params)
+}
+
+func NewNotificationRepo(queries *generated.Queries, timeouts utils.Timeouts) NotificationRepository {
+	return &notificationRepository{
+		Queries:  queries,
+		Timeouts: timeouts,
+	}
+}
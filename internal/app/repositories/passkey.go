@@ -0,0 +1,171 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"encoding/json"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+type PasskeyRepository interface {
+	GetCredentials(ctx context.Context, userID uuid.UUID) ([]webauthn.Credential, error)
+	ListPasskeys(ctx context.Context, userID uuid.UUID) ([]generated.ListPasskeysRow, error)
+	CreatePasskey(ctx context.Context, userID uuid.UUID, name string, credential *webauthn.Credential) error
+	UpdateSignCount(ctx context.Context, credential *webauthn.Credential) error
+	DeletePasskey(ctx context.Context, userID, id uuid.UUID) (bool, error)
+	// SaveSession stores the ceremony state until the client answers the
+	// challenge and returns the ID to hand back to it.
+	SaveSession(ctx context.Context, session *webauthn.SessionData, ttl time.Duration) (uuid.UUID, error)
+	// ConsumeSession loads and deletes the ceremony state, so every
+	// challenge can only be answered once.
+	ConsumeSession(ctx context.Context, id uuid.UUID) (*webauthn.SessionData, error)
+}
+
+type PasskeyInput struct {
+	Name string `query:"name" validate:"max_len:64"`
+}
+
+type passkeyRepository struct {
+	Queries  *generated.Queries
+	Timeouts utils.Timeouts
+}
+
+func (p *passkeyRepository) GetCredentials(ctx context.Context, userID uuid.UUID) ([]webauthn.Credential, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	passkeys, err := p.Queries.GetPasskeysByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, len(passkeys))
+	for i, passkey := range passkeys {
+		transports := make([]protocol.AuthenticatorTransport, len(passkey.Transports))
+		for j, transport := range passkey.Transports {
+			transports[j] = protocol.AuthenticatorTransport(transport)
+		}
+
+		credentials[i] = webauthn.Credential{
+			ID:              passkey.CredentialID,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				UserPresent:    true,
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    passkey.Aaguid,
+				SignCount: uint32(passkey.SignCount),
+			},
+		}
+	}
+
+	return credentials, nil
+}
+
+func (p *passkeyRepository) ListPasskeys(ctx context.Context, userID uuid.UUID) ([]generated.ListPasskeysRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.ListPasskeys(ctx, userID)
+}
+
+func (p *passkeyRepository) CreatePasskey(ctx context.Context, userID uuid.UUID, name string, credential *webauthn.Credential) error {
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.CreatePasskey(ctx, generated.CreatePasskeyParams{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		Aaguid:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	})
+}
+
+func (p *passkeyRepository) UpdateSignCount(ctx context.Context, credential *webauthn.Credential) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.UpdatePasskeySignCount(ctx, generated.UpdatePasskeySignCountParams{
+		CredentialID: credential.ID,
+		SignCount:    int64(credential.Authenticator.SignCount),
+		BackupState:  credential.Flags.BackupState,
+	})
+}
+
+func (p *passkeyRepository) DeletePasskey(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	deleted, err := p.Queries.DeletePasskey(ctx, generated.DeletePasskeyParams{
+		ID:     id,
+		UserID: userID,
+	})
+
+	return deleted > 0, err
+}
+
+func (p *passkeyRepository) SaveSession(ctx context.Context, session *webauthn.SessionData, ttl time.Duration) (uuid.UUID, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	if err := p.Queries.DeleteExpiredWebauthnSessions(ctx); err != nil {
+		return uuid.Nil, err
+	}
+
+	return p.Queries.CreateWebauthnSession(ctx, generated.CreateWebauthnSessionParams{
+		SessionData: data,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  time.Now().Add(ttl),
+			Valid: true,
+		},
+	})
+}
+
+func (p *passkeyRepository) ConsumeSession(ctx context.Context, id uuid.UUID) (*webauthn.SessionData, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	data, err := p.Queries.ConsumeWebauthnSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	session := new(webauthn.SessionData)
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func NewPasskeyRepo(queries *generated.Queries, timeouts utils.Timeouts) PasskeyRepository {
+	return &passkeyRepository{
+		Queries:  queries,
+		Timeouts: timeouts,
+	}
+}
@@ -0,0 +1,258 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+type PushRepository interface {
+	// Subscribe stores the subscription, taking the endpoint over when it
+	// was registered before, and keeps only the newest keep subscriptions.
+	Subscribe(ctx context.Context, userID uuid.UUID, input *PushSubscriptionInput, userAgent string, keep int32) (generated.UpsertPushSubscriptionRow, error)
+	ListSubscriptions(ctx context.Context, userID uuid.UUID) ([]generated.ListPushSubscriptionsRow, error)
+	// ListTargets returns the subscriptions with their keys to send to.
+	ListTargets(ctx context.Context, userID uuid.UUID) ([]generated.ListPushTargetsRow, error)
+	TouchSubscription(ctx context.Context, id uuid.UUID) error
+	DeleteSubscription(ctx context.Context, userID, id uuid.UUID) (bool, error)
+	DeleteEndpoint(ctx context.Context, endpoint string) error
+	// RegisterDevice stores the device token for the session, taking it
+	// over from another user, and keeps only the newest keep devices.
+	RegisterDevice(ctx context.Context, userID uuid.UUID, input *PushDeviceInput, sessionHash string, keep int32) (generated.UpsertPushDeviceRow, error)
+	ListDevices(ctx context.Context, userID uuid.UUID) ([]generated.ListPushDevicesRow, error)
+	DeleteDevice(ctx context.Context, userID, id uuid.UUID) (bool, error)
+	// ForgetDevice deletes a device whatever user it belongs to.
+	ForgetDevice(ctx context.Context, id uuid.UUID) error
+	DeleteSessionDevices(ctx context.Context, sessionHash string) error
+	TouchDevice(ctx context.Context, id uuid.UUID) error
+	// EnqueueDeliveries queues payload for every device of the user, merging
+	// it into a waiting delivery with the same collapse key. It returns the
+	// number of devices.
+	EnqueueDeliveries(ctx context.Context, userID uuid.UUID, collapseKey string, payload []byte) (int64, error)
+	// ClaimDeliveries leases up to batch due deliveries for lease.
+	ClaimDeliveries(ctx context.Context, batch int32, lease time.Duration) ([]generated.ClaimPushDeliveriesRow, error)
+	// CompleteDelivery deletes the delivery unless it was merged with
+	// another notification since revision.
+	CompleteDelivery(ctx context.Context, id uuid.UUID, revision int32) error
+	// RetryDelivery reschedules the delivery unless it was merged with
+	// another notification since revision.
+	RetryDelivery(ctx context.Context, id uuid.UUID, revision int32, at time.Time) error
+}
+
+// PushSubscriptionInput is the JSON of a browser PushSubscription.
+type PushSubscriptionInput struct {
+	Endpoint string `json:"endpoint" validate:"required|max_len:1024"`
+	Keys     struct {
+		P256dh string `json:"p256dh" validate:"required|max_len:128"`
+		Auth   string `json:"auth" validate:"required|max_len:32"`
+	} `json:"keys"`
+}
+
+// PushDeviceInput registers a native app.
+type PushDeviceInput struct {
+	Platform string `json:"platform" validate:"required|in:ios,android"`
+	// Token is the APNs device token or the FCM registration token.
+	Token string `json:"token" validate:"required|max_len:512"`
+}
+
+type pushRepository struct {
+	Queries  *generated.Queries
+	Timeouts utils.Timeouts
+}
+
+func (p *pushRepository) Subscribe(ctx context.Context, userID uuid.UUID, input *PushSubscriptionInput, userAgent string, keep int32) (generated.UpsertPushSubscriptionRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	row, err := p.Queries.UpsertPushSubscription(ctx, generated.UpsertPushSubscriptionParams{
+		UserID:    userID,
+		Endpoint:  input.Endpoint,
+		P256dh:    input.Keys.P256dh,
+		Auth:      input.Keys.Auth,
+		UserAgent: userAgent,
+	})
+	if err != nil {
+		return row, err
+	}
+
+	return row, p.Queries.TrimPushSubscriptions(ctx, generated.TrimPushSubscriptionsParams{
+		UserID: userID,
+		Keep:   keep,
+	})
+}
+
+func (p *pushRepository) ListSubscriptions(ctx context.Context, userID uuid.UUID) ([]generated.ListPushSubscriptionsRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.ListPushSubscriptions(ctx, userID)
+}
+
+func (p *pushRepository) ListTargets(ctx context.Context, userID uuid.UUID) ([]generated.ListPushTargetsRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.ListPushTargets(ctx, userID)
+}
+
+func (p *pushRepository) TouchSubscription(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.TouchPushSubscription(ctx, id)
+}
+
+func (p *pushRepository) DeleteSubscription(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	deleted, err := p.Queries.DeletePushSubscription(ctx, generated.DeletePushSubscriptionParams{
+		ID:     id,
+		UserID: userID,
+	})
+
+	return deleted > 0, err
+}
+
+func (p *pushRepository) DeleteEndpoint(ctx context.Context, endpoint string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.DeletePushSubscriptionByEndpoint(ctx, endpoint)
+}
+
+func (p *pushRepository) RegisterDevice(ctx context.Context, userID uuid.UUID, input *PushDeviceInput, sessionHash string, keep int32) (generated.UpsertPushDeviceRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	err := p.Queries.DeleteForeignPushDevice(ctx, generated.DeleteForeignPushDeviceParams{
+		Platform: input.Platform,
+		Token:    input.Token,
+		UserID:   userID,
+	})
+	if err != nil {
+		return generated.UpsertPushDeviceRow{}, err
+	}
+
+	row, err := p.Queries.UpsertPushDevice(ctx, generated.UpsertPushDeviceParams{
+		UserID:      userID,
+		Platform:    input.Platform,
+		Token:       input.Token,
+		SessionHash: sessionHash,
+	})
+	if err != nil {
+		return row, err
+	}
+
+	return row, p.Queries.TrimPushDevices(ctx, generated.TrimPushDevicesParams{
+		UserID: userID,
+		Keep:   keep,
+	})
+}
+
+func (p *pushRepository) ListDevices(ctx context.Context, userID uuid.UUID) ([]generated.ListPushDevicesRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.ListPushDevices(ctx, userID)
+}
+
+func (p *pushRepository) DeleteDevice(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	deleted, err := p.Queries.DeletePushDevice(ctx, generated.DeletePushDeviceParams{
+		ID:     id,
+		UserID: userID,
+	})
+
+	return deleted > 0, err
+}
+
+func (p *pushRepository) ForgetDevice(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.DeletePushDeviceByID(ctx, id)
+}
+
+func (p *pushRepository) DeleteSessionDevices(ctx context.Context, sessionHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.DeletePushDevicesBySession(ctx, sessionHash)
+}
+
+func (p *pushRepository) TouchDevice(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.TouchPushDevice(ctx, id)
+}
+
+func (p *pushRepository) EnqueueDeliveries(ctx context.Context, userID uuid.UUID, collapseKey string, payload []byte) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.EnqueuePushDeliveries(ctx, generated.EnqueuePushDeliveriesParams{
+		CollapseKey: collapseKey,
+		Payload:     payload,
+		Now: pgtype.Timestamptz{
+			Time:  time.Now(),
+			Valid: true,
+		},
+		UserID: userID,
+	})
+}
+
+func (p *pushRepository) ClaimDeliveries(ctx context.Context, batch int32, lease time.Duration) ([]generated.ClaimPushDeliveriesRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	now := time.Now()
+	return p.Queries.ClaimPushDeliveries(ctx, generated.ClaimPushDeliveriesParams{
+		LeaseUntil: pgtype.Timestamptz{
+			Time:  now.Add(lease),
+			Valid: true,
+		},
+		Now: pgtype.Timestamptz{
+			Time:  now,
+			Valid: true,
+		},
+		Batch: batch,
+	})
+}
+
+func (p *pushRepository) CompleteDelivery(ctx context.Context, id uuid.UUID, revision int32) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.CompletePushDelivery(ctx, generated.CompletePushDeliveryParams{
+		ID:       id,
+		Revision: revision,
+	})
+}
+
+func (p *pushRepository) RetryDelivery(ctx context.Context, id uuid.UUID, revision int32, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeouts.Query)
+	defer cancel()
+
+	return p.Queries.RetryPushDelivery(ctx, generated.RetryPushDeliveryParams{
+		ID:       id,
+		Revision: revision,
+		NextAttemptAt: pgtype.Timestamptz{
+			Time:  at,
+			Valid: true,
+		},
+	})
+}
+
+func NewPushRepo(queries *generated.Queries, timeouts utils.Timeouts) PushRepository {
+	return &pushRepository{
+		Queries:  queries,
+		Timeouts: timeouts,
+	}
+}
@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"github.com/google/uuid"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+const (
+	PermissionModerateUsers    = "users:moderate"
+	PermissionModerateMessages = "messages:moderate"
+	PermissionReadRoles        = "roles:read"
+	PermissionAssignRoles      = "roles:assign"
+	PermissionOperateSystem    = "system:operate"
+	PermissionReadAudit        = "audit:read"
+)
+
+type RoleRepository interface {
+	ListRoles(ctx context.Context) ([]generated.ListRolesRow, error)
+	GetUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error)
+	// GetUserPermissions includes the permissions every user has through
+	// the implicit user role.
+	GetUserPermissions(ctx context.Context, userID uuid.UUID) ([]string, error)
+	AssignRole(ctx context.Context, userID uuid.UUID, role string) (bool, error)
+	RemoveRole(ctx context.Context, userID uuid.UUID, role string) (bool, error)
+	// CountRoleMembers counts the holders of role whose accounts are not
+	// pending deletion.
+	CountRoleMembers(ctx context.Context, role string) (int64, error)
+}
+
+type roleRepository struct {
+	Queries  *generated.Queries
+	Timeouts utils.Timeouts
+}
+
+func (r *roleRepository) ListRoles(ctx context.Context) ([]generated.ListRolesRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.ListRoles(ctx)
+}
+
+func (r *roleRepository) GetUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.GetUserRoles(ctx, userID)
+}
+
+func (r *roleRepository) GetUserPermissions(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.GetUserPermissions(ctx, userID)
+}
+
+func (r *roleRepository) AssignRole(ctx context.Context, userID uuid.UUID, role string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	assigned, err := r.Queries.AssignUserRole(ctx, generated.AssignUserRoleParams{
+		UserID: userID,
+		Role:   role,
+	})
+
+	return assigned > 0, err
+}
+
+func (r *roleRepository) RemoveRole(ctx context.Context, userID uuid.UUID, role string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	removed, err := r.Queries.RemoveUserRole(ctx, generated.RemoveUserRoleParams{
+		UserID: userID,
+		Role:   role,
+	})
+
+	return removed > 0, err
+}
+
+func (r *roleRepository) CountRoleMembers(ctx context.Context, role string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeouts.Query)
+	defer cancel()
+
+	return r.Queries.CountRoleMembers(ctx, role)
+}
+
+func NewRoleRepo(queries *generated.Queries, timeouts utils.Timeouts) RoleRepository {
+	return &roleRepository{
+		Queries:  queries,
+		Timeouts: timeouts,
+	}
+}
@@ -0,0 +1,141 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// SigningKeyPending keys verify tokens but are not used to sign yet, so
+	// they can be distributed before they are activated.
+	SigningKeyPending = "pending"
+	SigningKeyActive  = "active"
+	SigningKeyRetired = "retired"
+)
+
+type SigningKey struct {
+	Kid         string
+	PrivateKey  ed25519.PrivateKey
+	Status      string
+	CreatedAt   pgtype.Timestamptz
+	ActivatedAt pgtype.Timestamptz
+	RetiredAt   pgtype.Timestamptz
+}
+
+type SigningKeyRepository interface {
+	ListKeys(ctx context.Context) ([]SigningKey, error)
+	// CreateKey stores key unless kid already exists.
+	CreateKey(ctx context.Context, kid string, key ed25519.PrivateKey, status string) error
+	ActivateKey(ctx context.Context, kid string) (bool, error)
+	RetireKey(ctx context.Context, kid string) (bool, error)
+}
+
+// signingKeyRepository stores the key seeds encrypted with XChaCha20-Poly1305
+// under Secret, bound to their key ID.
+type signingKeyRepository struct {
+	Queries  *generated.Queries
+	Secret   []byte
+	Timeouts utils.Timeouts
+}
+
+func (s *signingKeyRepository) ListKeys(ctx context.Context) ([]SigningKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeouts.Query)
+	defer cancel()
+
+	rows, err := s.Queries.ListSigningKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]SigningKey, len(rows))
+	for i, row := range rows {
+		seed, err := s.open(row.Kid, row.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[i] = SigningKey{
+			Kid:         row.Kid,
+			PrivateKey:  ed25519.NewKeyFromSeed(seed),
+			Status:      row.Status,
+			CreatedAt:   row.CreatedAt,
+			ActivatedAt: row.ActivatedAt,
+			RetiredAt:   row.RetiredAt,
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *signingKeyRepository) CreateKey(ctx context.Context, kid string, key ed25519.PrivateKey, status string) error {
+	sealed, err := s.seal(kid, key.Seed())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeouts.Query)
+	defer cancel()
+
+	return s.Queries.CreateSigningKey(ctx, generated.CreateSigningKeyParams{
+		Kid:        kid,
+		PrivateKey: sealed,
+		Status:     status,
+	})
+}
+
+func (s *signingKeyRepository) ActivateKey(ctx context.Context, kid string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeouts.Query)
+	defer cancel()
+
+	activated, err := s.Queries.ActivateSigningKey(ctx, kid)
+	return activated > 0, err
+}
+
+func (s *signingKeyRepository) RetireKey(ctx context.Context, kid string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeouts.Query)
+	defer cancel()
+
+	retired, err := s.Queries.RetireSigningKey(ctx, kid)
+	return retired > 0, err
+}
+
+func (s *signingKeyRepository) seal(kid string, seed []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(s.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(seed)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, seed, []byte(kid)), nil
+}
+
+func (s *signingKeyRepository) open(kid string, sealed []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(s.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("signing key is malformed")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(kid))
+}
+
+func NewSigningKeyRepo(queries *generated.Queries, secret []byte, timeouts utils.Timeouts) SigningKeyRepository {
+	return &signingKeyRepository{
+		Queries:  queries,
+		Secret:   secret,
+		Timeouts: timeouts,
+	}
+}
@@ -0,0 +1,98 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+const (
+	TokenEmailVerification = "email_verification"
+	TokenPasswordReset     = "password_reset"
+)
+
+type TokenRepository interface {
+	// CreateToken issues a single-use token for purpose. Only its keyed hash
+	// is stored, the returned value is what gets sent to the user.
+	CreateToken(ctx context.Context, userID uuid.UUID, purpose string, ttl time.Duration) (string, error)
+	// ConsumeToken marks the token as used and returns its owner. It fails
+	// with pgx.ErrNoRows when the token is unknown, used or expired.
+	ConsumeToken(ctx context.Context, token, purpose string) (uuid.UUID, error)
+	DeleteTokens(ctx context.Context, userID uuid.UUID, purpose string) error
+}
+
+type tokenRepository struct {
+	Queries  *generated.Queries
+	Secret   []byte
+	Timeouts utils.Timeouts
+}
+
+func (t *tokenRepository) CreateToken(ctx context.Context, userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	err := t.Queries.CreateUserToken(ctx, generated.CreateUserTokenParams{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: t.hash(token, purpose),
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  time.Now().Add(ttl),
+			Valid: true,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (t *tokenRepository) ConsumeToken(ctx context.Context, token, purpose string) (uuid.UUID, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	return t.Queries.ConsumeUserToken(ctx, generated.ConsumeUserTokenParams{
+		TokenHash: t.hash(token, purpose),
+		Purpose:   purpose,
+	})
+}
+
+func (t *tokenRepository) DeleteTokens(ctx context.Context, userID uuid.UUID, purpose string) error {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	return t.Queries.DeleteUserTokens(ctx, generated.DeleteUserTokensParams{
+		UserID:  userID,
+		Purpose: purpose,
+	})
+}
+
+// hash signs the token together with its purpose, so a leaked table cannot
+// be replayed and a token cannot be reused for another purpose.
+func (t *tokenRepository) hash(token, purpose string) string {
+	mac := hmac.New(sha256.New, t.Secret)
+	mac.Write([]byte(purpose + ":" + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func NewTokenRepo(queries *generated.Queries, secret []byte, timeouts utils.Timeouts) TokenRepository {
+	return &tokenRepository{
+		Queries:  queries,
+		Secret:   secret,
+		Timeouts: timeouts,
+	}
+}
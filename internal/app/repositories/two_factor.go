@@ -0,0 +1,218 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"chat_backend/pkg/utils"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"strings"
+	"time"
+)
+
+type TwoFactorRepository interface {
+	// SetSecret stores a pending TOTP secret and disables 2FA until it is
+	// confirmed. An empty secret removes it.
+	SetSecret(ctx context.Context, id uuid.UUID, secret string) error
+	Enable(ctx context.Context, id uuid.UUID) error
+	// CreateRecoveryCodes replaces the user's recovery codes and returns the
+	// new ones in plain text. Only their argon2 hashes are stored.
+	CreateRecoveryCodes(ctx context.Context, id uuid.UUID, count int) ([]string, error)
+	// UseRecoveryCode burns the matching unused recovery code, if any.
+	UseRecoveryCode(ctx context.Context, id uuid.UUID, code string) (bool, error)
+	DeleteRecoveryCodes(ctx context.Context, id uuid.UUID) error
+	// GetState returns the second-factor state of the account, which is
+	// empty before its first attempt.
+	GetState(ctx context.Context, id uuid.UUID) (generated.GetTwoFactorStateRow, error)
+	// AcceptStep records the TOTP time step a code was accepted for. It
+	// reports false when the step is not newer than the last accepted one.
+	AcceptStep(ctx context.Context, id uuid.UUID, step int64) (bool, error)
+	// RecordFailure counts a failed attempt, forgetting earlier ones made
+	// before windowStart.
+	RecordFailure(ctx context.Context, id uuid.UUID, windowStart time.Time) error
+	ResetFailures(ctx context.Context, id uuid.UUID) error
+	// UsePreAuthToken marks a pre-auth token used until it expires. It
+	// reports false when it was used already.
+	UsePreAuthToken(ctx context.Context, token string, expiresAt time.Time) (bool, error)
+}
+
+type TwoFactorInput struct {
+	Code string `json:"code" validate:"required|max_len:20"`
+}
+
+type DisableTwoFactorInput struct {
+	Password string `json:"password" validate:"required|max_len:100"`
+}
+
+type twoFactorRepository struct {
+	Queries        *generated.Queries
+	AuthRepository AuthRepository
+	Timeouts       utils.Timeouts
+}
+
+func (t *twoFactorRepository) SetSecret(ctx context.Context, id uuid.UUID, secret string) error {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	return t.Queries.UpdateUserTOTPSecret(ctx, generated.UpdateUserTOTPSecretParams{
+		ID: id,
+		TotpSecret: pgtype.Text{
+			String: secret,
+			Valid:  len(secret) > 0,
+		},
+	})
+}
+
+func (t *twoFactorRepository) Enable(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	return t.Queries.EnableUserTOTP(ctx, id)
+}
+
+func (t *twoFactorRepository) CreateRecoveryCodes(ctx context.Context, id uuid.UUID, count int) ([]string, error) {
+	if err := t.DeleteRecoveryCodes(ctx, id); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, count)
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))[:10]
+
+		hash, err := t.AuthRepository.HashPassword(code)
+		if err != nil {
+			return nil, err
+		}
+
+		queryCtx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+		err = t.Queries.CreateRecoveryCode(queryCtx, generated.CreateRecoveryCodeParams{
+			UserID:   id,
+			CodeHash: string(hash),
+		})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+func (t *twoFactorRepository) UseRecoveryCode(ctx context.Context, id uuid.UUID, code string) (bool, error) {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	recoveryCodes, err := t.Queries.GetRecoveryCodes(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	for _, recoveryCode := range recoveryCodes {
+		ok, err := t.AuthRepository.VerifyPassword(recoveryCode.CodeHash, code)
+		if err != nil || !ok {
+			continue
+		}
+
+		used, err := t.Queries.UseRecoveryCode(ctx, recoveryCode.ID)
+		if err != nil {
+			return false, err
+		}
+
+		return used == 1, nil
+	}
+
+	return false, nil
+}
+
+func (t *twoFactorRepository) DeleteRecoveryCodes(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	return t.Queries.DeleteRecoveryCodes(ctx, id)
+}
+
+func (t *twoFactorRepository) GetState(ctx context.Context, id uuid.UUID) (generated.GetTwoFactorStateRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	state, err := t.Queries.GetTwoFactorState(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return generated.GetTwoFactorStateRow{}, nil
+	}
+
+	return state, err
+}
+
+func (t *twoFactorRepository) AcceptStep(ctx context.Context, id uuid.UUID, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	rows, err := t.Queries.AcceptTOTPStep(ctx, generated.AcceptTOTPStepParams{
+		UserID:   id,
+		LastStep: step,
+	})
+
+	return rows > 0, err
+}
+
+func (t *twoFactorRepository) RecordFailure(ctx context.Context, id uuid.UUID, windowStart time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	return t.Queries.RecordTwoFactorFailure(ctx, generated.RecordTwoFactorFailureParams{
+		UserID: id,
+		Now: pgtype.Timestamptz{
+			Time:  time.Now(),
+			Valid: true,
+		},
+		WindowStart: pgtype.Timestamptz{
+			Time:  windowStart,
+			Valid: true,
+		},
+	})
+}
+
+func (t *twoFactorRepository) ResetFailures(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	return t.Queries.ResetTwoFactorFailures(ctx, id)
+}
+
+func (t *twoFactorRepository) UsePreAuthToken(ctx context.Context, token string, expiresAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeouts.Query)
+	defer cancel()
+
+	sum := sha256.Sum256([]byte(token))
+	rows, err := t.Queries.UsePreAuthToken(ctx, generated.UsePreAuthTokenParams{
+		TokenHash: hex.EncodeToString(sum[:]),
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  expiresAt,
+			Valid: true,
+		},
+	})
+
+	return rows > 0, err
+}
+
+func NewTwoFactorRepo(queries *generated.Queries, repository AuthRepository, timeouts utils.Timeouts) TwoFactorRepository {
+	return &twoFactorRepository{
+		Queries:        queries,
+		AuthRepository: repository,
+		Timeouts:       timeouts,
+	}
+}
@@ -0,0 +1,179 @@
+package repositories
+
+import (
+	"chat_backend/generated"
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Repositories groups the repositories that share a single transaction.
+type Repositories struct {
+	Auth         AuthRepository
+	User         UserRepository
+	Token        TokenRepository
+	TwoFactor    TwoFactorRepository
+	Passkey      PasskeyRepository
+	Identity     IdentityRepository
+	SigningKey   SigningKeyRepository
+	ApiToken     ApiTokenRepository
+	Role         RoleRepository
+	Audit        AuditRepository
+	DataExport   DataExportRepository
+	Notification NotificationRepository
+	Push         PushRepository
+}
+
+// RepositoriesFactory builds the repositories on top of the given queries.
+type RepositoriesFactory func(queries *generated.Queries) Repositories
+
+// Tx is handed to the WithinTx callback. Its repositories run every query
+// inside the transaction.
+type Tx struct {
+	Repositories
+	Queries *generated.Queries
+
+	afterCommit   []func(ctx context.Context)
+	afterRollback []func(ctx context.Context)
+}
+
+// AfterCommit registers fn to run once the transaction has committed.
+func (t *Tx) AfterCommit(fn func(ctx context.Context)) {
+	t.afterCommit = append(t.afterCommit, fn)
+}
+
+// AfterRollback registers fn to run if the transaction is rolled back,
+// including before a retry.
+func (t *Tx) AfterRollback(fn func(ctx context.Context)) {
+	t.afterRollback = append(t.afterRollback, fn)
+}
+
+type TxManager interface {
+	// WithinTx runs fn in a transaction, committing when it returns nil and
+	// rolling back otherwise. Serialization failures and deadlocks are
+	// retried with a fresh transaction.
+	WithinTx(ctx context.Context, fn func(ctx context.Context, tx *Tx) error) error
+}
+
+type TxBeginner interface {
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+type txManager struct {
+	DB          TxBeginner
+	Factory     RepositoriesFactory
+	Options     pgx.TxOptions
+	MaxAttempts int
+}
+
+type txContextKey struct{}
+
+// AfterCommit runs fn once the transaction carried by ctx commits, or right
+// away when ctx is not inside a transaction.
+func AfterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	if tx, ok := ctx.Value(txContextKey{}).(*Tx); ok {
+		tx.AfterCommit(fn)
+		return
+	}
+	fn(ctx)
+}
+
+// AfterRollback runs fn if the transaction carried by ctx rolls back. It is
+// a no-op when ctx is not inside a transaction.
+func AfterRollback(ctx context.Context, fn func(ctx context.Context)) {
+	if tx, ok := ctx.Value(txContextKey{}).(*Tx); ok {
+		tx.AfterRollback(fn)
+	}
+}
+
+func (m *txManager) WithinTx(ctx context.Context, fn func(ctx context.Context, tx *Tx) error) error {
+	var err error
+
+	for attempt := 1; attempt <= m.MaxAttempts; attempt++ {
+		err = m.run(ctx, fn)
+		if err == nil || !isRetryable(err) || attempt == m.MaxAttempts {
+			return err
+		}
+
+		slog.WarnContext(ctx, "retry transaction", "attempt", attempt, "error", err)
+
+		backoff := time.Duration(attempt*attempt)*10*time.Millisecond + time.Duration(rand.Int63n(int64(10*time.Millisecond)))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+
+	return err
+}
+
+func (m *txManager) run(ctx context.Context, fn func(ctx context.Context, tx *Tx) error) (err error) {
+	pgTx, err := m.DB.BeginTx(ctx, m.Options)
+	if err != nil {
+		return err
+	}
+
+	queries := generated.New(pgTx)
+	tx := &Tx{
+		Repositories: m.Factory(queries),
+		Queries:      queries,
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = pgTx.Rollback(context.WithoutCancel(ctx))
+			runHooks(ctx, tx.afterRollback)
+			panic(p)
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, txContextKey{}, tx), tx); err != nil {
+		_ = pgTx.Rollback(context.WithoutCancel(ctx))
+		runHooks(ctx, tx.afterRollback)
+		return err
+	}
+
+	if err = pgTx.Commit(ctx); err != nil {
+		runHooks(ctx, tx.afterRollback)
+		return err
+	}
+
+	runHooks(ctx, tx.afterCommit)
+
+	return nil
+}
+
+func runHooks(ctx context.Context, hooks []func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+}
+
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	// serialization_failure and deadlock_detected
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+func NewTxManager(db TxBeginner, factory RepositoriesFactory, maxAttempts int) TxManager {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	return &txManager{
+		DB:          db,
+		Factory:     factory,
+		Options:     pgx.TxOptions{IsoLevel: pgx.Serializable},
+		MaxAttempts: maxAttempts,
+	}
+}
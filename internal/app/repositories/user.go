@@ -2,58 +2,168 @@ package repositories
 
 import (
 	"chat_backend/generated"
+	"chat_backend/pkg/imaging"
+	"chat_backend/pkg/storage"
+	"chat_backend/pkg/utils"
 	"context"
 	"fmt"
-	"github.com/cloudinary/cloudinary-go/v2"
-	"github.com/cloudinary/cloudinary-go/v2/api"
-	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
+	"io"
+	"log/slog"
 	"mime/multipart"
+	"path"
+	"strings"
+	"time"
 )
 
 type UserRepository interface {
-	GetUserByID(id uuid.UUID) (generated.GetUserByIDRow, error)
-	UpdateUser(input *UpdateInput, id uuid.UUID) error
-	DeleteUser(id uuid.UUID) error
+	GetUserByID(ctx context.Context, id uuid.UUID) (generated.GetUserByIDRow, error)
+	UpdateUser(ctx context.Context, input *UpdateInput, id uuid.UUID) error
+	// ScheduleDeletion marks the account deleted, to be purged at purgeAt.
+	// It reports false when deletion is already pending.
+	ScheduleDeletion(ctx context.Context, id uuid.UUID, purgeAt time.Time) (bool, error)
+	// IsActive reports whether the account exists and is not pending
+	// deletion.
+	IsActive(ctx context.Context, id uuid.UUID) (bool, error)
+	ListUsersToPurge(ctx context.Context, limit int32) ([]uuid.UUID, error)
+	// PurgeUser anonymizes an account whose restore window has ended and
+	// deletes its personal data, clearing its addresses from the audit log.
+	// The row is kept so that references to the user stay valid. It reports
+	// false when the account is not due.
+	PurgeUser(ctx context.Context, id uuid.UUID) (bool, error)
+	// DeleteMedia removes everything the user uploaded under chat_app/<id>/.
+	DeleteMedia(ctx context.Context, id uuid.UUID) error
+	// UploadAvatar stores the square variants of an uploaded image. Uploads
+	// are slow and not rolled back, so they happen before the transaction
+	// that sets the avatar.
+	UploadAvatar(ctx context.Context, id uuid.UUID, file multipart.File) (Avatar, error)
+	// UploadIdenticon stores the variants of the identicon of the account.
+	UploadIdenticon(ctx context.Context, id uuid.UUID) (Avatar, error)
+	// SetAvatar makes an uploaded avatar the current one. The previous one
+	// is deleted once the change is committed.
+	SetAvatar(ctx context.Context, id uuid.UUID, avatar Avatar) error
+	// DiscardAvatar deletes an uploaded avatar that was not set.
+	DiscardAvatar(ctx context.Context, avatar Avatar)
+	// GetPublicUser finds an account that is not pending deletion by its
+	// current username, ignoring case.
+	GetPublicUser(ctx context.Context, username string) (generated.GetPublicUserByUsernameRow, error)
+	// ResolveUsername returns the current username of the account that most
+	// recently gave up username.
+	ResolveUsername(ctx context.Context, username string) (string, error)
+	RecordUsernameChange(ctx context.Context, id uuid.UUID, previous string) error
+	CountUsernameChanges(ctx context.Context, id uuid.UUID, since time.Time) (int64, error)
+	// GetUsernameHolder returns the account that released username most
+	// recently after since, or fails with pgx.ErrNoRows.
+	GetUsernameHolder(ctx context.Context, username string, since time.Time) (uuid.UUID, error)
+	// ListUsernameHistory returns the names the user gave up, newest first.
+	ListUsernameHistory(ctx context.Context, id uuid.UUID) ([]generated.ListUsernameHistoryRow, error)
 }
 
 type UpdateInput struct {
 	Username string         `form:"username,omitempty" validate:"max_len:30"`
 	Password string         `form:"password,omitempty" validate:"max_len:100"`
+	Email    string         `form:"email,omitempty" validate:"email|max_len:254"`
 	Avatar   multipart.File `form:"avatar,omitempty"`
+	// The profile fields are kept when missing and cleared when empty.
+	DisplayName *string `json:"display_name,omitempty" form:"display_name,omitempty" validate:"max_len:64"`
+	Bio         *string `json:"bio,omitempty" form:"bio,omitempty" validate:"max_len:500"`
+	Pronouns    *string `json:"pronouns,omitempty" form:"pronouns,omitempty" validate:"max_len:40"`
+	Timezone    *string `json:"timezone,omitempty" form:"timezone,omitempty" validate:"max_len:64"`
+	Locale      *string `json:"locale,omitempty" form:"locale,omitempty" validate:"max_len:35"`
+	// Setting any of the status fields replaces the whole status. The
+	// expiry is an RFC 3339 time, the status never expires without one.
+	StatusText      *string `json:"status_text,omitempty" form:"status_text,omitempty" validate:"max_len:100"`
+	StatusEmoji     *string `json:"status_emoji,omitempty" form:"status_emoji,omitempty" validate:"max_len:32"`
+	StatusExpiresAt *string `json:"status_expires_at,omitempty" form:"status_expires_at,omitempty" validate:"max_len:40"`
 }
 
+// SetsStatus reports whether the input replaces the status.
+func (i *UpdateInput) SetsStatus() bool {
+	return i.StatusText != nil || i.StatusEmoji != nil || i.StatusExpiresAt != nil
+}
+
+// SetsProfile reports whether the input changes any profile field.
+func (i *UpdateInput) SetsProfile() bool {
+	return i.DisplayName != nil || i.Bio != nil || i.Pronouns != nil || i.Timezone != nil || i.Locale != nil || i.SetsStatus()
+}
+
+// Avatar is an uploaded version of an avatar.
+type Avatar struct {
+	// Prefix is the storage folder holding the variants.
+	Prefix string
+	// URLs are the variants by size.
+	URLs map[int]string
+}
+
+// avatarSizes are the widths of the square avatar variants. The largest is
+// stored as the avatar, the others as its thumbnails.
+var avatarSizes = []int{32, 64, 256, 1024}
+
+const maxAvatarSize = 10 * 1024 * 1024
+
 type userRepository struct {
 	Queries        *generated.Queries
-	Cloudinary     *cloudinary.Cloudinary
+	Storage        storage.Storage
 	AuthRepository AuthRepository
+	Timeouts       utils.Timeouts
 }
 
-func (u *userRepository) DeleteUser(id uuid.UUID) error {
-	return u.Queries.DeleteUser(context.Background(), id)
+func (u *userRepository) ScheduleDeletion(ctx context.Context, id uuid.UUID, purgeAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	rows, err := u.Queries.ScheduleUserDeletion(ctx, generated.ScheduleUserDeletionParams{
+		ID: id,
+		PurgeAt: pgtype.Timestamptz{
+			Time:  purgeAt,
+			Valid: true,
+		},
+	})
+
+	return rows > 0, err
 }
 
-func (u *userRepository) UpdateUser(input *UpdateInput, id uuid.UUID) error {
-	updates := make(map[string]interface{})
+func (u *userRepository) IsActive(ctx context.Context, id uuid.UUID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
 
-	if input.Avatar != nil {
-		upload, err := u.Cloudinary.Upload.Upload(context.Background(), input.Avatar, uploader.UploadParams{
-			Folder:         fmt.Sprintf("chat_app/%v/avatar", id),
-			PublicID:       "avatar",
-			Transformation: "c_crop,g_auto,h_1300,w_1300/f_auto/q_auto:good",
-			AllowedFormats: api.CldAPIArray{"png", "jpeg"},
-		})
-		if err != nil {
-			return err
-		}
+	return u.Queries.IsUserActive(ctx, id)
+}
 
-		updates["avatar"] = pgtype.Text{
-			String: upload.SecureURL,
-			Valid:  true,
-		}
+func (u *userRepository) ListUsersToPurge(ctx context.Context, limit int32) ([]uuid.UUID, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	return u.Queries.ListUsersToPurge(ctx, limit)
+}
+
+func (u *userRepository) PurgeUser(ctx context.Context, id uuid.UUID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	rows, err := u.Queries.PurgeUser(ctx, id)
+	if err != nil || rows == 0 {
+		return false, err
 	}
 
+	if err := u.Queries.DeleteUserPersonalData(ctx, id); err != nil {
+		return false, err
+	}
+
+	return true, u.Queries.ScrubUserAuditEvents(ctx, id)
+}
+
+func (u *userRepository) DeleteMedia(ctx context.Context, id uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Upload)
+	defer cancel()
+
+	return u.Storage.DeletePrefix(ctx, fmt.Sprintf("chat_app/%v/", id))
+}
+
+func (u *userRepository) UpdateUser(ctx context.Context, input *UpdateInput, id uuid.UUID) error {
+	updates := make(map[string]interface{})
+
 	if len(input.Password) > 0 {
 		password, err := u.AuthRepository.HashPassword(input.Password)
 		if err != nil {
@@ -67,22 +177,258 @@ func (u *userRepository) UpdateUser(input *UpdateInput, id uuid.UUID) error {
 		updates["username"] = input.Username
 	}
 
-	return u.Queries.UpdateUser(context.Background(), generated.UpdateUserParams{
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	err := u.Queries.UpdateUser(ctx, generated.UpdateUserParams{
 		Column1: updates["username"],
 		Column2: updates["password"],
-		Column3: updates["avatar"],
 		ID:      id,
 	})
+	if err != nil || !input.SetsProfile() {
+		return err
+	}
+
+	params := generated.UpdateUserProfileParams{
+		DisplayName: optionalText(input.DisplayName),
+		Bio:         optionalText(input.Bio),
+		Pronouns:    optionalText(input.Pronouns),
+		Timezone:    optionalText(input.Timezone),
+		Locale:      optionalText(input.Locale),
+		SetStatus:   input.SetsStatus(),
+		StatusText:  optionalText(input.StatusText),
+		StatusEmoji: optionalText(input.StatusEmoji),
+		ID:          id,
+	}
+
+	if input.StatusExpiresAt != nil && len(*input.StatusExpiresAt) > 0 {
+		expiresAt, err := time.Parse(time.RFC3339, *input.StatusExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		params.StatusExpiresAt = pgtype.Timestamptz{
+			Time:  expiresAt,
+			Valid: true,
+		}
+	}
+
+	return u.Queries.UpdateUserProfile(ctx, params)
+}
+
+// optionalText maps a missing field to NULL and a present one, even empty,
+// to its value.
+func optionalText(s *string) pgtype.Text {
+	if s == nil {
+		return pgtype.Text{}
+	}
+
+	return pgtype.Text{
+		String: *s,
+		Valid:  true,
+	}
+}
+
+func (u *userRepository) SetAvatar(ctx context.Context, id uuid.UUID, avatar Avatar) error {
+	current, err := u.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	text := func(size int) pgtype.Text {
+		return pgtype.Text{
+			String: avatar.URLs[size],
+			Valid:  true,
+		}
+	}
+
+	err = u.Queries.UpdateUserAvatar(ctx, generated.UpdateUserAvatarParams{
+		ID:        id,
+		Avatar:    text(1024),
+		Avatar32:  text(32),
+		Avatar64:  text(64),
+		Avatar256: text(256),
+	})
+	if err != nil {
+		return err
+	}
+
+	folder := fmt.Sprintf("chat_app/%v/avatar", id)
+	if previous := avatarPrefix(current.Avatar.String, folder); len(previous) > 0 && previous != avatar.Prefix {
+		AfterCommit(ctx, func(ctx context.Context) {
+			u.deleteAvatar(ctx, previous)
+		})
+	}
+
+	return nil
+}
+
+func (u *userRepository) UploadAvatar(ctx context.Context, id uuid.UUID, file multipart.File) (Avatar, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return Avatar{}, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAvatarSize))
+	if err != nil {
+		return Avatar{}, err
+	}
+
+	variants, err := imaging.Square(data, avatarSizes)
+	if err != nil {
+		return Avatar{}, err
+	}
+
+	return u.storeAvatar(ctx, id, variants)
+}
+
+func (u *userRepository) UploadIdenticon(ctx context.Context, id uuid.UUID) (Avatar, error) {
+	variants, err := imaging.NewIdenticon(id[:]).Variants(avatarSizes)
+	if err != nil {
+		return Avatar{}, err
+	}
+
+	return u.storeAvatar(ctx, id, variants)
+}
+
+func (u *userRepository) DiscardAvatar(ctx context.Context, avatar Avatar) {
+	if len(avatar.Prefix) > 0 {
+		u.deleteAvatar(context.WithoutCancel(ctx), avatar.Prefix)
+	}
+}
+
+// storeAvatar puts the variants under a fresh version so that the current
+// ones stay valid until the new one is set. A partial upload is removed.
+func (u *userRepository) storeAvatar(ctx context.Context, id uuid.UUID, variants []imaging.Variant) (Avatar, error) {
+	avatar := Avatar{
+		Prefix: fmt.Sprintf("chat_app/%v/avatar/%s/", id, uuid.NewString()),
+		URLs:   make(map[int]string, len(variants)),
+	}
+
+	for _, variant := range variants {
+		url, err := u.putAvatar(ctx, fmt.Sprintf("%s%d%s", avatar.Prefix, variant.Size, variant.Ext), variant)
+		if err != nil {
+			u.DiscardAvatar(ctx, avatar)
+			return Avatar{}, err
+		}
+
+		avatar.URLs[variant.Size] = url
+	}
+
+	return avatar, nil
+}
+
+func (u *userRepository) putAvatar(ctx context.Context, key string, variant imaging.Variant) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Upload)
+	defer cancel()
+
+	return u.Storage.Put(ctx, key, variant.Data, variant.ContentType)
+}
+
+// deleteAvatar removes a version of the avatar. A prefix without the
+// trailing slash is a single upload from before avatars had variants.
+func (u *userRepository) deleteAvatar(ctx context.Context, prefix string) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Upload)
+	defer cancel()
+
+	var err error
+	if strings.HasSuffix(prefix, "/") {
+		err = u.Storage.DeletePrefix(ctx, prefix)
+	} else {
+		err = u.Storage.Delete(ctx, prefix)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "delete avatar", "prefix", prefix, "error", err)
+	}
+}
+
+// avatarPrefix finds the version of the avatar a URL points to, or returns
+// an empty string when the URL does not point into folder.
+func avatarPrefix(url, folder string) string {
+	i := strings.Index(url, folder+"/")
+	if i < 0 {
+		return ""
+	}
+
+	key := url[i:]
+	if j := strings.Index(key[len(folder)+1:], "/"); j >= 0 {
+		return key[:len(folder)+1+j+1]
+	}
+
+	return strings.TrimSuffix(key, path.Ext(key))
+}
+
+func (u *userRepository) GetUserByID(ctx context.Context, id uuid.UUID) (generated.GetUserByIDRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	return u.Queries.GetUserByID(ctx, id)
+}
+
+func (u *userRepository) GetPublicUser(ctx context.Context, username string) (generated.GetPublicUserByUsernameRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	return u.Queries.GetPublicUserByUsername(ctx, username)
+}
+
+func (u *userRepository) ResolveUsername(ctx context.Context, username string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	return u.Queries.ResolveUsername(ctx, username)
+}
+
+func (u *userRepository) RecordUsernameChange(ctx context.Context, id uuid.UUID, previous string) error {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	return u.Queries.CreateUsernameHistory(ctx, generated.CreateUsernameHistoryParams{
+		UserID:   id,
+		Username: previous,
+	})
 }
 
-func (u *userRepository) GetUserByID(id uuid.UUID) (generated.GetUserByIDRow, error) {
-	return u.Queries.GetUserByID(context.Background(), id)
+func (u *userRepository) CountUsernameChanges(ctx context.Context, id uuid.UUID, since time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	return u.Queries.CountUsernameChanges(ctx, generated.CountUsernameChangesParams{
+		UserID: id,
+		ReleasedAt: pgtype.Timestamptz{
+			Time:  since,
+			Valid: true,
+		},
+	})
+}
+
+func (u *userRepository) ListUsernameHistory(ctx context.Context, id uuid.UUID) ([]generated.ListUsernameHistoryRow, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	return u.Queries.ListUsernameHistory(ctx, id)
+}
+
+func (u *userRepository) GetUsernameHolder(ctx context.Context, username string, since time.Time) (uuid.UUID, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.Timeouts.Query)
+	defer cancel()
+
+	return u.Queries.GetUsernameHolder(ctx, generated.GetUsernameHolderParams{
+		Username: username,
+		ReleasedAfter: pgtype.Timestamptz{
+			Time:  since,
+			Valid: true,
+		},
+	})
 }
 
-func NewUserRepo(queries *generated.Queries, cld *cloudinary.Cloudinary, repository AuthRepository) UserRepository {
+func NewUserRepo(queries *generated.Queries, store storage.Storage, repository AuthRepository, timeouts utils.Timeouts) UserRepository {
 	return &userRepository{
 		Queries:        queries,
-		Cloudinary:     cld,
+		Storage:        store,
 		AuthRepository: repository,
+		Timeouts:       timeouts,
 	}
 }
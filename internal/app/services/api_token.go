@@ -0,0 +1,138 @@
+package services
+
+import (
+	"chat_backend/generated"
+	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/utils"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeReadMessages  = "read:messages"
+	ScopeWriteMessages = "write:messages"
+	ScopeProfile       = "profile"
+	ScopeAdmin         = "admin"
+)
+
+// Scopes lists every scope an API token can be granted.
+var Scopes = []string{ScopeReadMessages, ScopeWriteMessages, ScopeProfile, ScopeAdmin}
+
+var (
+	ErrInvalidApiToken  = errors.New("api token is invalid")
+	ErrInvalidScope     = errors.New("scope does not exist")
+	ErrInvalidExpiry    = errors.New("expiry is in the past")
+	ErrTooManyApiTokens = errors.New("api token limit reached")
+)
+
+// CreatedApiToken is returned once, when the token is created. Afterwards
+// only its prefix is shown.
+type CreatedApiToken struct {
+	ID        uuid.UUID  `json:"id"`
+	Name      string     `json:"name"`
+	Token     string     `json:"token"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ApiTokenService manages the personal access tokens that scripts use
+// instead of a password. A token acts for its owner, limited to its scopes.
+type ApiTokenService interface {
+	CreateToken(ctx context.Context, userID uuid.UUID, input *repositories.ApiTokenInput) (CreatedApiToken, error)
+	// Authenticate returns the owner and scopes of a valid token.
+	Authenticate(ctx context.Context, token string) (uuid.UUID, []string, error)
+	ListTokens(ctx context.Context, userID uuid.UUID) ([]generated.ListApiTokensRow, error)
+	RevokeToken(ctx context.Context, userID, id uuid.UUID) (bool, error)
+}
+
+type apiTokenService struct {
+	apiTokenRepository repositories.ApiTokenRepository
+	limit              int
+}
+
+// IsApiToken reports whether token looks like an API token rather than a
+// session token.
+func IsApiToken(token string) bool {
+	return strings.HasPrefix(token, repositories.ApiTokenPrefix)
+}
+
+func (a *apiTokenService) CreateToken(ctx context.Context, userID uuid.UUID, input *repositories.ApiTokenInput) (CreatedApiToken, error) {
+	scopes := make([]string, 0, len(input.Scopes))
+	for _, scope := range input.Scopes {
+		if !slices.Contains(Scopes, scope) {
+			return CreatedApiToken{}, ErrInvalidScope
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	input.Scopes = scopes
+
+	if !input.ExpiresAt.IsZero() && !input.ExpiresAt.After(time.Now()) {
+		return CreatedApiToken{}, ErrInvalidExpiry
+	}
+
+	count, err := a.apiTokenRepository.CountTokens(ctx, userID)
+	if err != nil {
+		return CreatedApiToken{}, err
+	}
+	if count >= int64(a.limit) {
+		return CreatedApiToken{}, ErrTooManyApiTokens
+	}
+
+	token, row, err := a.apiTokenRepository.CreateToken(ctx, userID, input)
+	if err != nil {
+		return CreatedApiToken{}, err
+	}
+
+	created := CreatedApiToken{
+		ID:        row.ID,
+		Name:      input.Name,
+		Token:     token,
+		Scopes:    scopes,
+		CreatedAt: row.CreatedAt.Time,
+	}
+	if !input.ExpiresAt.IsZero() {
+		created.ExpiresAt = &input.ExpiresAt
+	}
+
+	return created, nil
+}
+
+func (a *apiTokenService) Authenticate(ctx context.Context, token string) (uuid.UUID, []string, error) {
+	row, err := a.apiTokenRepository.GetToken(ctx, token)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, nil, ErrInvalidApiToken
+	}
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	if err := a.apiTokenRepository.TouchToken(ctx, row.ID); err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	return row.UserID, row.Scopes, nil
+}
+
+func (a *apiTokenService) ListTokens(ctx context.Context, userID uuid.UUID) ([]generated.ListApiTokensRow, error) {
+	return a.apiTokenRepository.ListTokens(ctx, userID)
+}
+
+func (a *apiTokenService) RevokeToken(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	return a.apiTokenRepository.DeleteToken(ctx, userID, id)
+}
+
+// NewApiTokenService allows each user API_TOKEN_LIMIT tokens, 50 by default.
+func NewApiTokenService(r repositories.ApiTokenRepository) ApiTokenService {
+	return &apiTokenService{
+		apiTokenRepository: r,
+		limit:              utils.GetEnvInt("API_TOKEN_LIMIT", 50),
+	}
+}
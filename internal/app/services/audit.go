@@ -0,0 +1,238 @@
+package services
+
+import (
+	"chat_backend/generated"
+	"chat_backend/internal/app/repositories"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"strings"
+	"time"
+)
+
+const (
+	AuditSignup           = "user.signup"
+	AuditLogin            = "auth.login"
+	AuditLoginFailed      = "auth.login_failed"
+	AuditSignout          = "auth.signout"
+	AuditPasswordChange   = "user.password_change"
+	AuditPasswordReset    = "user.password_reset"
+	AuditUsernameChange   = "user.username_change"
+	AuditEmailChange      = "user.email_change"
+	AuditAvatarChange     = "user.avatar_change"
+	AuditAccountDelete    = "user.delete"
+	AuditAccountRestore   = "user.restore"
+	AuditTwoFactorEnable  = "user.two_factor_enable"
+	AuditTwoFactorDisable = "user.two_factor_disable"
+	AuditApiTokenCreate   = "user.api_token_create"
+	AuditApiTokenRevoke   = "user.api_token_revoke"
+	AuditDataExport       = "user.data_export"
+	AuditRoleAssign       = "admin.role_assign"
+	AuditRoleRemove       = "admin.role_remove"
+)
+
+const (
+	auditPageSize    = 50
+	maxUserAgentSize = 255
+)
+
+var ErrInvalidAuditFilter = errors.New("audit filter is invalid")
+
+// AuditEvent is a security-relevant event. ActorID is the user who caused it
+// and TargetID the user it concerns, either is uuid.Nil when unknown.
+type AuditEvent struct {
+	Action    string
+	ActorID   uuid.UUID
+	TargetID  uuid.UUID
+	IP        string
+	UserAgent string
+	RequestID string
+	Metadata  map[string]interface{}
+}
+
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Action    string          `json:"action"`
+	ActorID   *uuid.UUID      `json:"actor_id"`
+	TargetID  *uuid.UUID      `json:"target_id"`
+	IP        string          `json:"ip"`
+	UserAgent string          `json:"user_agent"`
+	RequestID string          `json:"request_id"`
+	Metadata  json.RawMessage `json:"metadata"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// AuditPage holds a page of events, newest first. Next is passed as before
+// to get the following page, it is nil on the last one.
+type AuditPage struct {
+	Events []AuditEntry `json:"events"`
+	Next   *int64       `json:"next"`
+}
+
+// AuditService keeps the append-only trail of security-relevant events.
+type AuditService interface {
+	Record(ctx context.Context, event AuditEvent) error
+	ListEvents(ctx context.Context, filter *repositories.AuditFilter) (AuditPage, error)
+	ListUserEvents(ctx context.Context, userID uuid.UUID, before int64, limit int) (AuditPage, error)
+}
+
+type auditService struct {
+	auditRepository repositories.AuditRepository
+}
+
+func (a *auditService) Record(ctx context.Context, event AuditEvent) error {
+	metadata := []byte("{}")
+	if len(event.Metadata) > 0 {
+		var err error
+		if metadata, err = json.Marshal(event.Metadata); err != nil {
+			return err
+		}
+	}
+
+	userAgent := event.UserAgent
+	if len(userAgent) > maxUserAgentSize {
+		userAgent = userAgent[:maxUserAgentSize]
+	}
+	userAgent = strings.ToValidUTF8(userAgent, "")
+
+	return a.auditRepository.CreateEvent(ctx, generated.CreateAuditEventParams{
+		Action:    event.Action,
+		ActorID:   optionalUUID(event.ActorID),
+		TargetID:  optionalUUID(event.TargetID),
+		Ip:        event.IP,
+		UserAgent: userAgent,
+		RequestID: event.RequestID,
+		Metadata:  metadata,
+	})
+}
+
+func (a *auditService) ListEvents(ctx context.Context, filter *repositories.AuditFilter) (AuditPage, error) {
+	params := generated.ListAuditEventsParams{
+		Action: pgtype.Text{
+			String: filter.Action,
+			Valid:  len(filter.Action) > 0,
+		},
+		Before: pgtype.Int8{
+			Int64: filter.Before,
+			Valid: filter.Before > 0,
+		},
+		Limit: pageSize(filter.Limit),
+	}
+
+	var err error
+	if params.ActorID, err = parseOptionalUUID(filter.Actor); err != nil {
+		return AuditPage{}, err
+	}
+	if params.TargetID, err = parseOptionalUUID(filter.Target); err != nil {
+		return AuditPage{}, err
+	}
+	if params.Since, err = parseOptionalTime(filter.Since); err != nil {
+		return AuditPage{}, err
+	}
+	if params.Until, err = parseOptionalTime(filter.Until); err != nil {
+		return AuditPage{}, err
+	}
+
+	events, err := a.auditRepository.ListEvents(ctx, params)
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	return newAuditPage(events, params.Limit), nil
+}
+
+func (a *auditService) ListUserEvents(ctx context.Context, userID uuid.UUID, before int64, limit int) (AuditPage, error) {
+	events, err := a.auditRepository.ListUserEvents(ctx, userID, before, pageSize(limit))
+	if err != nil {
+		return AuditPage{}, err
+	}
+
+	return newAuditPage(events, pageSize(limit)), nil
+}
+
+func newAuditPage(events []generated.AuditEvent, limit int32) AuditPage {
+	page := AuditPage{
+		Events: make([]AuditEntry, len(events)),
+	}
+
+	for i, event := range events {
+		page.Events[i] = AuditEntry{
+			ID:        event.ID,
+			Action:    event.Action,
+			ActorID:   uuidOrNil(event.ActorID),
+			TargetID:  uuidOrNil(event.TargetID),
+			IP:        event.Ip,
+			UserAgent: event.UserAgent,
+			RequestID: event.RequestID,
+			Metadata:  event.Metadata,
+			CreatedAt: event.CreatedAt.Time,
+		}
+	}
+
+	if len(events) == int(limit) {
+		page.Next = &events[len(events)-1].ID
+	}
+
+	return page
+}
+
+func pageSize(limit int) int32 {
+	if limit <= 0 || limit > 100 {
+		return auditPageSize
+	}
+
+	return int32(limit)
+}
+
+func optionalUUID(id uuid.UUID) pgtype.UUID {
+	return pgtype.UUID{
+		Bytes: id,
+		Valid: id != uuid.Nil,
+	}
+}
+
+func uuidOrNil(id pgtype.UUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+
+	value := uuid.UUID(id.Bytes)
+	return &value
+}
+
+func parseOptionalUUID(value string) (pgtype.UUID, error) {
+	if len(value) == 0 {
+		return pgtype.UUID{}, nil
+	}
+
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return pgtype.UUID{}, ErrInvalidAuditFilter
+	}
+
+	return optionalUUID(id), nil
+}
+
+func parseOptionalTime(value string) (pgtype.Timestamptz, error) {
+	if len(value) == 0 {
+		return pgtype.Timestamptz{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return pgtype.Timestamptz{}, ErrInvalidAuditFilter
+	}
+
+	return pgtype.Timestamptz{
+		Time:  t,
+		Valid: true,
+	}, nil
+}
+
+func NewAuditService(r repositories.AuditRepository) AuditService {
+	return &auditService{
+		auditRepository: r,
+	}
+}
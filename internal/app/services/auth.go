@@ -3,17 +3,48 @@ package services
 import (
 	"chat_backend/generated"
 	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/mailer"
+	"chat_backend/pkg/password"
+	"chat_backend/pkg/utils"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log/slog"
+	"strings"
 )
 
 type AuthService interface {
-	GetUserByUsername(username string) (generated.User, error)
-	CreateNewUser(input *repositories.AuthInput) error
+	GetUserByUsername(ctx context.Context, username string) (generated.User, error)
+	GetUserByEmail(ctx context.Context, email string) (generated.User, error)
+	CreateNewUser(ctx context.Context, input *repositories.AuthInput) error
 	HashPassword(password string) ([]byte, error)
 	VerifyPassword(currentPassword, password string) (bool, error)
+	// UpgradePassword rehashes the password of user after a successful login
+	// when its stored hash uses weaker parameters than the configured ones.
+	UpgradePassword(ctx context.Context, user generated.User, password string) error
+	// ForgotPassword mails a reset link when email belongs to a verified
+	// address. It returns before looking the address up, so that unknown
+	// addresses are answered as fast as known ones; failures are logged.
+	// Requests beyond MAIL_CONCURRENCY in flight are dropped.
+	ForgotPassword(ctx context.Context, email string)
+	// ResetPassword returns the ID of the user whose password was reset.
+	ResetPassword(ctx context.Context, input *repositories.ResetPasswordInput) (uuid.UUID, error)
+	VerifyEmail(ctx context.Context, token string) error
+	// RestoreUser cancels the pending deletion of the account, which signing
+	// in does during the restore window. It reports whether one was pending.
+	RestoreUser(ctx context.Context, id uuid.UUID) (bool, error)
 }
 
 type authService struct {
 	authRepository repositories.AuthRepository
+	userRepository repositories.UserRepository
+	txManager      repositories.TxManager
+	mailer         mailer.Mailer
+	passwordPolicy *password.Policy
+	usernamePolicy *UsernamePolicy
+	jobs           *background
 }
 
 func (a *authService) HashPassword(password string) ([]byte, error) {
@@ -24,16 +55,145 @@ func (a *authService) VerifyPassword(currentPassword, password string) (bool, er
 	return a.authRepository.VerifyPassword(currentPassword, password)
 }
 
-func (a *authService) CreateNewUser(input *repositories.AuthInput) error {
-	return a.authRepository.CreateNewUser(input)
+func (a *authService) UpgradePassword(ctx context.Context, user generated.User, password string) error {
+	if !a.authRepository.NeedsRehash(user.Password) {
+		return nil
+	}
+
+	return a.authRepository.RehashPassword(ctx, user.ID, user.Password, password)
+}
+
+func (a *authService) CreateNewUser(ctx context.Context, input *repositories.AuthInput) error {
+	if err := a.passwordPolicy.Check(input.Password, input.Username); err != nil {
+		return err
+	}
+
+	var userID uuid.UUID
+
+	err := a.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		if err := a.usernamePolicy.checkAvailable(ctx, tx, uuid.Nil, input.Username); err != nil {
+			return err
+		}
+
+		if err := tx.Auth.CreateNewUser(ctx, input); err != nil {
+			// The checks above race with other signups.
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+				if strings.Contains(pgErr.ConstraintName, "email") {
+					return ErrEmailTaken
+				}
+				return ErrUsernameTaken
+			}
+			return err
+		}
+
+		user, err := tx.Auth.GetUserByUsername(ctx, input.Username)
+		if err != nil {
+			return err
+		}
+
+		userID = user.ID
+
+		if len(input.Email) == 0 {
+			return nil
+		}
+
+		return sendTokenMail(ctx, tx, a.jobs, a.mailer, user.ID, user.Email.String, repositories.TokenEmailVerification)
+	})
+	if err != nil {
+		return err
+	}
+
+	// A storage failure leaves the account without an avatar.
+	if err := generateAvatar(ctx, a.userRepository, userID); err != nil {
+		slog.ErrorContext(ctx, "generate avatar", "error", err)
+	}
+
+	return nil
+}
+
+func (a *authService) GetUserByUsername(ctx context.Context, username string) (generated.User, error) {
+	return a.authRepository.GetUserByUsername(ctx, username)
+}
+
+func (a *authService) GetUserByEmail(ctx context.Context, email string) (generated.User, error) {
+	return a.authRepository.GetUserByEmail(ctx, email)
+}
+
+func (a *authService) ForgotPassword(ctx context.Context, email string) {
+	a.jobs.Go(ctx, "forgot password", utils.GetTimeouts().Request, func(ctx context.Context) error {
+		return a.forgotPassword(ctx, email)
+	})
+}
+
+func (a *authService) forgotPassword(ctx context.Context, email string) error {
+	user, err := a.authRepository.GetUserByEmail(ctx, email)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !user.EmailVerifiedAt.Valid) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		return sendTokenMail(ctx, tx, a.jobs, a.mailer, user.ID, user.Email.String, repositories.TokenPasswordReset)
+	})
+}
+
+func (a *authService) ResetPassword(ctx context.Context, input *repositories.ResetPasswordInput) (uuid.UUID, error) {
+	var userID uuid.UUID
+
+	err := a.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		var err error
+		userID, err = tx.Token.ConsumeToken(ctx, input.Token, repositories.TokenPasswordReset)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		user, err := tx.Auth.GetUserAccountByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := a.passwordPolicy.Check(input.Password, user.Username); err != nil {
+			return err
+		}
+
+		return tx.Auth.UpdatePassword(ctx, userID, input.Password)
+	})
+
+	return userID, err
+}
+
+func (a *authService) VerifyEmail(ctx context.Context, token string) error {
+	return a.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		userID, err := tx.Token.ConsumeToken(ctx, token, repositories.TokenEmailVerification)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Auth.VerifyEmail(ctx, userID)
+	})
 }
 
-func (a *authService) GetUserByUsername(username string) (generated.User, error) {
-	return a.authRepository.GetUserByUsername(username)
+func (a *authService) RestoreUser(ctx context.Context, id uuid.UUID) (bool, error) {
+	return a.authRepository.RestoreUser(ctx, id)
 }
 
-func NewAuthService(r repositories.AuthRepository) AuthService {
+func NewAuthService(r repositories.AuthRepository, userRepo repositories.UserRepository, txManager repositories.TxManager, m mailer.Mailer, policy *password.Policy, usernames *UsernamePolicy) AuthService {
 	return &authService{
 		authRepository: r,
+		userRepository: userRepo,
+		txManager:      txManager,
+		mailer:         m,
+		passwordPolicy: policy,
+		usernamePolicy: usernames,
+		jobs:           newBackground(utils.GetEnvInt("MAIL_CONCURRENCY", 16)),
 	}
 }
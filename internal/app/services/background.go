@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// background runs jobs off the request path, at most cap(slots) at a time.
+// A job that finds every slot busy is dropped with a log, so that a flood of
+// requests piles up neither goroutines nor mail.
+type background struct {
+	slots chan struct{}
+}
+
+// Go runs job in a goroutine with timeout, detached from the cancellation of
+// ctx. Failures are logged.
+func (b *background) Go(ctx context.Context, name string, timeout time.Duration, job func(ctx context.Context) error) {
+	select {
+	case b.slots <- struct{}{}:
+	default:
+		slog.WarnContext(ctx, "drop background job", "job", name)
+		return
+	}
+
+	go func() {
+		defer func() { <-b.slots }()
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+
+		if err := job(ctx); err != nil {
+			slog.ErrorContext(ctx, "run background job", "job", name, "error", err)
+		}
+	}()
+}
+
+func newBackground(size int) *background {
+	return &background{
+		slots: make(chan struct{}, size),
+	}
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"chat_backend/generated"
+	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/mailer"
+	"chat_backend/pkg/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+)
+
+const (
+	// exportStaleAfter is how long an export may stay running before another
+	// worker takes it over.
+	exportStaleAfter = 15 * time.Minute
+	maxAvatarSize    = 20 * 1024 * 1024
+)
+
+var ErrExportInProgress = errors.New("a data export is already in progress")
+
+// DataExportService builds the archives users download of their data.
+type DataExportService interface {
+	// RequestExport queues an export. Only one can be in progress at a time.
+	RequestExport(ctx context.Context, userID uuid.UUID) (generated.CreateDataExportRow, error)
+	ListExports(ctx context.Context, userID uuid.UUID) ([]generated.ListDataExportsRow, error)
+	// GetArchive fails with pgx.ErrNoRows unless the export is ready.
+	GetArchive(ctx context.Context, userID, id uuid.UUID) ([]byte, error)
+	// ProcessExports builds the queued archives, mails their owners and
+	// deletes the expired ones. It returns the number of archives built.
+	ProcessExports(ctx context.Context) (int, error)
+}
+
+// exportData is the machine-readable part of the archive, data.json.
+type exportData struct {
+	ExportedAt           time.Time                            `json:"exported_at"`
+	Profile              generated.GetUserByIDRow             `json:"profile"`
+	UsernameHistory      []generated.ListUsernameHistoryRow   `json:"username_history"`
+	Roles                []string                             `json:"roles"`
+	Passkeys             []generated.ListPasskeysRow          `json:"passkeys"`
+	Identities           []generated.ListLinkedIdentitiesRow  `json:"identities"`
+	ApiTokens            []generated.ListApiTokensRow         `json:"api_tokens"`
+	NotificationSettings NotificationSettings                 `json:"notification_settings"`
+	PushSubscriptions    []generated.ListPushSubscriptionsRow `json:"push_subscriptions"`
+	PushDevices          []generated.ListPushDevicesRow       `json:"push_devices"`
+	SecurityActivity     []AuditEntry                         `json:"security_activity"`
+	Avatar               string                               `json:"avatar,omitempty"`
+}
+
+var exportIndex = template.Must(template.New("index.html").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Data export of {{.Profile.Username}}</title>
+</head>
+<body>
+<h1>Data export of {{.Profile.Username}}</h1>
+<p>Exported on {{.ExportedAt.Format "2006-01-02 15:04 MST"}}. The same data is in data.json.</p>
+
+<h2>Profile</h2>
+<dl>
+<dt>Username</dt><dd>{{.Profile.Username}}</dd>
+{{if .Profile.DisplayName.Valid}}<dt>Display name</dt><dd>{{.Profile.DisplayName.String}}</dd>{{end}}
+{{if .Profile.Pronouns.Valid}}<dt>Pronouns</dt><dd>{{.Profile.Pronouns.String}}</dd>{{end}}
+{{if .Profile.Bio.Valid}}<dt>Bio</dt><dd>{{.Profile.Bio.String}}</dd>{{end}}
+{{if .Profile.Timezone.Valid}}<dt>Timezone</dt><dd>{{.Profile.Timezone.String}}</dd>{{end}}
+{{if .Profile.Locale.Valid}}<dt>Locale</dt><dd>{{.Profile.Locale.String}}</dd>{{end}}
+{{if .Profile.Email.Valid}}<dt>Email</dt><dd>{{.Profile.Email.String}}{{if not .Profile.EmailVerifiedAt.Valid}} (not verified){{end}}</dd>{{end}}
+<dt>Joined</dt><dd>{{.Profile.CreatedAt.Time.Format "2006-01-02"}}</dd>
+{{if .Avatar}}<dt>Avatar</dt><dd><img src="{{.Avatar}}" alt="Avatar" width="128"></dd>{{end}}
+<dt>Roles</dt><dd>{{range $i, $role := .Roles}}{{if $i}}, {{end}}{{$role}}{{else}}none{{end}}</dd>
+</dl>
+
+<h2>Previous usernames</h2>
+<ul>{{range .UsernameHistory}}<li>{{.Username}}, given up {{.ReleasedAt.Time.Format "2006-01-02"}}</li>{{else}}<li>None</li>{{end}}</ul>
+
+<h2>Passkeys</h2>
+<ul>{{range .Passkeys}}<li>{{.Name}}, added {{.CreatedAt.Time.Format "2006-01-02"}}</li>{{else}}<li>None</li>{{end}}</ul>
+
+<h2>Linked identities</h2>
+<ul>{{range .Identities}}<li>{{.Provider}}{{if .Email.Valid}} ({{.Email.String}}){{end}}</li>{{else}}<li>None</li>{{end}}</ul>
+
+<h2>API tokens</h2>
+<ul>{{range .ApiTokens}}<li>{{.Name}} ({{.Prefix}}…), created {{.CreatedAt.Time.Format "2006-01-02"}}</li>{{else}}<li>None</li>{{end}}</ul>
+
+<h2>Notification settings</h2>
+<dl>
+<dt>Notify of</dt><dd>{{.NotificationSettings.Level}}</dd>
+{{with .NotificationSettings.MutedUntil}}<dt>Muted until</dt><dd>{{.Format "2006-01-02 15:04 MST"}}</dd>{{end}}
+{{if .NotificationSettings.DNDStart}}<dt>Do not disturb</dt><dd>{{.NotificationSettings.DNDStart}} to {{.NotificationSettings.DNDEnd}} ({{.NotificationSettings.Timezone}})</dd>{{end}}
+</dl>
+
+<h2>Browser push subscriptions</h2>
+<ul>{{range .PushSubscriptions}}<li>{{.UserAgent}}, added {{.CreatedAt.Time.Format "2006-01-02"}}</li>{{else}}<li>None</li>{{end}}</ul>
+
+<h2>Mobile devices</h2>
+<ul>{{range .PushDevices}}<li>{{.Platform}}, added {{.CreatedAt.Time.Format "2006-01-02"}}</li>{{else}}<li>None</li>{{end}}</ul>
+
+<h2>Security activity</h2>
+<table>
+<tr><th>Time</th><th>Event</th><th>IP</th><th>User agent</th></tr>
+{{range .SecurityActivity}}<tr><td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td><td>{{.Action}}</td><td>{{.IP}}</td><td>{{.UserAgent}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type dataExportService struct {
+	repos     repositories.Repositories
+	txManager repositories.TxManager
+	mailer    mailer.Mailer
+	client    *http.Client
+	ttl       time.Duration
+}
+
+func (d *dataExportService) RequestExport(ctx context.Context, userID uuid.UUID) (generated.CreateDataExportRow, error) {
+	var export generated.CreateDataExportRow
+
+	err := d.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		active, err := tx.DataExport.CountActiveExports(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if active > 0 {
+			return ErrExportInProgress
+		}
+
+		export, err = tx.DataExport.CreateExport(ctx, userID)
+		return err
+	})
+
+	return export, err
+}
+
+func (d *dataExportService) ListExports(ctx context.Context, userID uuid.UUID) ([]generated.ListDataExportsRow, error) {
+	return d.repos.DataExport.ListExports(ctx, userID)
+}
+
+func (d *dataExportService) GetArchive(ctx context.Context, userID, id uuid.UUID) ([]byte, error) {
+	return d.repos.DataExport.GetArchive(ctx, userID, id)
+}
+
+func (d *dataExportService) ProcessExports(ctx context.Context) (int, error) {
+	built := 0
+	var errs []error
+
+	for {
+		export, err := d.repos.DataExport.ClaimExport(ctx, exportStaleAfter)
+		if errors.Is(err, pgx.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		expiresAt := time.Now().Add(d.ttl)
+
+		archive, profile, err := d.buildArchive(ctx, export.UserID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("build export %v: %w", export.ID, err))
+			if err := d.repos.DataExport.FailExport(ctx, export.ID, expiresAt); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if err := d.repos.DataExport.CompleteExport(ctx, export.ID, archive, expiresAt); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		built++
+
+		if profile.Email.Valid && profile.EmailVerifiedAt.Valid {
+			d.notify(ctx, profile.Email.String, expiresAt)
+		}
+	}
+
+	if _, err := d.repos.DataExport.DeleteExpiredExports(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	return built, errors.Join(errs...)
+}
+
+func (d *dataExportService) notify(ctx context.Context, email string, expiresAt time.Time) {
+	err := d.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf("The archive of your data can be downloaded from your settings:\n\n%s\n\nIt is available until %s.",
+			utils.GetEnv("CLIENT_URL", "")+"/settings/export", expiresAt.UTC().Format(time.RFC1123)),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "send mail", "purpose", "data_export", "error", err)
+	}
+}
+
+// buildArchive collects the user's data into a ZIP holding data.json, a
+// human-readable index.html and the avatar.
+func (d *dataExportService) buildArchive(ctx context.Context, userID uuid.UUID) ([]byte, generated.GetUserByIDRow, error) {
+	data := exportData{
+		ExportedAt: time.Now().UTC(),
+	}
+
+	var err error
+	if data.Profile, err = d.repos.User.GetUserByID(ctx, userID); err != nil {
+		return nil, data.Profile, err
+	}
+	if data.UsernameHistory, err = d.repos.User.ListUsernameHistory(ctx, userID); err != nil {
+		return nil, data.Profile, err
+	}
+	if data.Roles, err = d.repos.Role.GetUserRoles(ctx, userID); err != nil {
+		return nil, data.Profile, err
+	}
+	if data.Passkeys, err = d.repos.Passkey.ListPasskeys(ctx, userID); err != nil {
+		return nil, data.Profile, err
+	}
+	if data.Identities, err = d.repos.Identity.ListIdentities(ctx, userID); err != nil {
+		return nil, data.Profile, err
+	}
+	if data.ApiTokens, err = d.repos.ApiToken.ListTokens(ctx, userID); err != nil {
+		return nil, data.Profile, err
+	}
+	settings, err := d.repos.Notification.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, data.Profile, err
+	}
+	data.NotificationSettings = newNotificationSettings(settings)
+	if data.PushSubscriptions, err = d.repos.Push.ListSubscriptions(ctx, userID); err != nil {
+		return nil, data.Profile, err
+	}
+	if data.PushDevices, err = d.repos.Push.ListDevices(ctx, userID); err != nil {
+		return nil, data.Profile, err
+	}
+	if data.SecurityActivity, err = d.securityActivity(ctx, userID); err != nil {
+		return nil, data.Profile, err
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+
+	if data.Profile.Avatar.Valid {
+		data.Avatar = "avatar" + path.Ext(data.Profile.Avatar.String)
+		if err := d.copyAvatar(ctx, archive, data.Avatar, data.Profile.Avatar.String); err != nil {
+			return nil, data.Profile, err
+		}
+	}
+
+	file, err := archive.Create("data.json")
+	if err != nil {
+		return nil, data.Profile, err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return nil, data.Profile, err
+	}
+
+	if file, err = archive.Create("index.html"); err != nil {
+		return nil, data.Profile, err
+	}
+	if err := exportIndex.Execute(file, data); err != nil {
+		return nil, data.Profile, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, data.Profile, err
+	}
+
+	return buffer.Bytes(), data.Profile, nil
+}
+
+func (d *dataExportService) securityActivity(ctx context.Context, userID uuid.UUID) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+
+	var before int64
+	for {
+		events, err := d.repos.Audit.ListUserEvents(ctx, userID, before, 100)
+		if err != nil {
+			return nil, err
+		}
+
+		page := newAuditPage(events, 100)
+		entries = append(entries, page.Events...)
+		if page.Next == nil {
+			return entries, nil
+		}
+		before = *page.Next
+	}
+}
+
+func (d *dataExportService) copyAvatar(ctx context.Context, archive *zip.Writer, name, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("download avatar: %s", res.Status)
+	}
+
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, io.LimitReader(res.Body, maxAvatarSize))
+	return err
+}
+
+// NewDataExportService keeps archives for DATA_EXPORT_TTL, 7 days by default.
+// It reads the user's data from repos.
+func NewDataExportService(repos repositories.Repositories, txManager repositories.TxManager, m mailer.Mailer) DataExportService {
+	return &dataExportService{
+		repos:     repos,
+		txManager: txManager,
+		mailer:    m,
+		client: &http.Client{
+			Timeout: utils.GetTimeouts().Upload,
+		},
+		ttl: utils.GetEnvDuration("DATA_EXPORT_TTL", 7*24*time.Hour),
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/mailer"
+	"chat_backend/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("token is invalid or expired")
+	ErrEmailTaken   = errors.New("email is already in use")
+)
+
+// sendTokenMail issues a token for purpose inside tx, replacing older ones,
+// and mails a link carrying it once the transaction has committed. The mail
+// is sent on jobs, so that a slow mail server neither holds the request nor
+// shows in its timing whether a mail was sent.
+func sendTokenMail(ctx context.Context, tx *repositories.Tx, jobs *background, m mailer.Mailer, userID uuid.UUID, email, purpose string) error {
+	if err := tx.Token.DeleteTokens(ctx, userID, purpose); err != nil {
+		return err
+	}
+
+	var (
+		ttl     time.Duration
+		path    string
+		subject string
+		body    string
+	)
+
+	switch purpose {
+	case repositories.TokenEmailVerification:
+		ttl = utils.GetEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+		path = "/verify-email"
+		subject = "Verify your email address"
+		body = "Open the link below to verify your email address:\n\n%s\n\nThe link expires in %s."
+	case repositories.TokenPasswordReset:
+		ttl = utils.GetEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+		path = "/reset-password"
+		subject = "Reset your password"
+		body = "Open the link below to choose a new password:\n\n%s\n\nThe link expires in %s. If you did not ask for this, ignore this email."
+	default:
+		return fmt.Errorf("unknown token purpose %q", purpose)
+	}
+
+	token, err := tx.Token.CreateToken(ctx, userID, purpose, ttl)
+	if err != nil {
+		return err
+	}
+
+	link := utils.GetEnv("CLIENT_URL", "") + path + "?token=" + url.QueryEscape(token)
+
+	msg := mailer.Message{
+		To:      email,
+		Subject: subject,
+		Body:    fmt.Sprintf(body, link, ttl),
+	}
+
+	tx.AfterCommit(func(ctx context.Context) {
+		jobs.Go(ctx, "send "+purpose+" mail", utils.GetTimeouts().Mail, func(ctx context.Context) error {
+			return m.Send(ctx, msg)
+		})
+	})
+
+	return nil
+}
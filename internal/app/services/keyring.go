@@ -0,0 +1,267 @@
+package services
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/utils"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/o1egl/paseto"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidSessionToken = errors.New("session token is invalid")
+	ErrNoSigningKey        = errors.New("no active signing key")
+	ErrUnknownSigningKey   = errors.New("signing key does not exist or is in the wrong state")
+	ErrLastSigningKey      = errors.New("signing key is the last active one")
+)
+
+// legacyKid identifies the key derived from PRIVATE_KEY, which signed tokens
+// without a key ID before the keyring existed.
+const legacyKid = "legacy"
+
+// minReload limits how often an unknown key ID triggers a reload, so that
+// forged tokens cannot hammer the database.
+const minReload = 5 * time.Second
+
+type tokenFooter struct {
+	Kid string `json:"kid"`
+}
+
+// KeyringService signs session tokens with the newest active key and puts
+// its ID in the token footer, and verifies tokens against every key that is
+// not retired, so keys can be rotated without logging users out.
+type KeyringService interface {
+	Sign(ctx context.Context, data string, ttl time.Duration) (string, error)
+	// Verify returns the data of a valid, unexpired token.
+	Verify(ctx context.Context, token string) (string, error)
+	ListKeys(ctx context.Context) ([]repositories.SigningKey, error)
+	// GenerateKey creates a pending key, which verifies tokens but only
+	// signs them once activated.
+	GenerateKey(ctx context.Context) (string, error)
+	ActivateKey(ctx context.Context, kid string) error
+	RetireKey(ctx context.Context, kid string) error
+}
+
+type keyringService struct {
+	signingKeyRepository repositories.SigningKeyRepository
+	refresh              time.Duration
+
+	mu         sync.RWMutex
+	keys       map[string]ed25519.PrivateKey
+	signingKid string
+	loadedAt   time.Time
+}
+
+func (k *keyringService) Sign(ctx context.Context, data string, ttl time.Duration) (string, error) {
+	if err := k.ensureFresh(ctx); err != nil {
+		return "", err
+	}
+
+	k.mu.RLock()
+	kid, key := k.signingKid, k.keys[k.signingKid]
+	k.mu.RUnlock()
+
+	if key == nil {
+		return "", ErrNoSigningKey
+	}
+
+	payload, err := pasetoware.NewPayload(data, ttl)
+	if err != nil {
+		return "", err
+	}
+
+	return paseto.NewV2().Sign(key, payload, tokenFooter{Kid: kid})
+}
+
+func (k *keyringService) Verify(ctx context.Context, token string) (string, error) {
+	if len(token) == 0 {
+		return "", ErrInvalidSessionToken
+	}
+
+	var footer tokenFooter
+	if err := paseto.ParseFooter(token, &footer); err != nil {
+		return "", ErrInvalidSessionToken
+	}
+	if len(footer.Kid) == 0 {
+		footer.Kid = legacyKid
+	}
+
+	key, err := k.verificationKey(ctx, footer.Kid)
+	if err != nil {
+		return "", err
+	}
+
+	var payload paseto.JSONToken
+	if err := paseto.NewV2().Verify(token, key.Public(), &payload, nil); err != nil {
+		return "", ErrInvalidSessionToken
+	}
+	if err := payload.Validate(paseto.ValidAt(time.Now())); err != nil {
+		return "", ErrInvalidSessionToken
+	}
+
+	return payload.Get("data"), nil
+}
+
+func (k *keyringService) verificationKey(ctx context.Context, kid string) (ed25519.PrivateKey, error) {
+	if err := k.ensureFresh(ctx); err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	key, loadedAt := k.keys[kid], k.loadedAt
+	k.mu.RUnlock()
+
+	if key == nil && time.Since(loadedAt) > minReload {
+		if err := k.load(ctx); err != nil {
+			return nil, err
+		}
+
+		k.mu.RLock()
+		key = k.keys[kid]
+		k.mu.RUnlock()
+	}
+
+	if key == nil {
+		return nil, ErrInvalidSessionToken
+	}
+
+	return key, nil
+}
+
+func (k *keyringService) ensureFresh(ctx context.Context) error {
+	k.mu.RLock()
+	stale := time.Since(k.loadedAt) > k.refresh
+	k.mu.RUnlock()
+
+	if !stale {
+		return nil
+	}
+
+	return k.load(ctx)
+}
+
+func (k *keyringService) load(ctx context.Context) error {
+	keys, err := k.ListKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]ed25519.PrivateKey)
+	signingKid := ""
+	var activatedAt time.Time
+
+	for _, key := range keys {
+		if key.Status == repositories.SigningKeyRetired {
+			continue
+		}
+
+		loaded[key.Kid] = key.PrivateKey
+
+		if key.Status == repositories.SigningKeyActive && !key.ActivatedAt.Time.Before(activatedAt) {
+			signingKid = key.Kid
+			activatedAt = key.ActivatedAt.Time
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = loaded
+	k.signingKid = signingKid
+	k.loadedAt = time.Now()
+	k.mu.Unlock()
+
+	return nil
+}
+
+// ListKeys imports the legacy key into an empty keyring, so that tokens
+// issued before the keyring existed stay valid until it is retired.
+func (k *keyringService) ListKeys(ctx context.Context) ([]repositories.SigningKey, error) {
+	keys, err := k.signingKeyRepository.ListKeys(ctx)
+	if err != nil || len(keys) > 0 || len(utils.GetEnv("PRIVATE_KEY", "")) != 2*ed25519.SeedSize {
+		return keys, err
+	}
+
+	if err := k.signingKeyRepository.CreateKey(ctx, legacyKid, utils.GetPrivateKey(), repositories.SigningKeyActive); err != nil {
+		return nil, err
+	}
+
+	return k.signingKeyRepository.ListKeys(ctx)
+}
+
+func (k *keyringService) GenerateKey(ctx context.Context) (string, error) {
+	if _, err := k.ListKeys(ctx); err != nil {
+		return "", err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	kid := hex.EncodeToString(id)
+
+	if err := k.signingKeyRepository.CreateKey(ctx, kid, key, repositories.SigningKeyPending); err != nil {
+		return "", err
+	}
+
+	return kid, nil
+}
+
+func (k *keyringService) ActivateKey(ctx context.Context, kid string) error {
+	activated, err := k.signingKeyRepository.ActivateKey(ctx, kid)
+	if err != nil {
+		return err
+	}
+	if !activated {
+		return ErrUnknownSigningKey
+	}
+
+	return nil
+}
+
+// RetireKey refuses to retire the only active key, which would leave nothing
+// to sign new tokens with.
+func (k *keyringService) RetireKey(ctx context.Context, kid string) error {
+	keys, err := k.ListKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	active := 0
+	for _, key := range keys {
+		if key.Status == repositories.SigningKeyActive && key.Kid != kid {
+			active++
+		}
+	}
+	if active == 0 {
+		return ErrLastSigningKey
+	}
+
+	retired, err := k.signingKeyRepository.RetireKey(ctx, kid)
+	if err != nil {
+		return err
+	}
+	if !retired {
+		return ErrUnknownSigningKey
+	}
+
+	return nil
+}
+
+// NewKeyringService reloads the keys every refresh, so that keys activated
+// or retired by the keys command reach every instance.
+func NewKeyringService(r repositories.SigningKeyRepository, refresh time.Duration) KeyringService {
+	return &keyringService{
+		signingKeyRepository: r,
+		refresh:              refresh,
+	}
+}
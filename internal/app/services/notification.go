@@ -0,0 +1,163 @@
+package services
+
+import (
+	"chat_backend/generated"
+	"chat_backend/internal/app/repositories"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+// NotificationKind is what a notification is about. Users who only want
+// mentions are not notified of other messages.
+type NotificationKind int
+
+const (
+	NotificationMessage NotificationKind = iota
+	NotificationMention
+)
+
+var (
+	ErrInvalidMuteTime = errors.New("mute time is not a future RFC 3339 time")
+	ErrInvalidSchedule = errors.New("do-not-disturb schedule needs a HH:MM start and end")
+)
+
+// NotificationSettings are returned to the user. The mute is omitted once it
+// has passed and the schedule when there is none.
+type NotificationSettings struct {
+	Level      string     `json:"level"`
+	MutedUntil *time.Time `json:"muted_until"`
+	DNDStart   *string    `json:"dnd_start"`
+	DNDEnd     *string    `json:"dnd_end"`
+	// Timezone is the one the schedule is read in, from the profile.
+	Timezone string `json:"timezone"`
+}
+
+// NotificationService keeps the account-wide preferences that decide whether
+// a notification reaches a user. Senders of push and email notifications ask
+// ShouldNotify before delivering one.
+type NotificationService interface {
+	GetSettings(ctx context.Context, userID uuid.UUID) (NotificationSettings, error)
+	UpdateSettings(ctx context.Context, userID uuid.UUID, input *repositories.NotificationSettingsInput) (NotificationSettings, error)
+	// ShouldNotify reports whether the user wants a notification of kind
+	// now, given the level, the mute and the do-not-disturb schedule.
+	ShouldNotify(ctx context.Context, userID uuid.UUID, kind NotificationKind) (bool, error)
+}
+
+type notificationService struct {
+	notificationRepository repositories.NotificationRepository
+}
+
+func (n *notificationService) GetSettings(ctx context.Context, userID uuid.UUID) (NotificationSettings, error) {
+	row, err := n.notificationRepository.GetSettings(ctx, userID)
+	if err != nil {
+		return NotificationSettings{}, err
+	}
+
+	return newNotificationSettings(row), nil
+}
+
+func newNotificationSettings(row generated.GetNotificationSettingsRow) NotificationSettings {
+	settings := NotificationSettings{
+		Level:    row.Level,
+		Timezone: "UTC",
+	}
+	if row.MutedUntil.Valid && row.MutedUntil.Time.After(time.Now()) {
+		settings.MutedUntil = &row.MutedUntil.Time
+	}
+	if row.DndStart.Valid && row.DndEnd.Valid {
+		start, end := repositories.FormatClock(row.DndStart.Int16), repositories.FormatClock(row.DndEnd.Int16)
+		settings.DNDStart, settings.DNDEnd = &start, &end
+	}
+	if row.Timezone.Valid {
+		settings.Timezone = row.Timezone.String
+	}
+
+	return settings
+}
+
+func (n *notificationService) UpdateSettings(ctx context.Context, userID uuid.UUID, input *repositories.NotificationSettingsInput) (NotificationSettings, error) {
+	if input.MutedUntil != nil && len(*input.MutedUntil) > 0 {
+		mutedUntil, err := time.Parse(time.RFC3339, *input.MutedUntil)
+		if err != nil || !mutedUntil.After(time.Now()) {
+			return NotificationSettings{}, ErrInvalidMuteTime
+		}
+	}
+
+	if input.SetsSchedule() {
+		if input.DNDStart == nil || input.DNDEnd == nil || (len(*input.DNDStart) > 0) != (len(*input.DNDEnd) > 0) {
+			return NotificationSettings{}, ErrInvalidSchedule
+		}
+		if len(*input.DNDStart) > 0 {
+			start, err := repositories.ParseClock(*input.DNDStart)
+			if err != nil {
+				return NotificationSettings{}, ErrInvalidSchedule
+			}
+			end, err := repositories.ParseClock(*input.DNDEnd)
+			if err != nil || start == end {
+				return NotificationSettings{}, ErrInvalidSchedule
+			}
+		}
+	}
+
+	if err := n.notificationRepository.UpdateSettings(ctx, userID, input); err != nil {
+		return NotificationSettings{}, err
+	}
+
+	return n.GetSettings(ctx, userID)
+}
+
+func (n *notificationService) ShouldNotify(ctx context.Context, userID uuid.UUID, kind NotificationKind) (bool, error) {
+	row, err := n.notificationRepository.GetSettings(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	switch row.Level {
+	case repositories.NotifyNone:
+		return false, nil
+	case repositories.NotifyMentions:
+		if kind != NotificationMention {
+			return false, nil
+		}
+	}
+
+	now := time.Now()
+	if row.MutedUntil.Valid && row.MutedUntil.Time.After(now) {
+		return false, nil
+	}
+
+	if row.DndStart.Valid && row.DndEnd.Valid {
+		location := time.UTC
+		if row.Timezone.Valid {
+			if loaded, err := time.LoadLocation(row.Timezone.String); err == nil {
+				location = loaded
+			}
+		}
+
+		if inSchedule(now.In(location), row.DndStart.Int16, row.DndEnd.Int16) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// inSchedule reports whether the wall clock of t falls in [start, end), both
+// minutes after midnight. A start after the end wraps past midnight.
+func inSchedule(t time.Time, start, end int16) bool {
+	minute := int16(t.Hour()*60 + t.Minute())
+
+	if start <= end {
+		return minute >= start && minute < end
+	}
+
+	return minute >= start || minute < end
+}
+
+func NewNotificationService(notificationRepo repositories.NotificationRepository) NotificationService {
+	return &notificationService{
+		notificationRepository: notificationRepo,
+	}
+}
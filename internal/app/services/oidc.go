@@ -0,0 +1,395 @@
+package services
+
+import (
+	"chat_backend/generated"
+	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/utils"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/oauth2"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownProvider  = errors.New("identity provider is not configured")
+	ErrInvalidIdentity  = errors.New("identity provider response is invalid")
+	ErrIdentityLinked   = errors.New("identity is already linked")
+	ErrLastSignInMethod = errors.New("identity is the only way to sign in")
+)
+
+const (
+	maxUsernameLength   = 30
+	usernameAttempts    = 5
+	defaultOIDCUsername = "user"
+)
+
+var usernameReplacer = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// OIDCState is kept by the client between the redirect to the provider and
+// the callback.
+type OIDCState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// LinkUserID is set when a signed-in user links another provider.
+	LinkUserID uuid.UUID `json:"link_user_id"`
+}
+
+type OIDCService interface {
+	Providers() []string
+	// AuthCodeURL starts an authorization code flow with PKCE. linkUserID is
+	// uuid.Nil for a sign in.
+	AuthCodeURL(ctx context.Context, provider string, linkUserID uuid.UUID) (string, *OIDCState, error)
+	// Callback redeems the code and returns the user the identity belongs
+	// to, linking it or provisioning a new account when it is unknown.
+	Callback(ctx context.Context, state *OIDCState, code string) (generated.User, error)
+	ListIdentities(ctx context.Context, userID uuid.UUID) ([]generated.ListLinkedIdentitiesRow, error)
+	UnlinkIdentity(ctx context.Context, userID uuid.UUID, provider string) (bool, error)
+}
+
+type oidcService struct {
+	identityRepository repositories.IdentityRepository
+	userRepository     repositories.UserRepository
+	txManager          repositories.TxManager
+	usernamePolicy     *UsernamePolicy
+	providers          map[string]*oidcProvider
+}
+
+// oidcProvider discovers the provider configuration on first use, so the
+// application starts even when a provider is unreachable.
+type oidcProvider struct {
+	name   string
+	issuer string
+	config oauth2.Config
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, oauth2.Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(ctx, p.issuer)
+		if err != nil {
+			return nil, oauth2.Config{}, err
+		}
+		p.provider = provider
+	}
+
+	config := p.config
+	config.Endpoint = p.provider.Endpoint()
+
+	return p.provider, config, nil
+}
+
+func (o *oidcService) Providers() []string {
+	names := make([]string, 0, len(o.providers))
+	for name := range o.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (o *oidcService) AuthCodeURL(ctx context.Context, provider string, linkUserID uuid.UUID) (string, *OIDCState, error) {
+	p, ok := o.providers[provider]
+	if !ok {
+		return "", nil, ErrUnknownProvider
+	}
+
+	_, config, err := p.discover(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	state := &OIDCState{
+		Provider:   provider,
+		State:      randomHex(16),
+		Nonce:      randomHex(16),
+		Verifier:   oauth2.GenerateVerifier(),
+		LinkUserID: linkUserID,
+	}
+
+	url := config.AuthCodeURL(state.State,
+		oidc.Nonce(state.Nonce),
+		oauth2.S256ChallengeOption(state.Verifier),
+	)
+
+	return url, state, nil
+}
+
+func (o *oidcService) Callback(ctx context.Context, state *OIDCState, code string) (generated.User, error) {
+	p, ok := o.providers[state.Provider]
+	if !ok {
+		return generated.User{}, ErrUnknownProvider
+	}
+
+	provider, config, err := p.discover(ctx)
+	if err != nil {
+		return generated.User{}, err
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		slog.WarnContext(ctx, "exchange oidc code", "provider", p.name, "error", err)
+		return generated.User{}, ErrInvalidIdentity
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return generated.User{}, ErrInvalidIdentity
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		slog.WarnContext(ctx, "verify oidc id token", "provider", p.name, "error", err)
+		return generated.User{}, ErrInvalidIdentity
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(state.Nonce)) != 1 {
+		return generated.User{}, ErrInvalidIdentity
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return generated.User{}, ErrInvalidIdentity
+	}
+	if !claims.EmailVerified {
+		claims.Email = ""
+	}
+
+	var user generated.User
+	var provisioned bool
+	err = o.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		identity, err := tx.Identity.GetIdentity(ctx, p.name, idToken.Subject)
+		switch {
+		case err == nil:
+			if state.LinkUserID != uuid.Nil && identity.UserID != state.LinkUserID {
+				return ErrIdentityLinked
+			}
+
+			user, err = tx.Auth.GetUserAccountByID(ctx, identity.UserID)
+			return err
+		case !errors.Is(err, pgx.ErrNoRows):
+			return err
+		}
+
+		userID := state.LinkUserID
+		if userID == uuid.Nil {
+			userID, err = o.provisionUser(ctx, tx, &claims)
+			if err != nil {
+				return err
+			}
+			provisioned = true
+		}
+
+		if err := tx.Identity.LinkIdentity(ctx, userID, p.name, idToken.Subject, claims.Email); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+				return ErrIdentityLinked
+			}
+			return err
+		}
+
+		user, err = tx.Auth.GetUserAccountByID(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return generated.User{}, err
+	}
+
+	if provisioned {
+		if err := generateAvatar(ctx, o.userRepository, user.ID); err != nil {
+			slog.ErrorContext(ctx, "generate avatar", "error", err)
+		}
+	}
+
+	return user, nil
+}
+
+// provisionUser creates an account for a first-time sign in. Its password is
+// random, and the provider's email is kept only when it is verified and not
+// used by another account, so that an identity never takes over an existing
+// account by email.
+func (o *oidcService) provisionUser(ctx context.Context, tx *repositories.Tx, claims *oidcClaims) (uuid.UUID, error) {
+	username, err := o.provisionUsername(ctx, tx, claims)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	email := claims.Email
+	if len(email) > 0 {
+		_, err := tx.Auth.GetUserByEmail(ctx, email)
+		switch {
+		case err == nil:
+			email = ""
+		case !errors.Is(err, pgx.ErrNoRows):
+			return uuid.Nil, err
+		}
+	}
+
+	err = tx.Auth.CreateProvisionedUser(ctx, &repositories.AuthInput{
+		Username: username,
+		Password: randomHex(32),
+		Email:    email,
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	user, err := tx.Auth.GetUserByUsername(ctx, username)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if len(email) > 0 {
+		if err := tx.Auth.VerifyEmail(ctx, user.ID); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	return user.ID, nil
+}
+
+// provisionUsername derives a free username from the claims, adding a random
+// suffix on collisions and reserved names while staying within the
+// users.username length.
+func (o *oidcService) provisionUsername(ctx context.Context, tx *repositories.Tx, claims *oidcClaims) (string, error) {
+	base := ""
+	for _, candidate := range []string{claims.PreferredUsername, strings.Split(claims.Email, "@")[0], claims.Name} {
+		base = strings.Trim(usernameReplacer.ReplaceAllString(candidate, "_"), "_.-")
+		if len(base) > 0 {
+			break
+		}
+	}
+	if len(base) == 0 {
+		base = defaultOIDCUsername
+	}
+	if len(base) > maxUsernameLength {
+		base = base[:maxUsernameLength]
+	}
+
+	username := base
+	for attempt := 0; attempt < usernameAttempts; attempt++ {
+		err := o.usernamePolicy.checkAvailable(ctx, tx, uuid.Nil, username)
+		if err == nil {
+			return username, nil
+		}
+		if !errors.Is(err, ErrUsernameTaken) && !errors.Is(err, ErrUsernameReserved) {
+			return "", err
+		}
+
+		suffix := "_" + randomHex(3)
+		username = base
+		if len(username)+len(suffix) > maxUsernameLength {
+			username = username[:maxUsernameLength-len(suffix)]
+		}
+		username += suffix
+	}
+
+	return "", errors.New("no free username for identity")
+}
+
+func (o *oidcService) ListIdentities(ctx context.Context, userID uuid.UUID) ([]generated.ListLinkedIdentitiesRow, error) {
+	return o.identityRepository.ListIdentities(ctx, userID)
+}
+
+// UnlinkIdentity refuses to remove the last linked identity of an account
+// that has no password of its own, no verified email to reset one and no
+// passkey.
+func (o *oidcService) UnlinkIdentity(ctx context.Context, userID uuid.UUID, provider string) (bool, error) {
+	var deleted bool
+	err := o.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		count, err := tx.Identity.CountIdentities(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if count == 1 {
+			user, err := tx.Auth.GetUserAccountByID(ctx, userID)
+			if err != nil {
+				return err
+			}
+
+			passkeys, err := tx.Passkey.ListPasskeys(ctx, userID)
+			if err != nil {
+				return err
+			}
+
+			if !user.PasswordSet && !user.EmailVerifiedAt.Valid && len(passkeys) == 0 {
+				return ErrLastSignInMethod
+			}
+		}
+
+		deleted, err = tx.Identity.UnlinkIdentity(ctx, userID, provider)
+		return err
+	})
+
+	return deleted, err
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// NewOIDCService configures the providers named in the comma separated
+// OIDC_PROVIDERS. Each provider NAME reads OIDC_<NAME>_ISSUER,
+// OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET, and is redirected back
+// to <API_URL>/api/auth/oidc/<name>/callback.
+func NewOIDCService(identityRepo repositories.IdentityRepository, userRepo repositories.UserRepository, txManager repositories.TxManager, usernames *UsernamePolicy) OIDCService {
+	apiURL := strings.TrimSuffix(utils.GetEnv("API_URL", "http://localhost:6060"), "/")
+	providers := make(map[string]*oidcProvider)
+
+	for _, name := range strings.Split(utils.GetEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if len(name) == 0 {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers[name] = &oidcProvider{
+			name:   name,
+			issuer: utils.GetEnv(prefix+"ISSUER", ""),
+			config: oauth2.Config{
+				ClientID:     utils.GetEnv(prefix+"CLIENT_ID", ""),
+				ClientSecret: utils.GetEnv(prefix+"CLIENT_SECRET", ""),
+				RedirectURL:  apiURL + "/api/auth/oidc/" + name + "/callback",
+				Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+			},
+		}
+	}
+
+	return &oidcService{
+		identityRepository: identityRepo,
+		userRepository:     userRepo,
+		txManager:          txManager,
+		usernamePolicy:     usernames,
+		providers:          providers,
+	}
+}
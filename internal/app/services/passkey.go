@@ -0,0 +1,234 @@
+package services
+
+import (
+	"bytes"
+	"chat_backend/generated"
+	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/utils"
+	"context"
+	"errors"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var ErrInvalidPasskey = errors.New("passkey is invalid")
+
+const passkeySessionTTL = 5 * time.Minute
+
+type PasskeyService interface {
+	BeginRegistration(ctx context.Context, userID uuid.UUID) (*protocol.CredentialCreation, uuid.UUID, error)
+	FinishRegistration(ctx context.Context, userID, sessionID uuid.UUID, name string, body []byte) error
+	BeginLogin(ctx context.Context) (*protocol.CredentialAssertion, uuid.UUID, error)
+	// FinishLogin verifies the assertion and returns the user it belongs to.
+	FinishLogin(ctx context.Context, sessionID uuid.UUID, body []byte) (uuid.UUID, error)
+	ListPasskeys(ctx context.Context, userID uuid.UUID) ([]generated.ListPasskeysRow, error)
+	DeletePasskey(ctx context.Context, userID, id uuid.UUID) (bool, error)
+}
+
+type passkeyService struct {
+	authRepository    repositories.AuthRepository
+	passkeyRepository repositories.PasskeyRepository
+	webAuthn          *webauthn.WebAuthn
+}
+
+// passkeyUser adapts a user to the webauthn.User interface.
+type passkeyUser struct {
+	user        generated.User
+	credentials []webauthn.Credential
+}
+
+func (p *passkeyUser) WebAuthnID() []byte {
+	return p.user.ID[:]
+}
+
+func (p *passkeyUser) WebAuthnName() string {
+	return p.user.Username
+}
+
+func (p *passkeyUser) WebAuthnDisplayName() string {
+	return p.user.Username
+}
+
+func (p *passkeyUser) WebAuthnIcon() string {
+	return p.user.Avatar.String
+}
+
+func (p *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return p.credentials
+}
+
+func (p *passkeyService) loadUser(ctx context.Context, userID uuid.UUID) (*passkeyUser, error) {
+	user, err := p.authRepository.GetUserAccountByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := p.passkeyRepository.GetCredentials(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkeyUser{
+		user:        user,
+		credentials: credentials,
+	}, nil
+}
+
+func (p *passkeyService) BeginRegistration(ctx context.Context, userID uuid.UUID) (*protocol.CredentialCreation, uuid.UUID, error) {
+	user, err := p.loadUser(ctx, userID)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, len(user.credentials))
+	for i, credential := range user.credentials {
+		exclusions[i] = credential.Descriptor()
+	}
+
+	creation, session, err := p.webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	sessionID, err := p.passkeyRepository.SaveSession(ctx, session, passkeySessionTTL)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	return creation, sessionID, nil
+}
+
+func (p *passkeyService) FinishRegistration(ctx context.Context, userID, sessionID uuid.UUID, name string, body []byte) error {
+	session, err := p.consumeSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(session.UserID, userID[:]) {
+		return ErrInvalidPasskey
+	}
+
+	user, err := p.loadUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	response, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body))
+	if err != nil {
+		slog.WarnContext(ctx, "parse passkey registration", "error", err)
+		return ErrInvalidPasskey
+	}
+
+	credential, err := p.webAuthn.CreateCredential(user, *session, response)
+	if err != nil {
+		slog.WarnContext(ctx, "verify passkey registration", "error", err)
+		return ErrInvalidPasskey
+	}
+
+	if len(strings.TrimSpace(name)) == 0 {
+		name = "Passkey"
+	}
+
+	return p.passkeyRepository.CreatePasskey(ctx, userID, name, credential)
+}
+
+func (p *passkeyService) BeginLogin(ctx context.Context) (*protocol.CredentialAssertion, uuid.UUID, error) {
+	assertion, session, err := p.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	sessionID, err := p.passkeyRepository.SaveSession(ctx, session, passkeySessionTTL)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	return assertion, sessionID, nil
+}
+
+func (p *passkeyService) FinishLogin(ctx context.Context, sessionID uuid.UUID, body []byte) (uuid.UUID, error) {
+	session, err := p.consumeSession(ctx, sessionID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	response, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body))
+	if err != nil {
+		slog.WarnContext(ctx, "parse passkey assertion", "error", err)
+		return uuid.Nil, ErrInvalidPasskey
+	}
+
+	var userID uuid.UUID
+	credential, err := p.webAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		userID = id
+
+		return p.loadUser(ctx, id)
+	}, *session, response)
+	if err != nil {
+		slog.WarnContext(ctx, "verify passkey assertion", "error", err)
+		return uuid.Nil, ErrInvalidPasskey
+	}
+
+	if credential.Authenticator.CloneWarning {
+		slog.WarnContext(ctx, "passkey sign counter went backwards", "user", userID)
+		return uuid.Nil, ErrInvalidPasskey
+	}
+
+	if err := p.passkeyRepository.UpdateSignCount(ctx, credential); err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, nil
+}
+
+func (p *passkeyService) consumeSession(ctx context.Context, sessionID uuid.UUID) (*webauthn.SessionData, error) {
+	session, err := p.passkeyRepository.ConsumeSession(ctx, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidPasskey
+	}
+
+	return session, err
+}
+
+func (p *passkeyService) ListPasskeys(ctx context.Context, userID uuid.UUID) ([]generated.ListPasskeysRow, error) {
+	return p.passkeyRepository.ListPasskeys(ctx, userID)
+}
+
+func (p *passkeyService) DeletePasskey(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	return p.passkeyRepository.DeletePasskey(ctx, userID, id)
+}
+
+// NewPasskeyService configures the relying party from WEBAUTHN_RP_ID,
+// WEBAUTHN_RP_NAME and the comma separated WEBAUTHN_RP_ORIGINS, which
+// defaults to CLIENT_URL.
+func NewPasskeyService(authRepo repositories.AuthRepository, passkeyRepo repositories.PasskeyRepository) (PasskeyService, error) {
+	origins := strings.Split(utils.GetEnv("WEBAUTHN_RP_ORIGINS", utils.GetEnv("CLIENT_URL", "http://localhost:3000")), ",")
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          utils.GetEnv("WEBAUTHN_RP_ID", "localhost"),
+		RPDisplayName: utils.GetEnv("WEBAUTHN_RP_NAME", "Chat App"),
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkeyService{
+		authRepository:    authRepo,
+		passkeyRepository: passkeyRepo,
+		webAuthn:          webAuthn,
+	}, nil
+}
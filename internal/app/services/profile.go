@@ -0,0 +1,79 @@
+package services
+
+import (
+	"chat_backend/internal/app/repositories"
+	"errors"
+	"golang.org/x/text/language"
+	"strings"
+	"time"
+	_ "time/tzdata"
+	"unicode"
+)
+
+var (
+	ErrInvalidTimezone     = errors.New("timezone is not an IANA time zone")
+	ErrInvalidLocale       = errors.New("locale is not a BCP 47 language tag")
+	ErrInvalidStatusEmoji  = errors.New("status emoji is not an emoji")
+	ErrInvalidStatusExpiry = errors.New("status expiry is not a future RFC 3339 time")
+)
+
+// normalizeProfile checks the profile fields of input and rewrites the
+// locale in its canonical form. Empty fields clear the value and pass.
+func normalizeProfile(input *repositories.UpdateInput) error {
+	if input.Timezone != nil && len(*input.Timezone) > 0 {
+		if *input.Timezone == "Local" {
+			return ErrInvalidTimezone
+		}
+		if _, err := time.LoadLocation(*input.Timezone); err != nil {
+			return ErrInvalidTimezone
+		}
+	}
+
+	if input.Locale != nil && len(*input.Locale) > 0 {
+		tag, err := language.Parse(*input.Locale)
+		if err != nil {
+			return ErrInvalidLocale
+		}
+		locale := tag.String()
+		input.Locale = &locale
+	}
+
+	if input.StatusEmoji != nil && len(*input.StatusEmoji) > 0 && !isEmoji(*input.StatusEmoji) {
+		return ErrInvalidStatusEmoji
+	}
+
+	if input.StatusExpiresAt != nil && len(*input.StatusExpiresAt) > 0 {
+		expiresAt, err := time.Parse(time.RFC3339, *input.StatusExpiresAt)
+		if err != nil || !expiresAt.After(time.Now()) {
+			return ErrInvalidStatusExpiry
+		}
+	}
+
+	return nil
+}
+
+// isEmoji reports whether s is made of emoji only, including sequences
+// joined with ZWJ, skin tone modifiers, flags and keycaps.
+func isEmoji(s string) bool {
+	symbols := 0
+
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.So, r), unicode.Is(unicode.Sk, r):
+			symbols++
+		case r == '\u200d', r == '\ufe0f', r == '\u20e3':
+		case r >= '\U000e0020' && r <= '\U000e007f':
+			// Tag characters of subdivision flags.
+		case r >= '0' && r <= '9', r == '#', r == '*':
+			// Keycap bases, valid when followed by U+20E3.
+			if !strings.ContainsRune(s, '\u20e3') {
+				return false
+			}
+			symbols++
+		default:
+			return false
+		}
+	}
+
+	return symbols > 0
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"chat_backend/generated"
+	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/push"
+	"chat_backend/pkg/utils"
+	"chat_backend/pkg/webpush"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPushServices are the origins of the push services of Chrome,
+// Firefox, Safari and Edge.
+const defaultPushServices = "https://fcm.googleapis.com,https://updates.push.services.mozilla.com,https://*.push.apple.com,https://*.notify.windows.com"
+
+const (
+	pushBatchSize = 100
+	// pushLease is how long a claimed delivery is left to its sender before
+	// another worker sends it again.
+	pushLease = time.Minute
+)
+
+var (
+	ErrPushServiceNotAllowed = errors.New("push service is not allowed")
+	ErrInvalidPushKeys       = errors.New("push subscription keys are invalid")
+)
+
+// PushNotification is the JSON payload the service worker of the client
+// shows.
+type PushNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url,omitempty"`
+	// Tag makes the notification replace an earlier one with the same tag.
+	// Notifications for the native apps with the same tag are merged while
+	// they wait to be sent.
+	Tag string `json:"tag,omitempty"`
+}
+
+// PushService sends push notifications to the browsers and native apps of
+// users who are not connected. Browsers are sent to at once, native apps
+// through a queue that merges bursts and retries transient failures.
+type PushService interface {
+	// PublicKey is the VAPID key browsers subscribe with.
+	PublicKey() string
+	Subscribe(ctx context.Context, userID uuid.UUID, input *repositories.PushSubscriptionInput, userAgent string) (generated.UpsertPushSubscriptionRow, error)
+	ListSubscriptions(ctx context.Context, userID uuid.UUID) ([]generated.ListPushSubscriptionsRow, error)
+	Unsubscribe(ctx context.Context, userID, id uuid.UUID) (bool, error)
+	// RegisterDevice registers a native app for the session authenticated
+	// with sessionToken.
+	RegisterDevice(ctx context.Context, userID uuid.UUID, sessionToken string, input *repositories.PushDeviceInput) (generated.UpsertPushDeviceRow, error)
+	ListDevices(ctx context.Context, userID uuid.UUID) ([]generated.ListPushDevicesRow, error)
+	UnregisterDevice(ctx context.Context, userID, id uuid.UUID) (bool, error)
+	// EndSession forgets the devices registered by the session.
+	EndSession(ctx context.Context, sessionToken string) error
+	// Notify sends notification to every subscription and queues it for
+	// every device of the user, unless the notification settings hold a
+	// notification of kind back. Subscriptions the push service reports
+	// gone are deleted. It returns the number of browsers the notification
+	// was delivered to and devices it was queued for.
+	Notify(ctx context.Context, userID uuid.UUID, kind NotificationKind, notification PushNotification) (int, error)
+	// ProcessDeliveries sends the queued notifications that are due and
+	// returns how many were delivered. Devices the provider no longer
+	// accepts are forgotten.
+	ProcessDeliveries(ctx context.Context) (int, error)
+}
+
+type pushService struct {
+	pushRepository      repositories.PushRepository
+	notificationService NotificationService
+	client              *webpush.Client
+	// services are the origins subscriptions may point to. A host starting
+	// with *. matches its subdomains.
+	services    []*url.URL
+	limit       int
+	ttl         time.Duration
+	sendTimeout time.Duration
+
+	senders      map[string]push.PushSender
+	deviceLimit  int
+	maxAttempts  int
+	retryBackoff time.Duration
+}
+
+func (p *pushService) PublicKey() string {
+	return p.client.PublicKey()
+}
+
+func (p *pushService) Subscribe(ctx context.Context, userID uuid.UUID, input *repositories.PushSubscriptionInput, userAgent string) (generated.UpsertPushSubscriptionRow, error) {
+	if !p.allowed(input.Endpoint) {
+		return generated.UpsertPushSubscriptionRow{}, ErrPushServiceNotAllowed
+	}
+
+	keys := webpush.Keys{P256dh: input.Keys.P256dh, Auth: input.Keys.Auth}
+	if err := keys.Validate(); err != nil {
+		return generated.UpsertPushSubscriptionRow{}, ErrInvalidPushKeys
+	}
+
+	if len(userAgent) > maxUserAgentSize {
+		userAgent = userAgent[:maxUserAgentSize]
+	}
+	userAgent = strings.ToValidUTF8(userAgent, "")
+
+	return p.pushRepository.Subscribe(ctx, userID, input, userAgent, int32(p.limit))
+}
+
+// allowed reports whether endpoint is an HTTP URL of one of the push
+// services, so that subscriptions cannot make the server call anything else.
+func (p *pushService) allowed(endpoint string) bool {
+	target, err := url.Parse(endpoint)
+	if err != nil || target.User != nil || len(target.Host) == 0 {
+		return false
+	}
+
+	for _, service := range p.services {
+		if target.Scheme != service.Scheme {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(service.Host, "*"); ok {
+			if strings.HasSuffix(target.Host, suffix) && len(target.Host) > len(suffix) {
+				return true
+			}
+			continue
+		}
+		if target.Host == service.Host {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *pushService) ListSubscriptions(ctx context.Context, userID uuid.UUID) ([]generated.ListPushSubscriptionsRow, error) {
+	return p.pushRepository.ListSubscriptions(ctx, userID)
+}
+
+func (p *pushService) Unsubscribe(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	return p.pushRepository.DeleteSubscription(ctx, userID, id)
+}
+
+func (p *pushService) Notify(ctx context.Context, userID uuid.UUID, kind NotificationKind, notification PushNotification) (int, error) {
+	notify, err := p.notificationService.ShouldNotify(ctx, userID, kind)
+	if err != nil || !notify {
+		return 0, err
+	}
+
+	targets, err := p.pushRepository.ListTargets(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return 0, err
+	}
+
+	options := webpush.Options{
+		TTL:     p.ttl,
+		Urgency: "normal",
+	}
+	if kind == NotificationMention {
+		options.Urgency = "high"
+	}
+
+	sent, err := p.sendWeb(ctx, targets, payload, options)
+	if err != nil {
+		return sent, err
+	}
+
+	collapseKey := notification.Tag
+	if len(collapseKey) == 0 {
+		collapseKey = uuid.NewString()
+	}
+	if len(collapseKey) > 64 {
+		sum := sha256.Sum256([]byte(collapseKey))
+		collapseKey = hex.EncodeToString(sum[:])
+	}
+
+	queued, err := p.pushRepository.EnqueueDeliveries(ctx, userID, collapseKey, payload)
+	return sent + int(queued), err
+}
+
+// sendWeb sends to the subscriptions concurrently. The push services are
+// given sendTimeout together, so a slow one holds the request back for no
+// longer than that.
+func (p *pushService) sendWeb(ctx context.Context, targets []generated.ListPushTargetsRow, payload []byte, options webpush.Options) (int, error) {
+	sendCtx, cancel := context.WithTimeout(ctx, p.sendTimeout)
+	defer cancel()
+
+	var sent atomic.Int32
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target generated.ListPushTargetsRow) {
+			defer wg.Done()
+
+			err := p.client.Send(sendCtx, target.Endpoint, webpush.Keys{P256dh: target.P256dh, Auth: target.Auth}, payload, options)
+			if errors.Is(err, webpush.ErrGone) {
+				if err := p.pushRepository.DeleteEndpoint(ctx, target.Endpoint); err != nil {
+					slog.ErrorContext(ctx, "delete push subscription", "error", err)
+				}
+				return
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "send push notification", "subscription", target.ID, "error", err)
+				return
+			}
+
+			if err := p.pushRepository.TouchSubscription(ctx, target.ID); err != nil {
+				slog.ErrorContext(ctx, "touch push subscription", "error", err)
+			}
+			sent.Add(1)
+		}(target)
+	}
+	wg.Wait()
+
+	return int(sent.Load()), nil
+}
+
+func (p *pushService) RegisterDevice(ctx context.Context, userID uuid.UUID, sessionToken string, input *repositories.PushDeviceInput) (generated.UpsertPushDeviceRow, error) {
+	return p.pushRepository.RegisterDevice(ctx, userID, input, sessionHash(sessionToken), int32(p.deviceLimit))
+}
+
+func (p *pushService) ListDevices(ctx context.Context, userID uuid.UUID) ([]generated.ListPushDevicesRow, error) {
+	return p.pushRepository.ListDevices(ctx, userID)
+}
+
+func (p *pushService) UnregisterDevice(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	return p.pushRepository.DeleteDevice(ctx, userID, id)
+}
+
+func (p *pushService) EndSession(ctx context.Context, sessionToken string) error {
+	return p.pushRepository.DeleteSessionDevices(ctx, sessionHash(sessionToken))
+}
+
+func (p *pushService) ProcessDeliveries(ctx context.Context) (int, error) {
+	sent := 0
+
+	for {
+		deliveries, err := p.pushRepository.ClaimDeliveries(ctx, pushBatchSize, pushLease)
+		if err != nil {
+			return sent, err
+		}
+
+		for _, delivery := range deliveries {
+			if p.deliver(ctx, delivery) {
+				sent++
+			}
+		}
+
+		if len(deliveries) < pushBatchSize {
+			return sent, nil
+		}
+	}
+}
+
+// deliver sends a claimed delivery and reports whether it arrived. Transient
+// failures are retried with a growing delay until maxAttempts, other
+// failures drop the delivery.
+func (p *pushService) deliver(ctx context.Context, delivery generated.ClaimPushDeliveriesRow) bool {
+	var notification PushNotification
+	if err := json.Unmarshal(delivery.Payload, &notification); err != nil {
+		slog.ErrorContext(ctx, "decode push delivery", "delivery", delivery.ID, "error", err)
+		p.completeDelivery(ctx, delivery)
+		return false
+	}
+
+	sender, ok := p.senders[delivery.Platform]
+	if !ok {
+		slog.ErrorContext(ctx, "send push delivery", "delivery", delivery.ID, "error", "no sender for "+delivery.Platform)
+		p.completeDelivery(ctx, delivery)
+		return false
+	}
+
+	msg := push.Message{
+		Token:       delivery.Token,
+		Title:       notification.Title,
+		Body:        notification.Body,
+		CollapseKey: delivery.CollapseKey,
+		Data: map[string]string{
+			"count": strconv.Itoa(int(delivery.Count)),
+		},
+	}
+	if delivery.Count > 1 {
+		msg.Body = fmt.Sprintf("%d new messages", delivery.Count)
+	}
+	if len(notification.URL) > 0 {
+		msg.Data["url"] = notification.URL
+	}
+	if len(notification.Tag) > 0 {
+		msg.Data["tag"] = notification.Tag
+	}
+
+	err := sender.Send(ctx, msg)
+	switch {
+	case err == nil:
+		p.completeDelivery(ctx, delivery)
+		if err := p.pushRepository.TouchDevice(ctx, delivery.DeviceID); err != nil {
+			slog.ErrorContext(ctx, "touch push device", "error", err)
+		}
+		return true
+	case errors.Is(err, push.ErrUnregistered):
+		if err := p.pushRepository.ForgetDevice(ctx, delivery.DeviceID); err != nil {
+			slog.ErrorContext(ctx, "forget push device", "error", err)
+		}
+	case errors.Is(err, push.ErrTemporary) && int(delivery.Attempts) < p.maxAttempts:
+		backoff := time.Duration(delivery.Attempts*delivery.Attempts) * p.retryBackoff
+		slog.WarnContext(ctx, "retry push delivery", "delivery", delivery.ID, "attempt", delivery.Attempts, "error", err)
+		if err := p.pushRepository.RetryDelivery(ctx, delivery.ID, delivery.Revision, time.Now().Add(backoff)); err != nil {
+			slog.ErrorContext(ctx, "reschedule push delivery", "error", err)
+		}
+	default:
+		slog.WarnContext(ctx, "drop push delivery", "delivery", delivery.ID, "attempt", delivery.Attempts, "error", err)
+		p.completeDelivery(ctx, delivery)
+	}
+
+	return false
+}
+
+func (p *pushService) completeDelivery(ctx context.Context, delivery generated.ClaimPushDeliveriesRow) {
+	if err := p.pushRepository.CompleteDelivery(ctx, delivery.ID, delivery.Revision); err != nil {
+		slog.ErrorContext(ctx, "complete push delivery", "error", err)
+	}
+}
+
+// sessionHash identifies a session without storing its token.
+func sessionHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewPushService signs with the VAPID key, see utils.GetVAPIDKey, as
+// VAPID_SUBJECT, a mailto: or https: URL. Subscriptions must point to one of
+// the comma-separated PUSH_SERVICES origins, the push services of the major
+// browsers by default. Each user keeps PUSH_SUBSCRIPTION_LIMIT browsers, 10
+// by default, and push services hold notifications for PUSH_TTL, a day by
+// default. Browsers are sent to within PUSH_SEND_TIMEOUT, 5 seconds by
+// default. Native apps are sent to with senders, by platform. Each user
+// keeps PUSH_DEVICE_LIMIT devices, 10 by default, and transient failures
+// are retried PUSH_MAX_ATTEMPTS times, 5 by default, after PUSH_RETRY_BACKOFF
+// times the square of the attempt, 30 seconds by default.
+func NewPushService(pushRepo repositories.PushRepository, notificationService NotificationService, senders map[string]push.PushSender) (PushService, error) {
+	vapidKey, err := utils.GetVAPIDKey()
+	if err != nil {
+		return nil, err
+	}
+
+	sendTimeout := utils.GetEnvDuration("PUSH_SEND_TIMEOUT", 5*time.Second)
+	client, err := webpush.New(vapidKey, utils.GetEnv("VAPID_SUBJECT", "mailto:no-reply@localhost"), &http.Client{
+		Timeout: sendTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var services []*url.URL
+	for _, origin := range strings.Split(utils.GetEnv("PUSH_SERVICES", defaultPushServices), ",") {
+		service, err := url.Parse(strings.TrimSpace(origin))
+		if err != nil || len(service.Host) == 0 {
+			return nil, errors.New("push service is not an origin: " + origin)
+		}
+		services = append(services, service)
+	}
+
+	return &pushService{
+		pushRepository:      pushRepo,
+		notificationService: notificationService,
+		client:              client,
+		services:            services,
+		limit:               utils.GetEnvInt("PUSH_SUBSCRIPTION_LIMIT", 10),
+		ttl:                 utils.GetEnvDuration("PUSH_TTL", 24*time.Hour),
+		sendTimeout:         sendTimeout,
+		senders:             senders,
+		deviceLimit:         utils.GetEnvInt("PUSH_DEVICE_LIMIT", 10),
+		maxAttempts:         utils.GetEnvInt("PUSH_MAX_ATTEMPTS", 5),
+		retryBackoff:        utils.GetEnvDuration("PUSH_RETRY_BACKOFF", 30*time.Second),
+	}, nil
+}
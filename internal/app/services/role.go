@@ -0,0 +1,158 @@
+package services
+
+import (
+	"chat_backend/generated"
+	"chat_backend/internal/app/repositories"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"slices"
+)
+
+var (
+	ErrUnknownRole  = errors.New("role does not exist")
+	ErrUnknownUser  = errors.New("user does not exist")
+	ErrLastAdmin    = errors.New("user is the last admin")
+	ErrImplicitRole = errors.New("role is held by every user")
+)
+
+// RoleService grants permissions through roles. Every user implicitly holds
+// the user role, the others are assigned by admins.
+type RoleService interface {
+	HasPermission(ctx context.Context, userID uuid.UUID, permission string) (bool, error)
+	ListRoles(ctx context.Context) ([]generated.ListRolesRow, error)
+	GetUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error)
+	AssignRole(ctx context.Context, userID uuid.UUID, role string) (bool, error)
+	// RemoveRole refuses to remove the last admin, who would be the only one
+	// able to assign the role again. Admins pending deletion do not count,
+	// since their accounts are purged at the end of the restore window.
+	RemoveRole(ctx context.Context, userID uuid.UUID, role string) (bool, error)
+}
+
+type roleService struct {
+	roleRepository repositories.RoleRepository
+	txManager      repositories.TxManager
+}
+
+func (r *roleService) HasPermission(ctx context.Context, userID uuid.UUID, permission string) (bool, error) {
+	permissions, err := r.roleRepository.GetUserPermissions(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(permissions, permission), nil
+}
+
+func (r *roleService) ListRoles(ctx context.Context) ([]generated.ListRolesRow, error) {
+	return r.roleRepository.ListRoles(ctx)
+}
+
+func (r *roleService) GetUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	var roles []string
+
+	err := r.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		if err := userExists(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		assigned, err := tx.Role.GetUserRoles(ctx, userID)
+		roles = append([]string{repositories.RoleUser}, assigned...)
+		return err
+	})
+
+	return roles, err
+}
+
+func (r *roleService) AssignRole(ctx context.Context, userID uuid.UUID, role string) (bool, error) {
+	if role == repositories.RoleUser {
+		return false, ErrImplicitRole
+	}
+
+	var assigned bool
+
+	err := r.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		if err := roleExists(ctx, tx, role); err != nil {
+			return err
+		}
+		if err := userExists(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		var err error
+		assigned, err = tx.Role.AssignRole(ctx, userID, role)
+		return err
+	})
+
+	return assigned, err
+}
+
+func (r *roleService) RemoveRole(ctx context.Context, userID uuid.UUID, role string) (bool, error) {
+	if role == repositories.RoleUser {
+		return false, ErrImplicitRole
+	}
+
+	var removed bool
+
+	err := r.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		if role == repositories.RoleAdmin {
+			admins, err := tx.Role.CountRoleMembers(ctx, role)
+			if err != nil {
+				return err
+			}
+
+			roles, err := tx.Role.GetUserRoles(ctx, userID)
+			if err != nil {
+				return err
+			}
+
+			if admins == 1 && slices.Contains(roles, role) {
+				user, err := tx.Auth.GetUserAccountByID(ctx, userID)
+				if err != nil {
+					return err
+				}
+				// An admin pending deletion is not the one counted.
+				if !user.DeletedAt.Valid {
+					return ErrLastAdmin
+				}
+			}
+		}
+
+		var err error
+		removed, err = tx.Role.RemoveRole(ctx, userID, role)
+		return err
+	})
+
+	return removed, err
+}
+
+func roleExists(ctx context.Context, tx *repositories.Tx, role string) error {
+	roles, err := tx.Role.ListRoles(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range roles {
+		if r.Name == role {
+			return nil
+		}
+	}
+
+	return ErrUnknownRole
+}
+
+func userExists(ctx context.Context, tx *repositories.Tx, userID uuid.UUID) error {
+	_, err := tx.Auth.GetUserAccountByID(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUnknownUser
+	}
+
+	return err
+}
+
+func NewRoleService(r repositories.RoleRepository, tx repositories.TxManager) RoleService {
+	return &roleService{
+		roleRepository: r,
+		txManager:      tx,
+	}
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/utils"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication is not enrolled")
+	ErrInvalidCode          = errors.New("code is invalid")
+	ErrInvalidPassword      = errors.New("password is invalid")
+	ErrPasswordNotSet       = errors.New("account has no password")
+	ErrTwoFactorLocked      = errors.New("too many failed two-factor attempts")
+	ErrPreAuthTokenUsed     = errors.New("pre-auth token was already used")
+
+	// errWrongCode rolls back the claim of the pre-auth token, so that a
+	// mistyped code can be corrected.
+	errWrongCode = errors.New("two-factor code is wrong")
+
+	totpCode = regexp.MustCompile(`^\d{6}$`)
+)
+
+const (
+	recoveryCodeCount = 10
+	totpPeriod        = 30
+)
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TwoFactorService interface {
+	// Enroll generates a new pending secret. It only takes effect after Confirm.
+	// Accounts without a password of their own fail with ErrPasswordNotSet,
+	// since Disable asks for it.
+	Enroll(ctx context.Context, userID uuid.UUID) (*TwoFactorEnrollment, error)
+	// Confirm enables 2FA when code matches the pending secret and returns a
+	// fresh set of recovery codes.
+	Confirm(ctx context.Context, userID uuid.UUID, code string) ([]string, error)
+	Disable(ctx context.Context, userID uuid.UUID, password string) error
+	// Verify checks a TOTP code or burns a recovery code for the login
+	// started with preAuthToken, which is valid until expiresAt. The token is
+	// claimed before the code is looked at and kept only when the code is
+	// right, so a used token fails with ErrPreAuthTokenUsed without touching
+	// the codes. A TOTP code is accepted once. After too many failures the
+	// account is locked out of the second factor for a while and Verify
+	// fails with ErrTwoFactorLocked.
+	Verify(ctx context.Context, userID uuid.UUID, preAuthToken string, expiresAt time.Time, code string) (bool, error)
+}
+
+type twoFactorService struct {
+	authRepository      repositories.AuthRepository
+	twoFactorRepository repositories.TwoFactorRepository
+	txManager           repositories.TxManager
+	maxAttempts         int
+	lockout             time.Duration
+}
+
+func (t *twoFactorService) Enroll(ctx context.Context, userID uuid.UUID) (*TwoFactorEnrollment, error) {
+	user, err := t.authRepository.GetUserAccountByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TotpEnabledAt.Valid {
+		return nil, ErrTwoFactorEnabled
+	}
+	if !user.PasswordSet {
+		return nil, ErrPasswordNotSet
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      utils.GetEnv("TOTP_ISSUER", "Chat App"),
+		AccountName: user.Username,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.twoFactorRepository.SetSecret(ctx, userID, key.Secret()); err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+	}, nil
+}
+
+func (t *twoFactorService) Confirm(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	var codes []string
+
+	err := t.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		user, err := tx.Auth.GetUserAccountByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.TotpEnabledAt.Valid {
+			return ErrTwoFactorEnabled
+		}
+		if !user.TotpSecret.Valid {
+			return ErrTwoFactorNotEnrolled
+		}
+		step, ok := totpStep(code, user.TotpSecret.String, time.Now())
+		if !ok {
+			return ErrInvalidCode
+		}
+		if _, err := tx.TwoFactor.AcceptStep(ctx, userID, step); err != nil {
+			return err
+		}
+
+		if err := tx.TwoFactor.Enable(ctx, userID); err != nil {
+			return err
+		}
+
+		codes, err = tx.TwoFactor.CreateRecoveryCodes(ctx, userID, recoveryCodeCount)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (t *twoFactorService) Disable(ctx context.Context, userID uuid.UUID, password string) error {
+	return t.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		user, err := tx.Auth.GetUserAccountByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !user.TotpSecret.Valid {
+			return ErrTwoFactorNotEnrolled
+		}
+
+		ok, err := tx.Auth.VerifyPassword(user.Password, password)
+		if err != nil || !ok {
+			return ErrInvalidPassword
+		}
+
+		if err := tx.TwoFactor.SetSecret(ctx, userID, ""); err != nil {
+			return err
+		}
+
+		return tx.TwoFactor.DeleteRecoveryCodes(ctx, userID)
+	})
+}
+
+func (t *twoFactorService) Verify(ctx context.Context, userID uuid.UUID, preAuthToken string, expiresAt time.Time, code string) (bool, error) {
+	windowStart := time.Now().Add(-t.lockout)
+
+	err := t.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		fresh, err := tx.TwoFactor.UsePreAuthToken(ctx, preAuthToken, expiresAt)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrPreAuthTokenUsed
+		}
+
+		user, err := tx.Auth.GetUserAccountByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !user.TotpEnabledAt.Valid {
+			return ErrTwoFactorNotEnrolled
+		}
+
+		// The lockout is checked first, so that guessing recovery codes
+		// cannot keep the argon2 verification busy either.
+		state, err := tx.TwoFactor.GetState(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if int(state.FailedAttempts) >= t.maxAttempts && state.LastFailedAt.Time.After(windowStart) {
+			return ErrTwoFactorLocked
+		}
+
+		var ok bool
+		if totpCode.MatchString(code) {
+			if step, matched := totpStep(code, user.TotpSecret.String, time.Now()); matched {
+				ok, err = tx.TwoFactor.AcceptStep(ctx, userID, step)
+			}
+		} else {
+			ok, err = tx.TwoFactor.UseRecoveryCode(ctx, userID, code)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errWrongCode
+		}
+
+		if state.FailedAttempts > 0 {
+			return tx.TwoFactor.ResetFailures(ctx, userID)
+		}
+		return nil
+	})
+	if errors.Is(err, errWrongCode) {
+		return false, t.twoFactorRepository.RecordFailure(ctx, userID, windowStart)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// totpStep returns the time step, within one of now, that code is valid for.
+func totpStep(code, secret string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+
+	for step := current - 1; step <= current+1; step++ {
+		ok, _ := totp.ValidateCustom(code, secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if ok {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// NewTwoFactorService locks the second factor of an account for
+// TWO_FACTOR_LOCKOUT, 15 minutes by default, once TWO_FACTOR_MAX_ATTEMPTS
+// codes, 5 by default, failed within that time.
+func NewTwoFactorService(authRepo repositories.AuthRepository, twoFactorRepo repositories.TwoFactorRepository, txManager repositories.TxManager) TwoFactorService {
+	return &twoFactorService{
+		authRepository:      authRepo,
+		twoFactorRepository: twoFactorRepo,
+		txManager:           txManager,
+		maxAttempts:         utils.GetEnvInt("TWO_FACTOR_MAX_ATTEMPTS", 5),
+		lockout:             utils.GetEnvDuration("TWO_FACTOR_LOCKOUT", 15*time.Minute),
+	}
+}
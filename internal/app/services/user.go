@@ -3,33 +3,234 @@ package services
 import (
 	"chat_backend/generated"
 	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/mailer"
+	"chat_backend/pkg/password"
+	"chat_backend/pkg/utils"
+	"context"
+	"errors"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
 )
 
+// purgeBatchSize is the number of accounts PurgeDeletedUsers handles per query.
+const purgeBatchSize = 100
+
+var ErrDeletionPending = errors.New("account deletion is already pending")
+
 type UserService interface {
-	GetUserByID(id uuid.UUID) (generated.GetUserByIDRow, error)
-	UpdateUser(input *repositories.UpdateInput, id uuid.UUID) error
-	DeleteUser(id uuid.UUID) error
+	GetUserByID(ctx context.Context, id uuid.UUID) (generated.GetUserByIDRow, error)
+	// GetUserByUsername finds an account by its username or, failing that,
+	// by a name it used before. Compare the returned username to tell them
+	// apart.
+	GetUserByUsername(ctx context.Context, username string) (generated.GetPublicUserByUsernameRow, error)
+	UpdateUser(ctx context.Context, input *repositories.UpdateInput, id uuid.UUID) error
+	// ResetAvatar replaces the avatar with the identicon generated at signup.
+	ResetAvatar(ctx context.Context, id uuid.UUID) error
+	// DeleteUser schedules the account for deletion and returns when it will
+	// be purged. Signing in before then restores it.
+	DeleteUser(ctx context.Context, id uuid.UUID) (time.Time, error)
+	// IsActive reports whether the account exists and is not pending
+	// deletion.
+	IsActive(ctx context.Context, id uuid.UUID) (bool, error)
+	// PurgeDeletedUsers anonymizes the accounts whose restore window has
+	// ended and removes their personal data and uploads. It returns the
+	// number of accounts purged.
+	PurgeDeletedUsers(ctx context.Context) (int, error)
 }
 
 type userService struct {
 	userRepository repositories.UserRepository
+	txManager      repositories.TxManager
+	mailer         mailer.Mailer
+	passwordPolicy *password.Policy
+	usernamePolicy *UsernamePolicy
+	deletionGrace  time.Duration
+	jobs           *background
+}
+
+func (u *userService) DeleteUser(ctx context.Context, id uuid.UUID) (time.Time, error) {
+	purgeAt := time.Now().Add(u.deletionGrace)
+
+	scheduled, err := u.userRepository.ScheduleDeletion(ctx, id, purgeAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !scheduled {
+		return time.Time{}, ErrDeletionPending
+	}
+
+	return purgeAt, nil
+}
+
+func (u *userService) IsActive(ctx context.Context, id uuid.UUID) (bool, error) {
+	return u.userRepository.IsActive(ctx, id)
+}
+
+func (u *userService) PurgeDeletedUsers(ctx context.Context) (int, error) {
+	purged := 0
+
+	for {
+		ids, err := u.userRepository.ListUsersToPurge(ctx, purgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+
+		var errs []error
+		for _, id := range ids {
+			// Uploads go first so that a failure leaves the account due and
+			// the next run retries it.
+			if err := u.userRepository.DeleteMedia(ctx, id); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			var ok bool
+			err := u.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+				var err error
+				ok, err = tx.User.PurgeUser(ctx, id)
+				return err
+			})
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if ok {
+				purged++
+			}
+		}
+
+		if len(errs) > 0 || len(ids) < purgeBatchSize {
+			return purged, errors.Join(errs...)
+		}
+	}
+}
+
+func (u *userService) UpdateUser(ctx context.Context, input *repositories.UpdateInput, id uuid.UUID) error {
+	if err := normalizeProfile(input); err != nil {
+		return err
+	}
+
+	var avatar repositories.Avatar
+	if input.Avatar != nil {
+		var err error
+		avatar, err = u.userRepository.UploadAvatar(ctx, id, input.Avatar)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := u.txManager.WithinTx(ctx, func(ctx context.Context, tx *repositories.Tx) error {
+		if input.Avatar != nil {
+			if err := tx.User.SetAvatar(ctx, id, avatar); err != nil {
+				return err
+			}
+		}
+
+		if len(input.Password) > 0 || len(input.Username) > 0 {
+			user, err := tx.User.GetUserByID(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			username := user.Username
+			if len(input.Username) > 0 {
+				if err := u.usernamePolicy.changeUsername(ctx, tx, id, user.Username, input.Username); err != nil {
+					return err
+				}
+				username = input.Username
+			}
+
+			if len(input.Password) > 0 {
+				if err := u.passwordPolicy.Check(input.Password, username); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.User.UpdateUser(ctx, input, id); err != nil {
+			return err
+		}
+
+		if len(input.Email) == 0 {
+			return nil
+		}
+
+		email := repositories.NormalizeEmail(input.Email)
+
+		owner, err := tx.Auth.GetUserByEmail(ctx, email)
+		if err == nil {
+			if owner.ID == id {
+				return nil
+			}
+			return ErrEmailTaken
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		if err := tx.Auth.UpdateEmail(ctx, id, email); err != nil {
+			return err
+		}
+
+		return sendTokenMail(ctx, tx, u.jobs, u.mailer, id, email, repositories.TokenEmailVerification)
+	})
+	if err != nil {
+		u.userRepository.DiscardAvatar(ctx, avatar)
+	}
+
+	return err
 }
 
-func (u *userService) DeleteUser(id uuid.UUID) error {
-	return u.userRepository.DeleteUser(id)
+func (u *userService) ResetAvatar(ctx context.Context, id uuid.UUID) error {
+	return generateAvatar(ctx, u.userRepository, id)
 }
 
-func (u *userService) UpdateUser(input *repositories.UpdateInput, id uuid.UUID) error {
-	return u.userRepository.UpdateUser(input, id)
+// generateAvatar gives an account its identicon. New accounts get it once
+// they are committed, so that signing up neither uploads inside its
+// transaction nor depends on the storage.
+func generateAvatar(ctx context.Context, users repositories.UserRepository, id uuid.UUID) error {
+	avatar, err := users.UploadIdenticon(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := users.SetAvatar(ctx, id, avatar); err != nil {
+		users.DiscardAvatar(ctx, avatar)
+		return err
+	}
+
+	return nil
+}
+
+func (u *userService) GetUserByUsername(ctx context.Context, username string) (generated.GetPublicUserByUsernameRow, error) {
+	user, err := u.userRepository.GetPublicUser(ctx, username)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return user, err
+	}
+
+	current, err := u.userRepository.ResolveUsername(ctx, username)
+	if err != nil {
+		return user, err
+	}
+
+	return u.userRepository.GetPublicUser(ctx, current)
 }
 
-func (u *userService) GetUserByID(id uuid.UUID) (generated.GetUserByIDRow, error) {
-	return u.userRepository.GetUserByID(id)
+func (u *userService) GetUserByID(ctx context.Context, id uuid.UUID) (generated.GetUserByIDRow, error) {
+	return u.userRepository.GetUserByID(ctx, id)
 }
 
-func NewUserService(r repositories.UserRepository) UserService {
+// NewUserService keeps deleted accounts restorable for ACCOUNT_DELETION_GRACE,
+// 30 days by default.
+func NewUserService(r repositories.UserRepository, txManager repositories.TxManager, m mailer.Mailer, policy *password.Policy, usernames *UsernamePolicy) UserService {
 	return &userService{
 		userRepository: r,
+		txManager:      txManager,
+		mailer:         m,
+		passwordPolicy: policy,
+		usernamePolicy: usernames,
+		deletionGrace:  utils.GetEnvDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour),
+		jobs:           newBackground(utils.GetEnvInt("MAIL_CONCURRENCY", 16)),
 	}
 }
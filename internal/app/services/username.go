@@ -0,0 +1,117 @@
+package services
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/pkg/utils"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"strings"
+	"time"
+)
+
+// deletedUsernamePrefix starts the names of purged accounts, see PurgeUser.
+const deletedUsernamePrefix = "deleted_"
+
+var (
+	ErrUsernameTaken       = errors.New("username is taken")
+	ErrUsernameReserved    = errors.New("username is reserved")
+	ErrUsernameChangeLimit = errors.New("username was changed too often")
+)
+
+// UsernamePolicy decides which usernames can be claimed and how often an
+// account can change its own.
+type UsernamePolicy struct {
+	reserved []string
+	// cooldown is how long a released name is held for its previous owner.
+	cooldown     time.Duration
+	changeLimit  int
+	changePeriod time.Duration
+}
+
+// NewUsernamePolicy reads the comma-separated RESERVED_USERNAMES, admin,
+// system and support by default, USERNAME_COOLDOWN, 30 days by default, and
+// allows USERNAME_CHANGE_LIMIT changes, 3 by default, per
+// USERNAME_CHANGE_PERIOD, 30 days by default.
+func NewUsernamePolicy() *UsernamePolicy {
+	var reserved []string
+	for _, name := range strings.Split(utils.GetEnv("RESERVED_USERNAMES", "admin,system,support"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); len(name) > 0 {
+			reserved = append(reserved, name)
+		}
+	}
+
+	return &UsernamePolicy{
+		reserved:     reserved,
+		cooldown:     utils.GetEnvDuration("USERNAME_COOLDOWN", 30*24*time.Hour),
+		changeLimit:  utils.GetEnvInt("USERNAME_CHANGE_LIMIT", 3),
+		changePeriod: utils.GetEnvDuration("USERNAME_CHANGE_PERIOD", 30*24*time.Hour),
+	}
+}
+
+// IsReserved reports whether nobody may claim username.
+func (p *UsernamePolicy) IsReserved(username string) bool {
+	username = strings.ToLower(username)
+	if strings.HasPrefix(username, deletedUsernamePrefix) {
+		return true
+	}
+
+	for _, name := range p.reserved {
+		if username == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkAvailable returns ErrUsernameReserved or ErrUsernameTaken unless the
+// account userID, uuid.Nil for a new one, can claim username. A name another
+// account released within the cooldown counts as taken.
+func (p *UsernamePolicy) checkAvailable(ctx context.Context, tx *repositories.Tx, userID uuid.UUID, username string) error {
+	if p.IsReserved(username) {
+		return ErrUsernameReserved
+	}
+
+	owner, err := tx.Auth.GetUserByUsername(ctx, username)
+	if err == nil && owner.ID != userID {
+		return ErrUsernameTaken
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	holder, err := tx.User.GetUsernameHolder(ctx, username, time.Now().Add(-p.cooldown))
+	if err == nil && holder != userID {
+		return ErrUsernameTaken
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	return nil
+}
+
+// changeUsername checks and records the change of the account's username
+// from current to username. Changing only the case keeps the name and is not
+// recorded.
+func (p *UsernamePolicy) changeUsername(ctx context.Context, tx *repositories.Tx, userID uuid.UUID, current, username string) error {
+	if strings.EqualFold(current, username) {
+		return nil
+	}
+
+	if err := p.checkAvailable(ctx, tx, userID, username); err != nil {
+		return err
+	}
+
+	changes, err := tx.User.CountUsernameChanges(ctx, userID, time.Now().Add(-p.changePeriod))
+	if err != nil {
+		return err
+	}
+	if changes >= int64(p.changeLimit) {
+		return ErrUsernameChangeLimit
+	}
+
+	return tx.User.RecordUsernameChange(ctx, userID, current)
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/internal/app/services"
+	"errors"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/gookit/validate"
+	"log/slog"
+	"strings"
+)
+
+type ApiTokenSwagger struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+type CreatedApiTokenSwagger struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Token     string   `json:"token"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt string   `json:"expires_at"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// CreateApiTokenHandler creates a personal access token.
+//
+//	@Summary		Create API token
+//	@Description	Creates a personal access token with the given scopes (read:messages, write:messages, profile, admin) and optional expiry. The token is only returned in this response and is sent as Authorization: Bearer.
+//	@Tags			API tokens
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		repositories.ApiTokenInput	true	"Token name, scopes and expiry"
+//	@Success		201		{object}	CreatedApiTokenSwagger
+//	@Failure		400		{object}	ErrorResponseSwagger
+//	@Failure		403		{object}	ErrorResponseSwagger
+//	@Router			/user/tokens [post]
+func CreateApiTokenHandler(s services.ApiTokenService, audit services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		input := new(repositories.ApiTokenInput)
+
+		if err := ctx.BodyParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		token, err := s.CreateToken(ctx.UserContext(), userID, input)
+		switch {
+		case errors.Is(err, services.ErrInvalidScope):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Scope must be one of " + strings.Join(services.Scopes, ", ") + ".",
+			})
+		case errors.Is(err, services.ErrInvalidExpiry):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Expiry must be in the future.",
+			})
+		case errors.Is(err, services.ErrTooManyApiTokens):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Too many API tokens, revoke one first.",
+			})
+		case err != nil:
+			slog.ErrorContext(ctx.UserContext(), "create api token", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		recordAudit(ctx, audit, services.AuditApiTokenCreate, userID, userID, fiber.Map{
+			"token_id": token.ID,
+			"scopes":   token.Scopes,
+		})
+
+		return ctx.Status(fiber.StatusCreated).JSON(token)
+	}
+}
+
+// ListApiTokensHandler lists the personal access tokens.
+//
+//	@Summary		List API tokens
+//	@Description	Lists the personal access tokens of the account, without their secret part.
+//	@Tags			API tokens
+//	@Produce		json
+//	@Success		200	{array}	ApiTokenSwagger
+//	@Failure		500
+//	@Router			/user/tokens [get]
+func ListApiTokensHandler(s services.ApiTokenService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		tokens, err := s.ListTokens(ctx.UserContext(), userID)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "list api tokens", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(tokens)
+	}
+}
+
+// RevokeApiTokenHandler revokes a personal access token.
+//
+//	@Summary		Revoke API token
+//	@Description	Revokes a personal access token. It stops working immediately.
+//	@Tags			API tokens
+//	@Produce		plain
+//	@Param			id	path		string	true	"Token ID"
+//	@Success		200	{string}	string	"OK"
+//	@Failure		404
+//	@Router			/user/tokens/{id} [delete]
+func RevokeApiTokenHandler(s services.ApiTokenService, audit services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		id, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		revoked, err := s.RevokeToken(ctx.UserContext(), userID, id)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "revoke api token", "error", err)
+			return fiber.ErrInternalServerError
+		}
+		if !revoked {
+			return fiber.ErrNotFound
+		}
+
+		recordAudit(ctx, audit, services.AuditApiTokenRevoke, userID, userID, fiber.Map{
+			"token_id": id,
+		})
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
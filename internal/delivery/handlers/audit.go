@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/internal/app/services"
+	"chat_backend/pkg/utils"
+	"errors"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/gookit/validate"
+	"log/slog"
+)
+
+type AuditEntrySwagger struct {
+	ID        int64                  `json:"id"`
+	Action    string                 `json:"action"`
+	ActorID   string                 `json:"actor_id"`
+	TargetID  string                 `json:"target_id"`
+	IP        string                 `json:"ip"`
+	UserAgent string                 `json:"user_agent"`
+	RequestID string                 `json:"request_id"`
+	Metadata  map[string]interface{} `json:"metadata"`
+	CreatedAt string                 `json:"created_at"`
+}
+
+type AuditPageSwagger struct {
+	Events []AuditEntrySwagger `json:"events"`
+	Next   int64               `json:"next"`
+}
+
+// recordAudit appends an event to the audit log with the client's address,
+// user agent and the request ID. A failure is logged but does not fail the
+// request, which has already taken effect.
+func recordAudit(ctx *fiber.Ctx, s services.AuditService, action string, actor, target uuid.UUID, metadata fiber.Map) {
+	err := s.Record(ctx.UserContext(), services.AuditEvent{
+		Action:    action,
+		ActorID:   actor,
+		TargetID:  target,
+		IP:        ctx.IP(),
+		UserAgent: ctx.Get(fiber.HeaderUserAgent),
+		RequestID: utils.RequestIDFromContext(ctx.UserContext()),
+		Metadata:  metadata,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx.UserContext(), "record audit event", "action", action, "error", err)
+	}
+}
+
+// ListAuditEventsHandler lists the audit log.
+//
+//	@Summary		List audit events
+//	@Description	Lists audit events newest first, optionally filtered by action, actor, target and time range. Pass next as before to get the following page. Requires the audit:read permission.
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			action	query		string	false	"Action, such as auth.login"
+//	@Param			actor	query		string	false	"Actor user ID"
+//	@Param			target	query		string	false	"Target user ID"
+//	@Param			since	query		string	false	"RFC 3339 time, inclusive"
+//	@Param			until	query		string	false	"RFC 3339 time, exclusive"
+//	@Param			before	query		int		false	"Return events older than this ID"
+//	@Param			limit	query		int		false	"Page size, 50 by default and at most 100"
+//	@Success		200		{object}	AuditPageSwagger
+//	@Failure		400		{object}	ErrorResponseSwagger
+//	@Failure		403		{object}	ErrorResponseSwagger
+//	@Router			/admin/audit [get]
+func ListAuditEventsHandler(s services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		filter := new(repositories.AuditFilter)
+
+		if err := ctx.QueryParser(filter); err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		v := validate.New(filter)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		page, err := s.ListEvents(ctx.UserContext(), filter)
+		if errors.Is(err, services.ErrInvalidAuditFilter) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Actor and target must be user IDs, since and until RFC 3339 times.",
+			})
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "list audit events", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(page)
+	}
+}
+
+// SecurityActivityHandler lists the account's own audit events.
+//
+//	@Summary		List security activity
+//	@Description	Lists the security events of the account newest first, such as sign ins and password changes. Events another user caused on the account, such as an admin changing its roles, leave out that user's IP and user agent. Pass next as before to get the following page.
+//	@Tags			Profile
+//	@Produce		json
+//	@Param			before	query		int	false	"Return events older than this ID"
+//	@Param			limit	query		int	false	"Page size, 50 by default and at most 100"
+//	@Success		200		{object}	AuditPageSwagger
+//	@Failure		500
+//	@Router			/user/security/activity [get]
+func SecurityActivityHandler(s services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		page, err := s.ListUserEvents(ctx.UserContext(), userID, int64(ctx.QueryInt("before")), ctx.QueryInt("limit"))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "list security activity", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(page)
+	}
+}
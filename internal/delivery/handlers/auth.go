@@ -3,11 +3,15 @@ package handlers
 import (
 	"chat_backend/internal/app/repositories"
 	"chat_backend/internal/app/services"
+	"chat_backend/internal/delivery/middlewares"
+	"chat_backend/pkg/password"
 	"chat_backend/pkg/utils"
+	"errors"
 	pasetoware "github.com/gofiber/contrib/paseto"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"github.com/gookit/validate"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"time"
@@ -17,10 +21,34 @@ var (
 	prod, _ = strconv.ParseBool(os.Getenv("PROD"))
 )
 
+const sessionTTL = time.Hour
+
 type ErrorResponseSwagger struct {
 	Message string `json:"message"`
 }
 
+type SessionTokenSwagger struct {
+	Token     string `json:"token"`
+	TokenType string `json:"token_type"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+type PasswordPolicyErrorSwagger struct {
+	Message string            `json:"message"`
+	Score   int               `json:"score"`
+	Reasons []password.Reason `json:"reasons"`
+}
+
+// passwordRejected tells the client why the password policy rejected a
+// password.
+func passwordRejected(ctx *fiber.Ctx, err *password.PolicyError) error {
+	return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"message": "Password does not meet the policy.",
+		"score":   err.Score,
+		"reasons": err.Reasons,
+	})
+}
+
 // SignUpHandler handles the signup route.
 //
 //	@Summary		Handle user registration and create a new user.
@@ -31,9 +59,10 @@ type ErrorResponseSwagger struct {
 //	@Param			input	body		repositories.AuthInput	true	"User registration details"
 //	@Success		201		{string}	string					"Created"
 //	@Failure		400		{object}	ErrorResponseSwagger
-//	@Failure		403
+//	@Failure		403		{object}	PasswordPolicyErrorSwagger
+//	@Failure		500
 //	@Router			/auth/signup [post]
-func SignUpHandler(s services.AuthService) fiber.Handler {
+func SignUpHandler(s services.AuthService, audit services.AuditService) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		input := new(repositories.AuthInput)
 
@@ -46,7 +75,7 @@ func SignUpHandler(s services.AuthService) fiber.Handler {
 			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
 		}
 
-		user, _ := s.GetUserByUsername(input.Username)
+		user, _ := s.GetUserByUsername(ctx.UserContext(), input.Username)
 
 		if len(user.Username) > 0 {
 			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
@@ -54,9 +83,40 @@ func SignUpHandler(s services.AuthService) fiber.Handler {
 			})
 		}
 
-		err := s.CreateNewUser(input)
-		if err != nil {
-			log.Printf("Error in /signup - create new user: %v", err)
+		if len(input.Email) > 0 {
+			user, _ = s.GetUserByEmail(ctx.UserContext(), input.Email)
+
+			if len(user.Username) > 0 {
+				return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"message": "Email already in use.",
+				})
+			}
+		}
+
+		err := s.CreateNewUser(ctx.UserContext(), input)
+		var policyErr *password.PolicyError
+		switch {
+		case errors.Is(err, services.ErrUsernameReserved):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Username is reserved.",
+			})
+		case errors.Is(err, services.ErrUsernameTaken):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "User already exists.",
+			})
+		case errors.Is(err, services.ErrEmailTaken):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Email already in use.",
+			})
+		case errors.As(err, &policyErr):
+			return passwordRejected(ctx, policyErr)
+		case err != nil:
+			slog.ErrorContext(ctx.UserContext(), "create new user", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		if user, err = s.GetUserByUsername(ctx.UserContext(), input.Username); err == nil {
+			recordAudit(ctx, audit, services.AuditSignup, user.ID, user.ID, nil)
 		}
 
 		return ctx.SendStatus(fiber.StatusCreated)
@@ -66,16 +126,19 @@ func SignUpHandler(s services.AuthService) fiber.Handler {
 // LoginHandler handles the login route.
 //
 //	@Summary		Handle user login and generate an authentication token.
-//	@Description	Handle user login and generate an authentication token.
+//	@Description	Handle user login and generate an authentication token. When two-factor authentication is enabled a short-lived pre-auth token is issued instead, to be completed at /auth/2fa/verify. With mode=token the tokens are returned in the body instead of cookies, and the session token is sent as Authorization: Bearer.
 //	@Tags			Authentication
 //	@Accept			json
-//	@Produce		plain
+//	@Produce		json
 //	@Param			input	body		repositories.AuthInput	true	"User login details"
-//	@Success		200		{string}	string					"OK"
+//	@Param			mode	query		string					false	"Set to token to receive the tokens in the body"	Enums(token)
+//	@Success		200		{object}	SessionTokenSwagger
 //	@Failure		400		{object}	ErrorResponseSwagger
 //	@Failure		403
 //	@Router			/auth/login [post]
-func LoginHandler(s services.AuthService) fiber.Handler {
+func LoginHandler(s services.AuthService, keyring services.KeyringService, audit services.AuditService) fiber.Handler {
+	preAuthTTL := utils.GetEnvDuration("PRE_AUTH_TTL", 5*time.Minute)
+
 	return func(ctx *fiber.Ctx) error {
 		input := new(repositories.AuthInput)
 
@@ -88,9 +151,13 @@ func LoginHandler(s services.AuthService) fiber.Handler {
 			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
 		}
 
-		user, _ := s.GetUserByUsername(input.Username)
+		user, _ := s.GetUserByUsername(ctx.UserContext(), input.Username)
 
 		if len(user.Username) == 0 {
+			recordAudit(ctx, audit, services.AuditLoginFailed, uuid.Nil, uuid.Nil, fiber.Map{
+				"username": input.Username,
+				"reason":   "unknown_user",
+			})
 			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"message": "User not exists.",
 			})
@@ -98,30 +165,154 @@ func LoginHandler(s services.AuthService) fiber.Handler {
 
 		verifyPassword, err := s.VerifyPassword(user.Password, input.Password)
 		if err != nil {
-			log.Printf("Error in /login - verify password: %v", err)
+			slog.ErrorContext(ctx.UserContext(), "verify password", "error", err)
 		}
 		if !verifyPassword {
+			recordAudit(ctx, audit, services.AuditLoginFailed, uuid.Nil, user.ID, fiber.Map{
+				"reason": "wrong_password",
+			})
 			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"message": "Password not correct.",
 			})
 		}
 
-		userID := user.ID.String()
-		token, err := pasetoware.CreateToken(utils.GetPrivateKey(), userID, 1*time.Hour, pasetoware.PurposePublic)
-		if err != nil {
-			log.Printf("Error in /login - create new paseto token: %v", err)
+		if err := s.UpgradePassword(ctx.UserContext(), user, input.Password); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "upgrade password hash", "error", err)
 		}
 
-		ctx.Cookie(&fiber.Cookie{
-			Name:     "chat_app",
-			Value:    token,
-			HTTPOnly: prod,
-			Secure:   prod,
-			SameSite: fiber.CookieSameSiteStrictMode,
+		if user.TotpEnabledAt.Valid {
+			if tokenMode(ctx) {
+				token, err := preAuthToken(user.ID.String(), preAuthTTL)
+				if err != nil {
+					slog.ErrorContext(ctx.UserContext(), "create pre-auth token", "error", err)
+					return fiber.ErrInternalServerError
+				}
+
+				return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+					"two_factor_required": true,
+					"pre_auth_token":      token,
+				})
+			}
+
+			if err := setPreAuthCookie(ctx, user.ID.String(), preAuthTTL); err != nil {
+				slog.ErrorContext(ctx.UserContext(), "create pre-auth token", "error", err)
+				return fiber.ErrInternalServerError
+			}
+
+			return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+				"two_factor_required": true,
+			})
+		}
+
+		if err := restoreAccount(ctx, s, audit, user.ID); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "restore account", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		if err := sendSession(ctx, keyring, user.ID.String()); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "create session token", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		recordAudit(ctx, audit, services.AuditLogin, user.ID, user.ID, fiber.Map{
+			"method": "password",
 		})
 
+		return nil
+	}
+}
+
+// restoreAccount cancels a pending deletion of the account, which every
+// completed sign in does during the restore window.
+func restoreAccount(ctx *fiber.Ctx, s services.AuthService, audit services.AuditService, userID uuid.UUID) error {
+	restored, err := s.RestoreUser(ctx.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	if restored {
+		recordAudit(ctx, audit, services.AuditAccountRestore, userID, userID, nil)
+	}
+
+	return nil
+}
+
+// preAuthToken creates the token that /auth/2fa/verify exchanges for a
+// session once the second factor is checked.
+func preAuthToken(userID string, ttl time.Duration) (string, error) {
+	key, err := utils.GetPreAuthKey()
+	if err != nil {
+		return "", err
+	}
+
+	return pasetoware.CreateToken(key, userID, ttl, pasetoware.PurposeLocal)
+}
+
+func setPreAuthCookie(ctx *fiber.Ctx, userID string, ttl time.Duration) error {
+	token, err := preAuthToken(userID, ttl)
+	if err != nil {
+		return err
+	}
+
+	ctx.Cookie(&fiber.Cookie{
+		Name:     "chat_app_2fa",
+		Value:    token,
+		HTTPOnly: prod,
+		Secure:   prod,
+		SameSite: fiber.CookieSameSiteStrictMode,
+		Expires:  time.Now().Add(ttl),
+	})
+
+	return nil
+}
+
+// tokenMode reports whether the client asked for tokens in the response body
+// with ?mode=token, as native and bot clients do, instead of cookies.
+func tokenMode(ctx *fiber.Ctx) bool {
+	return ctx.Query("mode") == "token"
+}
+
+// setSessionCookie issues the session token for userID, signed with the
+// newest key in the keyring.
+func setSessionCookie(ctx *fiber.Ctx, keyring services.KeyringService, userID string) error {
+	token, err := keyring.Sign(ctx.UserContext(), userID, sessionTTL)
+	if err != nil {
+		return err
+	}
+
+	ctx.Cookie(&fiber.Cookie{
+		Name:     "chat_app",
+		Value:    token,
+		HTTPOnly: prod,
+		Secure:   prod,
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+
+	return nil
+}
+
+// sendSession completes a sign in. In token mode the session token is
+// returned to be sent as Authorization: Bearer, otherwise it is set as the
+// cookie.
+func sendSession(ctx *fiber.Ctx, keyring services.KeyringService, userID string) error {
+	if !tokenMode(ctx) {
+		if err := setSessionCookie(ctx, keyring, userID); err != nil {
+			return err
+		}
+
 		return ctx.SendStatus(fiber.StatusOK)
 	}
+
+	token, err := keyring.Sign(ctx.UserContext(), userID, sessionTTL)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+		"token":      token,
+		"token_type": "Bearer",
+		"expires_in": int(sessionTTL.Seconds()),
+	})
 }
 
 // SignOutHandler handles the signout route.
@@ -132,8 +323,17 @@ func LoginHandler(s services.AuthService) fiber.Handler {
 //	@Produce		plain
 //	@Success		200	{string}	string	"OK"
 //	@Router			/auth/signout [post]
-func SignOutHandler() fiber.Handler {
+func SignOutHandler(audit services.AuditService, push services.PushService) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
+		if userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string)); err == nil {
+			recordAudit(ctx, audit, services.AuditSignout, userID, userID, nil)
+		}
+
+		// The apps of the session stop receiving notifications.
+		if err := push.EndSession(ctx.UserContext(), middlewares.SessionToken(ctx)); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "end push session", "error", err)
+		}
+
 		ctx.Cookie(&fiber.Cookie{
 			Name:     "chat_app",
 			Value:    "",
@@ -145,3 +345,132 @@ func SignOutHandler() fiber.Handler {
 		return ctx.SendStatus(fiber.StatusOK)
 	}
 }
+
+// ForgotPasswordHandler handles the forgot password route.
+//
+//	@Summary		Send a password reset link.
+//	@Description	Send a password reset link to the verified email address. The response does not reveal whether the address is known.
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		plain
+//	@Param			input	body		repositories.ForgotPasswordInput	true	"Account email"
+//	@Success		202		{string}	string								"Accepted"
+//	@Failure		403
+//	@Failure		429
+//	@Router			/auth/password/forgot [post]
+func ForgotPasswordHandler(s services.AuthService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		input := new(repositories.ForgotPasswordInput)
+
+		if err := ctx.BodyParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		s.ForgotPassword(ctx.UserContext(), input.Email)
+
+		return ctx.SendStatus(fiber.StatusAccepted)
+	}
+}
+
+// PasswordPolicyHandler handles the password policy route.
+//
+//	@Summary		Get the password policy.
+//	@Description	Get the requirements new passwords must meet, so they can be shown before submitting.
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{object}	password.Policy
+//	@Router			/auth/password/policy [get]
+func PasswordPolicyHandler(policy *password.Policy) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		return ctx.Status(fiber.StatusOK).JSON(policy)
+	}
+}
+
+// ResetPasswordHandler handles the reset password route.
+//
+//	@Summary		Reset the password with a token.
+//	@Description	Reset the password with the single-use token from the reset email.
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		plain
+//	@Param			input	body		repositories.ResetPasswordInput	true	"Reset token and new password"
+//	@Success		200		{string}	string							"OK"
+//	@Failure		403		{object}	PasswordPolicyErrorSwagger
+//	@Router			/auth/password/reset [post]
+func ResetPasswordHandler(s services.AuthService, audit services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		input := new(repositories.ResetPasswordInput)
+
+		if err := ctx.BodyParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		userID, err := s.ResetPassword(ctx.UserContext(), input)
+		if errors.Is(err, services.ErrInvalidToken) {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Token is invalid or expired.",
+			})
+		}
+		var policyErr *password.PolicyError
+		if errors.As(err, &policyErr) {
+			return passwordRejected(ctx, policyErr)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "reset password", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		recordAudit(ctx, audit, services.AuditPasswordReset, userID, userID, nil)
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
+
+// VerifyEmailHandler handles the email verification route.
+//
+//	@Summary		Verify an email address.
+//	@Description	Verify an email address with the single-use token from the verification email.
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		plain
+//	@Param			input	body		repositories.VerifyEmailInput	true	"Verification token"
+//	@Success		200		{string}	string							"OK"
+//	@Failure		403		{object}	ErrorResponseSwagger
+//	@Router			/auth/email/verify [post]
+func VerifyEmailHandler(s services.AuthService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		input := new(repositories.VerifyEmailInput)
+
+		if err := ctx.BodyParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		err := s.VerifyEmail(ctx.UserContext(), input.Token)
+		if errors.Is(err, services.ErrInvalidToken) {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Token is invalid or expired.",
+			})
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "verify email", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
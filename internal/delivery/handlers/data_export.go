@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"chat_backend/internal/app/services"
+	"errors"
+	"fmt"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+	"time"
+)
+
+type DataExportSwagger struct {
+	ID          string `json:"id"`
+	Status      string `json:"status" enums:"pending,running,ready,failed"`
+	CreatedAt   string `json:"created_at"`
+	CompletedAt string `json:"completed_at"`
+	ExpiresAt   string `json:"expires_at"`
+}
+
+// RequestDataExportHandler queues an export of the account's data.
+//
+//	@Summary		Request data export
+//	@Description	Queues a ZIP archive of the account's data with data.json, a readable index.html and the avatar. A verified email address is notified when it is ready. Only one export can be in progress.
+//	@Tags			Data export
+//	@Produce		json
+//	@Success		202	{object}	DataExportSwagger
+//	@Failure		403	{object}	ErrorResponseSwagger
+//	@Router			/user/export [post]
+func RequestDataExportHandler(s services.DataExportService, audit services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		export, err := s.RequestExport(ctx.UserContext(), userID)
+		if errors.Is(err, services.ErrExportInProgress) {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "A data export is already in progress.",
+			})
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "request data export", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		recordAudit(ctx, audit, services.AuditDataExport, userID, userID, fiber.Map{
+			"export_id": export.ID,
+		})
+
+		return ctx.Status(fiber.StatusAccepted).JSON(export)
+	}
+}
+
+// ListDataExportsHandler lists the account's data exports.
+//
+//	@Summary		List data exports
+//	@Description	Lists the data exports of the account that have not expired, newest first.
+//	@Tags			Data export
+//	@Produce		json
+//	@Success		200	{array}	DataExportSwagger
+//	@Failure		500
+//	@Router			/user/export [get]
+func ListDataExportsHandler(s services.DataExportService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		exports, err := s.ListExports(ctx.UserContext(), userID)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "list data exports", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(exports)
+	}
+}
+
+// DownloadDataExportHandler sends a finished export.
+//
+//	@Summary		Download data export
+//	@Description	Downloads the ZIP archive of a ready data export.
+//	@Tags			Data export
+//	@Produce		application/zip
+//	@Param			id	path		string	true	"Export ID"
+//	@Success		200	{file}		file
+//	@Failure		404
+//	@Router			/user/export/{id} [get]
+func DownloadDataExportHandler(s services.DataExportService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		id, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		archive, err := s.GetArchive(ctx.UserContext(), userID, id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fiber.ErrNotFound
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "get data export", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		ctx.Set(fiber.HeaderContentType, "application/zip")
+		ctx.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="chat-export-%s.zip"`, time.Now().UTC().Format("2006-01-02")))
+		ctx.Set(fiber.HeaderCacheControl, "no-store")
+
+		return ctx.Status(fiber.StatusOK).Send(archive)
+	}
+}
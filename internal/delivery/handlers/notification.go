@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/internal/app/services"
+	"errors"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/gookit/validate"
+	"log/slog"
+)
+
+type NotificationSettingsSwagger struct {
+	Level      string `json:"level" enums:"all,mentions,none"`
+	MutedUntil string `json:"muted_until"`
+	DNDStart   string `json:"dnd_start" example:"22:00"`
+	DNDEnd     string `json:"dnd_end" example:"07:00"`
+	Timezone   string `json:"timezone" example:"Europe/Berlin"`
+}
+
+// GetNotificationSettingsHandler returns the account-wide notification
+// settings.
+//
+//	@Summary		Get notification settings
+//	@Description	Returns the account-wide notification level, the mute and the do-not-disturb schedule, read in the timezone of the profile or UTC without one.
+//	@Tags			Notifications
+//	@Produce		json
+//	@Success		200	{object}	NotificationSettingsSwagger
+//	@Failure		500
+//	@Router			/user/notifications [get]
+func GetNotificationSettingsHandler(s services.NotificationService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		settings, err := s.GetSettings(ctx.UserContext(), userID)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "get notification settings", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(settings)
+	}
+}
+
+// UpdateNotificationSettingsHandler changes the account-wide notification
+// settings.
+//
+//	@Summary		Update notification settings
+//	@Description	Changes the account-wide settings that are sent; there are no per-conversation settings yet. The level is all, mentions or none. muted_until silences every notification until an RFC 3339 time. dnd_start and dnd_end, as HH:MM, hold notifications back every day and may wrap past midnight. Empty strings clear the mute and the schedule.
+//	@Tags			Notifications
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		repositories.NotificationSettingsInput	true	"Notification settings"
+//	@Success		200		{object}	NotificationSettingsSwagger
+//	@Failure		400		{object}	ErrorResponseSwagger
+//	@Failure		403
+//	@Router			/user/notifications [patch]
+func UpdateNotificationSettingsHandler(s services.NotificationService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		input := new(repositories.NotificationSettingsInput)
+
+		if err := ctx.BodyParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		settings, err := s.UpdateSettings(ctx.UserContext(), userID, input)
+		switch {
+		case errors.Is(err, services.ErrInvalidMuteTime):
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Mute must end at a future RFC 3339 time.",
+			})
+		case errors.Is(err, services.ErrInvalidSchedule):
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Do-not-disturb schedule needs a different start and end as HH:MM.",
+			})
+		case err != nil:
+			slog.ErrorContext(ctx.UserContext(), "update notification settings", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(settings)
+	}
+}
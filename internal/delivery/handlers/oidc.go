@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"chat_backend/internal/app/services"
+	"chat_backend/pkg/utils"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+const (
+	oidcStateCookie = "chat_app_oidc"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+type IdentitySwagger struct {
+	Provider  string `json:"provider"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+type AuthorizationURLSwagger struct {
+	URL string `json:"url"`
+}
+
+// setOIDCStateCookie keeps the encrypted state until the provider redirects
+// back. It is Lax because the callback is a cross-site navigation.
+func setOIDCStateCookie(ctx *fiber.Ctx, state *services.OIDCState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	key, err := utils.GetOIDCStateKey()
+	if err != nil {
+		return err
+	}
+
+	token, err := pasetoware.CreateToken(key, string(data), oidcStateTTL, pasetoware.PurposeLocal)
+	if err != nil {
+		return err
+	}
+
+	ctx.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    token,
+		Path:     "/api/auth/oidc",
+		HTTPOnly: prod,
+		Secure:   prod,
+		SameSite: fiber.CookieSameSiteLaxMode,
+		Expires:  time.Now().Add(oidcStateTTL),
+	})
+
+	return nil
+}
+
+func clearOIDCStateCookie(ctx *fiber.Ctx) {
+	ctx.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/api/auth/oidc",
+		HTTPOnly: prod,
+		Secure:   prod,
+		SameSite: fiber.CookieSameSiteLaxMode,
+		Expires:  time.Now().Add(-time.Hour),
+	})
+}
+
+// redirectToClient sends the browser back to the client at path.
+func redirectToClient(ctx *fiber.Ctx, path string, query url.Values) error {
+	target := utils.GetEnv("CLIENT_URL", "") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	return ctx.Redirect(target, fiber.StatusFound)
+}
+
+func redirectOIDCError(ctx *fiber.Ctx, code string) error {
+	clearOIDCStateCookie(ctx)
+	return redirectToClient(ctx, "/login", url.Values{"error": {code}})
+}
+
+// OIDCStateErrorHandler is used by the callback's state cookie check, so a
+// missing or expired state ends on the client's login page.
+func OIDCStateErrorHandler(ctx *fiber.Ctx, err error) error {
+	return redirectOIDCError(ctx, "oidc_failed")
+}
+
+// OIDCProvidersHandler lists the configured identity providers.
+//
+//	@Summary		List identity providers
+//	@Description	Lists the OpenID Connect providers that can be used to sign in.
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{array}	string
+//	@Router			/auth/oidc/providers [get]
+func OIDCProvidersHandler(s services.OIDCService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		return ctx.Status(fiber.StatusOK).JSON(s.Providers())
+	}
+}
+
+// OIDCLoginHandler starts signing in with an identity provider.
+//
+//	@Summary		Sign in with an identity provider
+//	@Description	Redirects to the provider's authorization endpoint using the authorization code flow with PKCE.
+//	@Tags			Authentication
+//	@Param			provider	path	string	true	"Provider name"
+//	@Success		302
+//	@Failure		404
+//	@Router			/auth/oidc/{provider}/login [get]
+func OIDCLoginHandler(s services.OIDCService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		authURL, state, err := s.AuthCodeURL(ctx.UserContext(), ctx.Params("provider"), uuid.Nil)
+		if errors.Is(err, services.ErrUnknownProvider) {
+			return fiber.ErrNotFound
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "begin oidc login", "error", err)
+			return redirectOIDCError(ctx, "oidc_failed")
+		}
+
+		if err := setOIDCStateCookie(ctx, state); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "create oidc state token", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Redirect(authURL, fiber.StatusFound)
+	}
+}
+
+// OIDCCallbackHandler completes signing in or linking with an identity provider.
+//
+//	@Summary		Identity provider callback
+//	@Description	Validates the state and nonce, redeems the code and issues the authentication token, provisioning an account on first sign in. Redirects to the client in every case, with an error query parameter on failure.
+//	@Tags			Authentication
+//	@Param			provider	path	string	true	"Provider name"
+//	@Param			code		query	string	true	"Authorization code"
+//	@Param			state		query	string	true	"State"
+//	@Success		302
+//	@Router			/auth/oidc/{provider}/callback [get]
+func OIDCCallbackHandler(s services.OIDCService, keyring services.KeyringService, auth services.AuthService, audit services.AuditService) fiber.Handler {
+	preAuthTTL := utils.GetEnvDuration("PRE_AUTH_TTL", 5*time.Minute)
+
+	return func(ctx *fiber.Ctx) error {
+		state := new(services.OIDCState)
+		if err := json.Unmarshal([]byte(ctx.Locals(pasetoware.DefaultContextKey).(string)), state); err != nil {
+			return redirectOIDCError(ctx, "oidc_failed")
+		}
+		if state.Provider != ctx.Params("provider") ||
+			subtle.ConstantTimeCompare([]byte(state.State), []byte(ctx.Query("state"))) != 1 ||
+			len(ctx.Query("code")) == 0 {
+			return redirectOIDCError(ctx, "oidc_failed")
+		}
+
+		user, err := s.Callback(ctx.UserContext(), state, ctx.Query("code"))
+		if errors.Is(err, services.ErrIdentityLinked) {
+			return redirectOIDCError(ctx, "identity_linked")
+		}
+		if err != nil {
+			if !errors.Is(err, services.ErrInvalidIdentity) {
+				slog.ErrorContext(ctx.UserContext(), "complete oidc login", "error", err)
+			}
+			return redirectOIDCError(ctx, "oidc_failed")
+		}
+
+		clearOIDCStateCookie(ctx)
+
+		if state.LinkUserID != uuid.Nil {
+			return redirectToClient(ctx, "/settings", url.Values{"linked": {state.Provider}})
+		}
+
+		if user.TotpEnabledAt.Valid {
+			if err := setPreAuthCookie(ctx, user.ID.String(), preAuthTTL); err != nil {
+				slog.ErrorContext(ctx.UserContext(), "create pre-auth token", "error", err)
+				return redirectOIDCError(ctx, "oidc_failed")
+			}
+
+			return redirectToClient(ctx, "/login", url.Values{"two_factor_required": {"true"}})
+		}
+
+		if err := restoreAccount(ctx, auth, audit, user.ID); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "restore account", "error", err)
+			return redirectOIDCError(ctx, "oidc_failed")
+		}
+
+		if err := setSessionCookie(ctx, keyring, user.ID.String()); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "create session token", "error", err)
+			return redirectOIDCError(ctx, "oidc_failed")
+		}
+
+		recordAudit(ctx, audit, services.AuditLogin, user.ID, user.ID, fiber.Map{
+			"method":   "oidc",
+			"provider": state.Provider,
+		})
+
+		return redirectToClient(ctx, "/", nil)
+	}
+}
+
+// LinkIdentityHandler starts linking an identity provider to the account.
+//
+//	@Summary		Link identity provider
+//	@Description	Returns the provider's authorization URL to navigate to. The callback links the identity to the signed-in account.
+//	@Tags			Identities
+//	@Produce		json
+//	@Param			provider	path		string	true	"Provider name"
+//	@Success		200			{object}	AuthorizationURLSwagger
+//	@Failure		404
+//	@Router			/user/identities/{provider}/link [post]
+func LinkIdentityHandler(s services.OIDCService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		authURL, state, err := s.AuthCodeURL(ctx.UserContext(), ctx.Params("provider"), userID)
+		if errors.Is(err, services.ErrUnknownProvider) {
+			return fiber.ErrNotFound
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "begin oidc link", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		if err := setOIDCStateCookie(ctx, state); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "create oidc state token", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"url": authURL,
+		})
+	}
+}
+
+// ListIdentitiesHandler lists the linked identity providers.
+//
+//	@Summary		List linked identities
+//	@Description	Lists the identity providers linked to the account.
+//	@Tags			Identities
+//	@Produce		json
+//	@Success		200	{array}	IdentitySwagger
+//	@Failure		500
+//	@Router			/user/identities [get]
+func ListIdentitiesHandler(s services.OIDCService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		identities, err := s.ListIdentities(ctx.UserContext(), userID)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "list identities", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(identities)
+	}
+}
+
+// UnlinkIdentityHandler removes a linked identity provider.
+//
+//	@Summary		Unlink identity provider
+//	@Description	Removes a linked identity provider. The last one cannot be removed unless the account has a password of its own, a verified email or a passkey.
+//	@Tags			Identities
+//	@Produce		plain
+//	@Param			provider	path		string	true	"Provider name"
+//	@Success		200			{string}	string	"OK"
+//	@Failure		403			{object}	ErrorResponseSwagger
+//	@Failure		404
+//	@Router			/user/identities/{provider} [delete]
+func UnlinkIdentityHandler(s services.OIDCService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		deleted, err := s.UnlinkIdentity(ctx.UserContext(), userID, ctx.Params("provider"))
+		if errors.Is(err, services.ErrLastSignInMethod) {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Set a password, verify your email or add a passkey before unlinking your only identity provider.",
+			})
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "unlink identity", "error", err)
+			return fiber.ErrInternalServerError
+		}
+		if !deleted {
+			return fiber.ErrNotFound
+		}
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
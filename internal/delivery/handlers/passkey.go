@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/internal/app/services"
+	"errors"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/gookit/validate"
+	"log/slog"
+	"time"
+)
+
+const passkeySessionCookie = "chat_app_webauthn"
+
+type PasskeySwagger struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	LastUsedAt string `json:"last_used_at"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// setPasskeySessionCookie remembers which stored ceremony the client is answering.
+func setPasskeySessionCookie(ctx *fiber.Ctx, sessionID uuid.UUID) {
+	ctx.Cookie(&fiber.Cookie{
+		Name:     passkeySessionCookie,
+		Value:    sessionID.String(),
+		HTTPOnly: prod,
+		Secure:   prod,
+		SameSite: fiber.CookieSameSiteStrictMode,
+		Expires:  time.Now().Add(5 * time.Minute),
+	})
+}
+
+func passkeySessionID(ctx *fiber.Ctx) (uuid.UUID, error) {
+	sessionID, err := uuid.Parse(ctx.Cookies(passkeySessionCookie))
+
+	ctx.Cookie(&fiber.Cookie{
+		Name:     passkeySessionCookie,
+		Value:    "",
+		HTTPOnly: prod,
+		Secure:   prod,
+		SameSite: fiber.CookieSameSiteStrictMode,
+		Expires:  time.Now().Add(-time.Hour),
+	})
+
+	return sessionID, err
+}
+
+// BeginPasskeyRegistrationHandler starts a passkey registration ceremony.
+//
+//	@Summary		Begin passkey registration
+//	@Description	Returns the PublicKeyCredentialCreationOptions for navigator.credentials.create().
+//	@Tags			Passkeys
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		500
+//	@Router			/user/passkeys/register/begin [post]
+func BeginPasskeyRegistrationHandler(s services.PasskeyService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		creation, sessionID, err := s.BeginRegistration(ctx.UserContext(), userID)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "begin passkey registration", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		setPasskeySessionCookie(ctx, sessionID)
+
+		return ctx.Status(fiber.StatusOK).JSON(creation)
+	}
+}
+
+// FinishPasskeyRegistrationHandler completes a passkey registration ceremony.
+//
+//	@Summary		Finish passkey registration
+//	@Description	Verifies the attestation returned by navigator.credentials.create() and stores the passkey.
+//	@Tags			Passkeys
+//	@Accept			json
+//	@Produce		plain
+//	@Param			name	query		string	false	"Passkey name"
+//	@Success		201		{string}	string	"Created"
+//	@Failure		403		{object}	ErrorResponseSwagger
+//	@Router			/user/passkeys/register/finish [post]
+func FinishPasskeyRegistrationHandler(s services.PasskeyService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		input := new(repositories.PasskeyInput)
+
+		if err := ctx.QueryParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		sessionID, err := passkeySessionID(ctx)
+		if err != nil {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Passkey not valid.",
+			})
+		}
+
+		err = s.FinishRegistration(ctx.UserContext(), userID, sessionID, input.Name, ctx.Body())
+		if errors.Is(err, services.ErrInvalidPasskey) {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Passkey not valid.",
+			})
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "finish passkey registration", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.SendStatus(fiber.StatusCreated)
+	}
+}
+
+// BeginPasskeyLoginHandler starts a passkey login ceremony.
+//
+//	@Summary		Begin passkey login
+//	@Description	Returns the PublicKeyCredentialRequestOptions for navigator.credentials.get().
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		500
+//	@Router			/auth/passkeys/login/begin [post]
+func BeginPasskeyLoginHandler(s services.PasskeyService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		assertion, sessionID, err := s.BeginLogin(ctx.UserContext())
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "begin passkey login", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		setPasskeySessionCookie(ctx, sessionID)
+
+		return ctx.Status(fiber.StatusOK).JSON(assertion)
+	}
+}
+
+// FinishPasskeyLoginHandler completes a passkey login ceremony.
+//
+//	@Summary		Finish passkey login
+//	@Description	Verifies the assertion returned by navigator.credentials.get() and issues the authentication token.
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			mode	query		string	false	"Set to token to receive the session token in the body"	Enums(token)
+//	@Success		200		{object}	SessionTokenSwagger
+//	@Failure		403		{object}	ErrorResponseSwagger
+//	@Router			/auth/passkeys/login/finish [post]
+func FinishPasskeyLoginHandler(s services.PasskeyService, keyring services.KeyringService, auth services.AuthService, audit services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		sessionID, err := passkeySessionID(ctx)
+		if err != nil {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Passkey not valid.",
+			})
+		}
+
+		userID, err := s.FinishLogin(ctx.UserContext(), sessionID, ctx.Body())
+		if errors.Is(err, services.ErrInvalidPasskey) {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Passkey not valid.",
+			})
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "finish passkey login", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		if err := restoreAccount(ctx, auth, audit, userID); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "restore account", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		if err := sendSession(ctx, keyring, userID.String()); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "create session token", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		recordAudit(ctx, audit, services.AuditLogin, userID, userID, fiber.Map{
+			"method": "passkey",
+		})
+
+		return nil
+	}
+}
+
+// ListPasskeysHandler lists the registered passkeys.
+//
+//	@Summary		List passkeys
+//	@Description	Lists the passkeys registered to the account.
+//	@Tags			Passkeys
+//	@Produce		json
+//	@Success		200	{array}	PasskeySwagger
+//	@Failure		500
+//	@Router			/user/passkeys [get]
+func ListPasskeysHandler(s services.PasskeyService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		passkeys, err := s.ListPasskeys(ctx.UserContext(), userID)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "list passkeys", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(passkeys)
+	}
+}
+
+// DeletePasskeyHandler removes a registered passkey.
+//
+//	@Summary		Delete passkey
+//	@Description	Removes a passkey from the account.
+//	@Tags			Passkeys
+//	@Produce		plain
+//	@Param			id	path		string	true	"Passkey ID"
+//	@Success		200	{string}	string	"OK"
+//	@Failure		404
+//	@Router			/user/passkeys/{id} [delete]
+func DeletePasskeyHandler(s services.PasskeyService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		id, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		deleted, err := s.DeletePasskey(ctx.UserContext(), userID, id)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "delete passkey", "error", err)
+			return fiber.ErrInternalServerError
+		}
+		if !deleted {
+			return fiber.ErrNotFound
+		}
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
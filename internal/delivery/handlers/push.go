@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/internal/app/services"
+	"chat_backend/internal/delivery/middlewares"
+	"errors"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/gookit/validate"
+	"log/slog"
+)
+
+type PushSubscriptionSwagger struct {
+	ID         string `json:"id"`
+	Endpoint   string `json:"endpoint"`
+	UserAgent  string `json:"user_agent"`
+	LastUsedAt string `json:"last_used_at"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type PushDeviceSwagger struct {
+	ID         string `json:"id"`
+	Platform   string `json:"platform"`
+	LastUsedAt string `json:"last_used_at"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type PushKeySwagger struct {
+	PublicKey string `json:"public_key"`
+}
+
+type PushTestSwagger struct {
+	Sent int `json:"sent"`
+}
+
+// PushKeyHandler returns the VAPID public key.
+//
+//	@Summary		Get push key
+//	@Description	Returns the VAPID public key, base64url encoded, to pass as applicationServerKey to PushManager.subscribe().
+//	@Tags			Push
+//	@Produce		json
+//	@Success		200	{object}	PushKeySwagger
+//	@Router			/user/push/key [get]
+func PushKeyHandler(s services.PushService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"public_key": s.PublicKey(),
+		})
+	}
+}
+
+// SubscribePushHandler registers a browser for push notifications.
+//
+//	@Summary		Subscribe to push
+//	@Description	Registers the PushSubscription of a browser, as returned by its toJSON(). Subscribing an endpoint again replaces its keys, and only the newest subscriptions of an account are kept.
+//	@Tags			Push
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		repositories.PushSubscriptionInput	true	"Push subscription"
+//	@Success		201		{object}	PushSubscriptionSwagger
+//	@Failure		400		{object}	ErrorResponseSwagger
+//	@Failure		403
+//	@Router			/user/push/subscriptions [post]
+func SubscribePushHandler(s services.PushService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		input := new(repositories.PushSubscriptionInput)
+
+		if err := ctx.BodyParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		subscription, err := s.Subscribe(ctx.UserContext(), userID, input, ctx.Get(fiber.HeaderUserAgent))
+		switch {
+		case errors.Is(err, services.ErrPushServiceNotAllowed):
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Endpoint is not a known push service.",
+			})
+		case errors.Is(err, services.ErrInvalidPushKeys):
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Subscription keys are invalid.",
+			})
+		case err != nil:
+			slog.ErrorContext(ctx.UserContext(), "subscribe push", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusCreated).JSON(subscription)
+	}
+}
+
+// ListPushSubscriptionsHandler lists the browsers subscribed to push.
+//
+//	@Summary		List push subscriptions
+//	@Description	Lists the browsers of the account that receive push notifications.
+//	@Tags			Push
+//	@Produce		json
+//	@Success		200	{array}	PushSubscriptionSwagger
+//	@Failure		500
+//	@Router			/user/push/subscriptions [get]
+func ListPushSubscriptionsHandler(s services.PushService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		subscriptions, err := s.ListSubscriptions(ctx.UserContext(), userID)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "list push subscriptions", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(subscriptions)
+	}
+}
+
+// UnsubscribePushHandler stops push notifications to a browser.
+//
+//	@Summary		Unsubscribe from push
+//	@Description	Deletes a push subscription of the account.
+//	@Tags			Push
+//	@Produce		plain
+//	@Param			id	path		string	true	"Subscription ID"
+//	@Success		200	{string}	string	"OK"
+//	@Failure		404
+//	@Router			/user/push/subscriptions/{id} [delete]
+func UnsubscribePushHandler(s services.PushService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		id, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		deleted, err := s.Unsubscribe(ctx.UserContext(), userID, id)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "unsubscribe push", "error", err)
+			return fiber.ErrInternalServerError
+		}
+		if !deleted {
+			return fiber.ErrNotFound
+		}
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
+
+// TestPushHandler sends a test notification.
+//
+//	@Summary		Send test push
+//	@Description	Sends a test notification to every subscribed browser and registered app of the account, unless the notification settings hold it back. Apps are sent to shortly after the response.
+//	@Tags			Push
+//	@Produce		json
+//	@Success		200	{object}	PushTestSwagger
+//	@Failure		500
+//	@Router			/user/push/test [post]
+func TestPushHandler(s services.PushService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		sent, err := s.Notify(ctx.UserContext(), userID, services.NotificationMessage, services.PushNotification{
+			Title: "Test notification",
+			Body:  "Push notifications are working.",
+			Tag:   "test",
+		})
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "send test push", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"sent": sent,
+		})
+	}
+}
+
+// RegisterPushDeviceHandler registers a native app for push notifications.
+//
+//	@Summary		Register push device
+//	@Description	Registers the APNs or FCM device token of a native app for the current session. Signing out of the session unregisters it, registering a token again moves it to the current session, and only the newest devices of an account are kept.
+//	@Tags			Push
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		repositories.PushDeviceInput	true	"Device token"
+//	@Success		201		{object}	PushDeviceSwagger
+//	@Failure		403
+//	@Router			/user/push/devices [post]
+func RegisterPushDeviceHandler(s services.PushService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		input := new(repositories.PushDeviceInput)
+
+		if err := ctx.BodyParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		device, err := s.RegisterDevice(ctx.UserContext(), userID, middlewares.SessionToken(ctx), input)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "register push device", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusCreated).JSON(device)
+	}
+}
+
+// ListPushDevicesHandler lists the native apps registered for push.
+//
+//	@Summary		List push devices
+//	@Description	Lists the native apps of the account that receive push notifications.
+//	@Tags			Push
+//	@Produce		json
+//	@Success		200	{array}	PushDeviceSwagger
+//	@Failure		500
+//	@Router			/user/push/devices [get]
+func ListPushDevicesHandler(s services.PushService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		devices, err := s.ListDevices(ctx.UserContext(), userID)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "list push devices", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(devices)
+	}
+}
+
+// UnregisterPushDeviceHandler stops push notifications to a native app.
+//
+//	@Summary		Unregister push device
+//	@Description	Deletes a push device of the account.
+//	@Tags			Push
+//	@Produce		plain
+//	@Param			id	path		string	true	"Device ID"
+//	@Success		200	{string}	string	"OK"
+//	@Failure		404
+//	@Router			/user/push/devices/{id} [delete]
+func UnregisterPushDeviceHandler(s services.PushService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		id, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		deleted, err := s.UnregisterDevice(ctx.UserContext(), userID, id)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "unregister push device", "error", err)
+			return fiber.ErrInternalServerError
+		}
+		if !deleted {
+			return fiber.ErrNotFound
+		}
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"chat_backend/internal/app/services"
+	"errors"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"log/slog"
+)
+
+type RoleSwagger struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// roleError answers the errors of role changes the client can act upon, and
+// logs the others as msg.
+func roleError(ctx *fiber.Ctx, err error, msg string) error {
+	switch {
+	case errors.Is(err, services.ErrUnknownRole), errors.Is(err, services.ErrUnknownUser):
+		return fiber.ErrNotFound
+	case errors.Is(err, services.ErrImplicitRole):
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Every user holds the user role.",
+		})
+	case errors.Is(err, services.ErrLastAdmin):
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Assign another admin before removing the last one.",
+		})
+	}
+
+	slog.ErrorContext(ctx.UserContext(), msg, "error", err)
+	return fiber.ErrInternalServerError
+}
+
+// ListRolesHandler lists the roles.
+//
+//	@Summary		List roles
+//	@Description	Lists the roles and the permissions they grant. Requires the roles:read permission.
+//	@Tags			Admin
+//	@Produce		json
+//	@Success		200	{array}		RoleSwagger
+//	@Failure		403	{object}	ErrorResponseSwagger
+//	@Router			/admin/roles [get]
+func ListRolesHandler(s services.RoleService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		roles, err := s.ListRoles(ctx.UserContext())
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "list roles", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(roles)
+	}
+}
+
+// GetUserRolesHandler lists the roles of a user.
+//
+//	@Summary		List user roles
+//	@Description	Lists the roles held by a user, including the implicit user role. Requires the roles:read permission.
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		string	true	"User ID"
+//	@Success		200	{array}		string
+//	@Failure		403	{object}	ErrorResponseSwagger
+//	@Failure		404
+//	@Router			/admin/users/{id}/roles [get]
+func GetUserRolesHandler(s services.RoleService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		roles, err := s.GetUserRoles(ctx.UserContext(), userID)
+		if err != nil {
+			return roleError(ctx, err, "get user roles")
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(roles)
+	}
+}
+
+// AssignRoleHandler assigns a role to a user.
+//
+//	@Summary		Assign role
+//	@Description	Assigns a role to a user. Assigning a role the user already holds does nothing. Requires the roles:assign permission.
+//	@Tags			Admin
+//	@Produce		plain
+//	@Param			id		path		string	true	"User ID"
+//	@Param			role	path		string	true	"Role name"
+//	@Success		200		{string}	string	"OK"
+//	@Success		201		{string}	string	"Created"
+//	@Failure		403		{object}	ErrorResponseSwagger
+//	@Failure		404
+//	@Router			/admin/users/{id}/roles/{role} [put]
+func AssignRoleHandler(s services.RoleService, audit services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		assigned, err := s.AssignRole(ctx.UserContext(), userID, ctx.Params("role"))
+		if err != nil {
+			return roleError(ctx, err, "assign role")
+		}
+		if !assigned {
+			return ctx.SendStatus(fiber.StatusOK)
+		}
+
+		actorID, _ := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		recordAudit(ctx, audit, services.AuditRoleAssign, actorID, userID, fiber.Map{
+			"role": ctx.Params("role"),
+		})
+
+		return ctx.SendStatus(fiber.StatusCreated)
+	}
+}
+
+// RemoveRoleHandler removes a role from a user.
+//
+//	@Summary		Remove role
+//	@Description	Removes a role from a user. The last admin cannot be removed. Requires the roles:assign permission.
+//	@Tags			Admin
+//	@Produce		plain
+//	@Param			id		path		string	true	"User ID"
+//	@Param			role	path		string	true	"Role name"
+//	@Success		200		{string}	string	"OK"
+//	@Failure		403		{object}	ErrorResponseSwagger
+//	@Failure		404
+//	@Router			/admin/users/{id}/roles/{role} [delete]
+func RemoveRoleHandler(s services.RoleService, audit services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		removed, err := s.RemoveRole(ctx.UserContext(), userID, ctx.Params("role"))
+		if err != nil {
+			return roleError(ctx, err, "remove role")
+		}
+		if !removed {
+			return fiber.ErrNotFound
+		}
+
+		actorID, _ := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		recordAudit(ctx, audit, services.AuditRoleRemove, actorID, userID, fiber.Map{
+			"role": ctx.Params("role"),
+		})
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"chat_backend/internal/app/repositories"
+	"chat_backend/internal/app/services"
+	"chat_backend/pkg/utils"
+	"errors"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/gookit/validate"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+type RecoveryCodesSwagger struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// EnrollTwoFactorHandler starts two-factor enrolment.
+//
+//	@Summary		Start two-factor enrolment
+//	@Description	Generates a TOTP secret and its otpauth:// URI. It takes effect after confirmation. Accounts created through an identity provider set a password first, since disabling two-factor authentication asks for it.
+//	@Tags			Two-factor
+//	@Produce		json
+//	@Success		200	{object}	services.TwoFactorEnrollment
+//	@Failure		403	{object}	ErrorResponseSwagger
+//	@Router			/user/2fa/enroll [post]
+func EnrollTwoFactorHandler(s services.TwoFactorService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		enrollment, err := s.Enroll(ctx.UserContext(), userID)
+		if errors.Is(err, services.ErrTwoFactorEnabled) {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Two-factor authentication is already enabled.",
+			})
+		}
+		if errors.Is(err, services.ErrPasswordNotSet) {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Set a password before enabling two-factor authentication.",
+			})
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "enroll two-factor", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(enrollment)
+	}
+}
+
+// ConfirmTwoFactorHandler completes two-factor enrolment.
+//
+//	@Summary		Confirm two-factor enrolment
+//	@Description	Enables two-factor authentication with a first TOTP code and returns the recovery codes. They are only shown once.
+//	@Tags			Two-factor
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		repositories.TwoFactorInput	true	"TOTP code"
+//	@Success		200		{object}	RecoveryCodesSwagger
+//	@Failure		403		{object}	ErrorResponseSwagger
+//	@Router			/user/2fa/confirm [post]
+func ConfirmTwoFactorHandler(s services.TwoFactorService, audit services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		input := new(repositories.TwoFactorInput)
+
+		if err := ctx.BodyParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		codes, err := s.Confirm(ctx.UserContext(), userID, input.Code)
+		switch {
+		case errors.Is(err, services.ErrTwoFactorEnabled):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Two-factor authentication is already enabled.",
+			})
+		case errors.Is(err, services.ErrTwoFactorNotEnrolled):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Two-factor authentication is not enrolled.",
+			})
+		case errors.Is(err, services.ErrInvalidCode):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Code not correct.",
+			})
+		case err != nil:
+			slog.ErrorContext(ctx.UserContext(), "confirm two-factor", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		recordAudit(ctx, audit, services.AuditTwoFactorEnable, userID, userID, nil)
+
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// DisableTwoFactorHandler turns two-factor authentication off.
+//
+//	@Summary		Disable two-factor authentication
+//	@Description	Disables two-factor authentication and deletes the recovery codes. Requires the current password.
+//	@Tags			Two-factor
+//	@Accept			json
+//	@Produce		plain
+//	@Param			input	body		repositories.DisableTwoFactorInput	true	"Current password"
+//	@Success		200		{string}	string								"OK"
+//	@Failure		403		{object}	ErrorResponseSwagger
+//	@Router			/user/2fa/disable [post]
+func DisableTwoFactorHandler(s services.TwoFactorService, audit services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		input := new(repositories.DisableTwoFactorInput)
+
+		if err := ctx.BodyParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		err = s.Disable(ctx.UserContext(), userID, input.Password)
+		switch {
+		case errors.Is(err, services.ErrTwoFactorNotEnrolled):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Two-factor authentication is not enrolled.",
+			})
+		case errors.Is(err, services.ErrInvalidPassword):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Password not correct.",
+			})
+		case err != nil:
+			slog.ErrorContext(ctx.UserContext(), "disable two-factor", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		recordAudit(ctx, audit, services.AuditTwoFactorDisable, userID, userID, nil)
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
+
+// VerifyTwoFactorHandler completes a two-step login.
+//
+//	@Summary		Complete login with a second factor
+//	@Description	Exchanges the pre-auth token from /auth/login, as the cookie or as Authorization: Bearer, and a TOTP or recovery code for the session token. Each pre-auth token and TOTP code completes one login, and too many wrong codes lock the second factor of the account for a while.
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			input	body		repositories.TwoFactorInput	true	"TOTP or recovery code"
+//	@Param			mode	query		string						false	"Set to token to receive the session token in the body"	Enums(token)
+//	@Success		200		{object}	SessionTokenSwagger
+//	@Failure		401
+//	@Failure		403		{object}	ErrorResponseSwagger
+//	@Failure		429		{object}	ErrorResponseSwagger
+//	@Router			/auth/2fa/verify [post]
+func VerifyTwoFactorHandler(s services.TwoFactorService, keyring services.KeyringService, auth services.AuthService, audit services.AuditService) fiber.Handler {
+	preAuthTTL := utils.GetEnvDuration("PRE_AUTH_TTL", 5*time.Minute)
+
+	return func(ctx *fiber.Ctx) error {
+		input := new(repositories.TwoFactorInput)
+
+		if err := ctx.BodyParser(input); err != nil {
+			return err
+		}
+
+		v := validate.New(input)
+		if !v.Validate() {
+			return ctx.Status(fiber.StatusForbidden).JSON(v.Errors)
+		}
+
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			return fiber.ErrUnauthorized
+		}
+
+		// The token outlives this login, so it is remembered as used.
+		ok, err := s.Verify(ctx.UserContext(), userID, requestPreAuthToken(ctx), time.Now().Add(preAuthTTL), input.Code)
+		if errors.Is(err, services.ErrPreAuthTokenUsed) {
+			return fiber.ErrUnauthorized
+		}
+		if errors.Is(err, services.ErrTwoFactorLocked) {
+			recordAudit(ctx, audit, services.AuditLoginFailed, uuid.Nil, userID, fiber.Map{
+				"reason": "two_factor_locked",
+			})
+			return ctx.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"message": "Too many wrong codes, try again later.",
+			})
+		}
+		if err != nil && !errors.Is(err, services.ErrTwoFactorNotEnrolled) {
+			slog.ErrorContext(ctx.UserContext(), "verify two-factor", "error", err)
+			return fiber.ErrInternalServerError
+		}
+		if !ok {
+			recordAudit(ctx, audit, services.AuditLoginFailed, uuid.Nil, userID, fiber.Map{
+				"reason": "wrong_code",
+			})
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Code not correct.",
+			})
+		}
+
+		ctx.Cookie(&fiber.Cookie{
+			Name:     "chat_app_2fa",
+			Value:    "",
+			HTTPOnly: prod,
+			Secure:   prod,
+			SameSite: fiber.CookieSameSiteStrictMode,
+			Expires:  time.Now().Add(-time.Hour),
+		})
+
+		if err := restoreAccount(ctx, auth, audit, userID); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "restore account", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		if err := sendSession(ctx, keyring, userID.String()); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "create session token", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		recordAudit(ctx, audit, services.AuditLogin, userID, userID, fiber.Map{
+			"method": "two_factor",
+		})
+
+		return nil
+	}
+}
+
+// requestPreAuthToken returns the pre-auth token the request was
+// authenticated with, from the Authorization header or else the cookie.
+func requestPreAuthToken(ctx *fiber.Ctx) string {
+	if token, ok := strings.CutPrefix(ctx.Get(fiber.HeaderAuthorization), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+
+	return ctx.Cookies("chat_app_2fa")
+}
@@ -3,21 +3,75 @@ package handlers
 import (
 	"chat_backend/internal/app/repositories"
 	"chat_backend/internal/app/services"
+	"chat_backend/pkg/imaging"
+	"chat_backend/pkg/password"
 	"chat_backend/pkg/utils"
+	"errors"
 	pasetoware "github.com/gofiber/contrib/paseto"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/gookit/validate"
-	"log"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
 	"mime/multipart"
+	"net/url"
+	"strings"
 	"time"
 )
 
 type GetUserByIDRowSwagger struct {
-	Username  string `json:"username"`
-	Avatar    string `json:"avatar"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	Username        string `json:"username"`
+	Avatar          string `json:"avatar"`
+	Avatar32        string `json:"avatar_32"`
+	Avatar64        string `json:"avatar_64"`
+	Avatar256       string `json:"avatar_256"`
+	CreatedAt       string `json:"created_at"`
+	UpdatedAt       string `json:"updated_at"`
+	Email           string `json:"email"`
+	EmailVerifiedAt string `json:"email_verified_at"`
+	DisplayName     string `json:"display_name"`
+	Bio             string `json:"bio"`
+	StatusText      string `json:"status_text"`
+	StatusEmoji     string `json:"status_emoji"`
+	StatusExpiresAt string `json:"status_expires_at"`
+	Pronouns        string `json:"pronouns"`
+	Timezone        string `json:"timezone"`
+	Locale          string `json:"locale"`
+}
+
+type PublicUserSwagger struct {
+	ID              string `json:"id"`
+	Username        string `json:"username"`
+	Avatar          string `json:"avatar"`
+	Avatar32        string `json:"avatar_32"`
+	Avatar64        string `json:"avatar_64"`
+	Avatar256       string `json:"avatar_256"`
+	CreatedAt       string `json:"created_at"`
+	DisplayName     string `json:"display_name"`
+	Bio             string `json:"bio"`
+	StatusText      string `json:"status_text"`
+	StatusEmoji     string `json:"status_emoji"`
+	StatusExpiresAt string `json:"status_expires_at"`
+	Pronouns        string `json:"pronouns"`
+	Timezone        string `json:"timezone"`
+}
+
+type UpdateProfileSwagger struct {
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	Email           string `json:"email"`
+	DisplayName     string `json:"display_name"`
+	Bio             string `json:"bio"`
+	Pronouns        string `json:"pronouns"`
+	Timezone        string `json:"timezone" example:"Europe/Berlin"`
+	Locale          string `json:"locale" example:"de-DE"`
+	StatusText      string `json:"status_text"`
+	StatusEmoji     string `json:"status_emoji"`
+	StatusExpiresAt string `json:"status_expires_at" example:"2026-01-02T15:04:05Z"`
+}
+
+type DeletionSwagger struct {
+	PurgeAt string `json:"purge_at"`
 }
 
 // GetProfileHandler retrieves the user profile.
@@ -33,33 +87,68 @@ func GetProfileHandler(s services.UserService) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
 		if err != nil {
-			log.Printf("Error in /profile - parse uuid: %v", err)
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
 		}
 
-		userData, err := s.GetUserByID(userID)
+		userData, err := s.GetUserByID(ctx.UserContext(), userID)
 		if err != nil {
-			log.Printf("Error in /profile - get user by id: %v", err)
+			slog.ErrorContext(ctx.UserContext(), "get user by id", "error", err)
 		}
 
 		return ctx.Status(fiber.StatusOK).JSON(userData)
 	}
 }
 
+// GetUserByUsernameHandler looks up an account by username.
+//
+//	@Summary		Get user by username
+//	@Description	Looks up an account by its username, ignoring case. A name the account used before redirects to its current one.
+//	@Tags			Users
+//	@Produce		json
+//	@Param			username	path		string	true	"Username"
+//	@Success		200			{object}	PublicUserSwagger
+//	@Success		302
+//	@Failure		404
+//	@Router			/users/{username} [get]
+func GetUserByUsernameHandler(s services.UserService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		username := ctx.Params("username")
+
+		user, err := s.GetUserByUsername(ctx.UserContext(), username)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fiber.ErrNotFound
+		}
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "get user by username", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		if !strings.EqualFold(user.Username, username) {
+			return ctx.Redirect("/api/users/"+url.PathEscape(user.Username), fiber.StatusFound)
+		}
+
+		return ctx.Status(fiber.StatusOK).JSON(user)
+	}
+}
+
 // UpdateProfileHandler updates the user profile.
 //
 //	@Summary		Update user profile
-//	@Description	Updates the user profile
+//	@Description	Updates the user profile. Missing fields are kept and empty profile fields cleared. Setting any status field replaces the whole status, which expires at status_expires_at if given.
 //	@Tags			Profile
 //	@Accept			json
 //	@Accept			mpfd
 //	@Produce		plain
-//	@Param			avatar	formData	file					false	"Avatar file (jpeg/png)"
-//	@Param			input	body		repositories.AuthInput	false	"User update details"
+//	@Param			avatar	formData	file					false	"Avatar file (jpeg/png/webp/gif)"
+//	@Param			input	body		UpdateProfileSwagger	false	"User update details"
 //	@Success		200		{string}	string					"OK"
-//	@Failure		400
+//	@Failure		400		{object}	ErrorResponseSwagger
+//	@Failure		403		{object}	PasswordPolicyErrorSwagger
 //	@Failure		422
+//	@Failure		429		{object}	ErrorResponseSwagger
+//	@Failure		500
 //	@Router			/user/profile/update [patch]
-func UpdateProfileHandler(s services.UserService) fiber.Handler {
+func UpdateProfileHandler(s services.UserService, audit services.AuditService) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		input := new(repositories.UpdateInput)
 
@@ -77,7 +166,7 @@ func UpdateProfileHandler(s services.UserService) fiber.Handler {
 		if file != nil {
 			if !utils.IsImageFile(file) {
 				return ctx.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
-					"message": "Only image file are allowed (jpeg/png).",
+					"message": "Only image file are allowed (jpeg/png/webp/gif).",
 				})
 			}
 
@@ -91,39 +180,168 @@ func UpdateProfileHandler(s services.UserService) fiber.Handler {
 
 		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
 		if err != nil {
-			log.Printf("Error in /profile/update - parse uuid: %v", err)
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
 		}
 
-		err = s.UpdateUser(input, userID)
-		if err != nil {
-			log.Printf("Error in /profile/update - update user: %v", err)
+		var policyErr *password.PolicyError
+
+		err = s.UpdateUser(ctx.UserContext(), input, userID)
+		switch {
+		case errors.Is(err, imaging.ErrUnsupported):
+			return ctx.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"message": "Only image file are allowed (jpeg/png/webp/gif).",
+			})
+		case errors.Is(err, imaging.ErrTooLarge):
+			return ctx.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"message": "Image is too large.",
+			})
+		case errors.Is(err, services.ErrInvalidTimezone):
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Timezone must be an IANA time zone such as Europe/Berlin.",
+			})
+		case errors.Is(err, services.ErrInvalidLocale):
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Locale must be a language tag such as de-DE.",
+			})
+		case errors.Is(err, services.ErrInvalidStatusEmoji):
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Status emoji must be an emoji.",
+			})
+		case errors.Is(err, services.ErrInvalidStatusExpiry):
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Status expiry must be a future RFC 3339 time.",
+			})
+		case errors.Is(err, services.ErrUsernameReserved):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Username is reserved.",
+			})
+		case errors.Is(err, services.ErrUsernameTaken):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Username is not available.",
+			})
+		case errors.Is(err, services.ErrUsernameChangeLimit):
+			return ctx.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"message": "Username was changed too often, try again later.",
+			})
+		case errors.Is(err, services.ErrEmailTaken):
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Email already in use.",
+			})
+		case errors.As(err, &policyErr):
+			return passwordRejected(ctx, policyErr)
+		case err != nil:
+			slog.ErrorContext(ctx.UserContext(), "update user", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		if len(input.Username) > 0 {
+			recordAudit(ctx, audit, services.AuditUsernameChange, userID, userID, fiber.Map{
+				"username": input.Username,
+			})
+		}
+		if len(input.Password) > 0 {
+			recordAudit(ctx, audit, services.AuditPasswordChange, userID, userID, nil)
+		}
+		if len(input.Email) > 0 {
+			recordAudit(ctx, audit, services.AuditEmailChange, userID, userID, nil)
+		}
+		if input.Avatar != nil {
+			recordAudit(ctx, audit, services.AuditAvatarChange, userID, userID, nil)
 		}
 
 		return ctx.SendStatus(fiber.StatusOK)
 	}
 }
 
-// DeleteUserHandler deletes the user account.
+// ResetAvatarHandler replaces the avatar with the generated identicon.
 //
-//	@Summary		Delete user account
-//	@Description	Deletes the user account
+//	@Summary		Reset avatar
+//	@Description	Replaces the uploaded avatar with the identicon generated from the user ID, as given at signup.
 //	@Tags			Profile
 //	@Produce		plain
 //	@Success		200	{string}	string	"OK"
-//	@Failure		500	{object}	ErrorResponseSwagger
+//	@Failure		500
+//	@Router			/user/profile/avatar [delete]
+func ResetAvatarHandler(s services.UserService, audit services.AuditService) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
+			return fiber.ErrUnauthorized
+		}
+
+		if err := s.ResetAvatar(ctx.UserContext(), userID); err != nil {
+			slog.ErrorContext(ctx.UserContext(), "reset avatar", "error", err)
+			return fiber.ErrInternalServerError
+		}
+
+		recordAudit(ctx, audit, services.AuditAvatarChange, userID, userID, fiber.Map{
+			"reset": true,
+		})
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
+
+// IdenticonHandler renders the identicon of a user.
+//
+//	@Summary		Get identicon
+//	@Description	Renders the identicon generated from a user ID as SVG. The same ID always gives the same picture.
+//	@Tags			Users
+//	@Produce		image/svg+xml
+//	@Param			id	path		string	true	"User ID"
+//	@Success		200	{string}	string	"SVG image"
+//	@Failure		404
+//	@Router			/users/{id}/identicon.svg [get]
+func IdenticonHandler() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		id, err := uuid.Parse(ctx.Params("id"))
+		if err != nil {
+			return fiber.ErrNotFound
+		}
+
+		ctx.Set(fiber.HeaderContentType, "image/svg+xml")
+		ctx.Set(fiber.HeaderCacheControl, "public, max-age=31536000, immutable")
+		// The picture is loaded by the client from another origin.
+		ctx.Set("Cross-Origin-Resource-Policy", "cross-origin")
+
+		return ctx.Send(imaging.NewIdenticon(id[:]).SVG())
+	}
+}
+
+// DeleteUserHandler schedules the deletion of the user account.
+//
+//	@Summary		Delete user account
+//	@Description	Signs out and schedules the account for deletion. Signing in before purge_at restores it, afterwards the account is anonymized and its personal data and uploads removed.
+//	@Tags			Profile
+//	@Produce		json
+//	@Success		200	{object}	DeletionSwagger
+//	@Failure		403	{object}	ErrorResponseSwagger
+//	@Failure		500
 //	@Router			/user/profile/delete [delete]
-func DeleteUserHandler(s services.UserService) fiber.Handler {
+func DeleteUserHandler(s services.UserService, audit services.AuditService) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		userID, err := uuid.Parse(ctx.Locals(pasetoware.DefaultContextKey).(string))
 		if err != nil {
-			log.Printf("Error in /profile/update - parse uuid: %v", err)
+			slog.ErrorContext(ctx.UserContext(), "parse uuid", "error", err)
 		}
 
-		err = s.DeleteUser(userID)
+		purgeAt, err := s.DeleteUser(ctx.UserContext(), userID)
+		if errors.Is(err, services.ErrDeletionPending) {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Account deletion is already pending.",
+			})
+		}
 		if err != nil {
-			log.Printf("Error in /profile/delete - delete user: %v", err)
+			slog.ErrorContext(ctx.UserContext(), "delete user", "error", err)
+			return fiber.ErrInternalServerError
 		}
 
+		recordAudit(ctx, audit, services.AuditAccountDelete, userID, userID, fiber.Map{
+			"purge_at": purgeAt,
+		})
+
 		ctx.Cookie(&fiber.Cookie{
 			Name:     "chat_app",
 			Value:    "",
@@ -133,6 +351,8 @@ func DeleteUserHandler(s services.UserService) fiber.Handler {
 			Expires:  time.Now().Add(-time.Hour),
 		})
 
-		return ctx.SendStatus(fiber.StatusOK)
+		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+			"purge_at": purgeAt,
+		})
 	}
 }
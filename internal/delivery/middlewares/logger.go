@@ -0,0 +1,64 @@
+package middlewares
+
+import (
+	"chat_backend/pkg/utils"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
+)
+
+// RequestID assigns every request an ID, reusing an incoming X-Request-ID
+// header, and stores it in the user context so that it reaches the logs.
+func RequestID() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		id := ctx.Get(fiber.HeaderXRequestID)
+		if len(id) == 0 || len(id) > 128 {
+			id = uuid.NewString()
+		}
+
+		ctx.Set(fiber.HeaderXRequestID, id)
+		ctx.SetUserContext(utils.WithRequestID(ctx.UserContext(), id))
+
+		return ctx.Next()
+	}
+}
+
+// Logger writes one structured access log line per request.
+func Logger() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		start := time.Now()
+		chainErr := ctx.Next()
+		if chainErr != nil {
+			if err := ctx.App().ErrorHandler(ctx, chainErr); err != nil {
+				_ = ctx.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		level := slog.LevelInfo
+		if ctx.Response().StatusCode() >= fiber.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		slog.Log(ctx.UserContext(), level, "request",
+			"method", ctx.Method(),
+			"path", ctx.Path(),
+			"status", ctx.Response().StatusCode(),
+			"latency", time.Since(start).String(),
+			"ip", ctx.IP(),
+		)
+
+		return nil
+	}
+}
+
+// UserContext is used as the PASETO success handler to attach the
+// authenticated user ID to the user context.
+func UserContext(ctx *fiber.Ctx) error {
+	if userID, ok := ctx.Locals(pasetoware.DefaultContextKey).(string); ok {
+		ctx.SetUserContext(utils.WithUserID(ctx.UserContext(), userID))
+	}
+
+	return ctx.Next()
+}
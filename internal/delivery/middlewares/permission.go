@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"chat_backend/internal/app/services"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"log/slog"
+)
+
+// Permission lets the request through when the authenticated user holds
+// permission through one of their roles. It goes after Session or Scope.
+func Permission(roles services.RoleService, permission string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		id, _ := ctx.Locals(pasetoware.DefaultContextKey).(string)
+		userID, err := uuid.Parse(id)
+		if err != nil {
+			return fiber.ErrUnauthorized
+		}
+
+		allowed, err := roles.HasPermission(ctx.UserContext(), userID, permission)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "check permission", "error", err)
+			return fiber.ErrInternalServerError
+		}
+		if !allowed {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "You do not have permission to perform this request.",
+			})
+		}
+
+		return ctx.Next()
+	}
+}
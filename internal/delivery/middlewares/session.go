@@ -0,0 +1,159 @@
+package middlewares
+
+import (
+	"chat_backend/internal/app/services"
+	"chat_backend/pkg/utils"
+	"errors"
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Session authenticates the request against the signing keyring, reading the
+// token from an Authorization: Bearer header or else the chat_app cookie, and
+// stores the user ID where the PASETO middleware used to. API tokens are
+// refused, routes that accept them are declared with Scope. Sessions of
+// accounts pending deletion are refused too; signing in again restores them.
+//
+// Unsafe requests authenticated by the cookie must also come from the client
+// or the API itself. The cookie is SameSite=Strict already; the origin check
+// covers browsers that ignore it. Bearer tokens are never sent by the browser
+// on its own, so they need no such check.
+func Session(keyring services.KeyringService, apiTokens services.ApiTokenService, users services.UserService) fiber.Handler {
+	return authenticate(keyring, apiTokens, users, "")
+}
+
+// Scope authenticates like Session, but also accepts API tokens that were
+// granted scope. Routes using it must be registered before Session.
+func Scope(keyring services.KeyringService, apiTokens services.ApiTokenService, users services.UserService, scope string) fiber.Handler {
+	return authenticate(keyring, apiTokens, users, scope)
+}
+
+func authenticate(keyring services.KeyringService, apiTokens services.ApiTokenService, users services.UserService, scope string) fiber.Handler {
+	clientOrigin := origin(utils.GetEnv("CLIENT_URL", ""))
+
+	return func(ctx *fiber.Ctx) error {
+		token, bearer, ok := bearerToken(ctx)
+		if !ok {
+			return fiber.ErrUnauthorized
+		}
+
+		if bearer && services.IsApiToken(token) {
+			userID, scopes, err := apiTokens.Authenticate(ctx.UserContext(), token)
+			if errors.Is(err, services.ErrInvalidApiToken) {
+				return fiber.ErrUnauthorized
+			}
+			if err != nil {
+				slog.ErrorContext(ctx.UserContext(), "authenticate api token", "error", err)
+				return fiber.ErrInternalServerError
+			}
+
+			if len(scope) == 0 || !slices.Contains(scopes, scope) {
+				return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"message": "API token is not allowed to perform this request.",
+				})
+			}
+
+			ctx.Locals(pasetoware.DefaultContextKey, userID.String())
+
+			return UserContext(ctx)
+		}
+
+		if !bearer {
+			token = ctx.Cookies("chat_app")
+		}
+
+		userID, err := keyring.Verify(ctx.UserContext(), token)
+		if err != nil {
+			return fiber.ErrUnauthorized
+		}
+
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			return fiber.ErrUnauthorized
+		}
+		active, err := users.IsActive(ctx.UserContext(), id)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "check account", "error", err)
+			return fiber.ErrInternalServerError
+		}
+		if !active {
+			return fiber.ErrUnauthorized
+		}
+
+		if !bearer && !isSafeMethod(ctx.Method()) && !trustedOrigin(ctx, clientOrigin) {
+			return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "Cross-site request refused.",
+			})
+		}
+
+		ctx.Locals(pasetoware.DefaultContextKey, userID)
+
+		return UserContext(ctx)
+	}
+}
+
+// SessionToken returns the session token a request passed by Session was
+// authenticated with.
+func SessionToken(ctx *fiber.Ctx) string {
+	if token, bearer, _ := bearerToken(ctx); bearer {
+		return token
+	}
+
+	return ctx.Cookies("chat_app")
+}
+
+// bearerToken returns the token of an Authorization: Bearer header and
+// whether there was one. ok is false when the header uses another scheme.
+func bearerToken(ctx *fiber.Ctx) (token string, bearer, ok bool) {
+	header := ctx.Get(fiber.HeaderAuthorization)
+	if len(header) == 0 {
+		return "", false, true
+	}
+
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || len(token) == 0 {
+		return "", false, false
+	}
+
+	return strings.TrimSpace(token), true, true
+}
+
+func isSafeMethod(method string) bool {
+	return method == fiber.MethodGet || method == fiber.MethodHead || method == fiber.MethodOptions
+}
+
+// trustedOrigin checks the Origin header, or the Referer when it is missing.
+// Requests carrying neither come from non-browser clients, since browsers
+// always send an Origin on cross-site unsafe requests.
+func trustedOrigin(ctx *fiber.Ctx, clientOrigin string) bool {
+	source := ctx.Get(fiber.HeaderOrigin)
+	if len(source) == 0 {
+		source = ctx.Get(fiber.HeaderReferer)
+	}
+	if len(source) == 0 {
+		return true
+	}
+
+	source = origin(source)
+	if len(source) == 0 {
+		return false
+	}
+
+	return source == clientOrigin || source == origin(ctx.BaseURL())
+}
+
+// origin reduces a URL to its lowercase scheme://host, or returns "" when it
+// has neither.
+func origin(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return ""
+	}
+
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
@@ -0,0 +1,36 @@
+package middlewares
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"time"
+)
+
+// Timeout puts a deadline on the user context so that queries and uploads
+// started by a slow request are cancelled. fasthttp does not cancel the
+// context when the client disconnects, so the deadline is the only bound on
+// an abandoned request.
+//
+// A handler that gives up because of the deadline is answered with 503,
+// unless it has written a response already.
+func Timeout(timeout time.Duration) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		userCtx, cancel := context.WithTimeout(ctx.UserContext(), timeout)
+		defer cancel()
+
+		ctx.SetUserContext(userCtx)
+
+		err := ctx.Next()
+		if err == nil && userCtx.Err() == context.DeadlineExceeded && !responded(ctx) {
+			return fiber.ErrServiceUnavailable
+		}
+
+		return err
+	}
+}
+
+// responded reports whether the handler has set a status or body.
+func responded(ctx *fiber.Ctx) bool {
+	res := ctx.Response()
+	return res.StatusCode() != fiber.StatusOK || len(res.Body()) > 0 || res.IsBodyStream()
+}
@@ -5,20 +5,114 @@ import (
 	"chat_backend/internal/app/repositories"
 	"chat_backend/internal/app/services"
 	"chat_backend/internal/delivery/handlers"
+	"chat_backend/internal/delivery/middlewares"
+	"chat_backend/pkg/mailer"
+	"chat_backend/pkg/password"
+	"chat_backend/pkg/push"
+	"chat_backend/pkg/storage"
 	"chat_backend/pkg/utils"
 	"github.com/cloudinary/cloudinary-go/v2"
 	pasetoware "github.com/gofiber/contrib/paseto"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/monitor"
 	"github.com/gofiber/swagger"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log/slog"
+	"os"
 	"time"
 )
 
-func AppRouter(app *fiber.App, queries *generated.Queries, cld *cloudinary.Cloudinary) {
-	authRepo := repositories.NewAuthRepo(queries)
-	authService := services.NewAuthService(authRepo)
-	userRepo := repositories.NewUserRepo(queries, cld, authRepo)
-	userService := services.NewUserService(userRepo)
+// Services are the services that the background jobs run on.
+type Services struct {
+	User       services.UserService
+	DataExport services.DataExportService
+	Push       services.PushService
+}
+
+// AppRouter registers the routes on app. It starts no background work; the
+// caller runs the jobs on the returned services.
+func AppRouter(app *fiber.App, db *pgxpool.Pool, queries *generated.Queries, cld *cloudinary.Cloudinary) Services {
+	timeouts := utils.GetTimeouts()
+	hasher := password.NewHasher()
+	store := storage.New(cld)
+
+	tokenSecret, err := utils.GetTokenSecret()
+	if err != nil {
+		slog.Error("configure tokens", "error", err)
+		os.Exit(1)
+	}
+	keyringKey, err := utils.GetKeyringKey()
+	if err != nil {
+		slog.Error("configure keyring", "error", err)
+		os.Exit(1)
+	}
+	preAuthKey, err := utils.GetPreAuthKey()
+	if err != nil {
+		slog.Error("configure two-factor", "error", err)
+		os.Exit(1)
+	}
+	oidcStateKey, err := utils.GetOIDCStateKey()
+	if err != nil {
+		slog.Error("configure oidc", "error", err)
+		os.Exit(1)
+	}
+
+	newRepositories := func(queries *generated.Queries) repositories.Repositories {
+		authRepo := repositories.NewAuthRepo(queries, hasher, timeouts)
+		return repositories.Repositories{
+			Auth:         authRepo,
+			User:         repositories.NewUserRepo(queries, store, authRepo, timeouts),
+			Token:        repositories.NewTokenRepo(queries, tokenSecret, timeouts),
+			TwoFactor:    repositories.NewTwoFactorRepo(queries, authRepo, timeouts),
+			Passkey:      repositories.NewPasskeyRepo(queries, timeouts),
+			Identity:     repositories.NewIdentityRepo(queries, timeouts),
+			SigningKey:   repositories.NewSigningKeyRepo(queries, keyringKey, timeouts),
+			ApiToken:     repositories.NewApiTokenRepo(queries, tokenSecret, timeouts),
+			Role:         repositories.NewRoleRepo(queries, timeouts),
+			Audit:        repositories.NewAuditRepo(queries, timeouts),
+			DataExport:   repositories.NewDataExportRepo(queries, timeouts),
+			Notification: repositories.NewNotificationRepo(queries, timeouts),
+			Push:         repositories.NewPushRepo(queries, timeouts),
+		}
+	}
+
+	repos := newRepositories(queries)
+	txManager := repositories.NewTxManager(db, newRepositories, utils.GetEnvInt("TX_MAX_ATTEMPTS", 3))
+
+	mail, err := mailer.New()
+	if err != nil {
+		slog.Error("configure mailer", "error", err)
+		os.Exit(1)
+	}
+	keyring := services.NewKeyringService(repos.SigningKey, utils.GetEnvDuration("KEYRING_REFRESH", time.Minute))
+	passwordPolicy := password.NewPolicy()
+	usernamePolicy := services.NewUsernamePolicy()
+
+	authService := services.NewAuthService(repos.Auth, repos.User, txManager, mail, passwordPolicy, usernamePolicy)
+	userService := services.NewUserService(repos.User, txManager, mail, passwordPolicy, usernamePolicy)
+	twoFactorService := services.NewTwoFactorService(repos.Auth, repos.TwoFactor, txManager)
+	passkeyService, err := services.NewPasskeyService(repos.Auth, repos.Passkey)
+	if err != nil {
+		slog.Error("configure passkeys", "error", err)
+		os.Exit(1)
+	}
+	oidcService := services.NewOIDCService(repos.Identity, repos.User, txManager, usernamePolicy)
+	apiTokenService := services.NewApiTokenService(repos.ApiToken)
+	roleService := services.NewRoleService(repos.Role, txManager)
+	auditService := services.NewAuditService(repos.Audit)
+	dataExportService := services.NewDataExportService(repos, txManager, mail)
+	notificationService := services.NewNotificationService(repos.Notification)
+	senders, err := push.New()
+	if err != nil {
+		slog.Error("configure push", "error", err)
+		os.Exit(1)
+	}
+	pushService, err := services.NewPushService(repos.Push, notificationService, senders)
+	if err != nil {
+		slog.Error("configure push", "error", err)
+		os.Exit(1)
+	}
 
 	app.Get("/metrics", monitor.New(monitor.Config{
 		Title:   "ChatApp Resource Monitor",
@@ -27,25 +121,121 @@ func AppRouter(app *fiber.App, queries *generated.Queries, cld *cloudinary.Cloud
 
 	app.Get("/swagger/*", swagger.HandlerDefault)
 
-	api := app.Group("/api")
+	if local, ok := store.(*storage.LocalStorage); ok {
+		app.Static("/uploads", local.Dir, fiber.Static{
+			MaxAge: int((365 * 24 * time.Hour).Seconds()),
+			// Uploads are loaded by the client from another origin.
+			ModifyResponse: func(ctx *fiber.Ctx) error {
+				ctx.Set("Cross-Origin-Resource-Policy", "cross-origin")
+				return nil
+			},
+		})
+	}
+
+	bearer := func(ctx *fiber.Ctx) bool {
+		return len(ctx.Get(fiber.HeaderAuthorization)) > 0
+	}
+	unauthorized := func(ctx *fiber.Ctx, err error) error {
+		return fiber.ErrUnauthorized
+	}
+	// scope lets API tokens with the scope use a route. It must be
+	// registered before the session middleware, which refuses them.
+	scope := func(scope string) fiber.Handler {
+		return middlewares.Scope(keyring, apiTokenService, userService, scope)
+	}
+	permission := func(permission string) fiber.Handler {
+		return middlewares.Permission(roleService, permission)
+	}
+
+	api := app.Group("/api", middlewares.Timeout(timeouts.Request))
 	auth := api.Group("/auth")
 	user := api.Group("/user")
+	admin := api.Group("/admin")
+	users := api.Group("/users")
 
-	auth.Post("/signup", handlers.SignUpHandler(authService))
-	auth.Post("/login", handlers.LoginHandler(authService))
+	auth.Post("/signup", handlers.SignUpHandler(authService, auditService))
+	auth.Post("/login", handlers.LoginHandler(authService, keyring, auditService))
+	auth.Get("/password/policy", handlers.PasswordPolicyHandler(passwordPolicy))
+	auth.Post("/password/forgot", limiter.New(limiter.Config{
+		Max:        5,
+		Expiration: time.Minute,
+	}), handlers.ForgotPasswordHandler(authService))
+	auth.Post("/password/reset", handlers.ResetPasswordHandler(authService, auditService))
+	auth.Post("/email/verify", handlers.VerifyEmailHandler(authService))
+	auth.Post("/2fa/verify", limiter.New(limiter.Config{
+		Max:        5,
+		Expiration: time.Minute,
+	}), pasetoware.New(pasetoware.Config{
+		Next:         bearer,
+		SymmetricKey: preAuthKey,
+		TokenLookup:  [2]string{pasetoware.LookupCookie, "chat_app_2fa"},
+		ErrorHandler: unauthorized,
+	}), pasetoware.New(pasetoware.Config{
+		Next:         func(ctx *fiber.Ctx) bool { return !bearer(ctx) },
+		SymmetricKey: preAuthKey,
+		TokenPrefix:  "Bearer",
+		ErrorHandler: unauthorized,
+	}), handlers.VerifyTwoFactorHandler(twoFactorService, keyring, authService, auditService))
+	auth.Post("/passkeys/login/begin", handlers.BeginPasskeyLoginHandler(passkeyService))
+	auth.Post("/passkeys/login/finish", handlers.FinishPasskeyLoginHandler(passkeyService, keyring, authService, auditService))
+	auth.Get("/oidc/providers", handlers.OIDCProvidersHandler(oidcService))
+	auth.Get("/oidc/:provider/login", handlers.OIDCLoginHandler(oidcService))
+	auth.Get("/oidc/:provider/callback", pasetoware.New(pasetoware.Config{
+		SymmetricKey: oidcStateKey,
+		TokenLookup:  [2]string{pasetoware.LookupCookie, "chat_app_oidc"},
+		ErrorHandler: handlers.OIDCStateErrorHandler,
+	}), handlers.OIDCCallbackHandler(oidcService, keyring, authService, auditService))
 
-	api.Use(pasetoware.New(pasetoware.Config{
-		PrivateKey:  utils.GetPrivateKey(),
-		PublicKey:   utils.GetPrivateKey().Public(),
-		TokenLookup: [2]string{pasetoware.LookupCookie, "chat_app"},
-		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
-			return fiber.ErrUnauthorized
-		},
-	}))
+	user.Get("/profile", scope(services.ScopeProfile), handlers.GetProfileHandler(userService))
+
+	admin.Get("/roles", scope(services.ScopeAdmin), permission(repositories.PermissionReadRoles), handlers.ListRolesHandler(roleService))
+	admin.Get("/users/:id/roles", scope(services.ScopeAdmin), permission(repositories.PermissionReadRoles), handlers.GetUserRolesHandler(roleService))
+	admin.Put("/users/:id/roles/:role", scope(services.ScopeAdmin), permission(repositories.PermissionAssignRoles), handlers.AssignRoleHandler(roleService, auditService))
+	admin.Delete("/users/:id/roles/:role", scope(services.ScopeAdmin), permission(repositories.PermissionAssignRoles), handlers.RemoveRoleHandler(roleService, auditService))
+	admin.Get("/audit", scope(services.ScopeAdmin), permission(repositories.PermissionReadAudit), handlers.ListAuditEventsHandler(auditService))
+
+	// Identicons only reveal what the user ID already does.
+	users.Get("/:id/identicon.svg", handlers.IdenticonHandler())
+
+	api.Use(middlewares.Session(keyring, apiTokenService, userService))
+
+	auth.Post("/signout", handlers.SignOutHandler(auditService, pushService))
 
-	auth.Post("/signout", handlers.SignOutHandler())
+	user.Patch("/profile/update", handlers.UpdateProfileHandler(userService, auditService))
+	user.Delete("/profile/delete", handlers.DeleteUserHandler(userService, auditService))
+	user.Delete("/profile/avatar", handlers.ResetAvatarHandler(userService, auditService))
+	user.Post("/2fa/enroll", handlers.EnrollTwoFactorHandler(twoFactorService))
+	user.Post("/2fa/confirm", handlers.ConfirmTwoFactorHandler(twoFactorService, auditService))
+	user.Post("/2fa/disable", handlers.DisableTwoFactorHandler(twoFactorService, auditService))
+	user.Get("/passkeys", handlers.ListPasskeysHandler(passkeyService))
+	user.Post("/passkeys/register/begin", handlers.BeginPasskeyRegistrationHandler(passkeyService))
+	user.Post("/passkeys/register/finish", handlers.FinishPasskeyRegistrationHandler(passkeyService))
+	user.Delete("/passkeys/:id", handlers.DeletePasskeyHandler(passkeyService))
+	user.Get("/identities", handlers.ListIdentitiesHandler(oidcService))
+	user.Post("/identities/:provider/link", handlers.LinkIdentityHandler(oidcService))
+	user.Delete("/identities/:provider", handlers.UnlinkIdentityHandler(oidcService))
+	user.Get("/tokens", handlers.ListApiTokensHandler(apiTokenService))
+	user.Post("/tokens", handlers.CreateApiTokenHandler(apiTokenService, auditService))
+	user.Delete("/tokens/:id", handlers.RevokeApiTokenHandler(apiTokenService, auditService))
+	user.Get("/security/activity", handlers.SecurityActivityHandler(auditService))
+	users.Get("/:username", handlers.GetUserByUsernameHandler(userService))
+	user.Get("/export", handlers.ListDataExportsHandler(dataExportService))
+	user.Post("/export", handlers.RequestDataExportHandler(dataExportService, auditService))
+	user.Get("/export/:id", handlers.DownloadDataExportHandler(dataExportService))
+	user.Get("/notifications", handlers.GetNotificationSettingsHandler(notificationService))
+	user.Patch("/notifications", handlers.UpdateNotificationSettingsHandler(notificationService))
+	user.Get("/push/key", handlers.PushKeyHandler(pushService))
+	user.Get("/push/subscriptions", handlers.ListPushSubscriptionsHandler(pushService))
+	user.Post("/push/subscriptions", handlers.SubscribePushHandler(pushService))
+	user.Delete("/push/subscriptions/:id", handlers.UnsubscribePushHandler(pushService))
+	user.Get("/push/devices", handlers.ListPushDevicesHandler(pushService))
+	user.Post("/push/devices", handlers.RegisterPushDeviceHandler(pushService))
+	user.Delete("/push/devices/:id", handlers.UnregisterPushDeviceHandler(pushService))
+	user.Post("/push/test", handlers.TestPushHandler(pushService))
 
-	user.Get("/profile", handlers.GetProfileHandler(userService))
-	user.Patch("/profile/update", handlers.UpdateProfileHandler(userService))
-	user.Delete("/profile/delete", handlers.DeleteUserHandler(userService))
+	return Services{
+		User:       userService,
+		DataExport: dataExportService,
+		Push:       pushService,
+	}
 }
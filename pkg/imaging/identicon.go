@@ -0,0 +1,134 @@
+package imaging
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+const identiconGrid = 5
+
+var identiconBackground = color.RGBA{R: 0xf0, G: 0xf0, B: 0xf0, A: 0xff}
+
+// Identicon is a mirrored 5x5 pattern in one color, derived from a seed so
+// that the same seed always gives the same picture.
+type Identicon struct {
+	cells [identiconGrid][identiconGrid]bool
+	color color.RGBA
+}
+
+func NewIdenticon(seed []byte) Identicon {
+	hash := sha256.Sum256(seed)
+
+	var identicon Identicon
+	for row := 0; row < identiconGrid; row++ {
+		for col := 0; col <= identiconGrid/2; col++ {
+			on := hash[row*3+col]&1 == 0
+			identicon.cells[row][col] = on
+			identicon.cells[row][identiconGrid-1-col] = on
+		}
+	}
+
+	hue := float64(uint16(hash[28])<<8|uint16(hash[29])) / 65536 * 360
+	saturation := 0.45 + float64(hash[30])/255*0.2
+	lightness := 0.45 + float64(hash[31])/255*0.15
+	identicon.color = hsl(hue, saturation, lightness)
+
+	return identicon
+}
+
+// Variants renders the identicon as a PNG at each of sizes, with a border of
+// half a cell.
+func (i Identicon) Variants(sizes []int) ([]Variant, error) {
+	variants := make([]Variant, 0, len(sizes))
+
+	for _, size := range sizes {
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.Draw(img, img.Bounds(), image.NewUniform(identiconBackground), image.Point{}, draw.Src)
+
+		cell := size / (identiconGrid + 1)
+		margin := (size - cell*identiconGrid) / 2
+		for row := 0; row < identiconGrid; row++ {
+			for col := 0; col < identiconGrid; col++ {
+				if !i.cells[row][col] {
+					continue
+				}
+
+				r := image.Rect(margin+col*cell, margin+row*cell, margin+(col+1)*cell, margin+(row+1)*cell)
+				draw.Draw(img, r, image.NewUniform(i.color), image.Point{}, draw.Src)
+			}
+		}
+
+		var buffer bytes.Buffer
+		if err := png.Encode(&buffer, img); err != nil {
+			return nil, err
+		}
+
+		variants = append(variants, Variant{
+			Size:        size,
+			Data:        buffer.Bytes(),
+			ContentType: "image/png",
+			Ext:         ".png",
+		})
+	}
+
+	return variants, nil
+}
+
+// SVG renders the identicon as a scalable image.
+func (i Identicon) SVG() []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, 2*identiconGrid+2, 2*identiconGrid+2)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`, hex(identiconBackground))
+	fmt.Fprintf(&b, `<g fill="%s">`, hex(i.color))
+	for row := 0; row < identiconGrid; row++ {
+		for col := 0; col < identiconGrid; col++ {
+			if i.cells[row][col] {
+				fmt.Fprintf(&b, `<rect x="%d" y="%d" width="2" height="2"/>`, 1+2*col, 1+2*row)
+			}
+		}
+	}
+	b.WriteString(`</g></svg>`)
+
+	return b.Bytes()
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// hsl converts a hue in degrees, saturation and lightness to RGB.
+func hsl(h, s, l float64) color.RGBA {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	return color.RGBA{
+		R: uint8((r + m) * 255),
+		G: uint8((g + m) * 255),
+		B: uint8((b + m) * 255),
+		A: 0xff,
+	}
+}
@@ -0,0 +1,145 @@
+// Package imaging turns uploaded pictures into square variants. Decoding and
+// encoding again drops every metadata block, EXIF and its GPS position
+// included, after the EXIF orientation has been applied to the pixels.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// maxPixels bounds the decoded size so that small files cannot expand into
+// huge images.
+const maxPixels = 40_000_000
+
+var (
+	ErrUnsupported = errors.New("unsupported image format")
+	ErrTooLarge    = errors.New("image is too large")
+)
+
+// Variant is an encoded square rendition of an image.
+type Variant struct {
+	Size        int
+	Data        []byte
+	ContentType string
+	// Ext is the file extension matching ContentType, with the dot.
+	Ext string
+}
+
+// Square decodes a JPEG, PNG, WebP or GIF image, crops it to its centered
+// square and scales that to each of sizes. Opaque images are encoded as
+// JPEG, images with transparency as PNG. Only the first frame of an
+// animated GIF is used.
+func Square(data []byte, sizes []int) ([]Variant, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+
+	square := cropSquare(img)
+	opaque := square.Opaque()
+
+	variants := make([]Variant, 0, len(sizes))
+	for _, size := range sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), square, square.Bounds(), draw.Src, nil)
+
+		variant := Variant{
+			Size: size,
+		}
+
+		var buffer bytes.Buffer
+		if opaque {
+			err = jpeg.Encode(&buffer, dst, &jpeg.Options{Quality: 85})
+			variant.ContentType, variant.Ext = "image/jpeg", ".jpg"
+		} else {
+			err = png.Encode(&buffer, dst)
+			variant.ContentType, variant.Ext = "image/png", ".png"
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		variant.Data = buffer.Bytes()
+		variants = append(variants, variant)
+	}
+
+	return variants, nil
+}
+
+// cropSquare copies the largest centered square of img.
+func cropSquare(img image.Image) *image.RGBA {
+	b := img.Bounds()
+
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), img, origin, draw.Src)
+
+	return dst
+}
+
+// orient applies an EXIF orientation, 1 to 8, so that the pixels are upright.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	var dst *image.RGBA
+	if orientation >= 5 {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)))
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LogMailer logs messages instead of delivering them. The body is left out
+// since it carries verification and reset tokens; FileMailer keeps it.
+type LogMailer struct {
+	From string
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "send mail", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
+
+func NewLogMailer(from string) Mailer {
+	return &LogMailer{
+		From: from,
+	}
+}
+
+// FileMailer writes every message as an .eml file into Dir.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := filepath.Join(m.Dir, fmt.Sprintf("%d.eml", time.Now().UnixNano()))
+	if err := os.WriteFile(name, encode(m.From, msg), 0o600); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "send mail", "to", msg.To, "subject", msg.Subject, "file", name)
+
+	return nil
+}
+
+func NewFileMailer(dir, from string) Mailer {
+	return &FileMailer{
+		Dir:  dir,
+		From: from,
+	}
+}
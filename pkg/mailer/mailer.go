@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"chat_backend/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New picks the mailer from MAILER: "smtp" sends through SMTP_HOST, "file"
+// writes messages to MAILER_DIR and "log" only logs that they were sent.
+// MAILER defaults to "log" in development and must be set when PROD is, so
+// that a deployment does not silently drop its mail.
+func New() (Mailer, error) {
+	from := utils.GetEnv("MAIL_FROM", "Chat App <no-reply@localhost>")
+
+	kind := os.Getenv("MAILER")
+	if len(kind) == 0 {
+		if prod, _ := strconv.ParseBool(os.Getenv("PROD")); prod {
+			return nil, errors.New("MAILER is not set")
+		}
+		kind = "log"
+	}
+
+	switch kind {
+	case "smtp":
+		return NewSMTPMailer(
+			utils.GetEnv("SMTP_HOST", "localhost"),
+			utils.GetEnvInt("SMTP_PORT", 587),
+			utils.GetEnv("SMTP_USERNAME", ""),
+			utils.GetEnv("SMTP_PASSWORD", ""),
+			from,
+		), nil
+	case "file":
+		return NewFileMailer(utils.GetEnv("MAILER_DIR", "tmp/mail"), from), nil
+	case "log":
+		return NewLogMailer(from), nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", kind)
+	}
+}
+
+// encode renders msg as an RFC 5322 message.
+func encode(from string, msg Message) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
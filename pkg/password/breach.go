@@ -0,0 +1,53 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachList looks passwords up in a local copy of a k-anonymity breach
+// corpus: one file per five character SHA-1 prefix, named after the prefix,
+// holding "SUFFIX:COUNT" lines as served by the Pwned Passwords range API.
+// Only the prefix file of the password being checked is read.
+type BreachList struct {
+	dir string
+}
+
+// NewBreachList returns a list reading from dir. An empty dir disables the
+// check.
+func NewBreachList(dir string) *BreachList {
+	return &BreachList{dir: dir}
+}
+
+func (b *BreachList) Contains(password string) bool {
+	if b == nil || len(b.dir) == 0 {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(b.dir, prefix))
+	if os.IsNotExist(err) {
+		file, err = os.Open(filepath.Join(b.dir, prefix+".txt"))
+	}
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(line), suffix) {
+			return true
+		}
+	}
+
+	return false
+}
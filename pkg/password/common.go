@@ -0,0 +1,29 @@
+package password
+
+import (
+	_ "embed"
+	"strings"
+)
+
+//go:embed common.txt
+var commonList string
+
+// commonRanks maps the bundled common passwords to their popularity rank,
+// starting at 1 for the most common one.
+var commonRanks = func() map[string]int {
+	ranks := make(map[string]int)
+	for i, word := range strings.Fields(commonList) {
+		if _, ok := ranks[word]; !ok {
+			ranks[word] = i + 1
+		}
+	}
+
+	return ranks
+}()
+
+// IsCommon reports whether password, ignoring case, is on the bundled list
+// of common passwords.
+func IsCommon(password string) bool {
+	_, ok := commonRanks[strings.ToLower(password)]
+	return ok
+}
@@ -0,0 +1,111 @@
+package password
+
+import (
+	"bytes"
+	"chat_backend/pkg/utils"
+	"crypto/hmac"
+	"crypto/sha256"
+	"github.com/matthewhartstonge/argon2"
+)
+
+// pepperMarker prefixes hashes of peppered passwords, so hashes stored
+// before the pepper was configured still verify and get upgraded.
+var pepperMarker = []byte("$pepper")
+
+// Hasher hashes passwords with argon2id. Its parameters come from the
+// configuration and an optional pepper, kept outside the database, is mixed
+// into every password before hashing.
+type Hasher struct {
+	config argon2.Config
+	pepper []byte
+}
+
+// NewHasher reads ARGON2_TIME_COST, ARGON2_MEMORY_COST (KiB),
+// ARGON2_PARALLELISM, ARGON2_HASH_LENGTH and ARGON2_SALT_LENGTH, defaulting
+// to the RFC 9106 memory constrained recommendation, and PASSWORD_PEPPER.
+func NewHasher() *Hasher {
+	config := argon2.MemoryConstrainedDefaults()
+
+	config.TimeCost = envUint32("ARGON2_TIME_COST", config.TimeCost)
+	config.MemoryCost = envUint32("ARGON2_MEMORY_COST", config.MemoryCost)
+	config.Parallelism = uint8(min(envUint32("ARGON2_PARALLELISM", uint32(config.Parallelism)), 255))
+	config.HashLength = envUint32("ARGON2_HASH_LENGTH", config.HashLength)
+	config.SaltLength = envUint32("ARGON2_SALT_LENGTH", config.SaltLength)
+
+	return &Hasher{
+		config: config,
+		pepper: []byte(utils.GetEnv("PASSWORD_PEPPER", "")),
+	}
+}
+
+func envUint32(key string, fallback uint32) uint32 {
+	if value := utils.GetEnvInt(key, int(fallback)); value > 0 {
+		return uint32(value)
+	}
+
+	return fallback
+}
+
+func (h *Hasher) Hash(password string) ([]byte, error) {
+	encoded, err := h.config.HashEncoded(h.season([]byte(password), len(h.pepper) > 0))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(h.pepper) > 0 {
+		encoded = append(append([]byte{}, pepperMarker...), encoded...)
+	}
+
+	return encoded, nil
+}
+
+func (h *Hasher) Verify(encoded, password string) (bool, error) {
+	hash, peppered := h.split([]byte(encoded))
+	if peppered && len(h.pepper) == 0 {
+		return false, nil
+	}
+
+	return argon2.VerifyEncoded(h.season([]byte(password), peppered), hash)
+}
+
+// NeedsRehash reports whether encoded was made with weaker parameters than
+// the configured ones, or without the pepper, and should be replaced with a
+// new hash the next time the password is known.
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	hash, peppered := h.split([]byte(encoded))
+	if len(h.pepper) > 0 && !peppered {
+		return true
+	}
+
+	raw, err := argon2.Decode(hash)
+	if err != nil {
+		return false
+	}
+
+	return raw.Config.Mode != h.config.Mode ||
+		raw.Config.Version < h.config.Version ||
+		raw.Config.TimeCost < h.config.TimeCost ||
+		raw.Config.MemoryCost < h.config.MemoryCost ||
+		raw.Config.Parallelism < h.config.Parallelism ||
+		uint32(len(raw.Hash)) < h.config.HashLength ||
+		uint32(len(raw.Salt)) < h.config.SaltLength
+}
+
+func (h *Hasher) split(encoded []byte) ([]byte, bool) {
+	if bytes.HasPrefix(encoded, pepperMarker) {
+		return encoded[len(pepperMarker):], true
+	}
+
+	return encoded, false
+}
+
+// season mixes the pepper into password with HMAC-SHA256.
+func (h *Hasher) season(password []byte, peppered bool) []byte {
+	if !peppered {
+		return password
+	}
+
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write(password)
+	return mac.Sum(nil)
+}
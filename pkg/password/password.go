@@ -0,0 +1,134 @@
+package password
+
+import (
+	"chat_backend/pkg/utils"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	ReasonTooShort         = "too_short"
+	ReasonMissingLowercase = "missing_lowercase"
+	ReasonMissingUppercase = "missing_uppercase"
+	ReasonMissingDigit     = "missing_digit"
+	ReasonMissingSymbol    = "missing_symbol"
+	ReasonContainsUsername = "contains_username"
+	ReasonCommon           = "common"
+	ReasonBreached         = "breached"
+	ReasonTooWeak          = "too_weak"
+)
+
+// Reason explains one way in which a password fails the policy.
+type Reason struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PolicyError is returned when a password is rejected by the policy.
+type PolicyError struct {
+	Score   int      `json:"score"`
+	Reasons []Reason `json:"reasons"`
+}
+
+func (e *PolicyError) Error() string {
+	codes := make([]string, len(e.Reasons))
+	for i, reason := range e.Reasons {
+		codes[i] = reason.Code
+	}
+
+	return "password rejected by policy: " + strings.Join(codes, ", ")
+}
+
+// Policy describes the requirements for new passwords. It is returned to
+// clients as is, so they can show the rules before submitting.
+type Policy struct {
+	MinLength     int  `json:"min_length"`
+	RequireLower  bool `json:"require_lowercase"`
+	RequireUpper  bool `json:"require_uppercase"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+	// MinScore is the lowest accepted strength score, from 0 to 4.
+	MinScore int `json:"min_score"`
+
+	breaches *BreachList
+}
+
+// NewPolicy reads the policy from PASSWORD_MIN_LENGTH, PASSWORD_REQUIRE_LOWERCASE,
+// PASSWORD_REQUIRE_UPPERCASE, PASSWORD_REQUIRE_DIGIT, PASSWORD_REQUIRE_SYMBOL
+// and PASSWORD_MIN_SCORE. Breached passwords are looked up in
+// PASSWORD_BREACH_DIR when it is set.
+func NewPolicy() *Policy {
+	return &Policy{
+		MinLength:     utils.GetEnvInt("PASSWORD_MIN_LENGTH", 8),
+		RequireLower:  utils.GetEnvBool("PASSWORD_REQUIRE_LOWERCASE", false),
+		RequireUpper:  utils.GetEnvBool("PASSWORD_REQUIRE_UPPERCASE", false),
+		RequireDigit:  utils.GetEnvBool("PASSWORD_REQUIRE_DIGIT", false),
+		RequireSymbol: utils.GetEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		MinScore:      utils.GetEnvInt("PASSWORD_MIN_SCORE", 2),
+		breaches:      NewBreachList(utils.GetEnv("PASSWORD_BREACH_DIR", "")),
+	}
+}
+
+// Check returns a *PolicyError listing every rule password breaks, or nil.
+// username is rejected as part of the password and lowers its score.
+func (p *Policy) Check(password, username string) error {
+	var reasons []Reason
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		reasons = append(reasons, Reason{ReasonTooShort, "Password must be at least " + strconv.Itoa(p.MinLength) + " characters long."})
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	if p.RequireLower && !lower {
+		reasons = append(reasons, Reason{ReasonMissingLowercase, "Password must contain a lowercase letter."})
+	}
+	if p.RequireUpper && !upper {
+		reasons = append(reasons, Reason{ReasonMissingUppercase, "Password must contain an uppercase letter."})
+	}
+	if p.RequireDigit && !digit {
+		reasons = append(reasons, Reason{ReasonMissingDigit, "Password must contain a digit."})
+	}
+	if p.RequireSymbol && !symbol {
+		reasons = append(reasons, Reason{ReasonMissingSymbol, "Password must contain a symbol."})
+	}
+
+	normalized := strings.ToLower(password)
+	if len(username) >= 3 && strings.Contains(normalized, strings.ToLower(username)) {
+		reasons = append(reasons, Reason{ReasonContainsUsername, "Password must not contain the username."})
+	}
+
+	if IsCommon(password) {
+		reasons = append(reasons, Reason{ReasonCommon, "Password is too common."})
+	} else if p.breaches.Contains(password) {
+		reasons = append(reasons, Reason{ReasonBreached, "Password has appeared in a data breach."})
+	}
+
+	score := Score(password, username)
+	if score < p.MinScore {
+		reasons = append(reasons, Reason{ReasonTooWeak, "Password is too easy to guess."})
+	}
+
+	if len(reasons) > 0 {
+		return &PolicyError{
+			Score:   score,
+			Reasons: reasons,
+		}
+	}
+
+	return nil
+}
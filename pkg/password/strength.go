@@ -0,0 +1,202 @@
+package password
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Score thresholds in log2(guesses), matching zxcvbn's 10^3, 10^6, 10^8 and
+// 10^10 guesses.
+var scoreThresholds = [...]float64{9.97, 19.93, 26.58, 33.22}
+
+var leetReplacer = strings.NewReplacer(
+	"4", "a", "@", "a", "8", "b", "3", "e", "6", "g", "1", "i", "!", "i",
+	"0", "o", "5", "s", "$", "s", "7", "t", "+", "t", "2", "z",
+)
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+// commonWords holds the bundled common passwords longest first, so that the
+// longest dictionary match wins.
+var commonWords = func() []string {
+	words := make([]string, 0, len(commonRanks))
+	for word := range commonRanks {
+		if len(word) >= 3 {
+			words = append(words, word)
+		}
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if len(words[i]) != len(words[j]) {
+			return len(words[i]) > len(words[j])
+		}
+		return words[i] < words[j]
+	})
+
+	return words
+}()
+
+// Score estimates how hard password is to guess on zxcvbn's scale of 0 (too
+// guessable) to 4 (very unguessable). Dictionary words, leet substitutions,
+// the given user inputs, repeats, sequences and keyboard walks are all
+// charged far less than random characters.
+func Score(password string, userInputs ...string) int {
+	bits := entropy(password, userInputs)
+
+	score := 0
+	for _, threshold := range scoreThresholds {
+		if bits >= threshold {
+			score++
+		}
+	}
+
+	return score
+}
+
+func entropy(password string, userInputs []string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	lower := []rune(strings.ToLower(password))
+	unleeted := []rune(leetReplacer.Replace(strings.ToLower(password)))
+	if len(unleeted) != len(lower) {
+		unleeted = lower
+	}
+
+	covered := make([]bool, len(runes))
+	bits := 0.0
+
+	match := func(text []rune, word string, cost float64, leet bool) {
+		w := []rune(word)
+		for start := 0; start+len(w) <= len(text); start++ {
+			if string(text[start:start+len(w)]) != word || anyCovered(covered, start, len(w)) {
+				continue
+			}
+
+			for i := start; i < start+len(w); i++ {
+				covered[i] = true
+			}
+
+			bits += cost
+			if hasUpper(runes[start : start+len(w)]) {
+				bits++
+			}
+			if leet && string(lower[start:start+len(w)]) != word {
+				bits++
+			}
+		}
+	}
+
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		if len([]rune(input)) >= 3 {
+			match(lower, input, 1, false)
+			match(unleeted, input, 1, true)
+		}
+	}
+
+	for _, word := range commonWords {
+		cost := math.Log2(float64(commonRanks[word]) + 1)
+		match(lower, word, cost, false)
+		match(unleeted, word, cost, true)
+	}
+
+	charset := math.Log2(float64(charsetSize(runes)))
+	for i, r := range lower {
+		if covered[i] {
+			continue
+		}
+
+		if i > 0 && !covered[i-1] && isPattern(lower[i-1], r) {
+			bits++
+			continue
+		}
+
+		bits += charset
+	}
+
+	return bits
+}
+
+// isPattern reports whether next continues a repeat, an alphabetical or
+// numerical sequence, or a keyboard walk started by prev.
+func isPattern(prev, next rune) bool {
+	if prev == next || next-prev == 1 || prev-next == 1 {
+		return true
+	}
+
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, prev)
+		j := strings.IndexRune(row, next)
+		if i >= 0 && j >= 0 && (i-j == 1 || j-i == 1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func charsetSize(runes []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+
+	return size
+}
+
+func anyCovered(covered []bool, start, length int) bool {
+	for i := start; i < start+length; i++ {
+		if covered[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasUpper(runes []rune) bool {
+	for _, r := range runes {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+
+	return false
+}
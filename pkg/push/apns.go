@@ -0,0 +1,183 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apnsTokenRefresh is how long a provider token is used. APNs accepts one
+// for an hour and refuses new ones more often than every 20 minutes.
+const apnsTokenRefresh = 50 * time.Minute
+
+// APNsSender sends with token-based authentication over HTTP/2.
+type APNsSender struct {
+	Endpoint string
+	// Topic is the bundle ID of the app.
+	Topic  string
+	KeyID  string
+	TeamID string
+
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+func (a *APNsSender) Send(ctx context.Context, msg Message) error {
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{
+				"title": msg.Title,
+				"body":  msg.Body,
+			},
+			"sound":     "default",
+			"thread-id": msg.CollapseKey,
+		},
+	}
+	for key, value := range msg.Data {
+		if key != "aps" {
+			payload[key] = value
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	token, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Endpoint+"/3/device/"+msg.Token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", a.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+	if len(msg.CollapseKey) > 0 {
+		req.Header.Set("apns-collapse-id", collapseID(msg.CollapseKey))
+	}
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTemporary, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var failure struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&failure)
+
+	switch {
+	case res.StatusCode == http.StatusGone, failure.Reason == "BadDeviceToken", failure.Reason == "DeviceTokenNotForTopic":
+		return ErrUnregistered
+	case failure.Reason == "ExpiredProviderToken":
+		a.mu.Lock()
+		a.token = ""
+		a.mu.Unlock()
+		return fmt.Errorf("%w: apns provider token expired", ErrTemporary)
+	case res.StatusCode == http.StatusTooManyRequests, res.StatusCode >= 500:
+		return fmt.Errorf("%w: apns responded %d %s", ErrTemporary, res.StatusCode, failure.Reason)
+	}
+
+	return fmt.Errorf("apns responded %d %s", res.StatusCode, failure.Reason)
+}
+
+// providerToken returns the ES256 JWT that authenticates the team, signing
+// a new one when it is due.
+func (a *APNsSender) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.token) > 0 && time.Since(a.issuedAt) < apnsTokenRefresh {
+		return a.token, nil
+	}
+
+	issuedAt := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": a.KeyID})
+	claims, _ := json.Marshal(map[string]interface{}{"iss": a.TeamID, "iat": issuedAt.Unix()})
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	a.token = signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+	a.issuedAt = issuedAt
+
+	return a.token, nil
+}
+
+// collapseID fits key into the 64 bytes APNs allows.
+func collapseID(key string) string {
+	if len(key) <= 64 {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPNsSender signs with the .p8 key downloaded from the Apple developer
+// account, a PEM encoded PKCS #8 P-256 key.
+func NewAPNsSender(keyPEM []byte, keyID, teamID, topic, endpoint string, client *http.Client) (*APNsSender, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("apns key is not PEM encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("apns key: %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apns key is not an ECDSA key")
+	}
+
+	if len(keyID) == 0 || len(teamID) == 0 || len(topic) == 0 {
+		return nil, errors.New("apns needs a key ID, team ID and topic")
+	}
+
+	return &APNsSender{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Topic:    topic,
+		KeyID:    keyID,
+		TeamID:   teamID,
+		key:      key,
+		client:   client,
+	}, nil
+}
@@ -0,0 +1,52 @@
+package push
+
+import (
+	"context"
+	"sync"
+)
+
+// FakeSender keeps the messages it is given in memory, for tests. A failure
+// set for a token is returned instead of accepting the message.
+type FakeSender struct {
+	mu       sync.Mutex
+	messages []Message
+	failures map[string]error
+}
+
+func (f *FakeSender) Send(ctx context.Context, msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err, ok := f.failures[msg.Token]; ok {
+		return err
+	}
+
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+// Messages returns the messages accepted so far.
+func (f *FakeSender) Messages() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Message(nil), f.messages...)
+}
+
+// Fail makes sends to token return err, or succeed again when err is nil.
+func (f *FakeSender) Fail(token string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.failures, token)
+		return
+	}
+	f.failures[token] = err
+}
+
+func NewFakeSender() *FakeSender {
+	return &FakeSender{
+		failures: map[string]error{},
+	}
+}
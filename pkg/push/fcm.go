@@ -0,0 +1,133 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+	"net/http"
+	"strings"
+)
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCMSender sends with the Firebase Cloud Messaging HTTP v1 API,
+// authenticated as a service account.
+type FCMSender struct {
+	// URL is the messages:send endpoint of the project.
+	URL string
+
+	tokens oauth2.TokenSource
+	client *http.Client
+}
+
+func (f *FCMSender) Send(ctx context.Context, msg Message) error {
+	android := map[string]interface{}{
+		"priority": "high",
+	}
+	if len(msg.CollapseKey) > 0 {
+		android["collapse_key"] = msg.CollapseKey
+		android["notification"] = map[string]string{"tag": msg.CollapseKey}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token": msg.Token,
+			"notification": map[string]string{
+				"title": msg.Title,
+				"body":  msg.Body,
+			},
+			"data":    msg.Data,
+			"android": android,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	token, err := f.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTemporary, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	token.SetAuthHeader(req)
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTemporary, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var failure struct {
+		Error struct {
+			Status  string `json:"status"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&failure)
+
+	for _, detail := range failure.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return ErrUnregistered
+		}
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return ErrUnregistered
+	case res.StatusCode == http.StatusTooManyRequests, res.StatusCode >= 500:
+		return fmt.Errorf("%w: fcm responded %d %s", ErrTemporary, res.StatusCode, failure.Error.Status)
+	}
+
+	return fmt.Errorf("fcm responded %d %s", res.StatusCode, failure.Error.Status)
+}
+
+// NewFCMSender authenticates with the JSON key of a service account of the
+// Firebase project. endpoint is the FCM server, the project comes from the
+// key.
+func NewFCMSender(credentials []byte, endpoint string, client *http.Client) (*FCMSender, error) {
+	var account struct {
+		ProjectID    string `json:"project_id"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		TokenURI     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(credentials, &account); err != nil {
+		return nil, fmt.Errorf("fcm credentials: %w", err)
+	}
+	if len(account.ProjectID) == 0 || len(account.ClientEmail) == 0 || len(account.PrivateKey) == 0 {
+		return nil, errors.New("fcm credentials are not a service account key")
+	}
+	if len(account.TokenURI) == 0 {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	config := &jwt.Config{
+		Email:        account.ClientEmail,
+		PrivateKey:   []byte(account.PrivateKey),
+		PrivateKeyID: account.PrivateKeyID,
+		Scopes:       []string{fcmScope},
+		TokenURL:     account.TokenURI,
+	}
+
+	return &FCMSender{
+		URL:    strings.TrimSuffix(endpoint, "/") + "/v1/projects/" + account.ProjectID + "/messages:send",
+		tokens: config.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, client)),
+		client: client,
+	}, nil
+}
@@ -0,0 +1,87 @@
+// Package push delivers notifications to the native apps through Apple Push
+// Notification service and Firebase Cloud Messaging.
+package push
+
+import (
+	"chat_backend/pkg/utils"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+)
+
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+)
+
+var (
+	// ErrUnregistered is returned when the provider no longer accepts the
+	// device token. The device should be forgotten.
+	ErrUnregistered = errors.New("device token is not registered")
+	// ErrTemporary is wrapped by failures that are worth retrying later.
+	ErrTemporary = errors.New("push provider is temporarily unavailable")
+)
+
+type Message struct {
+	// Token is the device token the app registered.
+	Token string
+	Title string
+	Body  string
+	// CollapseKey makes the message replace an undelivered or displayed one
+	// with the same key.
+	CollapseKey string
+	// Data is passed to the app along with the notification.
+	Data map[string]string
+}
+
+type PushSender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the sender of each platform. iOS uses APNs when APNS_KEY_FILE,
+// a .p8 key, is set along with APNS_KEY_ID, APNS_TEAM_ID and APNS_TOPIC, the
+// bundle ID. Android uses FCM when FCM_CREDENTIALS_FILE, a service account
+// key, is set. APNS_ENDPOINT and FCM_ENDPOINT override the production
+// servers. Platforms without credentials have no sender, so their
+// deliveries are dropped.
+func New() (map[string]PushSender, error) {
+	client := &http.Client{
+		Timeout: utils.GetTimeouts().Upload,
+	}
+
+	senders := map[string]PushSender{}
+
+	if file := os.Getenv("APNS_KEY_FILE"); len(file) > 0 {
+		key, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		senders[PlatformIOS], err = NewAPNsSender(
+			key,
+			os.Getenv("APNS_KEY_ID"),
+			os.Getenv("APNS_TEAM_ID"),
+			os.Getenv("APNS_TOPIC"),
+			utils.GetEnv("APNS_ENDPOINT", "https://api.push.apple.com"),
+			client,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if file := os.Getenv("FCM_CREDENTIALS_FILE"); len(file) > 0 {
+		credentials, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		senders[PlatformAndroid], err = NewFCMSender(credentials, utils.GetEnv("FCM_ENDPOINT", "https://fcm.googleapis.com"), client)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return senders, nil
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/admin"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// CloudinaryStorage uploads files as Cloudinary images. The public ID is the
+// key without its extension.
+type CloudinaryStorage struct {
+	Cloudinary *cloudinary.Cloudinary
+}
+
+func (s *CloudinaryStorage) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	overwrite := true
+
+	res, err := s.Cloudinary.Upload.Upload(ctx, bytes.NewReader(data), uploader.UploadParams{
+		PublicID:  publicID(key),
+		Overwrite: &overwrite,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(res.Error.Message) > 0 {
+		return "", errors.New(res.Error.Message)
+	}
+
+	return res.SecureURL, nil
+}
+
+func (s *CloudinaryStorage) Delete(ctx context.Context, key string) error {
+	res, err := s.Cloudinary.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: publicID(key)})
+	if err != nil {
+		return err
+	}
+	if len(res.Error.Message) > 0 {
+		return errors.New(res.Error.Message)
+	}
+
+	return nil
+}
+
+func (s *CloudinaryStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	params := admin.DeleteAssetsByPrefixParams{
+		Prefix: api.CldAPIArray{prefix},
+	}
+
+	for {
+		res, err := s.Cloudinary.Admin.DeleteAssetsByPrefix(ctx, params)
+		if err != nil {
+			return err
+		}
+		if len(res.Error.Message) > 0 {
+			return errors.New(res.Error.Message)
+		}
+		if !res.Partial {
+			break
+		}
+
+		params.NextCursor = res.NextCursor
+	}
+
+	// Deleting assets leaves their folders behind. Only a prefix ending in a
+	// slash names a folder of its own.
+	if strings.HasSuffix(prefix, "/") {
+		s.deleteFolder(ctx, strings.TrimSuffix(prefix, "/"))
+	}
+
+	return nil
+}
+
+// deleteFolder removes folder and its sub-folders, which must be empty. The
+// folder is missing when nothing was uploaded into it, so failures are only
+// logged.
+func (s *CloudinaryStorage) deleteFolder(ctx context.Context, folder string) {
+	res, err := s.Cloudinary.Admin.SubFolders(ctx, admin.SubFoldersParams{Folder: folder})
+	if err == nil && len(res.Error.Message) == 0 {
+		for _, sub := range res.Folders {
+			s.deleteFolder(ctx, sub.Path)
+		}
+	}
+
+	deleted, err := s.Cloudinary.Admin.DeleteFolder(ctx, admin.DeleteFolderParams{Folder: folder})
+	if err == nil && len(deleted.Error.Message) > 0 {
+		err = errors.New(deleted.Error.Message)
+	}
+	if err != nil {
+		slog.DebugContext(ctx, "delete media folder", "folder", folder, "error", err)
+	}
+}
+
+func publicID(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key))
+}
+
+func NewCloudinaryStorage(cld *cloudinary.Cloudinary) *CloudinaryStorage {
+	return &CloudinaryStorage{
+		Cloudinary: cld,
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage writes files below Dir. They are expected to be served under
+// URL, see router.AppRouter.
+type LocalStorage struct {
+	Dir string
+	URL string
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	name := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		return "", err
+	}
+
+	return s.URL + "/" + key, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *LocalStorage) DeletePrefix(ctx context.Context, prefix string) error {
+	parent, base := path.Split(prefix)
+	dir := s.path(parent)
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), base) {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(base) == 0 {
+		return os.Remove(dir)
+	}
+
+	return nil
+}
+
+// path maps key below Dir. Keys are built by the server, the cleaning only
+// guards against a stray "..".
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func NewLocalStorage(dir, url string) *LocalStorage {
+	return &LocalStorage{
+		Dir: dir,
+		URL: url,
+	}
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"chat_backend/pkg/utils"
+	"context"
+	"github.com/cloudinary/cloudinary-go/v2"
+	"strings"
+)
+
+// Storage keeps uploaded files under slash-separated keys such as
+// chat_app/<user>/avatar/<version>/64.jpg.
+type Storage interface {
+	// Put stores data under key and returns its public URL.
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	// Delete removes the file under key. A missing file is not an error.
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every file whose key starts with prefix.
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// New picks the storage from STORAGE: "cloudinary" uploads to cld and "local"
+// writes to STORAGE_DIR, served under <API_URL>/uploads. Cloudinary is used
+// by default when it is configured.
+func New(cld *cloudinary.Cloudinary) Storage {
+	fallback := "local"
+	if cld != nil {
+		fallback = "cloudinary"
+	}
+
+	switch utils.GetEnv("STORAGE", fallback) {
+	case "cloudinary":
+		return NewCloudinaryStorage(cld)
+	default:
+		return NewLocalStorage(
+			utils.GetEnv("STORAGE_DIR", "tmp/uploads"),
+			strings.TrimSuffix(utils.GetEnv("API_URL", "http://localhost:6060"), "/")+"/uploads",
+		)
+	}
+}
@@ -4,14 +4,15 @@ import (
 	"chat_backend/generated"
 	"context"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"log"
+	"log/slog"
 	"os"
 )
 
 func Database() (*pgxpool.Pool, *generated.Queries) {
 	pool, err := pgxpool.New(context.Background(), os.Getenv("DATABASE_URL"))
 	if err != nil {
-		log.Fatalf("Unable to create connection pool: %v", err)
+		slog.Error("create connection pool", "error", err)
+		os.Exit(1)
 	}
 	queries := generated.New(pool)
 	return pool, queries
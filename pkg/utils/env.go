@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnv returns the environment variable or the fallback when it is unset.
+func GetEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && len(value) > 0 {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvInt returns the environment variable parsed as an int, or the
+// fallback when it is unset or malformed.
+func GetEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// GetEnvBool returns the environment variable parsed as a bool, or the
+// fallback when it is unset or malformed.
+func GetEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// GetEnvDuration returns the environment variable parsed as a duration
+// (e.g. "5s"), or the fallback when it is unset or malformed.
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
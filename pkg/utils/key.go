@@ -2,11 +2,85 @@ package utils
 
 import (
 	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"os"
+	"strings"
 )
 
+// GetPrivateKey returns the key derived from PRIVATE_KEY. It signed every
+// session token before the keyring and is imported into it as the legacy key.
 func GetPrivateKey() ed25519.PrivateKey {
 	seed, _ := hex.DecodeString(os.Getenv("PRIVATE_KEY"))
 	return ed25519.NewKeyFromSeed(seed)
 }
+
+// GetKeyringKey returns the key that encrypts the stored signing keys, from
+// KEYRING_SECRET or else derived from MASTER_SECRET. Neither depends on
+// PRIVATE_KEY, so rotating it leaves the stored keys readable.
+func GetKeyringKey() ([]byte, error) {
+	if secret := os.Getenv("KEYRING_SECRET"); len(secret) > 0 {
+		key := sha256.Sum256([]byte(secret))
+		return key[:], nil
+	}
+
+	return deriveKey("chat_app keyring")
+}
+
+// GetTokenSecret returns the key that HMACs the stored email, password reset
+// and API tokens, from TOKEN_SECRET or else derived from MASTER_SECRET.
+func GetTokenSecret() ([]byte, error) {
+	if secret := os.Getenv("TOKEN_SECRET"); len(secret) > 0 {
+		return []byte(secret), nil
+	}
+
+	return deriveKey("chat_app tokens")
+}
+
+// GetPreAuthKey derives the symmetric key for the short-lived tokens issued
+// between the password and second-factor login steps. They are local tokens,
+// so they never pass the public session token check.
+func GetPreAuthKey() ([]byte, error) {
+	return deriveKey("chat_app pre-auth")
+}
+
+// GetOIDCStateKey derives the symmetric key that encrypts the state, nonce
+// and PKCE verifier kept in a cookie during an OpenID Connect login.
+func GetOIDCStateKey() ([]byte, error) {
+	return deriveKey("chat_app oidc-state")
+}
+
+// GetVAPIDKey returns the P-256 private key that signs Web Push requests,
+// base64url encoded in VAPID_PRIVATE_KEY or else derived from MASTER_SECRET.
+// Browsers subscribe to its public key, so changing it ends their
+// subscriptions.
+func GetVAPIDKey() ([]byte, error) {
+	if key := os.Getenv("VAPID_PRIVATE_KEY"); len(key) > 0 {
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key, "="))
+		if err != nil {
+			return nil, errors.New("VAPID_PRIVATE_KEY is not base64url")
+		}
+		return decoded, nil
+	}
+
+	return deriveKey("chat_app vapid")
+}
+
+// deriveKey derives the key for label from MASTER_SECRET, at least 32 bytes
+// hex encoded. Keys derived from a missing or short secret could be computed
+// by anyone, so it fails instead. MASTER_SECRET is not meant to be rotated:
+// tokens in flight, stored token hashes and, without their own secrets, the
+// stored signing keys and Web Push subscriptions depend on it.
+func deriveKey(label string) ([]byte, error) {
+	secret, err := hex.DecodeString(os.Getenv("MASTER_SECRET"))
+	if err != nil || len(secret) < 32 {
+		return nil, errors.New("MASTER_SECRET must be set to at least 32 hex-encoded bytes")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(label))
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,27 @@
+package utils
+
+import "time"
+
+// Timeouts bounds how long a request and the operations it triggers may run.
+type Timeouts struct {
+	// Request is the deadline for the whole handler chain.
+	Request time.Duration
+	// Query is the deadline for a single database call.
+	Query time.Duration
+	// Upload is the deadline for a single storage call.
+	Upload time.Duration
+	// Mail is the deadline for sending a mail, which happens after the
+	// request has been answered.
+	Mail time.Duration
+}
+
+// GetTimeouts reads the timeouts from REQUEST_TIMEOUT, QUERY_TIMEOUT,
+// UPLOAD_TIMEOUT and MAIL_TIMEOUT.
+func GetTimeouts() Timeouts {
+	return Timeouts{
+		Request: GetEnvDuration("REQUEST_TIMEOUT", 30*time.Second),
+		Query:   GetEnvDuration("QUERY_TIMEOUT", 5*time.Second),
+		Upload:  GetEnvDuration("UPLOAD_TIMEOUT", 20*time.Second),
+		Mail:    GetEnvDuration("MAIL_TIMEOUT", 30*time.Second),
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"io"
 	"mime"
 	"mime/multipart"
@@ -17,14 +18,14 @@ func IsImageFile(file *multipart.FileHeader) bool {
 	}(src)
 
 	buf := make([]byte, 512)
-	_, err = io.ReadFull(src, buf)
-	if err != nil {
+	n, err := io.ReadFull(src, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
 		return false
 	}
 
 	_, _ = src.Seek(0, io.SeekStart)
 
-	mimeType := http.DetectContentType(buf)
+	mimeType := http.DetectContentType(buf[:n])
 
 	return isImageMIMEType(mimeType)
 }
@@ -35,5 +36,10 @@ func isImageMIMEType(mimeType string) bool {
 		return false
 	}
 
-	return mediaType == "image/jpeg" || mediaType == "image/png"
+	switch mediaType {
+	case "image/jpeg", "image/png", "image/webp", "image/gif":
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,206 @@
+// Package webpush sends Web Push messages with payloads encrypted as in
+// RFC 8291 and authenticated with VAPID, RFC 8292.
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// vapidExpiry is how long a VAPID token is valid, at most 24 hours.
+const vapidExpiry = 12 * time.Hour
+
+var (
+	// ErrGone is returned when the push service no longer knows the
+	// subscription. It should be deleted.
+	ErrGone            = errors.New("push subscription is gone")
+	ErrInvalidKeys     = errors.New("push subscription keys are invalid")
+	ErrPayloadTooLarge = errors.New("push payload is too large")
+)
+
+// Keys are the keys of a subscription, as the browser returns them in
+// PushSubscription.toJSON(), base64url encoded.
+type Keys struct {
+	// P256dh is the uncompressed P-256 public key of the user agent.
+	P256dh string `json:"p256dh"`
+	// Auth is the 16 bytes authentication secret.
+	Auth string `json:"auth"`
+}
+
+// Validate checks that the keys can be used to encrypt a payload.
+func (k Keys) Validate() error {
+	_, _, err := k.decode()
+	return err
+}
+
+func (k Keys) decode() (*ecdh.PublicKey, []byte, error) {
+	p256dh, err := decodeBase64(k.P256dh)
+	if err != nil {
+		return nil, nil, ErrInvalidKeys
+	}
+	public, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, nil, ErrInvalidKeys
+	}
+
+	auth, err := decodeBase64(k.Auth)
+	if err != nil || len(auth) != 16 {
+		return nil, nil, ErrInvalidKeys
+	}
+
+	return public, auth, nil
+}
+
+// Browsers pad the keys with = in some versions.
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// Options of a single message.
+type Options struct {
+	// TTL is how long the push service keeps the message for an offline
+	// user agent. Zero drops it unless it can be delivered at once.
+	TTL time.Duration
+	// Urgency is very-low, low, normal or high.
+	Urgency string
+	// Topic replaces a pending message with the same topic.
+	Topic string
+}
+
+type Client struct {
+	key     *ecdsa.PrivateKey
+	public  []byte
+	subject string
+	client  *http.Client
+}
+
+// New creates a client signing with the VAPID private key, a 32 bytes
+// P-256 scalar. subject is a mailto: or https: URL the push service can use
+// to contact the operator.
+func New(privateKey []byte, subject string, client *http.Client) (*Client, error) {
+	key, err := ecdh.P256().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("vapid key: %w", err)
+	}
+	public := key.PublicKey().Bytes()
+
+	// Responses are not followed anywhere, the endpoint was checked.
+	redirects := *client
+	redirects.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &Client{
+		key: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(public[1:33]),
+				Y:     new(big.Int).SetBytes(public[33:]),
+			},
+			D: new(big.Int).SetBytes(privateKey),
+		},
+		public:  public,
+		subject: subject,
+		client:  &redirects,
+	}, nil
+}
+
+// PublicKey is the applicationServerKey browsers subscribe with.
+func (c *Client) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(c.public)
+}
+
+// Send delivers payload to the subscription endpoint. It returns ErrGone
+// when the subscription has expired or was unsubscribed.
+func (c *Client) Send(ctx context.Context, endpoint string, keys Keys, payload []byte, options Options) error {
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	body, err := encrypt(keys, payload)
+	if err != nil {
+		return err
+	}
+
+	token, err := c.vapidToken(target.Scheme + "://" + target.Host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(options.TTL.Seconds())))
+	req.Header.Set("Authorization", "vapid t="+token+", k="+c.PublicKey())
+	if len(options.Urgency) > 0 {
+		req.Header.Set("Urgency", options.Urgency)
+	}
+	if len(options.Topic) > 0 {
+		req.Header.Set("Topic", options.Topic)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound, res.StatusCode == http.StatusGone:
+		return ErrGone
+	case res.StatusCode == http.StatusRequestEntityTooLarge:
+		return ErrPayloadTooLarge
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return fmt.Errorf("push service responded %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// vapidToken signs the ES256 JWT that identifies the server to the push
+// service at audience.
+func (c *Client) vapidToken(audience string) (string, error) {
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": audience,
+		"exp": time.Now().Add(vapidExpiry).Unix(),
+		"sub": c.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// JWS wants r and s as fixed-size big-endian integers.
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -1,11 +1,11 @@
 -- name: GetUserByUsername :one
 select *
 from users
-where username = $1;
+where lower(username) = lower(sqlc.arg('username'));
 
 -- name: CreateNewUser :exec
-insert into users (username, password, avatar)
-values ($1, $2, $3);
+insert into users (username, password, email, password_set)
+values ($1, $2, $3, $4);
 
 -- name: DeleteUserByUsername :exec
 delete
@@ -13,19 +13,752 @@ from users
 where username = $1;
 
 -- name: GetUserByID :one
-select username, avatar, created_at, updated_at
+select username,
+       avatar,
+       avatar_32,
+       avatar_64,
+       avatar_256,
+       created_at,
+       updated_at,
+       email,
+       email_verified_at,
+       display_name,
+       bio,
+       case when status_expires_at is null or status_expires_at > now() then status_text end       as status_text,
+       case when status_expires_at is null or status_expires_at > now() then status_emoji end      as status_emoji,
+       case when status_expires_at is null or status_expires_at > now() then status_expires_at end as status_expires_at,
+       pronouns,
+       timezone,
+       locale
 from users
 where id = $1;
 
 -- name: UpdateUser :exec
 update users as u
-set username   = coalesce(nullif($1, ''), u.username),
-    password   = coalesce(nullif($2, ''), u.password),
-    avatar     = coalesce(nullif($3, ''), u.avatar),
+set username     = coalesce(nullif($1, ''), u.username),
+    password     = coalesce(nullif($2, ''), u.password),
+    password_set = u.password_set or $2 <> '',
+    updated_at   = timezone('utc', now())
+where id = $3;
+
+-- name: UpdateUserAvatar :exec
+update users
+set avatar     = $2,
+    avatar_32  = $3,
+    avatar_64  = $4,
+    avatar_256 = $5,
+    updated_at = timezone('utc', now())
+where id = $1;
+
+-- name: UpdateUserProfile :exec
+-- A null argument keeps the column, an empty string clears it. The status is
+-- replaced as a whole when set_status is true.
+update users as u
+set display_name      = case when sqlc.narg('display_name')::text is null then u.display_name else nullif(sqlc.narg('display_name'), '') end,
+    bio               = case when sqlc.narg('bio')::text is null then u.bio else nullif(sqlc.narg('bio'), '') end,
+    pronouns          = case when sqlc.narg('pronouns')::text is null then u.pronouns else nullif(sqlc.narg('pronouns'), '') end,
+    timezone          = case when sqlc.narg('timezone')::text is null then u.timezone else nullif(sqlc.narg('timezone'), '') end,
+    locale            = case when sqlc.narg('locale')::text is null then u.locale else nullif(sqlc.narg('locale'), '') end,
+    status_text       = case when sqlc.arg('set_status')::boolean then nullif(sqlc.narg('status_text')::text, '') else u.status_text end,
+    status_emoji      = case when sqlc.arg('set_status')::boolean then nullif(sqlc.narg('status_emoji')::text, '') else u.status_emoji end,
+    status_expires_at = case when sqlc.arg('set_status')::boolean then sqlc.narg('status_expires_at')::timestamptz else u.status_expires_at end,
+    updated_at        = timezone('utc', now())
+where id = sqlc.arg('id');
+
+-- name: ScheduleUserDeletion :execrows
+update users
+set deleted_at = timezone('utc', now()),
+    purge_at   = $2,
+    updated_at = timezone('utc', now())
+where id = $1
+  and deleted_at is null;
+
+-- name: RestoreUser :execrows
+update users
+set deleted_at = null,
+    purge_at   = null,
     updated_at = timezone('utc', now())
-where id = $4;
+where id = $1
+  and purge_at is not null;
+
+-- name: IsUserActive :one
+select exists(select 1
+              from users
+              where id = $1
+                and deleted_at is null);
+
+-- name: ListUsersToPurge :many
+select id
+from users
+where purge_at <= timezone('utc', now())
+order by purge_at
+limit $1;
+
+-- name: PurgeUser :execrows
+update users
+set username          = 'deleted_' || left(replace(id::text, '-', ''), 22),
+    password          = '',
+    password_set      = false,
+    avatar            = null,
+    avatar_32         = null,
+    avatar_64         = null,
+    avatar_256        = null,
+    email             = null,
+    email_verified_at = null,
+    totp_secret       = null,
+    totp_enabled_at   = null,
+    display_name      = null,
+    bio               = null,
+    status_text       = null,
+    status_emoji      = null,
+    status_expires_at = null,
+    pronouns          = null,
+    timezone          = null,
+    locale            = null,
+    purge_at          = null,
+    updated_at        = timezone('utc', now())
+where id = $1
+  and purge_at <= timezone('utc', now());
+
+-- name: GetPublicUserByUsername :one
+select id,
+       username,
+       avatar,
+       avatar_32,
+       avatar_64,
+       avatar_256,
+       created_at,
+       display_name,
+       bio,
+       case when status_expires_at is null or status_expires_at > now() then status_text end       as status_text,
+       case when status_expires_at is null or status_expires_at > now() then status_emoji end      as status_emoji,
+       case when status_expires_at is null or status_expires_at > now() then status_expires_at end as status_expires_at,
+       pronouns,
+       timezone
+from users
+where lower(username) = lower($1)
+  and deleted_at is null;
+
+-- name: CreateUsernameHistory :exec
+insert into username_history (user_id, username)
+values ($1, $2);
+
+-- name: CountUsernameChanges :one
+select count(*)
+from username_history
+where user_id = $1
+  and released_at > $2;
+
+-- name: ListUsernameHistory :many
+select username, released_at
+from username_history
+where user_id = $1
+order by released_at desc;
 
--- name: DeleteUser :exec
+-- name: GetUsernameHolder :one
+select user_id
+from username_history
+where lower(username) = lower(sqlc.arg('username'))
+  and released_at > sqlc.arg('released_after')
+order by released_at desc
+limit 1;
+
+-- name: ResolveUsername :one
+select u.username
+from username_history h
+         join users u on u.id = h.user_id
+where lower(h.username) = lower($1)
+  and u.deleted_at is null
+order by h.released_at desc
+limit 1;
+
+-- name: DeleteUserPersonalData :exec
+with tokens as (delete from user_tokens where user_id = $1),
+     codes as (delete from recovery_codes where user_id = $1),
+     keys as (delete from passkeys where user_id = $1),
+     identities as (delete from linked_identities where user_id = $1),
+     api_tokens as (delete from api_tokens where user_id = $1),
+     exports as (delete from data_exports where user_id = $1),
+     usernames as (delete from username_history where user_id = $1),
+     notifications as (delete from notification_settings where user_id = $1),
+     push as (delete from push_subscriptions where user_id = $1),
+     devices as (delete from push_devices where user_id = $1),
+     factors as (delete from two_factor_state where user_id = $1)
 delete
+from user_roles
+where user_id = $1;
+
+-- name: ScrubUserAuditEvents :exec
+-- Clears the address and user agent of the events the account caused,
+-- counting the anonymous ones against it such as failed sign ins.
+update audit_events
+set ip         = '',
+    user_agent = ''
+where (actor_id = sqlc.arg('user_id')::uuid or (actor_id is null and target_id = sqlc.arg('user_id')::uuid))
+  and (ip <> '' or user_agent <> '');
+
+-- name: GetUserByEmail :one
+select *
 from users
-where id = $1;
\ No newline at end of file
+where email = $1;
+
+-- name: UpdateUserEmail :exec
+update users
+set email             = $2,
+    email_verified_at = null,
+    updated_at        = timezone('utc', now())
+where id = $1;
+
+-- name: VerifyUserEmail :exec
+update users
+set email_verified_at = timezone('utc', now())
+where id = $1;
+
+-- name: UpdateUserPassword :exec
+update users
+set password     = $2,
+    password_set = true,
+    updated_at   = timezone('utc', now())
+where id = $1;
+
+-- name: RehashUserPassword :execrows
+update users
+set password = $3
+where id = $1
+  and password = $2;
+
+-- name: CreateUserToken :exec
+insert into user_tokens (user_id, purpose, token_hash, expires_at)
+values ($1, $2, $3, $4);
+
+-- name: ConsumeUserToken :one
+update user_tokens
+set used_at = timezone('utc', now())
+where token_hash = $1
+  and purpose = $2
+  and used_at is null
+  and expires_at > now()
+returning user_id;
+
+-- name: DeleteUserTokens :exec
+delete
+from user_tokens
+where user_id = $1
+  and purpose = $2;
+
+-- name: GetUserAccountByID :one
+select *
+from users
+where id = $1;
+
+-- name: UpdateUserTOTPSecret :exec
+update users
+set totp_secret     = $2,
+    totp_enabled_at = null,
+    updated_at      = timezone('utc', now())
+where id = $1;
+
+-- name: EnableUserTOTP :exec
+update users
+set totp_enabled_at = timezone('utc', now()),
+    updated_at      = timezone('utc', now())
+where id = $1
+  and totp_secret is not null;
+
+-- name: CreateRecoveryCode :exec
+insert into recovery_codes (user_id, code_hash)
+values ($1, $2);
+
+-- name: GetRecoveryCodes :many
+select id, code_hash
+from recovery_codes
+where user_id = $1
+  and used_at is null;
+
+-- name: UseRecoveryCode :execrows
+update recovery_codes
+set used_at = timezone('utc', now())
+where id = $1
+  and used_at is null;
+
+-- name: DeleteRecoveryCodes :exec
+delete
+from recovery_codes
+where user_id = $1;
+
+-- name: GetTwoFactorState :one
+select last_step, failed_attempts, last_failed_at
+from two_factor_state
+where user_id = $1;
+
+-- name: AcceptTOTPStep :execrows
+-- Records step as the last accepted one unless it is not newer.
+insert into two_factor_state (user_id, last_step)
+values ($1, $2)
+on conflict (user_id) do update
+    set last_step = excluded.last_step
+where two_factor_state.last_step < excluded.last_step;
+
+-- name: RecordTwoFactorFailure :exec
+-- Counts a failed attempt, starting over when the previous one was before
+-- window_start.
+insert into two_factor_state (user_id, failed_attempts, last_failed_at)
+values (sqlc.arg('user_id'), 1, sqlc.arg('now'))
+on conflict (user_id) do update
+    set failed_attempts = case
+                              when two_factor_state.last_failed_at > sqlc.arg('window_start')::timestamptz
+                                  then two_factor_state.failed_attempts + 1
+                              else 1 end,
+        last_failed_at  = excluded.last_failed_at;
+
+-- name: ResetTwoFactorFailures :exec
+update two_factor_state
+set failed_attempts = 0,
+    last_failed_at  = null
+where user_id = $1;
+
+-- name: UsePreAuthToken :execrows
+-- Marks the token used, affecting no row when it was already. Expired tokens
+-- are forgotten on the way.
+with expired as (
+    delete from used_pre_auth_tokens
+    where expires_at < timezone('utc', now()))
+insert
+into used_pre_auth_tokens (token_hash, expires_at)
+values ($1, $2)
+on conflict do nothing;
+
+-- name: CreatePasskey :exec
+insert into passkeys (user_id, name, credential_id, public_key, attestation_type, transports, aaguid, sign_count,
+                      backup_eligible, backup_state)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+
+-- name: GetPasskeysByUserID :many
+select *
+from passkeys
+where user_id = $1
+order by created_at;
+
+-- name: ListPasskeys :many
+select id, name, last_used_at, created_at
+from passkeys
+where user_id = $1
+order by created_at;
+
+-- name: UpdatePasskeySignCount :exec
+update passkeys
+set sign_count   = $2,
+    backup_state = $3,
+    last_used_at = timezone('utc', now())
+where credential_id = $1;
+
+-- name: DeletePasskey :execrows
+delete
+from passkeys
+where id = $1
+  and user_id = $2;
+
+-- name: CreateWebauthnSession :one
+insert into webauthn_sessions (session_data, expires_at)
+values ($1, $2)
+returning id;
+
+-- name: ConsumeWebauthnSession :one
+delete
+from webauthn_sessions
+where id = $1
+  and expires_at > now()
+returning session_data;
+
+-- name: DeleteExpiredWebauthnSessions :exec
+delete
+from webauthn_sessions
+where expires_at <= now();
+
+-- name: GetLinkedIdentity :one
+select *
+from linked_identities
+where provider = $1
+  and subject = $2;
+
+-- name: CreateLinkedIdentity :exec
+insert into linked_identities (user_id, provider, subject, email)
+values ($1, $2, $3, $4);
+
+-- name: ListLinkedIdentities :many
+select provider, email, created_at
+from linked_identities
+where user_id = $1
+order by created_at;
+
+-- name: CountLinkedIdentities :one
+select count(*)
+from linked_identities
+where user_id = $1;
+
+-- name: DeleteLinkedIdentity :execrows
+delete
+from linked_identities
+where user_id = $1
+  and provider = $2;
+
+-- name: ListSigningKeys :many
+select *
+from signing_keys
+order by created_at;
+
+-- name: CreateSigningKey :exec
+insert into signing_keys (kid, private_key, status, activated_at)
+values ($1, $2, $3, case when $3 = 'active' then timezone('utc', now()) end)
+on conflict (kid) do nothing;
+
+-- name: ActivateSigningKey :execrows
+update signing_keys
+set status       = 'active',
+    activated_at = timezone('utc', now())
+where kid = $1
+  and status = 'pending';
+
+-- name: RetireSigningKey :execrows
+update signing_keys
+set status     = 'retired',
+    retired_at = timezone('utc', now())
+where kid = $1
+  and status <> 'retired';
+
+-- name: CreateApiToken :one
+insert into api_tokens (user_id, name, token_hash, prefix, scopes, expires_at)
+values ($1, $2, $3, $4, $5, $6)
+returning id, created_at;
+
+-- name: GetApiTokenByHash :one
+select id, user_id, scopes
+from api_tokens
+where token_hash = $1
+  and (expires_at is null or expires_at > timezone('utc', now()))
+  and user_id in (select id from users where deleted_at is null);
+
+-- name: TouchApiToken :exec
+update api_tokens
+set last_used_at = timezone('utc', now())
+where id = $1
+  and (last_used_at is null or last_used_at < timezone('utc', now()) - interval '1 minute');
+
+-- name: ListApiTokens :many
+select id, name, prefix, scopes, expires_at, last_used_at, created_at
+from api_tokens
+where user_id = $1
+order by created_at;
+
+-- name: CountApiTokens :one
+select count(*)
+from api_tokens
+where user_id = $1;
+
+-- name: DeleteApiToken :execrows
+delete
+from api_tokens
+where id = $1
+  and user_id = $2;
+
+-- name: ListRoles :many
+select r.name,
+       r.description,
+       coalesce(array_agg(rp.permission order by rp.permission) filter (where rp.permission is not null),
+                '{}')::text[] as permissions
+from roles r
+         left join role_permissions rp on rp.role = r.name
+group by r.name
+order by r.name;
+
+-- name: GetUserRoles :many
+select role
+from user_roles
+where user_id = $1
+order by role;
+
+-- name: GetUserPermissions :many
+select distinct permission
+from role_permissions
+where role = 'user'
+   or role in (select role from user_roles where user_id = $1)
+order by permission;
+
+-- name: AssignUserRole :execrows
+insert into user_roles (user_id, role)
+values ($1, $2)
+on conflict do nothing;
+
+-- name: RemoveUserRole :execrows
+delete
+from user_roles
+where user_id = $1
+  and role = $2;
+
+-- name: CountRoleMembers :one
+select count(*)
+from user_roles r
+         join users u on u.id = r.user_id
+where r.role = $1
+  and u.deleted_at is null;
+
+-- name: CreateAuditEvent :exec
+insert into audit_events (action, actor_id, target_id, ip, user_agent, request_id, metadata)
+values ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: ListAuditEvents :many
+select *
+from audit_events
+where (sqlc.narg('action')::varchar is null or action = sqlc.narg('action'))
+  and (sqlc.narg('actor_id')::uuid is null or actor_id = sqlc.narg('actor_id'))
+  and (sqlc.narg('target_id')::uuid is null or target_id = sqlc.narg('target_id'))
+  and (sqlc.narg('since')::timestamptz is null or created_at >= sqlc.narg('since'))
+  and (sqlc.narg('until')::timestamptz is null or created_at < sqlc.narg('until'))
+  and (sqlc.narg('before')::bigint is null or id < sqlc.narg('before'))
+order by id desc
+limit sqlc.arg('limit');
+
+-- name: ListUserAuditEvents :many
+-- Another user's address and user agent are left out of the events they
+-- caused on the account, such as an admin changing its roles.
+select id,
+       action,
+       actor_id,
+       target_id,
+       case when actor_id is null or actor_id = sqlc.arg('user_id') then ip else '' end::varchar as ip,
+       case when actor_id is null or actor_id = sqlc.arg('user_id') then user_agent else '' end::varchar as user_agent,
+       request_id,
+       metadata,
+       created_at
+from audit_events
+where (actor_id = sqlc.arg('user_id') or target_id = sqlc.arg('user_id'))
+  and (sqlc.narg('before')::bigint is null or id < sqlc.narg('before'))
+order by id desc
+limit sqlc.arg('limit');
+
+-- name: CreateDataExport :one
+insert into data_exports (user_id)
+values ($1)
+returning id, status, created_at;
+
+-- name: CountActiveDataExports :one
+select count(*)
+from data_exports
+where user_id = $1
+  and status in ('pending', 'running');
+
+-- name: ClaimDataExport :one
+update data_exports
+set status     = 'running',
+    started_at = timezone('utc', now())
+where id = (select id
+            from data_exports
+            where status = 'pending'
+               or (status = 'running' and started_at < $1)
+            order by created_at
+            limit 1 for update skip locked)
+returning id, user_id;
+
+-- name: CompleteDataExport :exec
+update data_exports
+set status       = 'ready',
+    archive      = $2,
+    completed_at = timezone('utc', now()),
+    expires_at   = $3
+where id = $1;
+
+-- name: FailDataExport :exec
+update data_exports
+set status       = 'failed',
+    completed_at = timezone('utc', now()),
+    expires_at   = $2
+where id = $1;
+
+-- name: ListDataExports :many
+select id, status, created_at, completed_at, expires_at
+from data_exports
+where user_id = $1
+  and (expires_at is null or expires_at > timezone('utc', now()))
+order by created_at desc;
+
+-- name: GetDataExportArchive :one
+select archive
+from data_exports
+where id = $1
+  and user_id = $2
+  and status = 'ready'
+  and expires_at > timezone('utc', now());
+
+-- name: DeleteExpiredDataExports :execrows
+delete
+from data_exports
+where expires_at <= timezone('utc', now());
+
+-- name: GetNotificationSettings :one
+select coalesce(s.level, 'all')::text as level,
+       s.muted_until,
+       s.dnd_start,
+       s.dnd_end,
+       u.timezone
+from users u
+         left join notification_settings s on s.user_id = u.id
+where u.id = $1;
+
+-- name: UpsertNotificationSettings :exec
+-- A null level keeps the current one. The mute and the do-not-disturb
+-- window are replaced when their set_ argument is true.
+insert into notification_settings as s (user_id, level, muted_until, dnd_start, dnd_end)
+values (sqlc.arg('user_id'), coalesce(sqlc.narg('level')::text, 'all'), sqlc.narg('muted_until')::timestamptz,
+        sqlc.narg('dnd_start')::smallint, sqlc.narg('dnd_end')::smallint)
+on conflict (user_id) do update
+    set level       = coalesce(sqlc.narg('level')::text, s.level),
+        muted_until = case when sqlc.arg('set_muted_until')::boolean then sqlc.narg('muted_until')::timestamptz else s.muted_until end,
+        dnd_start   = case when sqlc.arg('set_dnd')::boolean then sqlc.narg('dnd_start')::smallint else s.dnd_start end,
+        dnd_end     = case when sqlc.arg('set_dnd')::boolean then sqlc.narg('dnd_end')::smallint else s.dnd_end end,
+        updated_at  = timezone('utc', now());
+
+-- name: UpsertPushSubscription :one
+insert into push_subscriptions (user_id, endpoint, p256dh, auth, user_agent)
+values ($1, $2, $3, $4, $5)
+on conflict (endpoint) do update
+    set user_id      = excluded.user_id,
+        p256dh       = excluded.p256dh,
+        auth         = excluded.auth,
+        user_agent   = excluded.user_agent,
+        last_used_at = null,
+        created_at   = timezone('utc', now())
+returning id, endpoint, user_agent, last_used_at, created_at;
+
+-- name: TrimPushSubscriptions :exec
+-- Deletes all but the newest subscriptions of the user.
+delete
+from push_subscriptions
+where user_id = sqlc.arg('user_id')
+  and id not in (select id
+                 from push_subscriptions
+                 where user_id = sqlc.arg('user_id')
+                 order by created_at desc
+                 limit sqlc.arg('keep'));
+
+-- name: ListPushSubscriptions :many
+select id, endpoint, user_agent, last_used_at, created_at
+from push_subscriptions
+where user_id = $1
+order by created_at;
+
+-- name: ListPushTargets :many
+select id, endpoint, p256dh, auth
+from push_subscriptions
+where user_id = $1;
+
+-- name: TouchPushSubscription :exec
+update push_subscriptions
+set last_used_at = timezone('utc', now())
+where id = $1;
+
+-- name: DeletePushSubscription :execrows
+delete
+from push_subscriptions
+where id = $1
+  and user_id = $2;
+
+-- name: DeletePushSubscriptionByEndpoint :exec
+delete
+from push_subscriptions
+where endpoint = $1;
+
+-- name: DeleteForeignPushDevice :exec
+-- Forgets a device token registered by another user, and what was queued
+-- for it, before the user registers it.
+delete
+from push_devices
+where platform = $1
+  and token = $2
+  and user_id <> $3;
+
+-- name: UpsertPushDevice :one
+insert into push_devices (user_id, platform, token, session_hash)
+values ($1, $2, $3, $4)
+on conflict (platform, token) do update
+    set session_hash = excluded.session_hash,
+        created_at   = timezone('utc', now())
+returning id, platform, last_used_at, created_at;
+
+-- name: TrimPushDevices :exec
+-- Deletes all but the newest devices of the user.
+delete
+from push_devices
+where user_id = sqlc.arg('user_id')
+  and id not in (select id
+                 from push_devices
+                 where user_id = sqlc.arg('user_id')
+                 order by created_at desc
+                 limit sqlc.arg('keep'));
+
+-- name: ListPushDevices :many
+select id, platform, last_used_at, created_at
+from push_devices
+where user_id = $1
+order by created_at;
+
+-- name: DeletePushDevice :execrows
+delete
+from push_devices
+where id = $1
+  and user_id = $2;
+
+-- name: DeletePushDeviceByID :exec
+delete
+from push_devices
+where id = $1;
+
+-- name: DeletePushDevicesBySession :exec
+delete
+from push_devices
+where session_hash = $1;
+
+-- name: TouchPushDevice :exec
+update push_devices
+set last_used_at = timezone('utc', now())
+where id = $1;
+
+-- name: EnqueuePushDeliveries :execrows
+-- Queues payload for every device of the user, merging it into a waiting
+-- delivery with the same collapse key.
+insert into push_deliveries (device_id, collapse_key, payload, next_attempt_at)
+select id, sqlc.arg('collapse_key')::varchar, sqlc.arg('payload')::jsonb, sqlc.arg('now')::timestamptz
+from push_devices
+where user_id = sqlc.arg('user_id')
+on conflict (device_id, collapse_key) do update
+    set payload         = excluded.payload,
+        count           = push_deliveries.count + 1,
+        revision        = push_deliveries.revision + 1,
+        attempts        = 0,
+        next_attempt_at = excluded.next_attempt_at;
+
+-- name: ClaimPushDeliveries :many
+-- Leases the due deliveries until lease_until, when they are due again
+-- unless they were completed or rescheduled.
+update push_deliveries d
+set attempts        = d.attempts + 1,
+    next_attempt_at = sqlc.arg('lease_until')
+from push_devices p
+where p.id = d.device_id
+  and d.id in (select id
+               from push_deliveries
+               where next_attempt_at <= sqlc.arg('now')
+               order by next_attempt_at
+               limit sqlc.arg('batch') for update skip locked)
+returning d.id, d.device_id, d.collapse_key, d.payload, d.count, d.revision, d.attempts, p.platform, p.token;
+
+-- name: CompletePushDelivery :exec
+delete
+from push_deliveries
+where id = $1
+  and revision = $2;
+
+-- name: RetryPushDelivery :exec
+update push_deliveries
+set next_attempt_at = $3
+where id = $1
+  and revision = $2;
@@ -2,8 +2,339 @@ create table users
 (
     id         uuid primary key         default gen_random_uuid()      not null,
     username   varchar(30) unique                                      not null,
-    password   varchar(100)                                            not null,
+    password   varchar(255)                                            not null,
     avatar     varchar(254),
     created_at timestamp with time zone default timezone('utc', now()) not null,
-    updated_at timestamp with time zone default timezone('utc', now()) not null
-);
\ No newline at end of file
+    updated_at timestamp with time zone default timezone('utc', now()) not null,
+    email             varchar(254) unique,
+    email_verified_at timestamp with time zone,
+    totp_secret       varchar(64),
+    totp_enabled_at   timestamp with time zone,
+    -- deleted_at is set when deletion is requested. purge_at ends the restore
+    -- window and is cleared once the account has been anonymized.
+    deleted_at        timestamp with time zone,
+    purge_at          timestamp with time zone,
+    display_name      varchar(64),
+    bio               varchar(500),
+    -- The status is hidden once status_expires_at has passed.
+    status_text       varchar(100),
+    status_emoji      varchar(32),
+    status_expires_at timestamp with time zone,
+    pronouns          varchar(40),
+    timezone          varchar(64),
+    locale            varchar(35),
+    -- Thumbnails of avatar, which is 1024 pixels wide when uploaded.
+    avatar_32         varchar(254),
+    avatar_64         varchar(254),
+    avatar_256        varchar(254),
+    -- password_set is false while the password is the random one given to
+    -- accounts created by signing in with an identity provider.
+    password_set      boolean                  default true                  not null
+);
+
+create index users_purge_at_idx on users (purge_at) where purge_at is not null;
+create unique index users_username_lower_idx on users (lower(username));
+
+-- username_history keeps the names users gave up. A released name stays
+-- reserved for its previous owner for a cooldown and keeps resolving to the
+-- account until someone else claims it.
+create table username_history
+(
+    id          uuid primary key         default gen_random_uuid()      not null,
+    user_id     uuid references users (id) on delete cascade            not null,
+    username    varchar(30)                                             not null,
+    released_at timestamp with time zone default timezone('utc', now()) not null
+);
+
+create index username_history_username_idx on username_history (lower(username), released_at);
+create index username_history_user_id_idx on username_history (user_id, released_at);
+
+create table user_tokens
+(
+    id         uuid primary key         default gen_random_uuid()      not null,
+    user_id    uuid references users (id) on delete cascade            not null,
+    purpose    varchar(30)                                             not null,
+    token_hash varchar(64) unique                                      not null,
+    expires_at timestamp with time zone                                not null,
+    used_at    timestamp with time zone,
+    created_at timestamp with time zone default timezone('utc', now()) not null
+);
+
+create table recovery_codes
+(
+    id         uuid primary key         default gen_random_uuid()      not null,
+    user_id    uuid references users (id) on delete cascade            not null,
+    code_hash  varchar(255)                                            not null,
+    used_at    timestamp with time zone,
+    created_at timestamp with time zone default timezone('utc', now()) not null
+);
+
+-- two_factor_state holds the last TOTP time step accepted for the account,
+-- so that a code cannot be replayed within its window, and the recent failed
+-- second-factor attempts that lock the account out.
+create table two_factor_state
+(
+    user_id         uuid primary key references users (id) on delete cascade not null,
+    last_step       bigint                   default 0                     not null,
+    failed_attempts integer                  default 0                     not null,
+    last_failed_at  timestamp with time zone
+);
+
+-- used_pre_auth_tokens remembers the pre-auth tokens that completed a login
+-- until they expire, so that each completes only one.
+create table used_pre_auth_tokens
+(
+    token_hash varchar(64) primary key  not null,
+    expires_at timestamp with time zone not null
+);
+
+create table passkeys
+(
+    id               uuid primary key         default gen_random_uuid()      not null,
+    user_id          uuid references users (id) on delete cascade            not null,
+    name             varchar(64)                                             not null,
+    credential_id    bytea unique                                            not null,
+    public_key       bytea                                                   not null,
+    attestation_type varchar(32)                                             not null,
+    transports       text[]                   default '{}'                   not null,
+    aaguid           bytea                                                   not null,
+    sign_count       bigint                                                  not null,
+    backup_eligible  boolean                                                 not null,
+    backup_state     boolean                                                 not null,
+    last_used_at     timestamp with time zone,
+    created_at       timestamp with time zone default timezone('utc', now()) not null
+);
+
+create table webauthn_sessions
+(
+    id           uuid primary key default gen_random_uuid() not null,
+    session_data jsonb                                      not null,
+    expires_at   timestamp with time zone                   not null
+);
+
+create table linked_identities
+(
+    id         uuid primary key         default gen_random_uuid()      not null,
+    user_id    uuid references users (id) on delete cascade            not null,
+    provider   varchar(30)                                             not null,
+    subject    varchar(255)                                            not null,
+    email      varchar(254),
+    created_at timestamp with time zone default timezone('utc', now()) not null,
+    unique (provider, subject),
+    unique (user_id, provider)
+);
+
+create table signing_keys
+(
+    kid          varchar(32) primary key                                 not null,
+    private_key  bytea                                                   not null,
+    status       varchar(10)                                             not null,
+    created_at   timestamp with time zone default timezone('utc', now()) not null,
+    activated_at timestamp with time zone,
+    retired_at   timestamp with time zone
+);
+
+create table api_tokens
+(
+    id           uuid primary key         default gen_random_uuid()      not null,
+    user_id      uuid references users (id) on delete cascade            not null,
+    name         varchar(64)                                             not null,
+    token_hash   varchar(64) unique                                      not null,
+    prefix       varchar(16)                                             not null,
+    scopes       text[]                   default '{}'                   not null,
+    expires_at   timestamp with time zone,
+    last_used_at timestamp with time zone,
+    created_at   timestamp with time zone default timezone('utc', now()) not null
+);
+
+create table roles
+(
+    name        varchar(30) primary key not null,
+    description varchar(255)            not null
+);
+
+create table permissions
+(
+    name        varchar(50) primary key not null,
+    description varchar(255)            not null
+);
+
+create table role_permissions
+(
+    role       varchar(30) references roles (name) on delete cascade       not null,
+    permission varchar(50) references permissions (name) on delete cascade not null,
+    primary key (role, permission)
+);
+
+create table user_roles
+(
+    user_id    uuid references users (id) on delete cascade                  not null,
+    role       varchar(30) references roles (name) on delete cascade          not null,
+    created_at timestamp with time zone default timezone('utc', now())        not null,
+    primary key (user_id, role)
+);
+
+insert into roles (name, description)
+values ('user', 'Every signed-in user.'),
+       ('moderator', 'Moderates users and messages.'),
+       ('admin', 'Operates the workspace and manages roles.')
+on conflict (name) do nothing;
+
+insert into permissions (name, description)
+values ('users:moderate', 'Suspend and restore users.'),
+       ('messages:moderate', 'Hide and delete any message.'),
+       ('roles:read', 'List roles and the roles of users.'),
+       ('roles:assign', 'Assign and remove roles.'),
+       ('system:operate', 'Use operational endpoints.'),
+       ('audit:read', 'Read the audit log.')
+on conflict (name) do nothing;
+
+insert into role_permissions (role, permission)
+values ('moderator', 'users:moderate'),
+       ('moderator', 'messages:moderate'),
+       ('moderator', 'roles:read'),
+       ('admin', 'users:moderate'),
+       ('admin', 'messages:moderate'),
+       ('admin', 'roles:read'),
+       ('admin', 'roles:assign'),
+       ('admin', 'system:operate'),
+       ('admin', 'audit:read')
+on conflict do nothing;
+
+-- audit_events is append-only. Actor and target are not foreign keys so that
+-- events outlive the accounts they mention.
+create table audit_events
+(
+    id         bigint generated always as identity primary key,
+    action     varchar(50)                                             not null,
+    actor_id   uuid,
+    target_id  uuid,
+    ip         varchar(45)                                             not null,
+    user_agent varchar(255)                                            not null,
+    request_id varchar(128)                                            not null,
+    metadata   jsonb                    default '{}'                   not null,
+    created_at timestamp with time zone default timezone('utc', now()) not null
+);
+
+create index audit_events_actor_id_idx on audit_events (actor_id, id);
+create index audit_events_target_id_idx on audit_events (target_id, id);
+
+-- The only change let through clears the address and user agent of an event
+-- whose subject is a purged account, keeping the rest of the row.
+create or replace function audit_events_append_only() returns trigger as
+$$
+begin
+    if tg_op = 'UPDATE' then
+        if new.ip = '' and new.user_agent = ''
+            and (new.id, new.action, new.actor_id, new.target_id, new.request_id, new.metadata, new.created_at)
+                is not distinct from
+                (old.id, old.action, old.actor_id, old.target_id, old.request_id, old.metadata, old.created_at)
+            and exists (select 1
+                        from users
+                        where id = coalesce(old.actor_id, old.target_id)
+                          and deleted_at is not null
+                          and purge_at is null) then
+            return new;
+        end if;
+    end if;
+
+    raise exception 'audit_events is append-only';
+end;
+$$ language plpgsql;
+
+create trigger audit_events_append_only
+    before update or delete
+    on audit_events
+    for each row
+    execute function audit_events_append_only();
+
+create trigger audit_events_no_truncate
+    before truncate
+    on audit_events
+    execute function audit_events_append_only();
+
+-- data_exports holds the archives users request of their data. The archive
+-- is kept until expires_at, failed exports expire the same way.
+create table data_exports
+(
+    id           uuid primary key         default gen_random_uuid()      not null,
+    user_id      uuid references users (id) on delete cascade            not null,
+    status       varchar(16)              default 'pending'              not null,
+    archive      bytea,
+    started_at   timestamp with time zone,
+    completed_at timestamp with time zone,
+    expires_at   timestamp with time zone,
+    created_at   timestamp with time zone default timezone('utc', now()) not null
+);
+
+create index data_exports_user_id_idx on data_exports (user_id, created_at);
+create index data_exports_status_idx on data_exports (status, created_at) where status in ('pending', 'running');
+
+-- notification_settings holds the account-wide level, mute and do-not-disturb
+-- schedule that decide which notifications reach a user. Users without a row
+-- get everything. The do-not-disturb window is in minutes after midnight in
+-- the user's timezone and may wrap past midnight.
+create table notification_settings
+(
+    user_id     uuid primary key references users (id) on delete cascade not null,
+    level       varchar(16)              default 'all'                  not null,
+    muted_until timestamp with time zone,
+    dnd_start   smallint,
+    dnd_end     smallint,
+    updated_at  timestamp with time zone default timezone('utc', now()) not null
+);
+
+-- push_subscriptions are the Web Push subscriptions of the user's browsers.
+-- An endpoint belongs to one browser profile, subscribing again from another
+-- account moves it.
+create table push_subscriptions
+(
+    id           uuid primary key         default gen_random_uuid()      not null,
+    user_id      uuid references users (id) on delete cascade            not null,
+    endpoint     varchar(1024) unique                                    not null,
+    p256dh       varchar(128)                                            not null,
+    auth         varchar(32)                                             not null,
+    user_agent   varchar(255)                                            not null,
+    last_used_at timestamp with time zone,
+    created_at   timestamp with time zone default timezone('utc', now()) not null
+);
+
+create index push_subscriptions_user_id_idx on push_subscriptions (user_id, created_at);
+
+-- push_devices are the native apps registered for push. session_hash is the
+-- SHA-256 of the session token that registered the device, signing out of
+-- that session forgets it.
+create table push_devices
+(
+    id           uuid primary key         default gen_random_uuid()      not null,
+    user_id      uuid references users (id) on delete cascade            not null,
+    platform     varchar(10)                                             not null,
+    token        varchar(512)                                            not null,
+    session_hash varchar(64)                                             not null,
+    last_used_at timestamp with time zone,
+    created_at   timestamp with time zone default timezone('utc', now()) not null,
+    unique (platform, token)
+);
+
+create index push_devices_user_id_idx on push_devices (user_id, created_at);
+create index push_devices_session_hash_idx on push_devices (session_hash);
+
+-- push_deliveries queues the notifications for push_devices. Notifications
+-- with the same collapse key are merged while they wait, count keeps how
+-- many were. revision changes with every merge so that a sender finishing
+-- an older revision leaves the newer one queued.
+create table push_deliveries
+(
+    id              uuid primary key         default gen_random_uuid()      not null,
+    device_id       uuid references push_devices (id) on delete cascade     not null,
+    collapse_key    varchar(64)                                             not null,
+    payload         jsonb                                                   not null,
+    count           integer                  default 1                      not null,
+    revision        integer                  default 1                      not null,
+    attempts        integer                  default 0                      not null,
+    next_attempt_at timestamp with time zone                                not null,
+    created_at      timestamp with time zone default timezone('utc', now()) not null,
+    unique (device_id, collapse_key)
+);
+
+create index push_deliveries_next_attempt_at_idx on push_deliveries (next_attempt_at);
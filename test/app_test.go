@@ -1,30 +1,82 @@
 package test
 
 import (
+	"archive/zip"
 	"bytes"
 	"chat_backend/generated"
+	"chat_backend/internal/app/repositories"
+	"chat_backend/internal/app/services"
+	"chat_backend/internal/delivery/middlewares"
 	"chat_backend/internal/delivery/router"
+	"chat_backend/pkg/mailer"
+	passwords "chat_backend/pkg/password"
+	"chat_backend/pkg/push"
 	"chat_backend/pkg/utils"
 	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/cloudinary/cloudinary-go/v2"
+	pasetoware "github.com/gofiber/contrib/paseto"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/helmet"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gookit/validate"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
+	"github.com/matthewhartstonge/argon2"
+	"github.com/o1egl/paseto"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/hkdf"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"io"
 	"log"
+	"math/big"
 	"math/rand"
+	"mime/multipart"
+	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+var (
+	databaseOnce sync.Once
+	testDB       *pgxpool.Pool
+	testQueries  *generated.Queries
+)
+
+// database returns the pool that all the test apps share.
+func database() (*pgxpool.Pool, *generated.Queries) {
+	databaseOnce.Do(func() {
+		testDB, testQueries = utils.Database()
+	})
+	return testDB, testQueries
+}
+
+// appTest builds an app on the shared pool. It starts none of the background
+// jobs, so the tests run them themselves where they need them.
 func appTest() (*fiber.App, *generated.Queries) {
 	err := godotenv.Load("../.env")
 	if err != nil {
@@ -41,18 +93,19 @@ func appTest() (*fiber.App, *generated.Queries) {
 		AllowCredentials: true,
 	}))
 	app.Use(helmet.New())
-	app.Use(logger.New())
+	app.Use(middlewares.RequestID())
+	app.Use(middlewares.Logger())
 	app.Use(recover.New())
 
 	validate.Config(func(opt *validate.GlobalOption) {
 		opt.StopOnError = false
 	})
 
-	_, queries := utils.Database()
+	db, queries := database()
 
 	cld, _ := cloudinary.NewFromURL(os.Getenv("CLOUDINARY_URL"))
 
-	router.AppRouter(app, queries, cld)
+	_ = router.AppRouter(app, db, queries, cld)
 
 	return app, queries
 }
@@ -66,7 +119,8 @@ const (
 	letterBytes    = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	username       = "test-user"
 	updateUsername = "test-user-updated"
-	password       = "test-password"
+	password       = "test-Horse-battery-7"
+	oidcUsername   = "test-oidc-user"
 )
 
 func genValue(l int) string {
@@ -84,6 +138,7 @@ func afterAll() func(t *testing.T) {
 	_, queries := appTest()
 	_ = queries.DeleteUserByUsername(context.Background(), username)
 	_ = queries.DeleteUserByUsername(context.Background(), updateUsername)
+	_ = queries.DeleteUserByUsername(context.Background(), oidcUsername)
 	return func(t *testing.T) {
 		t.Log("Clean up.")
 	}
@@ -178,6 +233,39 @@ func TestSignUp(t *testing.T) {
 		}
 	})
 
+	t.Run("Should return error when password does not meet the policy", func(t *testing.T) {
+		inputSchema := fiber.Map{
+			"username": username,
+			"password": "password",
+		}
+
+		input, _ := json.Marshal(inputSchema)
+
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+		req.Header.Set("Content-Type", "application/json")
+
+		res, _ := app.Test(req)
+
+		rejection := struct {
+			Score   int `json:"score"`
+			Reasons []struct {
+				Code string `json:"code"`
+			} `json:"reasons"`
+		}{}
+		body, _ := io.ReadAll(res.Body)
+		_ = json.Unmarshal(body, &rejection)
+
+		codes := make([]string, len(rejection.Reasons))
+		for i, reason := range rejection.Reasons {
+			codes[i] = reason.Code
+		}
+
+		assert.Equal(t, fiber.StatusForbidden, res.StatusCode)
+		assert.Equal(t, 0, rejection.Score)
+		assert.Contains(t, codes, "common")
+		assert.Contains(t, codes, "too_weak")
+	})
+
 	t.Run("Should return CREATED", func(t *testing.T) {
 		inputSchema := fiber.Map{
 			"username": username,
@@ -246,6 +334,165 @@ func TestSignUp(t *testing.T) {
 	})
 }
 
+func TestTransactions(t *testing.T) {
+	defer afterAll()
+
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
+
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = app.Test(signUpReq)
+
+	db, queries := database()
+
+	user, _ := queries.GetUserByUsername(context.Background(), username)
+	txManager := repositories.NewTxManager(db, func(queries *generated.Queries) repositories.Repositories {
+		return repositories.Repositories{}
+	}, 3)
+
+	setBio := func(ctx context.Context, queries *generated.Queries, bio string) error {
+		return queries.UpdateUserProfile(ctx, generated.UpdateUserProfileParams{
+			ID:  user.ID,
+			Bio: pgtype.Text{String: bio, Valid: true},
+		})
+	}
+
+	t.Run("Should retry serialization failures with a fresh transaction", func(t *testing.T) {
+		var attempts atomic.Int32
+		var read, done sync.WaitGroup
+		read.Add(2)
+		results := make([]error, 2)
+
+		// Both transactions read the bio before either writes it, so the
+		// second writer fails to serialize and has to start over.
+		for i := range results {
+			done.Add(1)
+			go func(i int) {
+				defer done.Done()
+
+				first := true
+				results[i] = txManager.WithinTx(context.Background(), func(ctx context.Context, tx *repositories.Tx) error {
+					attempts.Add(1)
+
+					current, err := tx.Queries.GetUserByID(ctx, user.ID)
+					if err != nil {
+						return err
+					}
+
+					if first {
+						first = false
+						read.Done()
+						read.Wait()
+					}
+
+					return setBio(ctx, tx.Queries, current.Bio.String+"x")
+				})
+			}(i)
+		}
+		done.Wait()
+
+		updated, _ := queries.GetUserByID(context.Background(), user.ID)
+
+		tests := []TestCase{
+			{expected: []error{nil, nil}, actual: results},
+			{expected: int32(3), actual: attempts.Load()},
+			{expected: "xx", actual: updated.Bio.String},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should run the hooks of each attempt in order", func(t *testing.T) {
+		var events []string
+		attempt := 0
+
+		err := txManager.WithinTx(context.Background(), func(ctx context.Context, tx *repositories.Tx) error {
+			attempt++
+			n := attempt
+
+			tx.AfterCommit(func(ctx context.Context) {
+				events = append(events, fmt.Sprintf("commit %d", n))
+			})
+			repositories.AfterRollback(ctx, func(ctx context.Context) {
+				events = append(events, fmt.Sprintf("rollback %d", n))
+			})
+
+			if n == 1 {
+				return &pgconn.PgError{Code: "40001"}
+			}
+			return nil
+		})
+
+		repositories.AfterCommit(context.Background(), func(ctx context.Context) {
+			events = append(events, "outside")
+		})
+
+		tests := []TestCase{
+			{expected: nil, actual: err},
+			{expected: []string{"rollback 1", "commit 2", "outside"}, actual: events},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should roll back other errors without retrying", func(t *testing.T) {
+		var events []string
+		attempts := 0
+		failure := errors.New("failure")
+
+		err := txManager.WithinTx(context.Background(), func(ctx context.Context, tx *repositories.Tx) error {
+			attempts++
+
+			tx.AfterCommit(func(ctx context.Context) {
+				events = append(events, "commit")
+			})
+			tx.AfterRollback(func(ctx context.Context) {
+				events = append(events, "rollback")
+			})
+
+			if err := setBio(ctx, tx.Queries, "rolled back"); err != nil {
+				return err
+			}
+			return failure
+		})
+
+		updated, _ := queries.GetUserByID(context.Background(), user.ID)
+
+		tests := []TestCase{
+			{expected: failure, actual: err},
+			{expected: 1, actual: attempts},
+			{expected: []string{"rollback"}, actual: events},
+			{expected: "xx", actual: updated.Bio.String},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	t.Run("Should return the password policy", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/auth/password/policy", nil)
+		res, _ := app.Test(req)
+
+		policy := map[string]interface{}{}
+		body, _ := io.ReadAll(res.Body)
+		_ = json.Unmarshal(body, &policy)
+
+		assert.Equal(t, fiber.StatusOK, res.StatusCode)
+		assert.Contains(t, policy, "min_length")
+		assert.Contains(t, policy, "min_score")
+	})
+}
+
 func TestLogin(t *testing.T) {
 	defer afterAll()
 
@@ -399,92 +646,150 @@ func TestLogin(t *testing.T) {
 	})
 }
 
-func TestLogout(t *testing.T) {
+func TestPasswordRehash(t *testing.T) {
 	defer afterAll()
 
-	t.Run("Should return error when not logged", func(t *testing.T) {
-		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/signout", nil)
-		res, _ := app.Test(req)
-
-		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
-	})
+	t.Run("Should upgrade a weaker password hash on login", func(t *testing.T) {
+		_, queries := appTest()
 
-	t.Run("Should log out", func(t *testing.T) {
-		inputSchema := fiber.Map{
+		input, _ := json.Marshal(fiber.Map{
 			"username": username,
 			"password": password,
-		}
-
-		input, _ := json.Marshal(inputSchema)
+		})
 
 		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
 		signUpReq.Header.Set("Content-Type", "application/json")
 		_, _ = app.Test(signUpReq)
 
+		user, _ := queries.GetUserByUsername(context.Background(), username)
+
+		legacy := argon2.DefaultConfig()
+		legacy.TimeCost = 1
+		legacyHash, _ := legacy.HashEncoded([]byte(password))
+		_ = queries.UpdateUserPassword(context.Background(), generated.UpdateUserPasswordParams{
+			ID:       user.ID,
+			Password: string(legacyHash),
+		})
+
 		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
 		loginReq.Header.Set("Content-Type", "application/json")
 		loginRes, _ := app.Test(loginReq)
 
-		cookie := loginRes.Cookies()
+		upgraded, _ := queries.GetUserByUsername(context.Background(), username)
+		hasher := passwords.NewHasher()
+		ok, _ := hasher.Verify(upgraded.Password, password)
 
-		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/signout", nil)
-		req.AddCookie(cookie[0])
-		res, _ := app.Test(req)
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: loginRes.StatusCode},
+			{expected: true, actual: upgraded.Password != string(legacyHash)},
+			{expected: false, actual: hasher.NeedsRehash(upgraded.Password)},
+			{expected: true, actual: ok},
+		}
 
-		assert.Equal(t, fiber.StatusOK, res.StatusCode)
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
 	})
 }
 
-func TestGetProfile(t *testing.T) {
+func TestSigningKeys(t *testing.T) {
 	defer afterAll()
 
-	t.Run("Should return error when not logged", func(t *testing.T) {
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
+
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = app.Test(signUpReq)
+
+	_, queries := appTest()
+	user, _ := queries.GetUserByUsername(context.Background(), username)
+
+	profile := func(token string) int {
 		req := httptest.NewRequest(fiber.MethodGet, "/api/user/profile", nil)
+		req.AddCookie(&http.Cookie{Name: "chat_app", Value: token})
 		res, _ := app.Test(req)
+		return res.StatusCode
+	}
 
-		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
-	})
+	t.Run("Should accept tokens issued before the keyring", func(t *testing.T) {
+		token, _ := pasetoware.CreateToken(utils.GetPrivateKey(), user.ID.String(), time.Hour, pasetoware.PurposePublic)
 
-	t.Run("Should return data", func(t *testing.T) {
-		inputSchema := fiber.Map{
-			"username": username,
-			"password": password,
-		}
+		assert.Equal(t, fiber.StatusOK, profile(token))
+	})
 
-		input, _ := json.Marshal(inputSchema)
+	t.Run("Should reject tokens signed by an unknown key", func(t *testing.T) {
+		_, key, _ := ed25519.GenerateKey(cryptorand.Reader)
+		payload, _ := pasetoware.NewPayload(user.ID.String(), time.Hour)
+		token, _ := paseto.NewV2().Sign(key, payload, fiber.Map{"kid": "unknown"})
 
-		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
-		signUpReq.Header.Set("Content-Type", "application/json")
-		_, _ = app.Test(signUpReq)
+		assert.Equal(t, fiber.StatusUnauthorized, profile(token))
+	})
 
+	t.Run("Should keep sessions valid across a rotation", func(t *testing.T) {
 		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
 		loginReq.Header.Set("Content-Type", "application/json")
 		loginRes, _ := app.Test(loginReq)
+		session := findCookie(loginRes.Cookies(), "chat_app")
 
-		cookie := loginRes.Cookies()
+		keyringKey, _ := utils.GetKeyringKey()
+		keyring := services.NewKeyringService(repositories.NewSigningKeyRepo(queries, keyringKey, utils.GetTimeouts()), 0)
+		kid, _ := keyring.GenerateKey(context.Background())
+		activateErr := keyring.ActivateKey(context.Background(), kid)
+		token, _ := keyring.Sign(context.Background(), user.ID.String(), time.Hour)
 
-		req := httptest.NewRequest(fiber.MethodGet, "/api/user/profile", nil)
-		req.AddCookie(cookie[0])
-		res, _ := app.Test(req)
+		var footer map[string]string
+		_ = paseto.ParseFooter(token, &footer)
 
-		body, _ := io.ReadAll(res.Body)
+		tests := []TestCase{
+			{expected: nil, actual: activateErr},
+			{expected: kid, actual: footer["kid"]},
+			{expected: fiber.StatusOK, actual: profile(session.Value)},
+			{expected: nil, actual: keyring.RetireKey(context.Background(), kid)},
+			{expected: services.ErrUnknownSigningKey, actual: keyring.ActivateKey(context.Background(), kid)},
+		}
 
-		assert.Equal(t, fiber.StatusOK, res.StatusCode)
-		assert.NotEmpty(t, body)
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
 	})
 }
 
-func TestUpdateProfile(t *testing.T) {
+func TestDerivedKeys(t *testing.T) {
+	secret := os.Getenv("MASTER_SECRET")
+	defer os.Setenv("MASTER_SECRET", secret)
+
+	derive := func(value string) error {
+		_ = os.Setenv("MASTER_SECRET", value)
+		_, err := utils.GetPreAuthKey()
+		return err
+	}
+
+	tests := []TestCase{
+		{expected: true, actual: derive("") != nil},
+		{expected: true, actual: derive("not hex") != nil},
+		{expected: true, actual: derive(strings.Repeat("ab", 16)) != nil},
+		{expected: nil, actual: derive(strings.Repeat("ab", 32))},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.actual)
+	}
+}
+
+func TestLogout(t *testing.T) {
 	defer afterAll()
 
 	t.Run("Should return error when not logged", func(t *testing.T) {
-		req := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", nil)
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/signout", nil)
 		res, _ := app.Test(req)
 
 		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
 	})
 
-	t.Run("Should update 1 field", func(t *testing.T) {
+	t.Run("Should log out", func(t *testing.T) {
 		inputSchema := fiber.Map{
 			"username": username,
 			"password": password,
@@ -502,87 +807,2055 @@ func TestUpdateProfile(t *testing.T) {
 
 		cookie := loginRes.Cookies()
 
-		inputUpdateSchema := fiber.Map{
-			"username": updateUsername,
-		}
-
-		inputUpdate, _ := json.Marshal(inputUpdateSchema)
-
-		req := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", bytes.NewReader(inputUpdate))
-		req.Header.Set("Content-Type", "application/json")
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/signout", nil)
 		req.AddCookie(cookie[0])
 		res, _ := app.Test(req)
 
 		assert.Equal(t, fiber.StatusOK, res.StatusCode)
 	})
+}
 
-	t.Run("Should update 2 field", func(t *testing.T) {
-		inputSchema := fiber.Map{
-			"username": username,
-			"password": password,
+func TestBearerToken(t *testing.T) {
+	defer afterAll()
+
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
+
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = app.Test(signUpReq)
+
+	t.Run("Should return the token in the body in token mode", func(t *testing.T) {
+		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login?mode=token", bytes.NewReader(input))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes, _ := app.Test(loginReq)
+
+		var body map[string]interface{}
+		_ = json.NewDecoder(loginRes.Body).Decode(&body)
+
+		req := httptest.NewRequest(fiber.MethodGet, "/api/user/profile", nil)
+		req.Header.Set("Authorization", "Bearer "+body["token"].(string))
+		res, _ := app.Test(req)
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: loginRes.StatusCode},
+			{expected: "Bearer", actual: body["token_type"]},
+			{expected: (*http.Cookie)(nil), actual: findCookie(loginRes.Cookies(), "chat_app")},
+			{expected: fiber.StatusOK, actual: res.StatusCode},
 		}
 
-		input, _ := json.Marshal(inputSchema)
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
 
-		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
-		signUpReq.Header.Set("Content-Type", "application/json")
-		_, _ = app.Test(signUpReq)
+	t.Run("Should reject other authorization schemes", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/user/profile", nil)
+		req.Header.Set("Authorization", "Basic dGVzdDp0ZXN0")
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
+	})
 
+	t.Run("Should refuse cross-site requests authenticated by the cookie", func(t *testing.T) {
 		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
 		loginReq.Header.Set("Content-Type", "application/json")
 		loginRes, _ := app.Test(loginReq)
+		session := findCookie(loginRes.Cookies(), "chat_app")
 
-		cookie := loginRes.Cookies()
+		crossSiteReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signout", nil)
+		crossSiteReq.Header.Set("Origin", "https://evil.example")
+		crossSiteReq.AddCookie(session)
+		crossSiteRes, _ := app.Test(crossSiteReq)
 
-		inputUpdateSchema := fiber.Map{
-			"username": updateUsername,
-			"password": "blah",
+		readReq := httptest.NewRequest(fiber.MethodGet, "/api/user/profile", nil)
+		readReq.Header.Set("Origin", "https://evil.example")
+		readReq.AddCookie(session)
+		readRes, _ := app.Test(readReq)
+
+		sameSiteReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signout", nil)
+		sameSiteReq.Header.Set("Origin", os.Getenv("CLIENT_URL"))
+		sameSiteReq.AddCookie(session)
+		sameSiteRes, _ := app.Test(sameSiteReq)
+
+		tests := []TestCase{
+			{expected: fiber.StatusForbidden, actual: crossSiteRes.StatusCode},
+			{expected: fiber.StatusOK, actual: readRes.StatusCode},
+			{expected: fiber.StatusOK, actual: sameSiteRes.StatusCode},
 		}
 
-		inputUpdate, _ := json.Marshal(inputUpdateSchema)
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
 
-		req := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", bytes.NewReader(inputUpdate))
-		req.Header.Set("Content-Type", "application/json")
-		req.AddCookie(cookie[0])
+	t.Run("Should not apply the origin check to bearer tokens", func(t *testing.T) {
+		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login?mode=token", bytes.NewReader(input))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes, _ := app.Test(loginReq)
+
+		var body map[string]interface{}
+		_ = json.NewDecoder(loginRes.Body).Decode(&body)
+
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/signout", nil)
+		req.Header.Set("Origin", "https://evil.example")
+		req.Header.Set("Authorization", "Bearer "+body["token"].(string))
 		res, _ := app.Test(req)
 
 		assert.Equal(t, fiber.StatusOK, res.StatusCode)
 	})
 }
 
-func TestDeleteUser(t *testing.T) {
+func TestApiTokens(t *testing.T) {
 	defer afterAll()
 
-	t.Run("Should return error when not logged", func(t *testing.T) {
-		req := httptest.NewRequest(fiber.MethodDelete, "/api/user/profile/delete", nil)
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
+
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = app.Test(signUpReq)
+
+	loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginRes, _ := app.Test(loginReq)
+	session := findCookie(loginRes.Cookies(), "chat_app")
+
+	createToken := func(body fiber.Map) *http.Response {
+		input, _ := json.Marshal(body)
+		req := httptest.NewRequest(fiber.MethodPost, "/api/user/tokens", bytes.NewReader(input))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(session)
 		res, _ := app.Test(req)
+		return res
+	}
 
-		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
+	withToken := func(method, target, token string) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, _ := app.Test(req)
+		return res.StatusCode
+	}
+
+	t.Run("Should return error when scope is unknown", func(t *testing.T) {
+		res := createToken(fiber.Map{"name": "ci", "scopes": []string{"everything"}})
+
+		assert.Equal(t, fiber.StatusForbidden, res.StatusCode)
 	})
 
-	t.Run("Should delete user data and log out", func(t *testing.T) {
-		inputSchema := fiber.Map{
-			"username": username,
-			"password": password,
+	t.Run("Should return error when expiry is in the past", func(t *testing.T) {
+		res := createToken(fiber.Map{"name": "ci", "scopes": []string{"profile"}, "expires_at": time.Now().Add(-time.Hour)})
+
+		assert.Equal(t, fiber.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("Should authenticate within the token's scopes until revoked", func(t *testing.T) {
+		res := createToken(fiber.Map{"name": "ci", "scopes": []string{"profile"}})
+
+		var created map[string]interface{}
+		_ = json.NewDecoder(res.Body).Decode(&created)
+		token, _ := created["token"].(string)
+
+		listReq := httptest.NewRequest(fiber.MethodGet, "/api/user/tokens", nil)
+		listReq.AddCookie(session)
+		listRes, _ := app.Test(listReq)
+		listBody, _ := io.ReadAll(listRes.Body)
+
+		profileStatus := withToken(fiber.MethodGet, "/api/user/profile", token)
+		unscopedStatus := withToken(fiber.MethodGet, "/api/user/tokens", token)
+
+		revokeReq := httptest.NewRequest(fiber.MethodDelete, "/api/user/tokens/"+created["id"].(string), nil)
+		revokeReq.AddCookie(session)
+		revokeRes, _ := app.Test(revokeReq)
+
+		tests := []TestCase{
+			{expected: fiber.StatusCreated, actual: res.StatusCode},
+			{expected: true, actual: strings.HasPrefix(token, "chat_pat_")},
+			{expected: fiber.StatusOK, actual: listRes.StatusCode},
+			{expected: false, actual: strings.Contains(string(listBody), token)},
+			{expected: fiber.StatusOK, actual: profileStatus},
+			{expected: fiber.StatusForbidden, actual: unscopedStatus},
+			{expected: fiber.StatusOK, actual: revokeRes.StatusCode},
+			{expected: fiber.StatusUnauthorized, actual: withToken(fiber.MethodGet, "/api/user/profile", token)},
 		}
 
-		input, _ := json.Marshal(inputSchema)
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+}
 
-		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
-		signUpReq.Header.Set("Content-Type", "application/json")
-		_, _ = app.Test(signUpReq)
+func TestRoles(t *testing.T) {
+	defer afterAll()
 
-		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
-		loginReq.Header.Set("Content-Type", "application/json")
-		loginRes, _ := app.Test(loginReq)
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
 
-		cookie := loginRes.Cookies()
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = app.Test(signUpReq)
 
-		req := httptest.NewRequest(fiber.MethodDelete, "/api/user/profile/delete", nil)
-		req.AddCookie(cookie[0])
+	loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginRes, _ := app.Test(loginReq)
+	session := findCookie(loginRes.Cookies(), "chat_app")
+
+	_, queries := appTest()
+	user, _ := queries.GetUserByUsername(context.Background(), username)
+
+	adminRequest := func(method, target string) *http.Response {
+		req := httptest.NewRequest(method, target, nil)
+		req.AddCookie(session)
 		res, _ := app.Test(req)
+		return res
+	}
+
+	t.Run("Should return error without the permission", func(t *testing.T) {
+		res := adminRequest(fiber.MethodGet, "/api/admin/roles")
+
+		assert.Equal(t, fiber.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("Should let admins assign roles", func(t *testing.T) {
+		_, _ = queries.AssignUserRole(context.Background(), generated.AssignUserRoleParams{
+			UserID: user.ID,
+			Role:   "admin",
+		})
+
+		rolesRes := adminRequest(fiber.MethodGet, "/api/admin/roles")
+		assignRes := adminRequest(fiber.MethodPut, "/api/admin/users/"+user.ID.String()+"/roles/moderator")
+		reassignRes := adminRequest(fiber.MethodPut, "/api/admin/users/"+user.ID.String()+"/roles/moderator")
+		unknownRes := adminRequest(fiber.MethodPut, "/api/admin/users/"+user.ID.String()+"/roles/owner")
+
+		userRolesRes := adminRequest(fiber.MethodGet, "/api/admin/users/"+user.ID.String()+"/roles")
+		var userRoles []string
+		_ = json.NewDecoder(userRolesRes.Body).Decode(&userRoles)
+
+		removeRes := adminRequest(fiber.MethodDelete, "/api/admin/users/"+user.ID.String()+"/roles/moderator")
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: rolesRes.StatusCode},
+			{expected: fiber.StatusCreated, actual: assignRes.StatusCode},
+			{expected: fiber.StatusOK, actual: reassignRes.StatusCode},
+			{expected: fiber.StatusNotFound, actual: unknownRes.StatusCode},
+			{expected: []string{"user", "admin", "moderator"}, actual: userRoles},
+			{expected: fiber.StatusOK, actual: removeRes.StatusCode},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should not count admins pending deletion", func(t *testing.T) {
+		admins, _ := queries.CountRoleMembers(context.Background(), "admin")
+		_, _ = queries.ScheduleUserDeletion(context.Background(), generated.ScheduleUserDeletionParams{
+			ID:      user.ID,
+			PurgeAt: pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+		})
+		remaining, _ := queries.CountRoleMembers(context.Background(), "admin")
+
+		assert.Equal(t, admins-1, remaining)
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	defer afterAll()
+
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
+	wrongInput, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": "not-the-Password-1",
+	})
+
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = app.Test(signUpReq)
+
+	wrongReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(wrongInput))
+	wrongReq.Header.Set("Content-Type", "application/json")
+	_, _ = app.Test(wrongReq)
+
+	loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginReq.Header.Set("X-Request-ID", "audit-test-login")
+	loginRes, _ := app.Test(loginReq)
+	session := findCookie(loginRes.Cookies(), "chat_app")
+
+	_, queries := appTest()
+	user, _ := queries.GetUserByUsername(context.Background(), username)
+
+	request := func(target string) *http.Response {
+		req := httptest.NewRequest(fiber.MethodGet, target, nil)
+		req.AddCookie(session)
+		res, _ := app.Test(req)
+		return res
+	}
+
+	t.Run("Should list the account's security activity", func(t *testing.T) {
+		res := request("/api/user/security/activity")
+
+		var page struct {
+			Events []struct {
+				Action    string `json:"action"`
+				RequestID string `json:"request_id"`
+			} `json:"events"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&page)
+
+		actions := make([]string, len(page.Events))
+		for i, event := range page.Events {
+			actions[i] = event.Action
+		}
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: res.StatusCode},
+			{expected: []string{"auth.login", "auth.login_failed", "user.signup"}, actual: actions},
+			{expected: "audit-test-login", actual: page.Events[0].RequestID},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should hide other users' addresses", func(t *testing.T) {
+		_ = queries.CreateAuditEvent(context.Background(), generated.CreateAuditEventParams{
+			Action:    "role.assign",
+			ActorID:   pgtype.UUID{Bytes: [16]byte{1}, Valid: true},
+			TargetID:  pgtype.UUID{Bytes: user.ID, Valid: true},
+			Ip:        "203.0.113.7",
+			UserAgent: "admin-agent",
+			Metadata:  []byte("{}"),
+		})
+
+		res := request("/api/user/security/activity")
+
+		var page struct {
+			Events []struct {
+				Action    string `json:"action"`
+				IP        string `json:"ip"`
+				UserAgent string `json:"user_agent"`
+			} `json:"events"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&page)
+
+		tests := []TestCase{
+			{expected: "role.assign", actual: page.Events[0].Action},
+			{expected: "", actual: page.Events[0].IP},
+			{expected: "", actual: page.Events[0].UserAgent},
+			{expected: "0.0.0.0", actual: page.Events[1].IP},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should let admins query the audit log", func(t *testing.T) {
+		forbiddenRes := request("/api/admin/audit")
+
+		_, _ = queries.AssignUserRole(context.Background(), generated.AssignUserRoleParams{
+			UserID: user.ID,
+			Role:   "admin",
+		})
+
+		res := request("/api/admin/audit?action=auth.login_failed&target=" + user.ID.String())
+		var page struct {
+			Events []struct {
+				Action string `json:"action"`
+			} `json:"events"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&page)
+
+		invalidRes := request("/api/admin/audit?actor=someone")
+
+		tests := []TestCase{
+			{expected: fiber.StatusForbidden, actual: forbiddenRes.StatusCode},
+			{expected: fiber.StatusOK, actual: res.StatusCode},
+			{expected: 1, actual: len(page.Events)},
+			{expected: fiber.StatusBadRequest, actual: invalidRes.StatusCode},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+}
+
+func TestDataExport(t *testing.T) {
+	defer afterAll()
+
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
+
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = app.Test(signUpReq)
+
+	loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginRes, _ := app.Test(loginReq)
+	session := findCookie(loginRes.Cookies(), "chat_app")
+
+	request := func(method, target string) *http.Response {
+		req := httptest.NewRequest(method, target, nil)
+		req.AddCookie(session)
+		res, _ := app.Test(req)
+		return res
+	}
+
+	t.Run("Should return error when not logged", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/user/export", nil)
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("Should queue one export at a time", func(t *testing.T) {
+		res := request(fiber.MethodPost, "/api/user/export")
+		var export struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&export)
+
+		againRes := request(fiber.MethodPost, "/api/user/export")
+
+		listRes := request(fiber.MethodGet, "/api/user/export")
+		var exports []map[string]interface{}
+		_ = json.NewDecoder(listRes.Body).Decode(&exports)
+
+		downloadRes := request(fiber.MethodGet, "/api/user/export/"+export.ID)
+
+		tests := []TestCase{
+			{expected: fiber.StatusAccepted, actual: res.StatusCode},
+			{expected: "pending", actual: export.Status},
+			{expected: fiber.StatusForbidden, actual: againRes.StatusCode},
+			{expected: fiber.StatusOK, actual: listRes.StatusCode},
+			{expected: 1, actual: len(exports)},
+			{expected: fiber.StatusNotFound, actual: downloadRes.StatusCode},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should export the account's settings and devices", func(t *testing.T) {
+		db, queries := database()
+		user, _ := queries.GetUserByUsername(context.Background(), username)
+		_ = queries.CreateUsernameHistory(context.Background(), generated.CreateUsernameHistoryParams{
+			UserID:   user.ID,
+			Username: "previous_" + username,
+		})
+
+		timeouts := utils.GetTimeouts()
+		newRepositories := func(queries *generated.Queries) repositories.Repositories {
+			return repositories.Repositories{
+				User:         repositories.NewUserRepo(queries, nil, nil, timeouts),
+				Passkey:      repositories.NewPasskeyRepo(queries, timeouts),
+				Identity:     repositories.NewIdentityRepo(queries, timeouts),
+				ApiToken:     repositories.NewApiTokenRepo(queries, nil, timeouts),
+				Role:         repositories.NewRoleRepo(queries, timeouts),
+				Audit:        repositories.NewAuditRepo(queries, timeouts),
+				DataExport:   repositories.NewDataExportRepo(queries, timeouts),
+				Notification: repositories.NewNotificationRepo(queries, timeouts),
+				Push:         repositories.NewPushRepo(queries, timeouts),
+			}
+		}
+		exports := services.NewDataExportService(newRepositories(queries), repositories.NewTxManager(db, newRepositories, 1), mailer.NewLogMailer("test@example.com"))
+		built, buildErr := exports.ProcessExports(context.Background())
+
+		listRes := request(fiber.MethodGet, "/api/user/export")
+		var list []struct {
+			ID string `json:"id"`
+		}
+		_ = json.NewDecoder(listRes.Body).Decode(&list)
+
+		downloadRes := request(fiber.MethodGet, "/api/user/export/"+list[0].ID)
+		body, _ := io.ReadAll(downloadRes.Body)
+
+		var data struct {
+			UsernameHistory []struct {
+				Username string `json:"username"`
+			} `json:"username_history"`
+			NotificationSettings struct {
+				Level string `json:"level"`
+			} `json:"notification_settings"`
+			PushSubscriptions []interface{} `json:"push_subscriptions"`
+			PushDevices       []interface{} `json:"push_devices"`
+		}
+		archive, _ := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if archive != nil {
+			if file, err := archive.Open("data.json"); err == nil {
+				_ = json.NewDecoder(file).Decode(&data)
+			}
+		}
+
+		tests := []TestCase{
+			{expected: nil, actual: buildErr},
+			{expected: true, actual: built > 0},
+			{expected: fiber.StatusOK, actual: downloadRes.StatusCode},
+			{expected: 1, actual: len(data.UsernameHistory)},
+			{expected: "all", actual: data.NotificationSettings.Level},
+			{expected: 0, actual: len(data.PushSubscriptions)},
+			{expected: 0, actual: len(data.PushDevices)},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+}
+
+func TestNotificationSettings(t *testing.T) {
+	defer afterAll()
+
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
+
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = app.Test(signUpReq)
+
+	loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginRes, _ := app.Test(loginReq)
+	session := findCookie(loginRes.Cookies(), "chat_app")
+
+	update := func(body fiber.Map) (*http.Response, map[string]interface{}) {
+		input, _ := json.Marshal(body)
+		req := httptest.NewRequest(fiber.MethodPatch, "/api/user/notifications", bytes.NewReader(input))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(session)
+		res, _ := app.Test(req)
+
+		var settings map[string]interface{}
+		_ = json.NewDecoder(res.Body).Decode(&settings)
+		return res, settings
+	}
+
+	t.Run("Should return the defaults", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/user/notifications", nil)
+		req.AddCookie(session)
+		res, _ := app.Test(req)
+
+		var settings map[string]interface{}
+		_ = json.NewDecoder(res.Body).Decode(&settings)
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: res.StatusCode},
+			{expected: "all", actual: settings["level"]},
+			{expected: nil, actual: settings["muted_until"]},
+			{expected: nil, actual: settings["dnd_start"]},
+			{expected: "UTC", actual: settings["timezone"]},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should update and clear the settings", func(t *testing.T) {
+		mutedUntil := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+		res, settings := update(fiber.Map{
+			"level":       "mentions",
+			"muted_until": mutedUntil,
+			"dnd_start":   "22:00",
+			"dnd_end":     "07:00",
+		})
+		levelRes, levelSettings := update(fiber.Map{"level": "none"})
+		clearRes, cleared := update(fiber.Map{"muted_until": "", "dnd_start": "", "dnd_end": ""})
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: res.StatusCode},
+			{expected: "mentions", actual: settings["level"]},
+			{expected: mutedUntil, actual: settings["muted_until"]},
+			{expected: "22:00", actual: settings["dnd_start"]},
+			{expected: "07:00", actual: settings["dnd_end"]},
+			{expected: fiber.StatusOK, actual: levelRes.StatusCode},
+			{expected: "none", actual: levelSettings["level"]},
+			{expected: "22:00", actual: levelSettings["dnd_start"]},
+			{expected: fiber.StatusOK, actual: clearRes.StatusCode},
+			{expected: "none", actual: cleared["level"]},
+			{expected: nil, actual: cleared["muted_until"]},
+			{expected: nil, actual: cleared["dnd_start"]},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should refuse invalid settings", func(t *testing.T) {
+		levelRes, _ := update(fiber.Map{"level": "some"})
+		pastRes, _ := update(fiber.Map{"muted_until": "2000-01-01T00:00:00Z"})
+		halfRes, _ := update(fiber.Map{"dnd_start": "22:00"})
+		clockRes, _ := update(fiber.Map{"dnd_start": "25:00", "dnd_end": "07:00"})
+
+		tests := []TestCase{
+			{expected: fiber.StatusForbidden, actual: levelRes.StatusCode},
+			{expected: fiber.StatusBadRequest, actual: pastRes.StatusCode},
+			{expected: fiber.StatusBadRequest, actual: halfRes.StatusCode},
+			{expected: fiber.StatusBadRequest, actual: clockRes.StatusCode},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+}
+
+func TestGetProfile(t *testing.T) {
+	defer afterAll()
+
+	t.Run("Should return error when not logged", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/user/profile", nil)
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("Should return data", func(t *testing.T) {
+		inputSchema := fiber.Map{
+			"username": username,
+			"password": password,
+		}
+
+		input, _ := json.Marshal(inputSchema)
+
+		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+		signUpReq.Header.Set("Content-Type", "application/json")
+		_, _ = app.Test(signUpReq)
+
+		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes, _ := app.Test(loginReq)
+
+		cookie := loginRes.Cookies()
+
+		req := httptest.NewRequest(fiber.MethodGet, "/api/user/profile", nil)
+		req.AddCookie(cookie[0])
+		res, _ := app.Test(req)
+
+		body, _ := io.ReadAll(res.Body)
+
+		assert.Equal(t, fiber.StatusOK, res.StatusCode)
+		assert.NotEmpty(t, body)
+	})
+}
+
+func TestUpdateProfile(t *testing.T) {
+	defer afterAll()
+
+	t.Run("Should return error when not logged", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", nil)
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("Should update 1 field", func(t *testing.T) {
+		inputSchema := fiber.Map{
+			"username": username,
+			"password": password,
+		}
+
+		input, _ := json.Marshal(inputSchema)
+
+		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+		signUpReq.Header.Set("Content-Type", "application/json")
+		_, _ = app.Test(signUpReq)
+
+		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes, _ := app.Test(loginReq)
+
+		cookie := loginRes.Cookies()
+
+		inputUpdateSchema := fiber.Map{
+			"username": updateUsername,
+		}
+
+		inputUpdate, _ := json.Marshal(inputUpdateSchema)
+
+		req := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", bytes.NewReader(inputUpdate))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(cookie[0])
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusOK, res.StatusCode)
+	})
+
+	t.Run("Should update 2 field", func(t *testing.T) {
+		afterAll()
+
+		inputSchema := fiber.Map{
+			"username": username,
+			"password": password,
+		}
+
+		input, _ := json.Marshal(inputSchema)
+
+		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+		signUpReq.Header.Set("Content-Type", "application/json")
+		_, _ = app.Test(signUpReq)
+
+		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes, _ := app.Test(loginReq)
+
+		cookie := loginRes.Cookies()
+
+		inputUpdateSchema := fiber.Map{
+			"username": updateUsername,
+			"password": "updated-Staple-battery-9",
+		}
+
+		inputUpdate, _ := json.Marshal(inputUpdateSchema)
+
+		req := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", bytes.NewReader(inputUpdate))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(cookie[0])
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusOK, res.StatusCode)
+	})
+
+	t.Run("Should update the profile fields", func(t *testing.T) {
+		afterAll()
+
+		input, _ := json.Marshal(fiber.Map{
+			"username": username,
+			"password": password,
+		})
+
+		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+		signUpReq.Header.Set("Content-Type", "application/json")
+		_, _ = app.Test(signUpReq)
+
+		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes, _ := app.Test(loginReq)
+
+		cookie := loginRes.Cookies()
+
+		invalid, _ := json.Marshal(fiber.Map{
+			"timezone": "Mars/Olympus_Mons",
+		})
+
+		invalidReq := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", bytes.NewReader(invalid))
+		invalidReq.Header.Set("Content-Type", "application/json")
+		invalidReq.AddCookie(cookie[0])
+		invalidRes, _ := app.Test(invalidReq)
+
+		inputUpdate, _ := json.Marshal(fiber.Map{
+			"display_name":      "Test User",
+			"bio":               "Testing things.",
+			"pronouns":          "they/them",
+			"timezone":          "Europe/Berlin",
+			"locale":            "de-de",
+			"status_text":       "In a meeting",
+			"status_emoji":      "📅",
+			"status_expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+
+		req := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", bytes.NewReader(inputUpdate))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(cookie[0])
+		res, _ := app.Test(req)
+
+		inputClear, _ := json.Marshal(fiber.Map{
+			"bio": "",
+		})
+
+		clearReq := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", bytes.NewReader(inputClear))
+		clearReq.Header.Set("Content-Type", "application/json")
+		clearReq.AddCookie(cookie[0])
+		_, _ = app.Test(clearReq)
+
+		profileReq := httptest.NewRequest(fiber.MethodGet, "/api/user/profile", nil)
+		profileReq.AddCookie(cookie[0])
+		profileRes, _ := app.Test(profileReq)
+
+		var profile generated.GetUserByIDRow
+		_ = json.NewDecoder(profileRes.Body).Decode(&profile)
+
+		tests := []TestCase{
+			{expected: fiber.StatusBadRequest, actual: invalidRes.StatusCode},
+			{expected: fiber.StatusOK, actual: res.StatusCode},
+			{expected: "Test User", actual: profile.DisplayName.String},
+			{expected: false, actual: profile.Bio.Valid},
+			{expected: "they/them", actual: profile.Pronouns.String},
+			{expected: "Europe/Berlin", actual: profile.Timezone.String},
+			{expected: "de-DE", actual: profile.Locale.String},
+			{expected: "In a meeting", actual: profile.StatusText.String},
+			{expected: "📅", actual: profile.StatusEmoji.String},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+}
+
+func TestUpdateAvatar(t *testing.T) {
+	defer afterAll()
+
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
+
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = app.Test(signUpReq)
+
+	loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginRes, _ := app.Test(loginReq)
+
+	cookie := loginRes.Cookies()
+
+	upload := func(name string, data []byte) *http.Response {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, _ := form.CreateFormFile("avatar", name)
+		_, _ = file.Write(data)
+		_ = form.Close()
+
+		req := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.AddCookie(cookie[0])
+		res, _ := app.Test(req, 30_000)
+
+		return res
+	}
+
+	t.Run("Should reject files that are not images", func(t *testing.T) {
+		res := upload("avatar.txt", bytes.Repeat([]byte("not an image "), 100))
+
+		assert.Equal(t, fiber.StatusUnprocessableEntity, res.StatusCode)
+	})
+
+	t.Run("Should store the avatar in every size", func(t *testing.T) {
+		img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 200, A: 255}), image.Point{}, draw.Src)
+
+		var data bytes.Buffer
+		_ = png.Encode(&data, img)
+
+		res := upload("avatar.png", data.Bytes())
+
+		_, queries := appTest()
+		user, _ := queries.GetUserByUsername(context.Background(), username)
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: res.StatusCode},
+			{expected: true, actual: strings.HasSuffix(user.Avatar.String, "/1024.jpg")},
+			{expected: true, actual: strings.HasSuffix(user.Avatar32.String, "/32.jpg")},
+			{expected: true, actual: strings.HasSuffix(user.Avatar64.String, "/64.jpg")},
+			{expected: true, actual: strings.HasSuffix(user.Avatar256.String, "/256.jpg")},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should reset the avatar to the identicon", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodDelete, "/api/user/profile/avatar", nil)
+		req.AddCookie(cookie[0])
+		res, _ := app.Test(req, 30_000)
+
+		_, queries := appTest()
+		user, _ := queries.GetUserByUsername(context.Background(), username)
+
+		svgReq := httptest.NewRequest(fiber.MethodGet, "/api/users/"+user.ID.String()+"/identicon.svg", nil)
+		svgRes, _ := app.Test(svgReq)
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: res.StatusCode},
+			{expected: true, actual: strings.HasSuffix(user.Avatar.String, "/1024.png")},
+			{expected: true, actual: strings.HasSuffix(user.Avatar32.String, "/32.png")},
+			{expected: fiber.StatusOK, actual: svgRes.StatusCode},
+			{expected: "image/svg+xml", actual: svgRes.Header.Get(fiber.HeaderContentType)},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+}
+
+func TestUsernames(t *testing.T) {
+	defer afterAll()
+
+	t.Run("Should reject reserved usernames", func(t *testing.T) {
+		input, _ := json.Marshal(fiber.Map{
+			"username": "Admin",
+			"password": password,
+		})
+
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+		req.Header.Set("Content-Type", "application/json")
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("Should redirect the old username and hold it", func(t *testing.T) {
+		input, _ := json.Marshal(fiber.Map{
+			"username": username,
+			"password": password,
+		})
+
+		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+		signUpReq.Header.Set("Content-Type", "application/json")
+		_, _ = app.Test(signUpReq)
+
+		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes, _ := app.Test(loginReq)
+
+		cookie := loginRes.Cookies()
+
+		inputUpdate, _ := json.Marshal(fiber.Map{
+			"username": updateUsername,
+		})
+
+		updateReq := httptest.NewRequest(fiber.MethodPatch, "/api/user/profile/update", bytes.NewReader(inputUpdate))
+		updateReq.Header.Set("Content-Type", "application/json")
+		updateReq.AddCookie(cookie[0])
+		updateRes, _ := app.Test(updateReq)
+
+		getReq := httptest.NewRequest(fiber.MethodGet, "/api/users/"+strings.ToUpper(updateUsername), nil)
+		getRes, _ := app.Test(getReq)
+
+		var user generated.GetPublicUserByUsernameRow
+		_ = json.NewDecoder(getRes.Body).Decode(&user)
+
+		oldReq := httptest.NewRequest(fiber.MethodGet, "/api/users/"+username, nil)
+		oldRes, _ := app.Test(oldReq)
+
+		claimReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+		claimReq.Header.Set("Content-Type", "application/json")
+		claimRes, _ := app.Test(claimReq)
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: updateRes.StatusCode},
+			{expected: fiber.StatusOK, actual: getRes.StatusCode},
+			{expected: updateUsername, actual: user.Username},
+			{expected: fiber.StatusFound, actual: oldRes.StatusCode},
+			{expected: "/api/users/" + updateUsername, actual: oldRes.Header.Get(fiber.HeaderLocation)},
+			{expected: fiber.StatusForbidden, actual: claimRes.StatusCode},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should return not found for unknown usernames", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/users/"+genValue(20), nil)
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusNotFound, res.StatusCode)
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	defer afterAll()
+
+	t.Run("Should return error when not logged", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodDelete, "/api/user/profile/delete", nil)
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("Should delete user data and log out", func(t *testing.T) {
+		inputSchema := fiber.Map{
+			"username": username,
+			"password": password,
+		}
+
+		input, _ := json.Marshal(inputSchema)
+
+		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+		signUpReq.Header.Set("Content-Type", "application/json")
+		_, _ = app.Test(signUpReq)
+
+		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes, _ := app.Test(loginReq)
+
+		cookie := loginRes.Cookies()
+
+		req := httptest.NewRequest(fiber.MethodDelete, "/api/user/profile/delete", nil)
+		req.AddCookie(cookie[0])
+		res, _ := app.Test(req)
+
+		// The session stays valid as a token, the account is what is gone.
+		profileReq := httptest.NewRequest(fiber.MethodGet, "/api/user/profile", nil)
+		profileReq.AddCookie(cookie[0])
+		profileRes, _ := app.Test(profileReq)
+
+		assert.Equal(t, fiber.StatusOK, res.StatusCode)
+		assert.Empty(t, res.Cookies()[0].Value)
+		assert.Equal(t, fiber.StatusUnauthorized, profileRes.StatusCode)
+	})
+
+	t.Run("Should restore the account when logging in", func(t *testing.T) {
+		input, _ := json.Marshal(fiber.Map{
+			"username": username,
+			"password": password,
+		})
+
+		_, queries := appTest()
+		deleted, _ := queries.GetUserByUsername(context.Background(), username)
+
+		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes, _ := app.Test(loginReq)
+
+		restored, _ := queries.GetUserByUsername(context.Background(), username)
+
+		tests := []TestCase{
+			{expected: true, actual: deleted.DeletedAt.Valid},
+			{expected: true, actual: deleted.PurgeAt.Time.After(time.Now())},
+			{expected: fiber.StatusOK, actual: loginRes.StatusCode},
+			{expected: false, actual: restored.DeletedAt.Valid},
+			{expected: false, actual: restored.PurgeAt.Valid},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should purge the account's personal data", func(t *testing.T) {
+		db, queries := database()
+		user, _ := queries.GetUserByUsername(context.Background(), username)
+
+		_, _ = queries.ScheduleUserDeletion(context.Background(), generated.ScheduleUserDeletionParams{
+			ID:      user.ID,
+			PurgeAt: pgtype.Timestamptz{Time: time.Now().Add(-time.Minute), Valid: true},
+		})
+		_, _ = queries.AcceptTOTPStep(context.Background(), generated.AcceptTOTPStepParams{
+			UserID:   user.ID,
+			LastStep: 1,
+		})
+
+		// Tampering with the trail stays refused before the account is purged.
+		tamperErr := queries.ScrubUserAuditEvents(context.Background(), user.ID)
+
+		purged, _ := queries.PurgeUser(context.Background(), user.ID)
+		deleteErr := queries.DeleteUserPersonalData(context.Background(), user.ID)
+		scrubErr := queries.ScrubUserAuditEvents(context.Background(), user.ID)
+
+		events, _ := queries.ListUserAuditEvents(context.Background(), generated.ListUserAuditEventsParams{
+			UserID: pgtype.UUID{Bytes: user.ID, Valid: true},
+			Limit:  100,
+		})
+		var addresses []string
+		for _, event := range events {
+			if len(event.Ip) > 0 || len(event.UserAgent) > 0 {
+				addresses = append(addresses, event.Ip)
+			}
+		}
+
+		var states int
+		_ = db.QueryRow(context.Background(), "select count(*) from two_factor_state where user_id = $1", user.ID).Scan(&states)
+		_, rewriteErr := db.Exec(context.Background(), "update audit_events set action = 'user.rewritten' where actor_id = $1", user.ID)
+
+		tests := []TestCase{
+			{expected: true, actual: tamperErr != nil},
+			{expected: int64(1), actual: purged},
+			{expected: nil, actual: deleteErr},
+			{expected: nil, actual: scrubErr},
+			{expected: true, actual: len(events) > 0},
+			{expected: []string(nil), actual: addresses},
+			{expected: 0, actual: states},
+			{expected: true, actual: rewriteErr != nil},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+
+		purgedUser, _ := queries.GetUserByID(context.Background(), user.ID)
+		_ = queries.DeleteUserByUsername(context.Background(), purgedUser.Username)
+	})
+}
+
+func TestForgotPassword(t *testing.T) {
+	t.Run("Should return error when email is invalid", func(t *testing.T) {
+		inputSchema := fiber.Map{
+			"email": "not-an-email",
+		}
+
+		errorSchema := map[string]map[string]string{
+			"email": {
+				"email": "email value is an invalid email address",
+			},
+		}
+
+		input, _ := json.Marshal(inputSchema)
+		expected, _ := json.Marshal(errorSchema)
+
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/password/forgot", bytes.NewReader(input))
+		req.Header.Set("Content-Type", "application/json")
+
+		res, _ := app.Test(req)
+
+		body, _ := io.ReadAll(res.Body)
+
+		tests := []TestCase{
+			{
+				expected: fiber.StatusForbidden,
+				actual:   res.StatusCode,
+			},
+			{
+				expected: string(expected),
+				actual:   string(body),
+			},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should return ACCEPTED for unknown email", func(t *testing.T) {
+		inputSchema := fiber.Map{
+			"email": "unknown@example.com",
+		}
+
+		input, _ := json.Marshal(inputSchema)
+
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/password/forgot", bytes.NewReader(input))
+		req.Header.Set("Content-Type", "application/json")
+
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusAccepted, res.StatusCode)
+	})
+
+	t.Run("Should limit requests", func(t *testing.T) {
+		limitApp, _ := appTest()
+		input, _ := json.Marshal(fiber.Map{
+			"email": "unknown@example.com",
+		})
+
+		var statuses []int
+		for i := 0; i < 6; i++ {
+			req := httptest.NewRequest(fiber.MethodPost, "/api/auth/password/forgot", bytes.NewReader(input))
+			req.Header.Set("Content-Type", "application/json")
+			res, _ := limitApp.Test(req)
+			statuses = append(statuses, res.StatusCode)
+		}
+
+		assert.Equal(t, []int{202, 202, 202, 202, 202, fiber.StatusTooManyRequests}, statuses)
+	})
+}
+
+func TestResetPassword(t *testing.T) {
+	t.Run("Should return error when token is invalid", func(t *testing.T) {
+		inputSchema := fiber.Map{
+			"token":    genValue(43),
+			"password": password,
+		}
+
+		errorSchema := fiber.Map{
+			"message": "Token is invalid or expired.",
+		}
+
+		input, _ := json.Marshal(inputSchema)
+		expected, _ := json.Marshal(errorSchema)
+
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/password/reset", bytes.NewReader(input))
+		req.Header.Set("Content-Type", "application/json")
+
+		res, _ := app.Test(req)
+
+		body, _ := io.ReadAll(res.Body)
+
+		tests := []TestCase{
+			{
+				expected: fiber.StatusForbidden,
+				actual:   res.StatusCode,
+			},
+			{
+				expected: string(expected),
+				actual:   string(body),
+			},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+}
+
+func TestTwoFactor(t *testing.T) {
+	defer afterAll()
+
+	t.Run("Should return error when not logged", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/user/2fa/enroll", nil)
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("Should return error when verifying without pre-auth token", func(t *testing.T) {
+		input, _ := json.Marshal(fiber.Map{
+			"code": "123456",
+		})
+
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/2fa/verify", bytes.NewReader(input))
+		req.Header.Set("Content-Type", "application/json")
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("Should return secret and reject a wrong code", func(t *testing.T) {
+		inputSchema := fiber.Map{
+			"username": username,
+			"password": password,
+		}
+
+		input, _ := json.Marshal(inputSchema)
+
+		signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+		signUpReq.Header.Set("Content-Type", "application/json")
+		_, _ = app.Test(signUpReq)
+
+		loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+		loginReq.Header.Set("Content-Type", "application/json")
+		loginRes, _ := app.Test(loginReq)
+
+		cookie := loginRes.Cookies()
+
+		req := httptest.NewRequest(fiber.MethodPost, "/api/user/2fa/enroll", nil)
+		req.AddCookie(cookie[0])
+		res, _ := app.Test(req)
+
+		enrollment := map[string]string{}
+		body, _ := io.ReadAll(res.Body)
+		_ = json.Unmarshal(body, &enrollment)
+
+		assert.Equal(t, fiber.StatusOK, res.StatusCode)
+		assert.NotEmpty(t, enrollment["secret"])
+		assert.Contains(t, enrollment["uri"], "otpauth://totp/")
+
+		confirmInput, _ := json.Marshal(fiber.Map{
+			"code": "000000",
+		})
+
+		confirmReq := httptest.NewRequest(fiber.MethodPost, "/api/user/2fa/confirm", bytes.NewReader(confirmInput))
+		confirmReq.Header.Set("Content-Type", "application/json")
+		confirmReq.AddCookie(cookie[0])
+		confirmRes, _ := app.Test(confirmReq)
+
+		assert.Equal(t, fiber.StatusForbidden, confirmRes.StatusCode)
+	})
+
+	t.Run("Should accept each code and pre-auth token once and lock out guessing", func(t *testing.T) {
+		// The codes are generated for neighbouring time steps, so the
+		// subtest starts early in a step.
+		if elapsed := time.Now().Unix() % 30; elapsed > 20 {
+			time.Sleep(time.Duration(31-elapsed) * time.Second)
+		}
+
+		_ = os.Setenv("TWO_FACTOR_MAX_ATTEMPTS", "2")
+		defer os.Unsetenv("TWO_FACTOR_MAX_ATTEMPTS")
+		twoFactorApp, _ := appTest()
+
+		input, _ := json.Marshal(fiber.Map{
+			"username": username,
+			"password": password,
+		})
+
+		login := func() string {
+			req := httptest.NewRequest(fiber.MethodPost, "/api/auth/login?mode=token", bytes.NewReader(input))
+			req.Header.Set("Content-Type", "application/json")
+			res, _ := twoFactorApp.Test(req)
+
+			var body map[string]interface{}
+			_ = json.NewDecoder(res.Body).Decode(&body)
+			if token, ok := body["pre_auth_token"].(string); ok {
+				return token
+			}
+			token, _ := body["token"].(string)
+			return token
+		}
+
+		request := func(target, token string, body fiber.Map) *http.Response {
+			input, _ := json.Marshal(body)
+			req := httptest.NewRequest(fiber.MethodPost, target, bytes.NewReader(input))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+token)
+			res, _ := twoFactorApp.Test(req)
+			return res
+		}
+
+		session := login()
+		enrollRes := request("/api/user/2fa/enroll", session, nil)
+		enrollment := map[string]string{}
+		_ = json.NewDecoder(enrollRes.Body).Decode(&enrollment)
+
+		now := time.Now()
+		previous, _ := totp.GenerateCode(enrollment["secret"], now.Add(-30*time.Second))
+		current, _ := totp.GenerateCode(enrollment["secret"], now)
+		next, _ := totp.GenerateCode(enrollment["secret"], now.Add(30*time.Second))
+
+		confirmRes := request("/api/user/2fa/confirm", session, fiber.Map{"code": previous})
+
+		preAuth := login()
+		replayRes := request("/api/auth/2fa/verify?mode=token", preAuth, fiber.Map{"code": previous})
+		verifyRes := request("/api/auth/2fa/verify?mode=token", preAuth, fiber.Map{"code": current})
+		reuseRes := request("/api/auth/2fa/verify?mode=token", preAuth, fiber.Map{"code": next})
+		// The used token was refused before the code was looked at.
+		freshRes := request("/api/auth/2fa/verify?mode=token", login(), fiber.Map{"code": next})
+
+		lockoutApp, _ := appTest()
+		twoFactorApp = lockoutApp
+		preAuth = login()
+		firstWrongRes := request("/api/auth/2fa/verify", preAuth, fiber.Map{"code": "000000"})
+		secondWrongRes := request("/api/auth/2fa/verify", preAuth, fiber.Map{"code": "000000"})
+		lockedRes := request("/api/auth/2fa/verify", preAuth, fiber.Map{"code": "000000"})
+		var locked map[string]string
+		_ = json.NewDecoder(lockedRes.Body).Decode(&locked)
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: confirmRes.StatusCode},
+			{expected: fiber.StatusForbidden, actual: replayRes.StatusCode},
+			{expected: fiber.StatusOK, actual: verifyRes.StatusCode},
+			{expected: fiber.StatusUnauthorized, actual: reuseRes.StatusCode},
+			{expected: fiber.StatusOK, actual: freshRes.StatusCode},
+			{expected: fiber.StatusForbidden, actual: firstWrongRes.StatusCode},
+			{expected: fiber.StatusForbidden, actual: secondWrongRes.StatusCode},
+			{expected: fiber.StatusTooManyRequests, actual: lockedRes.StatusCode},
+			{expected: "Too many wrong codes, try again later.", actual: locked["message"]},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+}
+
+func TestPasskeys(t *testing.T) {
+	t.Run("Should return error when not logged", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/user/passkeys", nil)
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("Should return login challenge", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/passkeys/login/begin", nil)
+		res, _ := app.Test(req)
+
+		options := map[string]map[string]interface{}{}
+		body, _ := io.ReadAll(res.Body)
+		_ = json.Unmarshal(body, &options)
 
 		assert.Equal(t, fiber.StatusOK, res.StatusCode)
-		assert.Empty(t, res.Cookies()[0].Value)
+		assert.NotEmpty(t, options["publicKey"]["challenge"])
+		assert.NotEmpty(t, res.Cookies())
+	})
+
+	t.Run("Should return error when assertion is invalid", func(t *testing.T) {
+		beginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/passkeys/login/begin", nil)
+		beginRes, _ := app.Test(beginReq)
+
+		req := httptest.NewRequest(fiber.MethodPost, "/api/auth/passkeys/login/finish", bytes.NewReader([]byte("{}")))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(beginRes.Cookies()[0])
+		res, _ := app.Test(req)
+
+		assert.Equal(t, fiber.StatusForbidden, res.StatusCode)
+	})
+}
+
+// oidcStub is a minimal OpenID Connect provider. Codes are registered by the
+// test with the nonce and PKCE challenge taken from the authorization URL.
+type oidcStub struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	codes map[string]url.Values
+}
+
+func newOIDCStub() *oidcStub {
+	key, _ := rsa.GenerateKey(cryptorand.Reader, 2048)
+	stub := &oidcStub{key: key, codes: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                stub.URL,
+			"authorization_endpoint":                stub.URL + "/authorize",
+			"token_endpoint":                        stub.URL + "/token",
+			"jwks_uri":                              stub.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "stub",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		authorization, ok := stub.codes[r.PostForm.Get("code")]
+		delete(stub.codes, r.PostForm.Get("code"))
+
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": genValue(32),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token": stub.idToken(map[string]interface{}{
+				"iss":                stub.URL,
+				"sub":                "stub-subject",
+				"aud":                authorization.Get("client_id"),
+				"iat":                time.Now().Unix(),
+				"exp":                time.Now().Add(time.Hour).Unix(),
+				"nonce":              authorization.Get("nonce"),
+				"email":              oidcUsername + "@example.com",
+				"email_verified":     true,
+				"preferred_username": oidcUsername,
+			}),
+		})
+	})
+
+	stub.Server = httptest.NewServer(mux)
+	return stub
+}
+
+func (s *oidcStub) idToken(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "stub", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, _ := rsa.SignPKCS1v15(cryptorand.Reader, s.key, crypto.SHA256, digest[:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, cookie := range cookies {
+		if cookie.Name == name && len(cookie.Value) > 0 {
+			return cookie
+		}
+	}
+
+	return &http.Cookie{Name: name}
+}
+
+func TestOIDC(t *testing.T) {
+	defer afterAll()
+
+	stub := newOIDCStub()
+	defer stub.Close()
+
+	_ = os.Setenv("OIDC_PROVIDERS", "stub")
+	_ = os.Setenv("OIDC_STUB_ISSUER", stub.URL)
+	_ = os.Setenv("OIDC_STUB_CLIENT_ID", "chat-app")
+	_ = os.Setenv("OIDC_STUB_CLIENT_SECRET", "secret")
+	oidcApp, queries := appTest()
+
+	// authorize starts a login and lets the stub accept code for it.
+	authorize := func(code string) (url.Values, *http.Cookie) {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/auth/oidc/stub/login", nil)
+		res, _ := oidcApp.Test(req)
+
+		location, _ := url.Parse(res.Header.Get("Location"))
+		stub.codes[code] = location.Query()
+
+		return location.Query(), findCookie(res.Cookies(), "chat_app_oidc")
+	}
+
+	t.Run("Should return not found for unknown provider", func(t *testing.T) {
+		req := httptest.NewRequest(fiber.MethodGet, "/api/auth/oidc/unknown/login", nil)
+		res, _ := oidcApp.Test(req)
+
+		assert.Equal(t, fiber.StatusNotFound, res.StatusCode)
+	})
+
+	t.Run("Should redirect to provider with PKCE and nonce", func(t *testing.T) {
+		query, cookie := authorize(genValue(16))
+
+		tests := []TestCase{
+			{expected: "chat-app", actual: query.Get("client_id")},
+			{expected: "S256", actual: query.Get("code_challenge_method")},
+			{expected: true, actual: len(query.Get("state")) > 0},
+			{expected: true, actual: len(query.Get("nonce")) > 0},
+			{expected: true, actual: len(cookie.Value) > 0},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should reject callback with wrong state", func(t *testing.T) {
+		code := genValue(16)
+		_, cookie := authorize(code)
+
+		req := httptest.NewRequest(fiber.MethodGet, "/api/auth/oidc/stub/callback?code="+code+"&state=wrong", nil)
+		req.AddCookie(cookie)
+		res, _ := oidcApp.Test(req)
+
+		assert.Equal(t, fiber.StatusFound, res.StatusCode)
+		assert.Contains(t, res.Header.Get("Location"), "error=oidc_failed")
+		assert.Empty(t, findCookie(res.Cookies(), "chat_app").Value)
+	})
+
+	t.Run("Should provision account, link and unlink identity", func(t *testing.T) {
+		code := genValue(16)
+		query, cookie := authorize(code)
+
+		req := httptest.NewRequest(fiber.MethodGet, "/api/auth/oidc/stub/callback?code="+code+"&state="+query.Get("state"), nil)
+		req.AddCookie(cookie)
+		res, _ := oidcApp.Test(req)
+
+		session := findCookie(res.Cookies(), "chat_app")
+
+		assert.Equal(t, fiber.StatusFound, res.StatusCode)
+		assert.NotContains(t, res.Header.Get("Location"), "error=")
+		assert.NotEmpty(t, session.Value)
+
+		listReq := httptest.NewRequest(fiber.MethodGet, "/api/user/identities", nil)
+		listReq.AddCookie(session)
+		listRes, _ := oidcApp.Test(listReq)
+
+		var identities []map[string]interface{}
+		body, _ := io.ReadAll(listRes.Body)
+		_ = json.Unmarshal(body, &identities)
+
+		assert.Equal(t, fiber.StatusOK, listRes.StatusCode)
+		assert.Len(t, identities, 1)
+		assert.Equal(t, "stub", identities[0]["provider"])
+
+		profileReq := httptest.NewRequest(fiber.MethodGet, "/api/user/profile", nil)
+		profileReq.AddCookie(session)
+		profileRes, _ := oidcApp.Test(profileReq)
+
+		profile := map[string]interface{}{}
+		body, _ = io.ReadAll(profileRes.Body)
+		_ = json.Unmarshal(body, &profile)
+
+		assert.Equal(t, oidcUsername, profile["username"])
+
+		user, _ := queries.GetUserByUsername(context.Background(), oidcUsername)
+		assert.False(t, user.PasswordSet)
+
+		// Disabling two-factor authentication would ask for a password the
+		// account never had.
+		enrollReq := httptest.NewRequest(fiber.MethodPost, "/api/user/2fa/enroll", nil)
+		enrollReq.AddCookie(session)
+		enrollRes, _ := oidcApp.Test(enrollReq)
+
+		assert.Equal(t, fiber.StatusForbidden, enrollRes.StatusCode)
+
+		unlinkReq := httptest.NewRequest(fiber.MethodDelete, "/api/user/identities/stub", nil)
+		unlinkReq.AddCookie(session)
+		unlinkRes, _ := oidcApp.Test(unlinkReq)
+
+		assert.Equal(t, fiber.StatusOK, unlinkRes.StatusCode)
+	})
+}
+
+// pushStub is a push service. Endpoints under /gone answer as for expired
+// subscriptions, those under /slow answer after the sender gave up, the
+// others accept every message.
+type pushStub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	messages map[string][]pushMessage
+}
+
+type pushMessage struct {
+	header http.Header
+	body   []byte
+}
+
+func newPushStub() *pushStub {
+	stub := &pushStub{messages: map[string][]pushMessage{}}
+
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		stub.mu.Lock()
+		stub.messages[r.URL.Path] = append(stub.messages[r.URL.Path], pushMessage{header: r.Header, body: body})
+		stub.mu.Unlock()
+
+		if strings.HasPrefix(r.URL.Path, "/slow") {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+		if strings.HasPrefix(r.URL.Path, "/gone") {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	return stub
+}
+
+// received returns the messages sent to path.
+func (s *pushStub) received(path string) []pushMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.messages[path]
+}
+
+// decryptPush decrypts an aes128gcm message the way a browser does.
+func decryptPush(body []byte, key *ecdh.PrivateKey, auth []byte) []byte {
+	salt, keyID := body[:16], body[21:21+int(body[20])]
+	record := body[21+len(keyID):]
+
+	serverKey, _ := ecdh.P256().NewPublicKey(keyID)
+	shared, _ := key.ECDH(serverKey)
+
+	read := func(r io.Reader, n int) []byte {
+		out := make([]byte, n)
+		_, _ = io.ReadFull(r, out)
+		return out
+	}
+	keyInfo := append(append([]byte("WebPush: info\x00"), key.PublicKey().Bytes()...), keyID...)
+	ikm := read(hkdf.New(sha256.New, shared, auth, keyInfo), 32)
+	cek := read(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), 16)
+	nonce := read(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), 12)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, record, nil)
+	if err != nil {
+		return nil
+	}
+
+	return bytes.TrimSuffix(plaintext, []byte{0x02})
+}
+
+func TestPush(t *testing.T) {
+	defer afterAll()
+
+	stub := newPushStub()
+	defer stub.Close()
+
+	_ = os.Setenv("PUSH_SERVICES", stub.URL)
+	_ = os.Setenv("PUSH_SEND_TIMEOUT", "300ms")
+	pushApp, _ := appTest()
+
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
+
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = pushApp.Test(signUpReq)
+
+	loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login", bytes.NewReader(input))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginRes, _ := pushApp.Test(loginReq)
+	session := findCookie(loginRes.Cookies(), "chat_app")
+
+	request := func(method, target string, body fiber.Map) *http.Response {
+		var reader io.Reader
+		if body != nil {
+			input, _ := json.Marshal(body)
+			reader = bytes.NewReader(input)
+		}
+		req := httptest.NewRequest(method, target, reader)
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(session)
+		res, _ := pushApp.Test(req)
+		return res
+	}
+
+	browserKey, _ := ecdh.P256().GenerateKey(cryptorand.Reader)
+	auth := make([]byte, 16)
+	_, _ = cryptorand.Read(auth)
+	keys := fiber.Map{
+		"p256dh": base64.RawURLEncoding.EncodeToString(browserKey.PublicKey().Bytes()),
+		"auth":   base64.RawURLEncoding.EncodeToString(auth),
+	}
+
+	var subscription struct {
+		ID string `json:"id"`
+	}
+
+	t.Run("Should refuse other endpoints and invalid keys", func(t *testing.T) {
+		otherRes := request(fiber.MethodPost, "/api/user/push/subscriptions", fiber.Map{
+			"endpoint": "http://169.254.169.254/latest",
+			"keys":     keys,
+		})
+		keysRes := request(fiber.MethodPost, "/api/user/push/subscriptions", fiber.Map{
+			"endpoint": stub.URL + "/live",
+			"keys":     fiber.Map{"p256dh": "AAAA", "auth": "AAAA"},
+		})
+
+		tests := []TestCase{
+			{expected: fiber.StatusBadRequest, actual: otherRes.StatusCode},
+			{expected: fiber.StatusBadRequest, actual: keysRes.StatusCode},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should send encrypted notifications and prune gone subscriptions", func(t *testing.T) {
+		liveRes := request(fiber.MethodPost, "/api/user/push/subscriptions", fiber.Map{
+			"endpoint": stub.URL + "/live",
+			"keys":     keys,
+		})
+		_ = json.NewDecoder(liveRes.Body).Decode(&subscription)
+		goneRes := request(fiber.MethodPost, "/api/user/push/subscriptions", fiber.Map{
+			"endpoint": stub.URL + "/gone",
+			"keys":     keys,
+		})
+
+		keyRes := request(fiber.MethodGet, "/api/user/push/key", nil)
+		var key struct {
+			PublicKey string `json:"public_key"`
+		}
+		_ = json.NewDecoder(keyRes.Body).Decode(&key)
+
+		testRes := request(fiber.MethodPost, "/api/user/push/test", nil)
+		var sent struct {
+			Sent int `json:"sent"`
+		}
+		_ = json.NewDecoder(testRes.Body).Decode(&sent)
+
+		var notification map[string]interface{}
+		var authorization, encoding string
+		if messages := stub.received("/live"); len(messages) == 1 {
+			_ = json.Unmarshal(decryptPush(messages[0].body, browserKey, auth), &notification)
+			authorization = messages[0].header.Get("Authorization")
+			encoding = messages[0].header.Get("Content-Encoding")
+		}
+
+		listRes := request(fiber.MethodGet, "/api/user/push/subscriptions", nil)
+		var subscriptions []map[string]interface{}
+		_ = json.NewDecoder(listRes.Body).Decode(&subscriptions)
+		var listed []interface{}
+		for _, subscription := range subscriptions {
+			listed = append(listed, subscription["id"])
+		}
+
+		tests := []TestCase{
+			{expected: fiber.StatusCreated, actual: liveRes.StatusCode},
+			{expected: fiber.StatusCreated, actual: goneRes.StatusCode},
+			{expected: fiber.StatusOK, actual: testRes.StatusCode},
+			{expected: 1, actual: sent.Sent},
+			{expected: 1, actual: len(stub.received("/gone"))},
+			{expected: "Test notification", actual: notification["title"]},
+			{expected: "aes128gcm", actual: encoding},
+			{expected: true, actual: strings.HasPrefix(authorization, "vapid t=")},
+			{expected: true, actual: strings.HasSuffix(authorization, ", k="+key.PublicKey)},
+			{expected: []interface{}{subscription.ID}, actual: listed},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should not wait for slow push services", func(t *testing.T) {
+		slowRes := request(fiber.MethodPost, "/api/user/push/subscriptions", fiber.Map{
+			"endpoint": stub.URL + "/slow",
+			"keys":     keys,
+		})
+
+		start := time.Now()
+		testRes := request(fiber.MethodPost, "/api/user/push/test", nil)
+		elapsed := time.Since(start)
+		var sent struct {
+			Sent int `json:"sent"`
+		}
+		_ = json.NewDecoder(testRes.Body).Decode(&sent)
+
+		tests := []TestCase{
+			{expected: fiber.StatusCreated, actual: slowRes.StatusCode},
+			{expected: fiber.StatusOK, actual: testRes.StatusCode},
+			{expected: 1, actual: sent.Sent},
+			{expected: 1, actual: len(stub.received("/slow"))},
+			{expected: true, actual: elapsed < time.Second},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should respect the notification settings", func(t *testing.T) {
+		_ = request(fiber.MethodPatch, "/api/user/notifications", fiber.Map{
+			"muted_until": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+
+		testRes := request(fiber.MethodPost, "/api/user/push/test", nil)
+		var sent struct {
+			Sent int `json:"sent"`
+		}
+		_ = json.NewDecoder(testRes.Body).Decode(&sent)
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: testRes.StatusCode},
+			{expected: 0, actual: sent.Sent},
+			{expected: 2, actual: len(stub.received("/live"))},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should unsubscribe", func(t *testing.T) {
+		res := request(fiber.MethodDelete, "/api/user/push/subscriptions/"+subscription.ID, nil)
+		againRes := request(fiber.MethodDelete, "/api/user/push/subscriptions/"+subscription.ID, nil)
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: res.StatusCode},
+			{expected: fiber.StatusNotFound, actual: againRes.StatusCode},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+}
+
+func TestMobilePush(t *testing.T) {
+	defer afterAll()
+
+	mobileApp, queries := appTest()
+	db, _ := database()
+
+	input, _ := json.Marshal(fiber.Map{
+		"username": username,
+		"password": password,
+	})
+
+	signUpReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/signup", bytes.NewReader(input))
+	signUpReq.Header.Set("Content-Type", "application/json")
+	_, _ = mobileApp.Test(signUpReq)
+
+	loginReq := httptest.NewRequest(fiber.MethodPost, "/api/auth/login?mode=token", bytes.NewReader(input))
+	loginReq.Header.Set("Content-Type", "application/json")
+	loginRes, _ := mobileApp.Test(loginReq)
+	var login struct {
+		Token string `json:"token"`
+	}
+	_ = json.NewDecoder(loginRes.Body).Decode(&login)
+
+	request := func(method, target string, body fiber.Map) *http.Response {
+		var reader io.Reader
+		if body != nil {
+			input, _ := json.Marshal(body)
+			reader = bytes.NewReader(input)
+		}
+		req := httptest.NewRequest(method, target, reader)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+login.Token)
+		res, _ := mobileApp.Test(req)
+		return res
+	}
+
+	listDevices := func() []string {
+		res := request(fiber.MethodGet, "/api/user/push/devices", nil)
+		var devices []struct {
+			Platform string `json:"platform"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&devices)
+		platforms := []string{}
+		for _, device := range devices {
+			platforms = append(platforms, device.Platform)
+		}
+		return platforms
+	}
+
+	// Deliveries are sent by the service directly rather than by the
+	// background job, with a sender that keeps the messages.
+	sender := push.NewFakeSender()
+	pushService, _ := services.NewPushService(
+		repositories.NewPushRepo(queries, utils.GetTimeouts()),
+		services.NewNotificationService(repositories.NewNotificationRepo(queries, utils.GetTimeouts())),
+		map[string]push.PushSender{push.PlatformIOS: sender, push.PlatformAndroid: sender},
+	)
+	user, _ := queries.GetUserByUsername(context.Background(), username)
+
+	notify := func(tag, body string) int {
+		queued, _ := pushService.Notify(context.Background(), user.ID, services.NotificationMessage, services.PushNotification{
+			Title: "New message",
+			Body:  body,
+			Tag:   tag,
+		})
+		return queued
+	}
+
+	t.Run("Should register devices", func(t *testing.T) {
+		iosRes := request(fiber.MethodPost, "/api/user/push/devices", fiber.Map{
+			"platform": "ios",
+			"token":    "ios-token",
+		})
+		androidRes := request(fiber.MethodPost, "/api/user/push/devices", fiber.Map{
+			"platform": "android",
+			"token":    "android-token",
+		})
+		invalidRes := request(fiber.MethodPost, "/api/user/push/devices", fiber.Map{
+			"platform": "symbian",
+			"token":    "symbian-token",
+		})
+
+		var device struct {
+			ID string `json:"id"`
+		}
+		otherRes := request(fiber.MethodPost, "/api/user/push/devices", fiber.Map{
+			"platform": "android",
+			"token":    "other-token",
+		})
+		_ = json.NewDecoder(otherRes.Body).Decode(&device)
+		deleteRes := request(fiber.MethodDelete, "/api/user/push/devices/"+device.ID, nil)
+		againRes := request(fiber.MethodDelete, "/api/user/push/devices/"+device.ID, nil)
+
+		tests := []TestCase{
+			{expected: fiber.StatusCreated, actual: iosRes.StatusCode},
+			{expected: fiber.StatusCreated, actual: androidRes.StatusCode},
+			{expected: fiber.StatusForbidden, actual: invalidRes.StatusCode},
+			{expected: fiber.StatusOK, actual: deleteRes.StatusCode},
+			{expected: fiber.StatusNotFound, actual: againRes.StatusCode},
+			{expected: 2, actual: len(listDevices())},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should collapse a burst into one notification", func(t *testing.T) {
+		firstQueued := notify("conversation-1", "Hello")
+		_ = notify("conversation-1", "Are you there?")
+
+		sent, err := pushService.ProcessDeliveries(context.Background())
+		messages := sender.Messages()
+
+		bodies := []string{}
+		for _, msg := range messages {
+			bodies = append(bodies, msg.Body+" "+msg.Data["count"]+" "+msg.CollapseKey)
+		}
+
+		tests := []TestCase{
+			{expected: 2, actual: firstQueued},
+			{expected: nil, actual: err},
+			{expected: 2, actual: sent},
+			{expected: []string{"2 new messages 2 conversation-1", "2 new messages 2 conversation-1"}, actual: bodies},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should retry transient failures", func(t *testing.T) {
+		sender.Fail("android-token", fmt.Errorf("%w: unavailable", push.ErrTemporary))
+		_ = notify("conversation-2", "Hello")
+
+		firstSent, _ := pushService.ProcessDeliveries(context.Background())
+
+		sender.Fail("android-token", nil)
+		waitingSent, _ := pushService.ProcessDeliveries(context.Background())
+
+		// The retry waits for the backoff, which is skipped here.
+		_, _ = db.Exec(context.Background(), "update push_deliveries set next_attempt_at = now() where collapse_key = 'conversation-2'")
+		retriedSent, _ := pushService.ProcessDeliveries(context.Background())
+		emptySent, _ := pushService.ProcessDeliveries(context.Background())
+
+		var last push.Message
+		if messages := sender.Messages(); len(messages) > 0 {
+			last = messages[len(messages)-1]
+		}
+
+		tests := []TestCase{
+			{expected: 1, actual: firstSent},
+			{expected: 0, actual: waitingSent},
+			{expected: 1, actual: retriedSent},
+			{expected: 0, actual: emptySent},
+			{expected: "android-token", actual: last.Token},
+			{expected: "Hello", actual: last.Body},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should forget unregistered devices", func(t *testing.T) {
+		sender.Fail("ios-token", push.ErrUnregistered)
+		_ = notify("conversation-3", "Hello")
+
+		sent, _ := pushService.ProcessDeliveries(context.Background())
+
+		tests := []TestCase{
+			{expected: 1, actual: sent},
+			{expected: []string{"android"}, actual: listDevices()},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
+	})
+
+	t.Run("Should unregister the session's devices on signout", func(t *testing.T) {
+		res := request(fiber.MethodPost, "/api/auth/signout", nil)
+
+		devices, _ := queries.ListPushDevices(context.Background(), user.ID)
+
+		tests := []TestCase{
+			{expected: fiber.StatusOK, actual: res.StatusCode},
+			{expected: 0, actual: len(devices)},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.expected, test.actual)
+		}
 	})
 }